@@ -17,15 +17,29 @@ import (
 
 // Application holds all application dependencies and services
 type Application struct {
-	config        *config.Config
-	logger        *logger.Logger
-	mongodb       *database.MongoDB
-	repos         *repositories.Provider
-	tenantService services.TenantService
-	router        *gin.Engine
-	handlers      *Handlers
-	webHandlers   *WebHandlers
-	sessionConfig middleware.SessionConfig
+	config                *config.Config
+	logger                *logger.Logger
+	mongodb               *database.MongoDB
+	repos                 *repositories.Provider
+	tenantService         services.TenantService
+	recipeService         services.RecipeService
+	rolloutService        services.RolloutService
+	syncService           services.RecipeSyncService
+	inventoryService      services.InventoryService
+	orderService          services.OrderService
+	freshnessService      services.FreshnessService
+	layoutService         services.KitchenLayoutService
+	calibrationService    services.CalibrationService
+	reconciliationService services.ReconciliationService
+	costingService        services.CostingService
+	catalogService        services.CatalogService
+	libraryRecipeService  services.RecipeService
+	libraryService        services.LibraryService
+	stepTemplateService   services.StepTemplateService
+	router                *gin.Engine
+	handlers              *Handlers
+	webHandlers           *WebHandlers
+	sessionConfig         middleware.SessionConfig
 }
 
 // New creates a new Application instance
@@ -46,12 +60,33 @@ func New(cfg *config.Config, log *logger.Logger, mongodb *database.MongoDB) (*Ap
 	// Create tenant service with Keycloak integration
 	tenantService := services.NewTenantService(repos.Tenant, keycloakSvc)
 
+	recipeService := services.NewRecipeService(repos.Recipe, repos.Ingredient, repos.Site, repos.Region, repos.Tenant, repos.StepTemplate, repos.AuditLog)
+
+	// Library recipes are authored like tenant recipes but are never published to sites
+	libraryRecipeService := services.NewRecipeService(repos.LibraryRecipe, repos.LibraryIngredient, nil, nil, nil, nil, nil)
+
+	inventoryService := services.NewInventoryService(repos.Inventory, repos.Order, repos.Recipe, repos.Ingredient, repos.Kitchen, repos.Consumption)
+
 	app := &Application{
-		config:        cfg,
-		logger:        log,
-		mongodb:       mongodb,
-		repos:         repos,
-		tenantService: tenantService,
+		config:                cfg,
+		logger:                log,
+		mongodb:               mongodb,
+		repos:                 repos,
+		tenantService:         tenantService,
+		recipeService:         recipeService,
+		rolloutService:        services.NewRolloutService(repos.Recipe, repos.Rollout, repos.Order, repos.Site, repos.Region, repos.AuditLog),
+		syncService:           services.NewRecipeSyncService(repos.Recipe, repos.Rollout, repos.Site, repos.KOSInstance),
+		inventoryService:      inventoryService,
+		orderService:          services.NewOrderService(repos.Order, repos.Recipe, repos.Ingredient, repos.Site, repos.Rollout, inventoryService),
+		freshnessService:      services.NewFreshnessService(repos.Canister, repos.Ingredient, repos.Kitchen),
+		layoutService:         services.NewKitchenLayoutService(repos.Layout, repos.Ingredient),
+		calibrationService:    services.NewCalibrationService(repos.Calibration, repos.Ingredient, repos.Kitchen),
+		reconciliationService: services.NewReconciliationService(repos.Consumption, repos.Recipe, repos.Ingredient, repos.Kitchen),
+		costingService:        services.NewCostingService(repos.Cost, repos.MenuPrice, repos.Recipe, repos.Ingredient, repos.Order, repos.Region, repos.Tenant),
+		catalogService:        services.NewCatalogService(repos.Ingredient, repos.Recipe, recipeService, repos.AuditLog),
+		libraryRecipeService:  libraryRecipeService,
+		libraryService:        services.NewLibraryService(repos.LibraryRecipe, repos.LibraryIngredient, libraryRecipeService, repos.Recipe, repos.Ingredient, recipeService, repos.Tenant, repos.AuditLog),
+		stepTemplateService:   services.NewStepTemplateService(repos.StepTemplate, repos.Recipe, recipeService, repos.AuditLog),
 	}
 
 	// Create handlers with repositories
@@ -152,6 +187,18 @@ func (a *Application) setupRoutes() {
 			sites.GET("/:id", a.getSite)
 			sites.PUT("/:id", a.updateSite)
 			sites.DELETE("/:id", a.deleteSite)
+			sites.GET("/:id/inventory", a.getSiteInventory)
+			sites.GET("/:id/inventory/forecast", a.getSiteInventoryForecast)
+			sites.GET("/:id/menu-availability", a.getSiteMenuAvailability)
+			sites.GET("/:id/freshness", a.getSiteFreshness)
+			sites.GET("/:id/calibrations", a.listSiteCalibrations)
+			sites.POST("/:id/calibrations", a.setSiteCalibrations)
+			sites.GET("/:id/calibrations/history", a.getSiteCalibrationHistory)
+			sites.GET("/:id/consumption-variance", a.getSiteConsumptionVariance)
+			sites.GET("/:id/menu-prices", a.listSiteMenuPrices)
+			sites.POST("/:id/menu-prices", a.setSiteMenuPrices)
+			sites.GET("/:id/recipe-margins", a.getSiteRecipeMargins)
+			sites.GET("/:id/cost-history", a.getSiteCostHistory)
 		}
 
 		// Kitchen management
@@ -161,6 +208,10 @@ func (a *Application) setupRoutes() {
 			kitchens.POST("", a.createKitchen)
 			kitchens.GET("/:id", a.getKitchen)
 			kitchens.PUT("/:id", a.updateKitchen)
+			kitchens.GET("/:id/layout", a.getKitchenLayout)
+			kitchens.PUT("/:id/layout", a.updateKitchenLayout)
+			kitchens.GET("/:id/layout/versions", a.listKitchenLayoutVersions)
+			kitchens.GET("/:id/recipe-check", a.checkKitchenRecipes)
 		}
 
 		// KOS instance management
@@ -176,6 +227,7 @@ func (a *Application) setupRoutes() {
 			kos.DELETE("/:id", a.deleteKOSInstance)
 			kos.POST("/:id/deactivate", a.deactivateKOSInstance)
 			kos.POST("/:id/activate", a.activateKOSInstance)
+			kos.GET("/:id/recipe-sync", a.listKOSRecipeSync)
 		}
 
 		// Ingredient management
@@ -183,10 +235,16 @@ func (a *Application) setupRoutes() {
 		{
 			ingredients.GET("", a.listIngredients)
 			ingredients.POST("", a.createIngredient)
+			ingredients.POST("/import", a.importIngredients)
+			ingredients.GET("/export", a.exportIngredients)
 			ingredients.GET("/:id", a.getIngredient)
 			ingredients.PUT("/:id", a.updateIngredient)
 			ingredients.DELETE("/:id", a.deleteIngredient)
 			ingredients.POST("/:id/toggle-active", a.toggleIngredientActive)
+			ingredients.GET("/:id/usage", a.getIngredientUsage)
+			ingredients.POST("/:id/replace", a.replaceIngredient)
+			ingredients.GET("/:id/costs", a.listIngredientCosts)
+			ingredients.POST("/:id/costs", a.recordIngredientCost)
 		}
 
 		// Recipe management
@@ -194,11 +252,73 @@ func (a *Application) setupRoutes() {
 		{
 			recipes.GET("", a.listRecipes)
 			recipes.POST("", a.createRecipe)
+			recipes.POST("/validate", a.validateRecipe)
+			recipes.POST("/import", a.importRecipes)
+			recipes.GET("/export", a.exportRecipes)
+			recipes.GET("/actions", a.listRecipeActions)
+			recipes.GET("/lint-rules", a.listLintRules)
+			recipes.GET("/units", a.listUnits)
+			recipes.GET("/sync-matrix", a.getRecipeSyncMatrix)
+			recipes.GET("/upstream-updates", a.listUpstreamUpdates)
 			recipes.GET("/:id", a.getRecipe)
 			recipes.PUT("/:id", a.updateRecipe)
 			recipes.DELETE("/:id", a.deleteRecipe)
 			recipes.POST("/:id/publish", a.publishRecipe)
 			recipes.POST("/:id/unpublish", a.unpublishRecipe)
+			recipes.POST("/:id/submit", a.submitRecipeForReview)
+			recipes.POST("/:id/approve", a.approveRecipe)
+			recipes.POST("/:id/reject", a.rejectRecipe)
+			recipes.GET("/:id/audit", a.listRecipeAuditLog)
+			recipes.GET("/:id/versions", a.listRecipeVersions)
+			recipes.GET("/:id/versions/:version", a.getRecipeVersion)
+			recipes.POST("/:id/versions/:version/rollback", a.rollbackRecipe)
+			recipes.GET("/:id/diff", a.diffRecipeVersions)
+			recipes.GET("/:id/scaled", a.previewRecipeScaling)
+			recipes.GET("/:id/nutrition", a.getRecipeNutrition)
+			recipes.GET("/:id/cost", a.getRecipeCost)
+			recipes.GET("/:id/rollouts", a.listRecipeRollouts)
+			recipes.POST("/:id/rollouts", a.startRecipeRollout)
+			recipes.GET("/:id/upstream", a.getRecipeUpstream)
+			recipes.POST("/:id/upstream/merge", a.mergeRecipeUpstream)
+			recipes.POST("/:id/revalidate", a.revalidateRecipe)
+		}
+
+		// Step templates: reusable step sequences recipes expand into their steps
+		stepTemplates := v1.Group("/step-templates")
+		{
+			stepTemplates.GET("", a.listStepTemplates)
+			stepTemplates.POST("", a.createStepTemplate)
+			stepTemplates.GET("/:id", a.getStepTemplate)
+			stepTemplates.PUT("/:id", a.updateStepTemplate)
+			stepTemplates.DELETE("/:id", a.deleteStepTemplate)
+			stepTemplates.GET("/:id/versions", a.listStepTemplateVersions)
+			stepTemplates.GET("/:id/versions/:version", a.getStepTemplateVersion)
+			stepTemplates.GET("/:id/usage", a.getStepTemplateUsage)
+		}
+
+		// Platform recipe library: reference recipes tenants fork into their catalogs
+		library := v1.Group("/library")
+		{
+			library.GET("/ingredients", a.listLibraryIngredients)
+			library.POST("/ingredients", a.createLibraryIngredient)
+			library.GET("/ingredients/:id", a.getLibraryIngredient)
+			library.PUT("/ingredients/:id", a.updateLibraryIngredient)
+			library.GET("/recipes", a.listLibraryRecipes)
+			library.POST("/recipes", a.createLibraryRecipe)
+			library.GET("/recipes/:id", a.getLibraryRecipe)
+			library.PUT("/recipes/:id", a.updateLibraryRecipe)
+			library.GET("/recipes/:id/versions", a.listLibraryRecipeVersions)
+			library.POST("/recipes/:id/release", a.releaseLibraryRecipe)
+			library.POST("/recipes/:id/fork", a.forkLibraryRecipe)
+		}
+
+		// Canary rollouts of new recipe versions
+		rollouts := v1.Group("/rollouts")
+		{
+			rollouts.GET("/:id", a.getRollout)
+			rollouts.POST("/:id/promote", a.promoteRollout)
+			rollouts.POST("/:id/rollback", a.rollbackRollout)
+			rollouts.POST("/:id/evaluate", a.evaluateRollout)
 		}
 
 		// Order management
@@ -207,6 +327,8 @@ func (a *Application) setupRoutes() {
 			orders.GET("", a.listOrders)
 			orders.POST("", a.createOrder)
 			orders.GET("/:id", a.getOrder)
+			orders.GET("/:id/nutrition", a.getOrderNutrition)
+			orders.GET("/:id/consumption", a.getOrderConsumption)
 			orders.PUT("/:id", a.updateOrder)
 			orders.POST("/:id/cancel", a.cancelOrder)
 		}
@@ -222,11 +344,18 @@ func (a *Application) setupRoutes() {
 
 			// Recipe sync (KOS pulls from KWS)
 			kosAPI.GET("/recipes", a.kosGetRecipes)
+			kosAPI.POST("/recipes/ack", a.kosAckRecipes)
 			kosAPI.GET("/ingredients", a.kosGetIngredients)
+			kosAPI.GET("/layouts", a.kosGetLayouts)
 
 			// Order sync
 			kosAPI.GET("/orders", a.kosGetOrders)
 			kosAPI.POST("/orders/:id/status", a.kosUpdateOrderStatus)
+
+			// Inventory (KOS reports what its kitchens have loaded)
+			kosAPI.POST("/inventory", a.kosReportInventory)
+			kosAPI.POST("/canisters", a.kosReportCanisters)
+			kosAPI.POST("/calibrations", a.kosReportCalibrations)
 		}
 	}
 }
@@ -1,14 +1,17 @@
 package app
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/ak/kws/internal/app/middleware"
 	"github.com/ak/kws/internal/domain/models"
 	"github.com/ak/kws/internal/domain/repositories"
 	"github.com/ak/kws/internal/domain/services"
 	infrarepos "github.com/ak/kws/internal/infrastructure/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
 	"github.com/ak/kws/internal/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -96,6 +99,33 @@ func errorResponse(c *gin.Context, status int, code, message string) {
 	})
 }
 
+// serviceErrorResponse writes the response for an error returned by a domain service.
+// Services report client-facing failures as *apperrors.APIError; anything else is internal.
+func serviceErrorResponse(c *gin.Context, err error, fallbackMessage string) {
+	var apiErr *apperrors.APIError
+	if errors.As(err, &apiErr) {
+		c.JSON(apiErr.HTTPStatus, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    string(apiErr.Code),
+				Message: apiErr.Message,
+				Details: apiErr.Details,
+			},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+	errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", fallbackMessage)
+}
+
+// currentUserID returns the ID of the logged-in user, or "" for unauthenticated requests
+func currentUserID(c *gin.Context) string {
+	if user := middleware.GetUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
 func getObjectID(c *gin.Context, param string) (primitive.ObjectID, bool) {
 	idStr := c.Param(param)
 	id, err := primitive.ObjectIDFromHex(idStr)
@@ -319,6 +349,12 @@ func (a *Application) updateTenant(c *gin.Context) {
 		tenant.Address = req.Address
 	}
 	if req.Settings != nil {
+		for _, rule := range req.Settings.DisabledLintRules {
+			if models.GetLintRule(rule) == nil {
+				errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "Unknown lint rule: "+rule)
+				return
+			}
+		}
 		tenant.Settings = req.Settings
 	}
 
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CalibrationRequest records calibrations of ingredient parameters at a site
+type CalibrationRequest struct {
+	Calibrations []CalibrationEntry `json:"calibrations" binding:"required,min=1,dive"`
+}
+
+// CalibrationEntry overrides ingredient parameters for a whole site, one kitchen
+// (kitchen_id) or one device of a kitchen (kitchen_id and device_id). Empty
+// parameters revert that scope to the defaults.
+type CalibrationEntry struct {
+	KitchenID    string             `json:"kitchen_id"`
+	DeviceID     string             `json:"device_id"`
+	IngredientID string             `json:"ingredient_id" binding:"required"`
+	Parameters   map[string]float64 `json:"parameters"`
+	MeasuredAt   *time.Time         `json:"measured_at"`
+	Notes        string             `json:"notes"`
+}
+
+// calibrationInputs converts request entries, writing the error response when an ID is malformed
+func calibrationInputs(c *gin.Context, entries []CalibrationEntry) ([]services.CalibrationInput, bool) {
+	inputs := make([]services.CalibrationInput, len(entries))
+	for i, e := range entries {
+		ingredientID, err := primitive.ObjectIDFromHex(e.IngredientID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format: "+e.IngredientID)
+			return nil, false
+		}
+		inputs[i] = services.CalibrationInput{
+			KitchenID:    e.KitchenID,
+			DeviceID:     e.DeviceID,
+			IngredientID: ingredientID,
+			Parameters:   e.Parameters,
+			Notes:        e.Notes,
+		}
+		if e.MeasuredAt != nil {
+			inputs[i].MeasuredAt = *e.MeasuredAt
+		}
+	}
+	return inputs, true
+}
+
+// kosReportCalibrations records the calibration results KOS measured on its devices
+func (a *Application) kosReportCalibrations(c *gin.Context) {
+	kosIDStr := c.GetHeader("X-KOS-ID")
+	if kosIDStr == "" {
+		errorResponse(c, http.StatusUnauthorized, "MISSING_KOS_ID", "KOS ID required")
+		return
+	}
+
+	kosID, err := primitive.ObjectIDFromHex(kosIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid kos_id format")
+		return
+	}
+
+	var req CalibrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	inputs, ok := calibrationInputs(c, req.Calibrations)
+	if !ok {
+		return
+	}
+
+	instance, err := a.repos.KOSInstance.GetByID(c.Request.Context(), kosID)
+	if err != nil || instance == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "KOS instance not found")
+		return
+	}
+
+	site, err := a.repos.Site.GetByID(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get site")
+		return
+	}
+	if site == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Site not found")
+		return
+	}
+
+	calibrations, err := a.calibrationService.Record(c.Request.Context(), site, inputs, models.CalibrationSourceKOS, kosIDStr)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record calibrations")
+		return
+	}
+
+	successResponse(c, calibrations)
+}
+
+// listSiteCalibrations returns the calibrations in effect at a site
+func (a *Application) listSiteCalibrations(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	calibrations, err := a.calibrationService.Current(c.Request.Context(), site.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list calibrations")
+		return
+	}
+
+	successResponse(c, calibrations)
+}
+
+// setSiteCalibrations records calibrations set by hand
+func (a *Application) setSiteCalibrations(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	var req CalibrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	inputs, ok := calibrationInputs(c, req.Calibrations)
+	if !ok {
+		return
+	}
+
+	calibrations, err := a.calibrationService.Record(c.Request.Context(), site, inputs, models.CalibrationSourceManual, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record calibrations")
+		return
+	}
+
+	createdResponse(c, calibrations)
+}
+
+// getSiteCalibrationHistory returns every calibration recorded at a site and how each
+// calibrated parameter drifted. It can be narrowed by ?ingredient_id, ?kitchen_id,
+// ?device_id and ?since (RFC 3339).
+func (a *Application) getSiteCalibrationHistory(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	filter := repositories.CalibrationFilter{
+		KitchenID: c.Query("kitchen_id"),
+		DeviceID:  c.Query("device_id"),
+	}
+	if param := c.Query("ingredient_id"); param != "" {
+		id, err := primitive.ObjectIDFromHex(param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format")
+			return
+		}
+		filter.IngredientID = &id
+	}
+	if param := c.Query("since"); param != "" {
+		since, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "since must be an RFC 3339 time")
+			return
+		}
+		filter.Since = since
+	}
+
+	history, err := a.calibrationService.History(c.Request.Context(), site.ID, filter)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list calibrations")
+		return
+	}
+	if history == nil {
+		history = []*models.Calibration{}
+	}
+
+	successResponse(c, gin.H{
+		"calibrations": history,
+		"drift":        services.CalibrationDrift(history),
+	})
+}
@@ -0,0 +1,433 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportSize caps the size of an uploaded import file
+const maxImportSize = 10 << 20
+
+// ingredientCSVColumns are the columns of an ingredient CSV, in export order. Imports
+// need a header row with name and moisture_type; the other columns are optional and
+// may come in any order.
+var ingredientCSVColumns = []string{
+	"name", "moisture_type", "shelf_life_hours", "allergens", "is_active",
+	"calories_per_100g", "protein_per_100g", "fat_per_100g", "carbs_per_100g",
+	"sugar_per_100g", "fiber_per_100g", "sodium_per_100g", "parameters",
+}
+
+// catalogTenantID reads the required ?tenant_id, writing the error response when it is
+// missing or malformed
+func catalogTenantID(c *gin.Context) (primitive.ObjectID, bool) {
+	tenantIDStr := c.Query("tenant_id")
+	if tenantIDStr == "" {
+		errorResponse(c, http.StatusBadRequest, "MISSING_PARAM", "tenant_id is required")
+		return primitive.NilObjectID, false
+	}
+	tenantID, err := primitive.ObjectIDFromHex(tenantIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid tenant_id format")
+		return primitive.NilObjectID, false
+	}
+	return tenantID, true
+}
+
+// readImport returns the body of an import and its format: ?format wins, then the
+// extension of a multipart "file" upload, then the Content-Type
+func readImport(c *gin.Context) ([]byte, string, error) {
+	format := strings.ToLower(c.Query("format"))
+	var reader io.Reader = c.Request.Body
+
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", fmt.Errorf("file is required")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		defer file.Close()
+		reader = file
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(path.Ext(header.Filename)), ".")
+		}
+	}
+	if format == "" {
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+	if format == "yml" {
+		format = "yaml"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxImportSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read import: %w", err)
+	}
+	if len(body) > maxImportSize {
+		return nil, "", fmt.Errorf("import is larger than %d MB", maxImportSize>>20)
+	}
+	return body, format, nil
+}
+
+// importOptions reads ?mode and ?dry_run
+func importOptions(c *gin.Context) (models.ImportMode, bool) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	return models.ImportMode(c.Query("mode")), dryRun
+}
+
+// importIngredients creates or updates a tenant's ingredients from a CSV file or a JSON
+// array. ?mode=create|upsert|skip decides what happens to names already in use and
+// ?dry_run=true reports the outcome of each row without saving anything.
+func (a *Application) importIngredients(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+	body, format, err := readImport(c)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	var records []models.IngredientRecord
+	switch format {
+	case "csv":
+		records, err = parseIngredientCSV(body)
+	case "json":
+		err = json.Unmarshal(body, &records)
+	default:
+		err = fmt.Errorf("ingredients import from csv or json, not %s", format)
+	}
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	mode, dryRun := importOptions(c)
+	result, err := a.catalogService.ImportIngredients(c.Request.Context(), tenantID, records, mode, dryRun)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to import ingredients")
+		return
+	}
+
+	successResponse(c, result)
+}
+
+// exportIngredients downloads a tenant's ingredients as CSV or, with ?format=json, a
+// JSON array; both import back unchanged
+func (a *Application) exportIngredients(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "format must be csv or json")
+		return
+	}
+
+	records, err := a.catalogService.ExportIngredients(c.Request.Context(), tenantID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to export ingredients")
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=ingredients."+format)
+	if format == "json" {
+		c.JSON(http.StatusOK, records)
+		return
+	}
+	data, err := writeIngredientCSV(records)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to write CSV")
+		return
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+// importRecipes creates or updates a tenant's recipes from a JSON or YAML bundle, with
+// the same ?mode and ?dry_run as importIngredients. Failing rows carry the recipe
+// validation errors with their field paths.
+func (a *Application) importRecipes(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+	body, format, err := readImport(c)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	var bundle models.RecipeBundle
+	switch format {
+	case "json":
+		err = json.Unmarshal(body, &bundle)
+	case "yaml":
+		err = unmarshalYAML(body, &bundle)
+	default:
+		err = fmt.Errorf("recipes import from json or yaml, not %s", format)
+	}
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	mode, dryRun := importOptions(c)
+	result, err := a.catalogService.ImportRecipes(c.Request.Context(), tenantID, &bundle, mode, dryRun, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to import recipes")
+		return
+	}
+
+	successResponse(c, result)
+}
+
+// exportRecipes downloads a tenant's recipes as a JSON or, with ?format=yaml, a YAML
+// bundle that imports back unchanged
+func (a *Application) exportRecipes(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format == "yml" {
+		format = "yaml"
+	}
+	if format != "json" && format != "yaml" {
+		errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "format must be json or yaml")
+		return
+	}
+
+	bundle, err := a.catalogService.ExportRecipes(c.Request.Context(), tenantID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to export recipes")
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=recipes."+format)
+	if format == "json" {
+		c.JSON(http.StatusOK, bundle)
+		return
+	}
+	data, err := marshalYAML(bundle)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to write YAML")
+		return
+	}
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+}
+
+// parseIngredientCSV reads ingredient records from a CSV with a header row. Allergens
+// are separated by semicolons and parameters are a JSON object.
+func parseIngredientCSV(data []byte) ([]models.IngredientRecord, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the CSV has no header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		known := false
+		for _, column := range ingredientCSVColumns {
+			known = known || column == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"name", "moisture_type"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the CSV has no %s column", required)
+		}
+	}
+
+	records := make([]models.IngredientRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("row %d: "+format, append([]any{n + 1}, args...)...)
+		}
+		number := func(name string) (float64, error) {
+			value := field(name)
+			if value == "" {
+				return 0, nil
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, invalid("%s must be a number, not %q", name, value)
+			}
+			return v, nil
+		}
+
+		record := models.IngredientRecord{
+			Name:         field("name"),
+			MoistureType: models.MoistureType(strings.ToLower(field("moisture_type"))),
+		}
+		if value := field("shelf_life_hours"); value != "" {
+			hours, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, invalid("shelf_life_hours must be a whole number, not %q", value)
+			}
+			record.ShelfLifeHours = hours
+		}
+		for _, allergen := range strings.Split(field("allergens"), ";") {
+			if trimmed := strings.TrimSpace(allergen); trimmed != "" {
+				record.Allergens = append(record.Allergens, trimmed)
+			}
+		}
+		if value := field("is_active"); value != "" {
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, invalid("is_active must be true or false, not %q", value)
+			}
+			record.IsActive = &active
+		}
+
+		var nutrition models.NutritionInfo
+		for name, target := range map[string]*float64{
+			"calories_per_100g": &nutrition.CaloriesPer100g,
+			"protein_per_100g":  &nutrition.ProteinPer100g,
+			"fat_per_100g":      &nutrition.FatPer100g,
+			"carbs_per_100g":    &nutrition.CarbsPer100g,
+			"sugar_per_100g":    &nutrition.SugarPer100g,
+			"fiber_per_100g":    &nutrition.FiberPer100g,
+			"sodium_per_100g":   &nutrition.SodiumPer100g,
+		} {
+			if *target, err = number(name); err != nil {
+				return nil, err
+			}
+		}
+		if nutrition != (models.NutritionInfo{}) {
+			record.Nutrition = &nutrition
+		}
+
+		if value := field("parameters"); value != "" {
+			if err := json.Unmarshal([]byte(value), &record.Parameters); err != nil {
+				return nil, invalid("parameters must be a JSON object: %v", err)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// writeIngredientCSV writes ingredient records in the layout parseIngredientCSV reads
+func writeIngredientCSV(records []models.IngredientRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(ingredientCSVColumns); err != nil {
+		return nil, err
+	}
+	number := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for _, record := range records {
+		var nutrition models.NutritionInfo
+		if record.Nutrition != nil {
+			nutrition = *record.Nutrition
+		}
+		active := record.IsActive == nil || *record.IsActive
+		parameters := ""
+		if len(record.Parameters) > 0 {
+			data, err := json.Marshal(record.Parameters)
+			if err != nil {
+				return nil, err
+			}
+			parameters = string(data)
+		}
+		row := []string{
+			record.Name, string(record.MoistureType), strconv.Itoa(record.ShelfLifeHours),
+			strings.Join(record.Allergens, ";"), strconv.FormatBool(active),
+			number(nutrition.CaloriesPer100g), number(nutrition.ProteinPer100g), number(nutrition.FatPer100g),
+			number(nutrition.CarbsPer100g), number(nutrition.SugarPer100g), number(nutrition.FiberPer100g),
+			number(nutrition.SodiumPer100g), parameters,
+		}
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// unmarshalYAML decodes YAML into a value with JSON tags by way of JSON, so that YAML
+// and JSON bundles share one set of field names
+func unmarshalYAML(data []byte, v any) error {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+	asJSON, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+	return json.Unmarshal(asJSON, v)
+}
+
+// marshalYAML encodes a value with JSON tags as block-style YAML, keeping the field order
+func marshalYAML(v any) ([]byte, error) {
+	asJSON, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML; parsing it into a node keeps the key order
+	var node yaml.Node
+	if err := yaml.Unmarshal(asJSON, &node); err != nil {
+		return nil, err
+	}
+	var blockStyle func(n *yaml.Node)
+	blockStyle = func(n *yaml.Node) {
+		n.Style = 0 // The encoder still quotes strings that would read as another type
+		for _, child := range n.Content {
+			blockStyle(child)
+		}
+	}
+	blockStyle(&node)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultVarianceWindow is how far back a variance report looks without ?since
+const defaultVarianceWindow = 7 * 24 * time.Hour
+
+// getOrderConsumption returns what each dispensing step of an order was expected to
+// use and what KOS measured it dispensed
+func (a *Application) getOrderConsumption(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := a.repos.Order.GetByID(ctx, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get order")
+		return
+	}
+	if order == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Order not found")
+		return
+	}
+
+	records, err := a.reconciliationService.ForOrder(ctx, order.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get consumption")
+		return
+	}
+	if records == nil {
+		records = []*models.ConsumptionRecord{}
+	}
+
+	successResponse(c, records)
+}
+
+// getSiteConsumptionVariance reports how far a site's dispensers are off the recipes,
+// by ingredient and by device, since ?since (RFC 3339, default the last 7 days)
+func (a *Application) getSiteConsumptionVariance(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-defaultVarianceWindow)
+	if param := c.Query("since"); param != "" {
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "since must be an RFC 3339 time")
+			return
+		}
+		since = t
+	}
+
+	report, err := a.reconciliationService.VarianceReport(c.Request.Context(), site.ID, since)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to build variance report")
+		return
+	}
+
+	successResponse(c, report)
+}
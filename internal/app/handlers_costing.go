@@ -0,0 +1,280 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultCostHistoryWindow is how far back a cost history looks without ?since
+const defaultCostHistoryWindow = 30 * 24 * time.Hour
+
+// IngredientCostRequest records what an ingredient costs, per unit, for the whole
+// tenant or, with region_id, for the sites of one region
+type IngredientCostRequest struct {
+	RegionID      string     `json:"region_id"`
+	Cost          *float64   `json:"cost" binding:"required"`
+	Unit          string     `json:"unit" binding:"required"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+	Notes         string     `json:"notes"`
+}
+
+// MenuPriceRequest records what a site charges for recipes
+type MenuPriceRequest struct {
+	Prices []MenuPriceEntry `json:"prices" binding:"required,min=1,dive"`
+}
+
+// MenuPriceEntry is the price of a recipe at a pot size (default 100)
+type MenuPriceEntry struct {
+	RecipeID      string     `json:"recipe_id" binding:"required"`
+	PotPercentage int        `json:"pot_percentage"`
+	Price         *float64   `json:"price" binding:"required"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
+
+// requireIngredient loads the ingredient named by the :id parameter, writing the error
+// response when it is malformed or missing
+func (a *Application) requireIngredient(c *gin.Context) (*models.Ingredient, bool) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	ingredient, err := a.repos.Ingredient.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get ingredient")
+		return nil, false
+	}
+	if ingredient == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Ingredient not found")
+		return nil, false
+	}
+
+	return ingredient, true
+}
+
+// listIngredientCosts returns an ingredient's cost history, oldest first
+func (a *Application) listIngredientCosts(c *gin.Context) {
+	ingredient, ok := a.requireIngredient(c)
+	if !ok {
+		return
+	}
+
+	costs, err := a.costingService.Costs(c.Request.Context(), ingredient.TenantID, &ingredient.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list ingredient costs")
+		return
+	}
+
+	successResponse(c, costs)
+}
+
+// recordIngredientCost records a new cost of an ingredient
+func (a *Application) recordIngredientCost(c *gin.Context) {
+	ingredient, ok := a.requireIngredient(c)
+	if !ok {
+		return
+	}
+
+	var req IngredientCostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	input := services.CostInput{
+		IngredientID: ingredient.ID,
+		Cost:         *req.Cost,
+		Unit:         req.Unit,
+		Notes:        req.Notes,
+	}
+	if req.RegionID != "" {
+		regionID, err := primitive.ObjectIDFromHex(req.RegionID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid region_id format")
+			return
+		}
+		input.RegionID = &regionID
+	}
+	if req.EffectiveFrom != nil {
+		input.EffectiveFrom = *req.EffectiveFrom
+	}
+
+	costs, err := a.costingService.RecordCosts(c.Request.Context(), ingredient.TenantID, []services.CostInput{input}, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record ingredient cost")
+		return
+	}
+
+	createdResponse(c, costs[0])
+}
+
+// getRecipeCost returns what a recipe costs at every pot size. ?site_id applies the
+// site region's cost overrides and adds the site's margins; ?at (RFC 3339) costs the
+// recipe as of an earlier time.
+func (a *Application) getRecipeCost(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	recipe, err := a.repos.Recipe.GetByID(ctx, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+		return
+	}
+
+	var site *models.Site
+	if param := c.Query("site_id"); param != "" {
+		siteID, err := primitive.ObjectIDFromHex(param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid site_id format")
+			return
+		}
+		site, err = a.repos.Site.GetByID(ctx, siteID)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get site")
+			return
+		}
+		if site == nil || site.TenantID != recipe.TenantID {
+			errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Site not found")
+			return
+		}
+	}
+
+	at := time.Now()
+	if param := c.Query("at"); param != "" {
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "at must be an RFC 3339 time")
+			return
+		}
+		at = t
+	}
+
+	cost, err := a.costingService.RecipeCost(ctx, recipe, site, at)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to compute recipe cost")
+		return
+	}
+
+	successResponse(c, cost)
+}
+
+// listSiteMenuPrices returns a site's menu price history, oldest first
+func (a *Application) listSiteMenuPrices(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	prices, err := a.costingService.Prices(c.Request.Context(), site.ID, nil)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list menu prices")
+		return
+	}
+
+	successResponse(c, prices)
+}
+
+// setSiteMenuPrices records new menu prices for a site
+func (a *Application) setSiteMenuPrices(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	var req MenuPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	inputs := make([]services.PriceInput, len(req.Prices))
+	for i, p := range req.Prices {
+		recipeID, err := primitive.ObjectIDFromHex(p.RecipeID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid recipe_id format: "+p.RecipeID)
+			return
+		}
+		inputs[i] = services.PriceInput{RecipeID: recipeID, PotPercentage: p.PotPercentage, Price: *p.Price}
+		if p.EffectiveFrom != nil {
+			inputs[i].EffectiveFrom = *p.EffectiveFrom
+		}
+	}
+
+	prices, err := a.costingService.SetPrices(c.Request.Context(), site, inputs, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record menu prices")
+		return
+	}
+
+	createdResponse(c, prices)
+}
+
+// getSiteRecipeMargins reports the cost, menu price, margin and food-cost percentage
+// of every recipe the site serves, at each pot size
+func (a *Application) getSiteRecipeMargins(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	recipes, err := a.rolloutService.RecipesForSite(c.Request.Context(), site.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get site recipes")
+		return
+	}
+
+	margins, err := a.costingService.Margins(c.Request.Context(), site, recipes)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to compute recipe margins")
+		return
+	}
+
+	successResponse(c, margins)
+}
+
+// getSiteCostHistory reports what the orders a site completed cost and earned, per day
+// and per recipe, from ?since to ?until (RFC 3339, default the last 30 days)
+func (a *Application) getSiteCostHistory(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	until := time.Now()
+	if param := c.Query("until"); param != "" {
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "until must be an RFC 3339 time")
+			return
+		}
+		until = t
+	}
+	since := until.Add(-defaultCostHistoryWindow)
+	if param := c.Query("since"); param != "" {
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "since must be an RFC 3339 time")
+			return
+		}
+		since = t
+	}
+
+	history, err := a.costingService.History(c.Request.Context(), site, since, until)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to build cost history")
+		return
+	}
+
+	successResponse(c, history)
+}
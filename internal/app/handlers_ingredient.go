@@ -1,12 +1,16 @@
 package app
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
 // ==================== Ingredient handlers ====================
@@ -195,21 +199,45 @@ func (a *Application) updateIngredient(c *gin.Context) {
 		}
 	}
 
+	oldName := ingredient.Name
 	ingredient.Name = req.Name
 	ingredient.MoistureType = models.MoistureType(req.MoistureType)
 	ingredient.ShelfLifeMinutes = req.ShelfLifeHours * 60
+	oldNutrition, oldDensity := ingredient.Nutrition, ingredient.Parameters[models.IngredientParamDensity]
+	oldPieceWeight := ingredient.Parameters[models.IngredientParamPieceWeight]
+	derivedChanged := !slices.Equal(ingredient.AllergenInfo, allergens)
 	ingredient.AllergenInfo = allergens
 	ingredient.IsActive = isActive
 	ingredient.Nutrition = nutrition
 	if req.Parameters != nil {
 		ingredient.Parameters = req.Parameters
 	}
+	derivedChanged = derivedChanged ||
+		(oldNutrition == nil) != (nutrition == nil) || (nutrition != nil && *oldNutrition != *nutrition) ||
+		fmt.Sprint(oldDensity) != fmt.Sprint(ingredient.Parameters[models.IngredientParamDensity]) ||
+		fmt.Sprint(oldPieceWeight) != fmt.Sprint(ingredient.Parameters[models.IngredientParamPieceWeight])
 
 	if err := a.repos.Ingredient.Update(c.Request.Context(), ingredient); err != nil {
 		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update ingredient")
 		return
 	}
 
+	// Recipes derive their allergens and nutrition from this ingredient; the ingredient is saved either way
+	if derivedChanged {
+		if err := a.recipeService.RefreshForIngredient(c.Request.Context(), ingredient.ID); err != nil {
+			a.logger.Warn("Failed to refresh recipes using ingredient", zap.String("ingredient_id", ingredient.ID.Hex()), zap.Error(err))
+		}
+	}
+
+	// Recipes not yet served to sites take the new name; the response lists published ones still carrying the old one
+	if ingredient.Name != oldName {
+		rename, err := a.recipeService.PropagateIngredientRename(c.Request.Context(), ingredient, oldName)
+		if err != nil {
+			a.logger.Warn("Failed to propagate ingredient rename", zap.String("ingredient_id", ingredient.ID.Hex()), zap.Error(err))
+		}
+		ingredient.Rename = rename
+	}
+
 	// Check if this is an HTMX request - redirect to list page
 	if c.GetHeader("HX-Request") == "true" {
 		c.Header("HX-Redirect", "/ingredients")
@@ -299,3 +327,79 @@ func (a *Application) toggleIngredientActive(c *gin.Context) {
 		"is_active": ingredient.IsActive,
 	})
 }
+
+// getIngredientUsage lists every recipe and version that uses an ingredient, and
+// which sites each is published to
+func (a *Application) getIngredientUsage(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	ingredient, err := a.repos.Ingredient.GetByID(ctx, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get ingredient")
+		return
+	}
+	if ingredient == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Ingredient not found")
+		return
+	}
+
+	usage, err := a.recipeService.IngredientUsage(ctx, ingredient)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list ingredient usage")
+		return
+	}
+
+	successResponse(c, usage)
+}
+
+// ReplaceIngredientRequest selects the recipes to swap an ingredient out of
+type ReplaceIngredientRequest struct {
+	ReplacementID string   `json:"replacement_id" binding:"required"`
+	RecipeIDs     []string `json:"recipe_ids" binding:"required,min=1"`
+	Comment       string   `json:"comment"`
+}
+
+// replaceIngredient swaps the ingredient for another in the selected recipes. Each
+// becomes a new draft version that must be reviewed and published again.
+func (a *Application) replaceIngredient(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req ReplaceIngredientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	replacementID, err := primitive.ObjectIDFromHex(req.ReplacementID)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid replacement_id format")
+		return
+	}
+	recipeIDs := make([]primitive.ObjectID, len(req.RecipeIDs))
+	for i, hex := range req.RecipeIDs {
+		if recipeIDs[i], err = primitive.ObjectIDFromHex(hex); err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid recipe_id format: "+hex)
+			return
+		}
+	}
+
+	result, err := a.recipeService.ReplaceIngredient(c.Request.Context(), services.ReplaceIngredientRequest{
+		FromID:    id,
+		ToID:      replacementID,
+		RecipeIDs: recipeIDs,
+		UserID:    currentUserID(c),
+		Comment:   req.Comment,
+	})
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to replace ingredient")
+		return
+	}
+
+	successResponse(c, result)
+}
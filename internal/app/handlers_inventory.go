@@ -0,0 +1,286 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KOSInventoryRequest replaces the on-hand levels of the listed ingredients; unlisted ones are left as they are
+type KOSInventoryRequest struct {
+	Levels []KOSInventoryLevel `json:"levels" binding:"required,min=1,dive"`
+}
+
+type KOSInventoryLevel struct {
+	KitchenID    string  `json:"kitchen_id" binding:"required"`
+	IngredientID string  `json:"ingredient_id" binding:"required"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit" binding:"required"` // grams, kg, ml or l
+}
+
+func (a *Application) kosReportInventory(c *gin.Context) {
+	kosIDStr := c.GetHeader("X-KOS-ID")
+	if kosIDStr == "" {
+		errorResponse(c, http.StatusUnauthorized, "MISSING_KOS_ID", "KOS ID required")
+		return
+	}
+
+	kosID, err := primitive.ObjectIDFromHex(kosIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid kos_id format")
+		return
+	}
+
+	var req KOSInventoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	reports := make([]services.InventoryReport, len(req.Levels))
+	for i, l := range req.Levels {
+		ingredientID, err := primitive.ObjectIDFromHex(l.IngredientID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format: "+l.IngredientID)
+			return
+		}
+		reports[i] = services.InventoryReport{
+			KitchenID:    l.KitchenID,
+			IngredientID: ingredientID,
+			Quantity:     l.Quantity,
+			Unit:         l.Unit,
+		}
+	}
+
+	instance, err := a.repos.KOSInstance.GetByID(c.Request.Context(), kosID)
+	if err != nil || instance == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "KOS instance not found")
+		return
+	}
+
+	site, err := a.repos.Site.GetByID(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get site")
+		return
+	}
+	if site == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Site not found")
+		return
+	}
+
+	levels, err := a.inventoryService.Report(c.Request.Context(), site, reports)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record inventory")
+		return
+	}
+
+	successResponse(c, levels)
+}
+
+func (a *Application) getSiteInventory(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	levels, err := a.inventoryService.ListForSite(c.Request.Context(), site.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list inventory")
+		return
+	}
+	if levels == nil {
+		levels = []*models.InventoryLevel{}
+	}
+
+	successResponse(c, levels)
+}
+
+func (a *Application) getSiteInventoryForecast(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	forecast, err := a.inventoryService.Forecast(c.Request.Context(), site.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to forecast inventory")
+		return
+	}
+
+	successResponse(c, forecast)
+}
+
+// getSiteMenuAvailability lists the recipes served to a site and whether its stock can still cover them
+func (a *Application) getSiteMenuAvailability(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	pct := 100
+	if param := c.Query("pot_percentage"); param != "" {
+		var err error
+		pct, err = strconv.Atoi(param)
+		if err != nil || pct < 1 || pct > 100 {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "pot_percentage must be between 1 and 100")
+			return
+		}
+	}
+
+	recipes, err := a.rolloutService.RecipesForSite(c.Request.Context(), site.ID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get site recipes")
+		return
+	}
+
+	availability, err := a.inventoryService.Availability(c.Request.Context(), site.ID, recipes, pct)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to check menu availability")
+		return
+	}
+	if availability == nil {
+		availability = []models.RecipeAvailability{}
+	}
+
+	successResponse(c, availability)
+}
+
+// KOSCanisterRequest reports canister loads and unloads in the order they happened
+type KOSCanisterRequest struct {
+	Events []KOSCanisterEvent `json:"events" binding:"required,min=1,dive"`
+}
+
+type KOSCanisterEvent struct {
+	Event        string     `json:"event"` // load (default) or unload
+	KitchenID    string     `json:"kitchen_id" binding:"required"`
+	CanisterID   string     `json:"canister_id" binding:"required"`
+	IngredientID string     `json:"ingredient_id"` // Required for loads
+	Lot          string     `json:"lot"`
+	LoadedAt     *time.Time `json:"loaded_at"`
+	UnloadedAt   *time.Time `json:"unloaded_at"`
+}
+
+func (a *Application) kosReportCanisters(c *gin.Context) {
+	kosIDStr := c.GetHeader("X-KOS-ID")
+	if kosIDStr == "" {
+		errorResponse(c, http.StatusUnauthorized, "MISSING_KOS_ID", "KOS ID required")
+		return
+	}
+
+	kosID, err := primitive.ObjectIDFromHex(kosIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid kos_id format")
+		return
+	}
+
+	var req KOSCanisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	events := make([]services.CanisterEvent, len(req.Events))
+	for i, e := range req.Events {
+		event := services.CanisterEvent{
+			Type:       services.CanisterEventType(e.Event),
+			KitchenID:  e.KitchenID,
+			CanisterID: e.CanisterID,
+			Lot:        e.Lot,
+		}
+		if event.Type == "" {
+			event.Type = services.CanisterEventLoad
+		}
+		if event.Type == services.CanisterEventLoad {
+			event.IngredientID, err = primitive.ObjectIDFromHex(e.IngredientID)
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format: "+e.IngredientID)
+				return
+			}
+			if e.LoadedAt != nil {
+				event.At = *e.LoadedAt
+			}
+		} else if e.UnloadedAt != nil {
+			event.At = *e.UnloadedAt
+		}
+		events[i] = event
+	}
+
+	instance, err := a.repos.KOSInstance.GetByID(c.Request.Context(), kosID)
+	if err != nil || instance == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "KOS instance not found")
+		return
+	}
+
+	site, err := a.repos.Site.GetByID(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get site")
+		return
+	}
+	if site == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Site not found")
+		return
+	}
+
+	loads, err := a.freshnessService.RecordEvents(c.Request.Context(), site, events)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record canister events")
+		return
+	}
+	if loads == nil {
+		loads = []*models.CanisterLoad{}
+	}
+
+	successResponse(c, loads)
+}
+
+// getSiteFreshness is the food-safety audit of a site's canisters: the lots loaded now
+// and those unloaded since ?since (RFC 3339, default 24 hours ago)
+func (a *Application) getSiteFreshness(c *gin.Context) {
+	site, ok := a.requireSite(c)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if param := c.Query("since"); param != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "since must be an RFC 3339 time")
+			return
+		}
+	}
+
+	report, err := a.freshnessService.Report(c.Request.Context(), site.ID, since)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to build freshness report")
+		return
+	}
+
+	successResponse(c, report)
+}
+
+// requireSite loads the site named by the :id path parameter, writing the error response when it cannot
+func (a *Application) requireSite(c *gin.Context) (*models.Site, bool) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	site, err := a.repos.Site.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get site")
+		return nil, false
+	}
+	if site == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Site not found")
+		return nil, false
+	}
+
+	return site, true
+}
@@ -1,21 +1,30 @@
 package app
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
 	"github.com/gin-gonic/gin"
 	qrcode "github.com/skip2/go-qrcode"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
 // ==================== KOS Instance Management handlers ====================
@@ -119,6 +128,22 @@ func (a *Application) getKOSInstance(c *gin.Context) {
 	successResponse(c, instance)
 }
 
+// listKOSRecipeSync returns the recipe versions delivered to and applied by a KOS
+func (a *Application) listKOSRecipeSync(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	records, err := a.syncService.ListForKOS(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list recipe sync records")
+		return
+	}
+
+	successResponse(c, records)
+}
+
 func (a *Application) updateKOSInstance(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
@@ -480,17 +505,26 @@ type L2TaskReport struct {
 
 // TaskReport represents an L4 task reported from KOS
 type TaskReport struct {
-	TaskID          string         `json:"task_id"`
-	StepNumber      int            `json:"step_number"`
-	Action          string         `json:"action"`
-	Status          string         `json:"status"`
-	Parameters      string         `json:"parameters"`
-	DependsOnTasks  []string       `json:"depends_on_tasks"`
-	ActualStartTime *time.Time     `json:"actual_start_time"`
-	ActualEndTime   *time.Time     `json:"actual_end_time"`
-	ErrorMessage    string         `json:"error_message"`
-	ErrorCode       string         `json:"error_code"`
-	L2Tasks         []L2TaskReport `json:"l2_tasks"`
+	TaskID          string          `json:"task_id"`
+	StepNumber      int             `json:"step_number"`
+	Action          string          `json:"action"`
+	Status          string          `json:"status"`
+	Parameters      string          `json:"parameters"`
+	DependsOnTasks  []string        `json:"depends_on_tasks"`
+	ActualStartTime *time.Time      `json:"actual_start_time"`
+	ActualEndTime   *time.Time      `json:"actual_end_time"`
+	ErrorMessage    string          `json:"error_message"`
+	ErrorCode       string          `json:"error_code"`
+	L2Tasks         []L2TaskReport  `json:"l2_tasks"`
+	Dispensed       *DispenseReport `json:"dispensed"` // Dispensing tasks: what was actually dispensed
+}
+
+// DispenseReport is the measured output of a dispensing task
+type DispenseReport struct {
+	IngredientID string  `json:"ingredient_id"` // The step's ingredient if empty
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"` // grams, kg, ml or l
+	DeviceID     string  `json:"device_id"`
 }
 
 // EquipmentReport represents equipment info from KOS
@@ -500,6 +534,18 @@ type EquipmentReport struct {
 	PyroID      string   `json:"pyro_id"`
 }
 
+// KOSRecipeAckRequest reports which delivered recipe versions KOS applied
+type KOSRecipeAckRequest struct {
+	Recipes []KOSRecipeAck `json:"recipes" binding:"required,min=1,dive"`
+}
+
+type KOSRecipeAck struct {
+	RecipeID string `json:"recipe_id" binding:"required"`
+	Version  int    `json:"version" binding:"required,min=1"`
+	Status   string `json:"status" binding:"omitempty,oneof=applied failed"` // applied (default) or failed
+	Error    string `json:"error"`
+}
+
 type KOSOrderStatusRequest struct {
 	Status      string           `json:"status" binding:"required"`
 	KOSOrderID  string           `json:"kos_order_id"`
@@ -610,7 +656,13 @@ func (a *Application) kosHeartbeat(c *gin.Context) {
 		a.logger.Info("Reset orphaned orders to pending")
 	}
 
-	successResponse(c, gin.H{"acknowledged": true, "orders_reset": resetCount})
+	// Surface expiring and expired canisters to the kitchen staff at every heartbeat
+	alerts, err := a.freshnessService.Alerts(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		a.logger.WithKOS(req.KOSID).Warn("Failed to check canister freshness", zap.Error(err))
+	}
+
+	successResponse(c, gin.H{"acknowledged": true, "orders_reset": resetCount, "freshness_alerts": alerts})
 }
 
 func (a *Application) kosGetRecipes(c *gin.Context) {
@@ -634,20 +686,105 @@ func (a *Application) kosGetRecipes(c *gin.Context) {
 		return
 	}
 
-	// Get recipes published to this site
-	recipes, err := a.repos.Recipe.GetPublishedForSite(c.Request.Context(), instance.SiteID)
+	since, incremental, ok := syncCursor(c)
+	if !ok {
+		return
+	}
+	cursor := nextSyncCursor()
+
+	// Get recipes published to this site, at the version this site is served
+	recipes, err := a.rolloutService.RecipesForSite(c.Request.Context(), instance.SiteID)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipes")
 		return
 	}
 
+	var deleted []models.KOSTombstone
+	if incremental {
+		recipes, deleted, err = a.syncService.ChangesSince(c.Request.Context(), instance, recipes, since)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe changes")
+			return
+		}
+	}
+
 	// Convert to KOS format
 	kosRecipes := make([]models.RecipeForKOS, len(recipes))
 	for i, r := range recipes {
 		kosRecipes[i] = r.ToKOSFormat()
+		// In the units the dispensers work in
+		if err := services.NormalizeKOSUnits(c.Request.Context(), a.repos.Ingredient, &kosRecipes[i]); err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe ingredients")
+			return
+		}
+	}
+
+	var sent bool
+	if incremental {
+		sent = kosCatalogResponse(c, models.RecipeDeltaForKOS{
+			Recipes: kosRecipes,
+			Deleted: deleted,
+			Cursor:  formatSyncCursor(cursor),
+		}, []any{kosRecipes, deleted}, cursor)
+	} else {
+		sent = kosCatalogResponse(c, kosRecipes, kosRecipes, cursor)
+	}
+
+	// Record what was sent; KOS confirms what it applied through /kos/recipes/ack
+	if sent {
+		if err := a.syncService.RecordDeliveries(c.Request.Context(), instance, recipes); err != nil {
+			a.logger.WithKOS(kosIDStr).Warn("Failed to record recipe deliveries", zap.Error(err))
+		}
+	}
+}
+
+func (a *Application) kosAckRecipes(c *gin.Context) {
+	kosIDStr := c.GetHeader("X-KOS-ID")
+	if kosIDStr == "" {
+		errorResponse(c, http.StatusUnauthorized, "MISSING_KOS_ID", "KOS ID required")
+		return
 	}
 
-	successResponse(c, kosRecipes)
+	kosID, err := primitive.ObjectIDFromHex(kosIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid kos_id format")
+		return
+	}
+
+	instance, err := a.repos.KOSInstance.GetByID(c.Request.Context(), kosID)
+	if err != nil || instance == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "KOS instance not found")
+		return
+	}
+
+	var req KOSRecipeAckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	acks := make([]services.RecipeSyncAck, len(req.Recipes))
+	for i, r := range req.Recipes {
+		recipeID, err := primitive.ObjectIDFromHex(r.RecipeID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid recipe_id format")
+			return
+		}
+		acks[i] = services.RecipeSyncAck{
+			RecipeID:     recipeID,
+			Version:      r.Version,
+			Failed:       r.Status == "failed",
+			ErrorMessage: r.Error,
+		}
+	}
+
+	records, err := a.syncService.Acknowledge(c.Request.Context(), instance, acks)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to record recipe sync")
+		return
+	}
+
+	successResponse(c, records)
 }
 
 func (a *Application) kosGetIngredients(c *gin.Context) {
@@ -671,6 +808,70 @@ func (a *Application) kosGetIngredients(c *gin.Context) {
 		return
 	}
 
+	since, incremental, ok := syncCursor(c)
+	if !ok {
+		return
+	}
+	cursor := nextSyncCursor()
+
+	if incremental {
+		// Deactivated ingredients are reported as tombstones rather than left out
+		ingredients, err := a.repos.Ingredient.ListUpdatedSince(c.Request.Context(), instance.TenantID, since)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get ingredient changes")
+			return
+		}
+
+		delta := models.IngredientDeltaForKOS{
+			Ingredients: make([]models.IngredientForKOS, 0, len(ingredients)),
+			Deleted:     make([]models.KOSTombstone, 0),
+			Cursor:      formatSyncCursor(cursor),
+		}
+		// A site calibration changes what this KOS receives for an unchanged ingredient
+		calibrated, err := a.calibrationService.IngredientsChangedSince(c.Request.Context(), instance.SiteID, since)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get calibration changes")
+			return
+		}
+		seen := make(map[primitive.ObjectID]bool, len(ingredients))
+		for _, ing := range ingredients {
+			seen[ing.ID] = true
+		}
+		var missing []primitive.ObjectID
+		for _, id := range calibrated {
+			if !seen[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			extra, err := a.repos.Ingredient.GetByIDs(c.Request.Context(), missing)
+			if err != nil {
+				errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get ingredient changes")
+				return
+			}
+			for _, ing := range extra {
+				if ing.TenantID == instance.TenantID {
+					ingredients = append(ingredients, ing)
+				}
+			}
+		}
+
+		for _, ing := range ingredients {
+			if !ing.IsActive {
+				delta.Deleted = append(delta.Deleted, models.KOSTombstone{ID: ing.ID.Hex(), Reason: models.KOSTombstoneDeactivated})
+				continue
+			}
+			delta.Ingredients = append(delta.Ingredients, ing.ToKOSFormat())
+		}
+		if err := a.calibrationService.ApplyToIngredients(c.Request.Context(), instance.SiteID, delta.Ingredients); err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get calibrations")
+			return
+		}
+
+		kosCatalogResponse(c, delta, []any{delta.Ingredients, delta.Deleted}, cursor)
+		return
+	}
+
 	// Get all active ingredients for this tenant
 	ingredients, _, err := a.repos.Ingredient.ListByTenant(c.Request.Context(), instance.TenantID, true, 1, 10000)
 	if err != nil {
@@ -684,7 +885,101 @@ func (a *Application) kosGetIngredients(c *gin.Context) {
 		kosIngredients[i] = ing.ToKOSFormat()
 	}
 
-	successResponse(c, kosIngredients)
+	// Tenant defaults, overridden by the calibrations of this site's devices
+	if err := a.calibrationService.ApplyToIngredients(c.Request.Context(), instance.SiteID, kosIngredients); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get calibrations")
+		return
+	}
+
+	kosCatalogResponse(c, kosIngredients, kosIngredients, cursor)
+}
+
+// kosSyncCursorOverlap is how far each sync cursor reaches back. Re-sending a few
+// unchanged entries is harmless; missing a change is not.
+const kosSyncCursorOverlap = 5 * time.Second
+
+// syncCursor reads the optional since query parameter of the KOS catalog endpoints.
+// It reports false after writing a 400 response if the cursor is malformed.
+func syncCursor(c *gin.Context) (since time.Time, incremental bool, ok bool) {
+	value := c.Query("since")
+	if value == "" {
+		return time.Time{}, false, true
+	}
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "since must be an RFC 3339 timestamp")
+		return time.Time{}, false, false
+	}
+	return since, true, true
+}
+
+// nextSyncCursor is taken before reading the catalog and set back by kosSyncCursorOverlap,
+// so a change committed while the request runs is sent again on the next sync rather than missed
+func nextSyncCursor() time.Time {
+	return time.Now().Add(-kosSyncCursorOverlap).Truncate(time.Millisecond)
+}
+
+func formatSyncCursor(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// kosCatalogResponse writes a KOS catalog with an ETag computed from content, answering
+// 304 when the KOS already holds it, and gzips the body when the KOS accepts it.
+// The cursor to pass as since on the next sync is returned in X-Sync-Cursor.
+// It reports whether the catalog body was sent.
+func kosCatalogResponse(c *gin.Context, data interface{}, content interface{}, cursor time.Time) bool {
+	hashed, err := json.Marshal(content)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "ENCODING_ERROR", "Failed to encode catalog")
+		return false
+	}
+	sum := sha256.Sum256(hashed)
+	etag := fmt.Sprintf(`W/"%s"`, hex.EncodeToString(sum[:16]))
+
+	c.Header("ETag", etag)
+	c.Header("X-Sync-Cursor", formatSyncCursor(cursor))
+	c.Header("Vary", "Accept-Encoding")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return false
+	}
+
+	body, err := json.Marshal(APIResponse{
+		Success:   true,
+		Data:      data,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "ENCODING_ERROR", "Failed to encode catalog")
+		return false
+	}
+
+	if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err == nil && zw.Close() == nil {
+			c.Header("Content-Encoding", "gzip")
+			body = buf.Bytes()
+		}
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	return true
+}
+
+// etagMatches implements the weak comparison If-None-Match uses
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *Application) kosGetOrders(c *gin.Context) {
@@ -715,15 +1010,101 @@ func (a *Application) kosGetOrders(c *gin.Context) {
 		return
 	}
 
-	// Convert to KOS format
-	kosOrders := make([]models.OrderForKOS, len(orders))
-	for i, o := range orders {
-		kosOrders[i] = o.ToKOSFormat()
+	// Expired canisters keep orders away from kitchens that have no fresh lot of a critical ingredient
+	loads, err := a.freshnessService.ActiveLoads(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get canister loads")
+		return
+	}
+	kitchenIDs := make(map[primitive.ObjectID]string)
+	if len(loads) > 0 {
+		kitchens, err := a.repos.Kitchen.ListBySite(c.Request.Context(), instance.SiteID)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get kitchens")
+			return
+		}
+		for _, k := range kitchens {
+			kitchenIDs[k.ID] = k.KitchenID
+		}
+	}
+	now := time.Now()
+
+	// Convert to KOS format, each with its recipe scaled to the order's pot size, its
+	// modifications applied, and the nutrition of the dish as ordered
+	recipes := make(map[string]*models.Recipe)
+	kosOrders := make([]models.OrderForKOS, 0, len(orders))
+	for _, o := range orders {
+		kosOrder := o.ToKOSFormat()
+
+		recipe, err := a.recipeAtVersion(c.Request.Context(), o.RecipeID, o.RecipeVersion, recipes)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get order recipe")
+			return
+		}
+		if recipe != nil {
+			scaled := services.ApplyModifications(services.ScaleRecipe(recipe, o.PotPercentage), o.Modifications).ToKOSFormat()
+			scaled.PotPercentage = o.PotPercentage
+			if err := services.NormalizeKOSUnits(c.Request.Context(), a.repos.Ingredient, &scaled); err != nil {
+				errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe ingredients")
+				return
+			}
+			nutrition, err := a.recipeService.Nutrition(c.Request.Context(), recipe, o.PotPercentage, o.Modifications)
+			if err != nil {
+				a.logger.Warn("Failed to compute order nutrition", zap.String("order_id", o.ID.Hex()), zap.Error(err))
+			}
+			scaled.SetNutrition(nutrition)
+			kosOrder.Recipe = &scaled
+
+			kosOrder.BlockedKitchens = services.BlockedKitchens(loads, recipe, o.Modifications, now)
+			if o.KitchenID != nil && slices.Contains(kosOrder.BlockedKitchens, kitchenIDs[*o.KitchenID]) {
+				a.logger.WithOrder(o.ID.Hex()).Warn("Holding order: its kitchen has no fresh lot of a critical ingredient",
+					zap.String("kitchen_id", kitchenIDs[*o.KitchenID]))
+				continue
+			}
+		}
+		kosOrders = append(kosOrders, kosOrder)
 	}
 
 	successResponse(c, kosOrders)
 }
 
+// recipeAtVersion returns a recipe as it was at a version, such as the one an order was
+// placed against. Without a snapshot it falls back to the current recipe for orders that
+// predate version tracking or when it is at that version, and returns nil otherwise.
+// cache is keyed by recipe and version and may be nil.
+func (a *Application) recipeAtVersion(ctx context.Context, recipeID primitive.ObjectID, version int, cache map[string]*models.Recipe) (*models.Recipe, error) {
+	key := fmt.Sprintf("%s@%d", recipeID.Hex(), version)
+	if recipe, ok := cache[key]; ok {
+		return recipe, nil
+	}
+
+	var recipe *models.Recipe
+	if version > 0 {
+		snapshot, err := a.repos.Recipe.GetVersion(ctx, recipeID, version)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot != nil {
+			recipe = &snapshot.Recipe
+		}
+	}
+	if recipe == nil {
+		current, err := a.repos.Recipe.GetByID(ctx, recipeID)
+		if err != nil {
+			return nil, err
+		}
+		// Never stand in the working copy for another version: it was not approved as served
+		if current != nil && (version == 0 || current.Version == version) {
+			recipe = current
+		}
+	}
+
+	if cache != nil {
+		cache[key] = recipe
+	}
+	return recipe, nil
+}
+
 func (a *Application) kosUpdateOrderStatus(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
@@ -793,6 +1174,14 @@ func (a *Application) kosUpdateOrderStatus(c *gin.Context) {
 				ErrorCode:       t.ErrorCode,
 				L2Tasks:         l2Tasks,
 			}
+			if t.Dispensed != nil {
+				order.Tasks[i].Dispensed = &models.TaskDispense{
+					IngredientID: t.Dispensed.IngredientID,
+					Quantity:     t.Dispensed.Quantity,
+					Unit:         t.Dispensed.Unit,
+					DeviceID:     t.Dispensed.DeviceID,
+				}
+			}
 		}
 	}
 
@@ -813,5 +1202,21 @@ func (a *Application) kosUpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	// Finished orders feed the canary metrics of a rollout; this must not fail the status update
+	if err := a.rolloutService.EvaluateForOrder(c.Request.Context(), order); err != nil {
+		a.logger.WithOrder(order.ID.Hex()).Warn("Failed to evaluate recipe rollout", zap.Error(err))
+	}
+
+	// Completed orders draw down the kitchen's stock by what KOS measured it dispensed;
+	// a failed deduction is caught up by the next KOS report
+	if order.Status == models.OrderStatusCompleted {
+		if _, err := a.reconciliationService.ReconcileOrder(c.Request.Context(), order); err != nil {
+			a.logger.WithOrder(order.ID.Hex()).Warn("Failed to reconcile dispensed quantities", zap.Error(err))
+		}
+		if err := a.inventoryService.RecordConsumption(c.Request.Context(), order); err != nil {
+			a.logger.WithOrder(order.ID.Hex()).Warn("Failed to record inventory consumption", zap.Error(err))
+		}
+	}
+
 	successResponse(c, gin.H{"updated": true})
 }
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KitchenLayoutRequest is the complete layout of a kitchen. base_version is the
+// version it was edited from (0 for a kitchen's first layout).
+type KitchenLayoutRequest struct {
+	BaseVersion int                `json:"base_version"`
+	Slots       []StorageSlotInput `json:"slots" binding:"dive"`
+	HydraLines  []HydraLineInput   `json:"hydra_lines" binding:"dive"`
+	PyroCount   int                `json:"pyro_count" binding:"min=0"`
+	ChangeNote  string             `json:"change_note"`
+}
+
+type StorageSlotInput struct {
+	SlotID       string  `json:"slot_id" binding:"required"`
+	CapacityG    float64 `json:"capacity_g" binding:"min=0"`
+	IngredientID string  `json:"ingredient_id"` // Empty for an empty slot
+}
+
+type HydraLineInput struct {
+	LineID       string  `json:"line_id" binding:"required"`
+	CapacityML   float64 `json:"capacity_ml" binding:"min=0"`
+	IngredientID string  `json:"ingredient_id"` // Empty for an unused line
+}
+
+func (a *Application) updateKitchenLayout(c *gin.Context) {
+	kitchen, ok := a.requireKitchen(c)
+	if !ok {
+		return
+	}
+
+	var req KitchenLayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	update := services.LayoutUpdate{
+		BaseVersion: req.BaseVersion,
+		Slots:       make([]models.StorageSlot, len(req.Slots)),
+		HydraLines:  make([]models.HydraLine, len(req.HydraLines)),
+		PyroCount:   req.PyroCount,
+		ChangeNote:  req.ChangeNote,
+	}
+	for i, s := range req.Slots {
+		update.Slots[i] = models.StorageSlot{SlotID: s.SlotID, CapacityG: s.CapacityG}
+		if s.IngredientID != "" {
+			id, err := primitive.ObjectIDFromHex(s.IngredientID)
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format: "+s.IngredientID)
+				return
+			}
+			update.Slots[i].IngredientID = &id
+		}
+	}
+	for i, l := range req.HydraLines {
+		update.HydraLines[i] = models.HydraLine{LineID: l.LineID, CapacityML: l.CapacityML}
+		if l.IngredientID != "" {
+			id, err := primitive.ObjectIDFromHex(l.IngredientID)
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format: "+l.IngredientID)
+				return
+			}
+			update.HydraLines[i].IngredientID = &id
+		}
+	}
+
+	layout, err := a.layoutService.Update(c.Request.Context(), kitchen, update, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to save kitchen layout")
+		return
+	}
+
+	successResponse(c, layout)
+}
+
+// getKitchenLayout returns the current layout of a kitchen, or the one at ?version
+func (a *Application) getKitchenLayout(c *gin.Context) {
+	kitchen, ok := a.requireKitchen(c)
+	if !ok {
+		return
+	}
+
+	var layout *models.KitchenLayout
+	var err error
+	if param := c.Query("version"); param != "" {
+		version, convErr := strconv.Atoi(param)
+		if convErr != nil || version < 1 {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "version must be a positive integer")
+			return
+		}
+		layout, err = a.repos.Layout.GetVersion(c.Request.Context(), kitchen.ID, version)
+	} else {
+		layout, err = a.layoutService.Current(c.Request.Context(), kitchen.ID)
+	}
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get kitchen layout")
+		return
+	}
+	if layout == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Kitchen layout not found")
+		return
+	}
+
+	successResponse(c, layout)
+}
+
+func (a *Application) listKitchenLayoutVersions(c *gin.Context) {
+	kitchen, ok := a.requireKitchen(c)
+	if !ok {
+		return
+	}
+
+	layouts, err := a.layoutService.Versions(c.Request.Context(), kitchen.ID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list kitchen layouts")
+		return
+	}
+	if layouts == nil {
+		layouts = []*models.KitchenLayout{}
+	}
+
+	successResponse(c, layouts)
+}
+
+// checkKitchenRecipes reports which recipes served to the kitchen's site its current
+// layout can run; ?recipe_id narrows the check to one recipe
+func (a *Application) checkKitchenRecipes(c *gin.Context) {
+	kitchen, ok := a.requireKitchen(c)
+	if !ok {
+		return
+	}
+
+	var recipeID *primitive.ObjectID
+	if param := c.Query("recipe_id"); param != "" {
+		id, err := primitive.ObjectIDFromHex(param)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid recipe_id format")
+			return
+		}
+		recipeID = &id
+	}
+
+	layout, err := a.layoutService.Current(c.Request.Context(), kitchen.ID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get kitchen layout")
+		return
+	}
+	if layout == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Kitchen has no layout")
+		return
+	}
+
+	recipes, err := a.rolloutService.RecipesForSite(c.Request.Context(), kitchen.SiteID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get site recipes")
+		return
+	}
+
+	checks := []models.KitchenRecipeCheck{}
+	for _, recipe := range recipes {
+		if recipeID != nil && recipe.ID != *recipeID {
+			continue
+		}
+		checks = append(checks, services.CheckLayout(layout, recipe))
+	}
+	if recipeID != nil && len(checks) == 0 {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe is not served to this kitchen's site")
+		return
+	}
+
+	successResponse(c, gin.H{"layout_version": layout.Version, "recipes": checks})
+}
+
+// kosGetLayouts returns the current layout of each kitchen at the KOS's site. KOS
+// pulls it with the recipes and applies it before running them.
+func (a *Application) kosGetLayouts(c *gin.Context) {
+	kosIDStr := c.GetHeader("X-KOS-ID")
+	if kosIDStr == "" {
+		errorResponse(c, http.StatusUnauthorized, "MISSING_KOS_ID", "KOS ID required")
+		return
+	}
+
+	kosID, err := primitive.ObjectIDFromHex(kosIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid kos_id format")
+		return
+	}
+
+	instance, err := a.repos.KOSInstance.GetByID(c.Request.Context(), kosID)
+	if err != nil || instance == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "KOS instance not found")
+		return
+	}
+
+	layouts, err := a.layoutService.ForSite(c.Request.Context(), instance.SiteID)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get kitchen layouts")
+		return
+	}
+
+	kosLayouts := make([]models.KitchenLayoutForKOS, len(layouts))
+	for i, l := range layouts {
+		kosLayouts[i] = l.ToKOSFormat()
+	}
+
+	kosCatalogResponse(c, kosLayouts, kosLayouts, nextSyncCursor())
+}
+
+// requireKitchen loads the kitchen named by the :id path parameter, writing the error response when it cannot
+func (a *Application) requireKitchen(c *gin.Context) (*models.Kitchen, bool) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	kitchen, err := a.repos.Kitchen.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get kitchen")
+		return nil, false
+	}
+	if kitchen == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Kitchen not found")
+		return nil, false
+	}
+
+	return kitchen, true
+}
@@ -0,0 +1,384 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/ak/kws/internal/app/middleware"
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ForkLibraryRecipeRequest forks a library recipe into a tenant's catalog
+type ForkLibraryRecipeRequest struct {
+	TenantID                 string            `json:"tenant_id" binding:"required"`
+	Name                     string            `json:"name"`           // Defaults to the library recipe's name
+	IngredientMap            map[string]string `json:"ingredient_map"` // Library ingredient ID -> tenant ingredient ID
+	CreateMissingIngredients bool              `json:"create_missing_ingredients"`
+}
+
+// MergeUpstreamRequest merges library changes into a fork
+type MergeUpstreamRequest struct {
+	Resolve models.MergeResolution `json:"resolve"` // ours or theirs; required when changes conflict
+}
+
+// requirePlatformAdmin writes a 403 unless the user is a platform admin
+func requirePlatformAdmin(c *gin.Context) bool {
+	if user := middleware.GetUser(c); user != nil && user.IsPlatformAdmin {
+		return true
+	}
+	errorResponse(c, http.StatusForbidden, "FORBIDDEN", "Only platform admins can manage the recipe library")
+	return false
+}
+
+// libraryDrafts reports whether to include library recipes tenants cannot see yet:
+// ?all=true, for platform admins only
+func libraryDrafts(c *gin.Context) bool {
+	user := middleware.GetUser(c)
+	return c.Query("all") == "true" && user != nil && user.IsPlatformAdmin
+}
+
+func (a *Application) listLibraryIngredients(c *gin.Context) {
+	ingredients, err := a.libraryService.ListIngredients(c.Request.Context())
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list library ingredients")
+		return
+	}
+
+	successResponse(c, ingredients)
+}
+
+func (a *Application) getLibraryIngredient(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ingredient, err := a.repos.LibraryIngredient.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get library ingredient")
+		return
+	}
+	if ingredient == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Library ingredient not found")
+		return
+	}
+
+	successResponse(c, ingredient)
+}
+
+func (a *Application) createLibraryIngredient(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	var req models.IngredientRecord
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	ingredient, err := a.libraryService.CreateIngredient(c.Request.Context(), req)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to create library ingredient")
+		return
+	}
+
+	createdResponse(c, ingredient)
+}
+
+func (a *Application) updateLibraryIngredient(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	var req models.IngredientRecord
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	ingredient, err := a.libraryService.UpdateIngredient(c.Request.Context(), id, req)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to update library ingredient")
+		return
+	}
+
+	successResponse(c, ingredient)
+}
+
+// listLibraryRecipes returns the released library recipes; platform admins see every
+// recipe as it is being edited with ?all=true
+func (a *Application) listLibraryRecipes(c *gin.Context) {
+	recipes, err := a.libraryService.ListRecipes(c.Request.Context(), !libraryDrafts(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list library recipes")
+		return
+	}
+
+	successResponse(c, recipes)
+}
+
+func (a *Application) getLibraryRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	recipe, err := a.libraryService.GetRecipe(c.Request.Context(), id, !libraryDrafts(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get library recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+// createLibraryRecipe adds a draft library recipe; its ingredients are library ingredients
+func (a *Application) createLibraryRecipe(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	var req CreateRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	recipe, ok := newRecipeFromRequest(c, &req, primitive.NilObjectID)
+	if !ok {
+		return
+	}
+	if recipe.UsesStepTemplates() {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "Library recipes cannot use step templates")
+		return
+	}
+
+	if err := a.libraryRecipeService.RefreshDerived(c.Request.Context(), recipe, req.Allergens); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to derive recipe allergens")
+		return
+	}
+
+	if err := a.repos.LibraryRecipe.Create(c.Request.Context(), recipe); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create library recipe")
+		return
+	}
+
+	createdResponse(c, recipe)
+}
+
+// updateLibraryRecipe saves a new version of a library recipe; tenants keep seeing the
+// released version until it is released
+func (a *Application) updateLibraryRecipe(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	recipe, err := a.repos.LibraryRecipe.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get library recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Library recipe not found")
+		return
+	}
+
+	var req UpdateRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	if !applyRecipeUpdate(c, recipe, &req) {
+		return
+	}
+	if recipe.UsesStepTemplates() {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "Library recipes cannot use step templates")
+		return
+	}
+
+	recipe.ResetReview()
+	recipe.Version++
+	recipe.UpdatedBy = currentUserID(c)
+
+	if err := a.libraryRecipeService.RefreshDerived(c.Request.Context(), recipe, req.Allergens); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to derive recipe allergens")
+		return
+	}
+
+	if err := a.repos.LibraryRecipe.Update(c.Request.Context(), recipe); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update library recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+func (a *Application) listLibraryRecipeVersions(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	versions, err := a.libraryRecipeService.ListVersions(c.Request.Context(), id)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list library recipe versions")
+		return
+	}
+
+	summaries := make([]models.RecipeVersionSummary, len(versions))
+	for i, v := range versions {
+		summaries[i] = v.Summary()
+	}
+
+	successResponse(c, summaries)
+}
+
+// releaseLibraryRecipe makes the current version the one tenants see, fork and merge
+func (a *Application) releaseLibraryRecipe(c *gin.Context) {
+	if !requirePlatformAdmin(c) {
+		return
+	}
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	recipe, err := a.libraryService.Release(c.Request.Context(), id, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to release library recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+// forkLibraryRecipe copies the released version of a library recipe into a tenant's
+// catalog as a draft
+func (a *Application) forkLibraryRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	var req ForkLibraryRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+	tenantID, err := primitive.ObjectIDFromHex(req.TenantID)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid tenant_id format")
+		return
+	}
+
+	ingredientMap := make(map[primitive.ObjectID]primitive.ObjectID, len(req.IngredientMap))
+	for libraryID, tenantIngredientID := range req.IngredientMap {
+		from, err := primitive.ObjectIDFromHex(libraryID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid library ingredient ID in ingredient_map")
+			return
+		}
+		to, err := primitive.ObjectIDFromHex(tenantIngredientID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient ID in ingredient_map")
+			return
+		}
+		ingredientMap[from] = to
+	}
+
+	recipe, err := a.libraryService.Fork(c.Request.Context(), services.ForkRequest{
+		LibraryRecipeID: id,
+		TenantID:        tenantID,
+		Name:            req.Name,
+		IngredientMap:   ingredientMap,
+		CreateMissing:   req.CreateMissingIngredients,
+		UserID:          currentUserID(c),
+	})
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to fork library recipe")
+		return
+	}
+
+	createdResponse(c, recipe)
+}
+
+// listUpstreamUpdates returns the tenant's forks whose library recipe has a newer release
+func (a *Application) listUpstreamUpdates(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+
+	updates, err := a.libraryService.UpstreamUpdates(c.Request.Context(), tenantID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list library updates")
+		return
+	}
+
+	successResponse(c, updates)
+}
+
+// getRecipeUpstream compares a fork with its library recipe and previews the merge
+func (a *Application) getRecipeUpstream(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+		return
+	}
+
+	status, err := a.libraryService.UpstreamStatus(c.Request.Context(), recipe)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to compare recipe with the library")
+		return
+	}
+
+	successResponse(c, status)
+}
+
+// mergeRecipeUpstream merges the library's changes into a fork as a new draft version
+func (a *Application) mergeRecipeUpstream(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	var req MergeUpstreamRequest
+	// The resolution is optional until changes conflict
+	_ = c.ShouldBindJSON(&req)
+
+	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+		return
+	}
+
+	merged, err := a.libraryService.MergeUpstream(c.Request.Context(), recipe, req.Resolve, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to merge library changes")
+		return
+	}
+
+	successResponse(c, merged)
+}
@@ -1,47 +1,18 @@
 package app
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
 // ==================== Order handlers ====================
 
-// CreateOrderBatchRequest creates multiple orders (one per recipe item)
-type CreateOrderBatchRequest struct {
-	TenantID            string             `json:"tenant_id" binding:"required"`
-	RegionID            string             `json:"region_id" binding:"required"`
-	SiteID              string             `json:"site_id" binding:"required"`
-	OrderReference      string             `json:"order_reference" binding:"required"`
-	CustomerName        string             `json:"customer_name"`
-	Items               []OrderItemRequest `json:"items" binding:"required,min=1"`
-	ExecutionTime       *time.Time         `json:"execution_time"`
-	Priority            int                `json:"priority"`
-	SpecialInstructions string             `json:"special_instructions"`
-	Notes               string             `json:"notes"`
-	Metadata            map[string]any     `json:"metadata"`
-}
-
-type OrderItemRequest struct {
-	RecipeID      string                `json:"recipe_id" binding:"required"`
-	Quantity      int                   `json:"quantity" binding:"required,min=1"` // Creates N separate orders
-	PotPercentage int                   `json:"pot_percentage"`
-	Modifications []ModificationRequest `json:"modifications"`
-	Notes         string                `json:"notes"`
-	Options       map[string]any        `json:"options"`
-}
-
-type ModificationRequest struct {
-	Type       string `json:"type" binding:"required"`
-	Ingredient string `json:"ingredient"`
-	Notes      string `json:"notes"`
-}
-
 type UpdateOrderRequest struct {
 	CustomerName        string         `json:"customer_name"`
 	ExecutionTime       *time.Time     `json:"execution_time"`
@@ -83,6 +54,9 @@ func (a *Application) listOrders(c *gin.Context) {
 		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list orders")
 		return
 	}
+	if err := services.AnnotateCurrentNames(c.Request.Context(), a.repos.Recipe, a.repos.Ingredient, orders); err != nil {
+		a.logger.Warn("Failed to look up current recipe names", zap.Error(err))
+	}
 
 	paginatedResponse(c, orders, page, limit, total)
 }
@@ -90,126 +64,24 @@ func (a *Application) listOrders(c *gin.Context) {
 // createOrder creates one or more orders from the request
 // Each item in the request creates separate orders (with quantity creating N orders)
 func (a *Application) createOrder(c *gin.Context) {
-	var req CreateOrderBatchRequest
+	var req services.CreateOrderBatchRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
 		return
 	}
 
-	tenantID, err := primitive.ObjectIDFromHex(req.TenantID)
+	orders, err := a.orderService.CreateBatch(c.Request.Context(), req)
 	if err != nil {
-		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid tenant_id format")
+		serviceErrorResponse(c, err, "Failed to create orders")
 		return
 	}
 
-	regionID, err := primitive.ObjectIDFromHex(req.RegionID)
-	if err != nil {
-		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid region_id format")
-		return
-	}
-
-	siteID, err := primitive.ObjectIDFromHex(req.SiteID)
-	if err != nil {
-		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid site_id format")
-		return
-	}
-
-	// Default execution time to now if not provided
-	executionTime := time.Now()
-	if req.ExecutionTime != nil {
-		executionTime = *req.ExecutionTime
-	}
-
-	priority := req.Priority
-	if priority == 0 {
-		priority = 5
-	}
-
-	// Generate a group ID to link all orders from this batch
-	groupID := primitive.NewObjectID().Hex()
-
-	var orders []*models.Order
-	orderNum := 0
-
-	// Create one order per recipe item (with quantity creating N orders)
-	for _, item := range req.Items {
-		recipeID, err := primitive.ObjectIDFromHex(item.RecipeID)
-		if err != nil {
-			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid recipe_id format")
-			return
-		}
-
-		// Get recipe name for denormalization
-		recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), recipeID)
-		if err != nil {
-			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
-			return
-		}
-		if recipe == nil {
-			errorResponse(c, http.StatusBadRequest, "NOT_FOUND", fmt.Sprintf("Recipe %s not found", item.RecipeID))
-			return
-		}
-
-		var modifications []models.Modification
-		for _, mod := range item.Modifications {
-			modifications = append(modifications, models.Modification{
-				Type:       mod.Type,
-				Ingredient: mod.Ingredient,
-				Notes:      mod.Notes,
-			})
-		}
-
-		potPct := item.PotPercentage
-		if potPct == 0 {
-			potPct = 100
-		}
-
-		// Create N orders based on quantity
-		for q := 0; q < item.Quantity; q++ {
-			orderNum++
-			orderRef := req.OrderReference
-			if len(req.Items) > 1 || item.Quantity > 1 {
-				orderRef = fmt.Sprintf("%s-%d", req.OrderReference, orderNum)
-			}
-
-			order := &models.Order{
-				TenantID:            tenantID,
-				RegionID:            regionID,
-				SiteID:              siteID,
-				OrderReference:      orderRef,
-				OrderGroupID:        groupID,
-				CustomerName:        req.CustomerName,
-				RecipeID:            recipeID,
-				RecipeName:          recipe.Name,
-				PotPercentage:       potPct,
-				Modifications:       modifications,
-				Status:              models.OrderStatusPending,
-				ExecutionTime:       executionTime,
-				Priority:            priority,
-				SpecialInstructions: req.SpecialInstructions,
-				Notes:               item.Notes,
-				Metadata:            req.Metadata,
-				Source:              models.OrderSourceAPI,
-				KOSSyncStatus:       models.KOSSyncStatusPending,
-				CreatedAt:           time.Now(),
-				UpdatedAt:           time.Now(),
-			}
-
-			if err := a.repos.Order.Create(c.Request.Context(), order); err != nil {
-				errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create order")
-				return
-			}
-
-			orders = append(orders, order)
-		}
-	}
-
 	// Return all created orders
 	if len(orders) == 1 {
 		createdResponse(c, orders[0])
 	} else {
 		createdResponse(c, gin.H{
-			"order_group_id": groupID,
+			"order_group_id": orders[0].OrderGroupID,
 			"orders":         orders,
 			"count":          len(orders),
 		})
@@ -231,10 +103,51 @@ func (a *Application) getOrder(c *gin.Context) {
 		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Order not found")
 		return
 	}
+	if err := services.AnnotateCurrentNames(c.Request.Context(), a.repos.Recipe, a.repos.Ingredient, []*models.Order{order}); err != nil {
+		a.logger.WithOrder(order.ID.Hex()).Warn("Failed to look up current recipe names", zap.Error(err))
+	}
 
 	successResponse(c, order)
 }
 
+// getOrderNutrition returns the nutrition of an order's dish: the recipe version it was
+// placed against, at its pot size, with its modifications applied
+func (a *Application) getOrderNutrition(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := a.repos.Order.GetByID(ctx, id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get order")
+		return
+	}
+	if order == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Order not found")
+		return
+	}
+
+	recipe, err := a.recipeAtVersion(ctx, order.RecipeID, order.RecipeVersion, nil)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get order recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Order recipe not found")
+		return
+	}
+
+	nutrition, err := a.recipeService.Nutrition(ctx, recipe, order.PotPercentage, order.Modifications)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to compute nutrition")
+		return
+	}
+
+	successResponse(c, nutrition)
+}
+
 func (a *Application) updateOrder(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
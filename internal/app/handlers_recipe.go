@@ -2,10 +2,12 @@ package app
 
 import (
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/ak/kws/internal/app/middleware"
 	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -22,30 +24,38 @@ type CreateRecipeRequest struct {
 	EstimatedPrepTimeSec    int                       `json:"estimated_prep_time_sec"`    // KOS-compatible field (seconds)
 	EstimatedCookingTimeSec int                       `json:"estimated_cooking_time_sec"` // KOS-compatible field (seconds)
 	Servings                int                       `json:"servings"`
+	Allergens               []string                  `json:"allergens"` // Added by hand; ingredient allergens are derived
 	Steps                   []RecipeStepRequest       `json:"steps"`
 	Ingredients             []RecipeIngredientRequest `json:"ingredients"`
 	Parameters              map[string]any            `json:"parameters"`
 }
 
 // RecipeStepRequest represents a recipe step in API requests
-// Action must be one of: add_liquid, add_solid, agitate, heat, open_pot_lid, close_pot_lid
+// Action must be one of: add_liquid, add_solid, agitate, heat, open_pot_lid, close_pot_lid,
+// or step_template with Template naming the step template and its arguments
 // Parameters structure depends on action type (see models.RecipeStep documentation)
 type RecipeStepRequest struct {
-	StepNumber     int            `json:"step_number" binding:"required,min=1"` // Sequential order (1,2,3...)
-	Action         string         `json:"action" binding:"required"`            // L4 action type
-	Parameters     map[string]any `json:"parameters,omitempty"`                 // Action-specific parameters
-	DependsOnSteps []int          `json:"depends_on_steps,omitempty"`           // Parent step numbers
-	Name           string         `json:"name,omitempty"`                       // Human-readable name (KWS-only)
-	Description    string         `json:"description,omitempty"`                // Step description (KWS-only)
+	StepNumber     int                           `json:"step_number" binding:"required,min=1"` // Sequential order (1,2,3...)
+	Action         string                        `json:"action" binding:"required"`            // L4 action type
+	Parameters     map[string]any                `json:"parameters,omitempty"`                 // Action-specific parameters
+	DependsOnSteps []int                         `json:"depends_on_steps,omitempty"`           // Parent step numbers
+	Name           string                        `json:"name,omitempty"`                       // Human-readable name (KWS-only)
+	Description    string                        `json:"description,omitempty"`                // Step description (KWS-only)
+	Scaling        map[string]models.ScalingRule `json:"scaling,omitempty"`                    // Per-parameter pot-percentage scaling
+
+	Template *models.StepTemplateRef `json:"template,omitempty"` // Step template a step_template step expands into
 }
 
 type RecipeIngredientRequest struct {
-	IngredientID     string  `json:"ingredient_id" binding:"required"`
-	QuantityRequired float64 `json:"quantity_required"` // Required quantity per serving
-	Unit             string  `json:"unit"`              // grams, ml
-	TimingStep       int     `json:"timing_step"`       // Recipe step when added
-	IsCritical       bool    `json:"is_critical"`       // Recipe fails without this
-	PrepNotes        string  `json:"prep_notes,omitempty"`
+	IngredientID     string              `json:"ingredient_id" binding:"required"`
+	QuantityRequired float64             `json:"quantity_required"` // Required quantity per serving
+	Unit             string              `json:"unit"`              // grams, ml
+	TimingStep       int                 `json:"timing_step"`       // Recipe step when added
+	IsCritical       bool                `json:"is_critical"`       // Recipe fails without this
+	PrepNotes        string              `json:"prep_notes,omitempty"`
+	Scaling          *models.ScalingRule `json:"scaling,omitempty"`          // Pot-percentage scaling; linear if unset
+	Substitutes      []string            `json:"substitutes,omitempty"`      // Ingredient IDs orders may swap in
+	MaxExtraFactor   float64             `json:"max_extra_factor,omitempty"` // Most an "extra" may add, as a fraction of the quantity
 }
 
 type UpdateRecipeRequest struct {
@@ -57,13 +67,101 @@ type UpdateRecipeRequest struct {
 	EstimatedPrepTimeSec    int                       `json:"estimated_prep_time_sec"`
 	EstimatedCookingTimeSec int                       `json:"estimated_cooking_time_sec"`
 	Servings                int                       `json:"servings"`
+	Allergens               []string                  `json:"allergens"` // Added by hand; ingredient allergens are derived
 	Steps                   []RecipeStepRequest       `json:"steps"`
 	Ingredients             []RecipeIngredientRequest `json:"ingredients"`
 	Parameters              map[string]any            `json:"parameters"`
 }
 
+// PublishRecipeRequest selects publish targets; it is also the body of unpublish,
+// where the targets are removed instead of added.
+// An empty body publishes to all sites on first publish, or fully unpublishes.
 type PublishRecipeRequest struct {
-	SiteIDs []string `json:"site_ids"` // Optional - if empty, publishes globally
+	All       bool     `json:"all"`        // Every site of the tenant
+	SiteIDs   []string `json:"site_ids"`   // Individual sites
+	RegionIDs []string `json:"region_ids"` // Expanded to the region's current sites
+}
+
+// toTargets converts the request IDs, writing a 400 response on invalid input
+func (r *PublishRecipeRequest) toTargets(c *gin.Context) (services.PublishTargets, bool) {
+	targets := services.PublishTargets{All: r.All}
+	for _, idStr := range r.SiteIDs {
+		id, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid site_id format")
+			return targets, false
+		}
+		targets.SiteIDs = append(targets.SiteIDs, id)
+	}
+	for _, idStr := range r.RegionIDs {
+		id, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid region_id format")
+			return targets, false
+		}
+		targets.RegionIDs = append(targets.RegionIDs, id)
+	}
+	return targets, true
+}
+
+// ValidateRecipeRequest carries the steps and ingredients being edited
+type ValidateRecipeRequest struct {
+	Steps       []RecipeStepRequest       `json:"steps"`
+	Ingredients []RecipeIngredientRequest `json:"ingredients"`
+}
+
+func recipeStepsFromRequest(reqSteps []RecipeStepRequest) []models.RecipeStep {
+	steps := make([]models.RecipeStep, len(reqSteps))
+	for i, s := range reqSteps {
+		steps[i] = models.RecipeStep{
+			StepNumber:     s.StepNumber,
+			Action:         models.L4Action(s.Action),
+			Parameters:     s.Parameters,
+			DependsOnSteps: s.DependsOnSteps,
+			Name:           s.Name,
+			Description:    s.Description,
+			Scaling:        s.Scaling,
+			Template:       s.Template,
+		}
+	}
+	return steps
+}
+
+// recipeIngredientsFromRequest converts the request ingredients, writing a 400 response on invalid IDs
+func recipeIngredientsFromRequest(c *gin.Context, reqIngredients []RecipeIngredientRequest) ([]models.RecipeIngredient, bool) {
+	ingredients := make([]models.RecipeIngredient, len(reqIngredients))
+	for i, ing := range reqIngredients {
+		ingID, err := primitive.ObjectIDFromHex(ing.IngredientID)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format")
+			return nil, false
+		}
+		if ing.MaxExtraFactor < 0 {
+			errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "max_extra_factor cannot be negative")
+			return nil, false
+		}
+		var substitutes []primitive.ObjectID
+		for _, sub := range ing.Substitutes {
+			subID, err := primitive.ObjectIDFromHex(sub)
+			if err != nil {
+				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid substitute ingredient ID format")
+				return nil, false
+			}
+			substitutes = append(substitutes, subID)
+		}
+		ingredients[i] = models.RecipeIngredient{
+			IngredientID:     ingID,
+			QuantityRequired: ing.QuantityRequired,
+			Unit:             ing.Unit,
+			TimingStep:       ing.TimingStep,
+			IsCritical:       ing.IsCritical,
+			PrepNotes:        ing.PrepNotes,
+			Scaling:          ing.Scaling,
+			Substitutes:      substitutes,
+			MaxExtraFactor:   ing.MaxExtraFactor,
+		}
+	}
+	return ingredients, true
 }
 
 func (a *Application) listRecipes(c *gin.Context) {
@@ -119,35 +217,42 @@ func (a *Application) createRecipe(c *gin.Context) {
 		return
 	}
 
-	// Convert steps
-	steps := make([]models.RecipeStep, len(req.Steps))
-	for i, s := range req.Steps {
-		steps[i] = models.RecipeStep{
-			StepNumber:     s.StepNumber,
-			Action:         models.L4Action(s.Action),
-			Parameters:     s.Parameters,
-			DependsOnSteps: s.DependsOnSteps,
-			Name:           s.Name,
-			Description:    s.Description,
-		}
+	recipe, ok := newRecipeFromRequest(c, &req, tenantID)
+	if !ok {
+		return
 	}
 
-	// Convert ingredients
-	ingredients := make([]models.RecipeIngredient, len(req.Ingredients))
-	for i, ing := range req.Ingredients {
-		ingID, err := primitive.ObjectIDFromHex(ing.IngredientID)
-		if err != nil {
-			errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format")
-			return
-		}
-		ingredients[i] = models.RecipeIngredient{
-			IngredientID:     ingID,
-			QuantityRequired: ing.QuantityRequired,
-			Unit:             ing.Unit,
-			TimingStep:       ing.TimingStep,
-			IsCritical:       ing.IsCritical,
-			PrepNotes:        ing.PrepNotes,
-		}
+	if err := a.stepTemplateService.ExpandRecipe(c.Request.Context(), recipe); err != nil {
+		serviceErrorResponse(c, err, "Failed to expand step templates")
+		return
+	}
+
+	// Drafts are held to the action schemas too; only the lint rules wait for submission
+	if err := a.recipeService.ValidateRecipe(c.Request.Context(), recipe); err != nil {
+		serviceErrorResponse(c, err, "Failed to validate recipe")
+		return
+	}
+
+	if err := a.recipeService.RefreshDerived(c.Request.Context(), recipe, req.Allergens); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to derive recipe allergens")
+		return
+	}
+
+	if err := a.repos.Recipe.Create(c.Request.Context(), recipe); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create recipe")
+		return
+	}
+
+	createdResponse(c, recipe)
+}
+
+// newRecipeFromRequest builds a draft recipe from a create request, writing the error
+// response when the request is malformed
+func newRecipeFromRequest(c *gin.Context, req *CreateRecipeRequest, tenantID primitive.ObjectID) (*models.Recipe, bool) {
+	steps := recipeStepsFromRequest(req.Steps)
+	ingredients, ok := recipeIngredientsFromRequest(c, req.Ingredients)
+	if !ok {
+		return nil, false
 	}
 
 	recipe := &models.Recipe{
@@ -160,27 +265,62 @@ func (a *Application) createRecipe(c *gin.Context) {
 		EstimatedPrepTimeSec:    req.EstimatedPrepTimeSec,
 		EstimatedCookingTimeSec: req.EstimatedCookingTimeSec,
 		Servings:                req.Servings,
-		Steps:                   steps,
 		Ingredients:             ingredients,
 		Parameters:              req.Parameters,
 		Status:                  models.RecipeStatusDraft,
 		Version:                 1,
+		CreatedBy:               currentUserID(c),
+		UpdatedBy:               currentUserID(c),
 	}
+	recipe.SetSteps(steps)
+	return recipe, true
+}
 
-	if err := a.repos.Recipe.Create(c.Request.Context(), recipe); err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to create recipe")
+func (a *Application) getRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	createdResponse(c, recipe)
+	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+		return
+	}
+
+	successResponse(c, recipe)
 }
 
-func (a *Application) getRecipe(c *gin.Context) {
+// listRecipeActions returns the parameter schema of every L4 action for the recipe editor
+func (a *Application) listRecipeActions(c *gin.Context) {
+	successResponse(c, models.ActionSchemas)
+}
+
+// previewRecipeScaling returns the recipe as KOS receives it for an order at each pot
+// percentage: ?pot_percentage=25,50 or, by default, every standard pot size
+func (a *Application) previewRecipeScaling(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
 		return
 	}
 
+	percentages := models.StandardPotPercentages
+	if param := c.Query("pot_percentage"); param != "" {
+		percentages = nil
+		for _, part := range strings.Split(param, ",") {
+			pct, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || pct < 1 || pct > 100 {
+				errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "pot_percentage must be between 1 and 100")
+				return
+			}
+			percentages = append(percentages, pct)
+		}
+	}
+
 	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
 	if err != nil {
 		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
@@ -191,7 +331,101 @@ func (a *Application) getRecipe(c *gin.Context) {
 		return
 	}
 
-	successResponse(c, recipe)
+	previews := make([]models.RecipeForKOS, len(percentages))
+	for i, pct := range percentages {
+		nutrition, err := a.recipeService.Nutrition(c.Request.Context(), recipe, pct, nil)
+		if err != nil {
+			serviceErrorResponse(c, err, "Failed to compute nutrition")
+			return
+		}
+		previews[i] = services.ScaleRecipe(recipe, pct).ToKOSFormat()
+		previews[i].PotPercentage = pct
+		previews[i].SetNutrition(nutrition)
+	}
+
+	successResponse(c, previews)
+}
+
+// getRecipeNutrition computes a recipe's nutrition from the current ingredient data at
+// ?pot_percentage= (default 100), per serving, per 100 g and in total
+func (a *Application) getRecipeNutrition(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	pct := 100
+	if param := c.Query("pot_percentage"); param != "" {
+		var err error
+		pct, err = strconv.Atoi(param)
+		if err != nil || pct < 1 || pct > 100 {
+			errorResponse(c, http.StatusBadRequest, "INVALID_PARAM", "pot_percentage must be between 1 and 100")
+			return
+		}
+	}
+
+	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		return
+	}
+	if recipe == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+		return
+	}
+
+	nutrition, err := a.recipeService.Nutrition(c.Request.Context(), recipe, pct, nil)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to compute nutrition")
+		return
+	}
+
+	successResponse(c, nutrition)
+}
+
+// listLintRules returns every recipe lint rule; tenants disable rules in their settings
+func (a *Application) listLintRules(c *gin.Context) {
+	successResponse(c, models.LintRules)
+}
+
+// listUnits returns the unit catalog ingredient quantities are measured in
+func (a *Application) listUnits(c *gin.Context) {
+	successResponse(c, models.Units)
+}
+
+// validateRecipe checks an unsaved step graph; the editor calls it as the recipe is edited
+func (a *Application) validateRecipe(c *gin.Context) {
+	var req ValidateRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	ingredients, ok := recipeIngredientsFromRequest(c, req.Ingredients)
+	if !ok {
+		return
+	}
+	recipe := &models.Recipe{
+		Ingredients: ingredients,
+	}
+	recipe.SetSteps(recipeStepsFromRequest(req.Steps))
+	// Lint with the session tenant's rules and expand its step templates; platform users get every rule
+	if tenantID, err := primitive.ObjectIDFromHex(middleware.GetEffectiveTenantID(c)); err == nil {
+		recipe.TenantID = tenantID
+	}
+
+	if err := a.stepTemplateService.ExpandRecipe(c.Request.Context(), recipe); err != nil {
+		serviceErrorResponse(c, err, "Failed to expand step templates")
+		return
+	}
+
+	analysis, err := a.recipeService.Analyze(c.Request.Context(), recipe)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to validate recipe")
+		return
+	}
+
+	successResponse(c, analysis)
 }
 
 func (a *Application) updateRecipe(c *gin.Context) {
@@ -216,6 +450,44 @@ func (a *Application) updateRecipe(c *gin.Context) {
 		return
 	}
 
+	if !applyRecipeUpdate(c, recipe, &req) {
+		return
+	}
+
+	recipe.ResetReview()
+
+	// Increment version (the repository snapshots each new version into recipe_versions)
+	recipe.Version++
+	recipe.UpdatedBy = currentUserID(c)
+
+	// Templated steps follow the latest template versions whenever the recipe is saved
+	if err := a.stepTemplateService.ExpandRecipe(c.Request.Context(), recipe); err != nil {
+		serviceErrorResponse(c, err, "Failed to expand step templates")
+		return
+	}
+
+	// Drafts are held to the action schemas too; only the lint rules wait for submission
+	if err := a.recipeService.ValidateRecipe(c.Request.Context(), recipe); err != nil {
+		serviceErrorResponse(c, err, "Failed to validate recipe")
+		return
+	}
+
+	if err := a.recipeService.RefreshDerived(c.Request.Context(), recipe, req.Allergens); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to derive recipe allergens")
+		return
+	}
+
+	if err := a.repos.Recipe.Update(c.Request.Context(), recipe); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+// applyRecipeUpdate copies the fields an update request sets onto a recipe, writing the
+// error response when the request is malformed
+func applyRecipeUpdate(c *gin.Context, recipe *models.Recipe, req *UpdateRecipeRequest) bool {
 	// Update fields
 	if req.Name != "" {
 		recipe.Name = req.Name
@@ -247,50 +519,18 @@ func (a *Application) updateRecipe(c *gin.Context) {
 
 	// Update steps if provided
 	if req.Steps != nil {
-		steps := make([]models.RecipeStep, len(req.Steps))
-		for i, s := range req.Steps {
-			steps[i] = models.RecipeStep{
-				StepNumber:     s.StepNumber,
-				Action:         models.L4Action(s.Action),
-				Parameters:     s.Parameters,
-				DependsOnSteps: s.DependsOnSteps,
-				Name:           s.Name,
-				Description:    s.Description,
-			}
-		}
-		recipe.Steps = steps
+		recipe.SetSteps(recipeStepsFromRequest(req.Steps))
 	}
 
 	// Update ingredients if provided
 	if req.Ingredients != nil {
-		ingredients := make([]models.RecipeIngredient, len(req.Ingredients))
-		for i, ing := range req.Ingredients {
-			ingID, err := primitive.ObjectIDFromHex(ing.IngredientID)
-			if err != nil {
-				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid ingredient_id format")
-				return
-			}
-			ingredients[i] = models.RecipeIngredient{
-				IngredientID:     ingID,
-				QuantityRequired: ing.QuantityRequired,
-				Unit:             ing.Unit,
-				TimingStep:       ing.TimingStep,
-				IsCritical:       ing.IsCritical,
-				PrepNotes:        ing.PrepNotes,
-			}
+		ingredients, ok := recipeIngredientsFromRequest(c, req.Ingredients)
+		if !ok {
+			return false
 		}
 		recipe.Ingredients = ingredients
 	}
-
-	// Increment version
-	recipe.Version++
-
-	if err := a.repos.Recipe.Update(c.Request.Context(), recipe); err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to update recipe")
-		return
-	}
-
-	successResponse(c, recipe)
+	return true
 }
 
 func (a *Application) deleteRecipe(c *gin.Context) {
@@ -310,7 +550,7 @@ func (a *Application) deleteRecipe(c *gin.Context) {
 	}
 
 	// Check if recipe is published
-	if recipe.Status == models.RecipeStatusPublished {
+	if recipe.LiveVersion() > 0 {
 		errorResponse(c, http.StatusConflict, "RECIPE_PUBLISHED", "Cannot delete a published recipe. Unpublish it first.")
 		return
 	}
@@ -323,91 +563,274 @@ func (a *Application) deleteRecipe(c *gin.Context) {
 	successResponse(c, gin.H{"deleted": true})
 }
 
+// getRecipeSyncMatrix returns the recipes × sites sync state of a tenant's fleet
+func (a *Application) getRecipeSyncMatrix(c *gin.Context) {
+	tenantIDStr := c.Query("tenant_id")
+	if tenantIDStr == "" {
+		errorResponse(c, http.StatusBadRequest, "MISSING_PARAM", "tenant_id is required")
+		return
+	}
+
+	tenantID, err := primitive.ObjectIDFromHex(tenantIDStr)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid tenant_id format")
+		return
+	}
+
+	matrix, err := a.syncService.Matrix(c.Request.Context(), tenantID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to build recipe sync matrix")
+		return
+	}
+
+	successResponse(c, matrix)
+}
+
 func (a *Application) publishRecipe(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
 		return
 	}
 
-	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	// Parse optional request body for targets
+	var req PublishRecipeRequest
+	// Ignore binding errors - targets are optional
+	_ = c.ShouldBindJSON(&req)
+
+	targets, ok := req.toTargets(c)
+	if !ok {
+		return
+	}
+
+	recipe, err := a.recipeService.Publish(c.Request.Context(), id, targets, currentUserID(c))
 	if err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		serviceErrorResponse(c, err, "Failed to publish recipe")
 		return
 	}
-	if recipe == nil {
-		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+
+	successResponse(c, recipe)
+}
+
+func (a *Application) unpublishRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	// Parse optional request body for site_ids
+	// Optional targets to remove; an empty body unpublishes everywhere
 	var req PublishRecipeRequest
-	// Ignore binding errors - site_ids is optional
 	_ = c.ShouldBindJSON(&req)
 
-	// Convert site IDs if provided
-	if len(req.SiteIDs) > 0 {
-		siteIDs := make([]primitive.ObjectID, len(req.SiteIDs))
-		for i, siteIDStr := range req.SiteIDs {
-			siteID, err := primitive.ObjectIDFromHex(siteIDStr)
-			if err != nil {
-				errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid site_id format")
-				return
-			}
-			siteIDs[i] = siteID
-		}
+	targets, ok := req.toTargets(c)
+	if !ok {
+		return
+	}
 
-		// Merge with existing published sites
-		existingSites := make(map[primitive.ObjectID]bool)
-		for _, s := range recipe.PublishedToSites {
-			existingSites[s] = true
-		}
-		for _, s := range siteIDs {
-			existingSites[s] = true
-		}
+	recipe, err := a.recipeService.Unpublish(c.Request.Context(), id, targets, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to unpublish recipe")
+		return
+	}
 
-		// Convert back to slice
-		allSites := make([]primitive.ObjectID, 0, len(existingSites))
-		for s := range existingSites {
-			allSites = append(allSites, s)
-		}
-		recipe.PublishedToSites = allSites
+	successResponse(c, recipe)
+}
+
+// ==================== Recipe review workflow handlers ====================
+
+type ReviewRecipeRequest struct {
+	Comment string `json:"comment"`
+}
+
+func (a *Application) submitRecipeForReview(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
 	}
 
-	// Set status to published
-	recipe.Status = models.RecipeStatusPublished
-	now := time.Now()
-	recipe.PublishedAt = &now
+	var req ReviewRecipeRequest
+	// Comment is optional on submit
+	_ = c.ShouldBindJSON(&req)
 
-	if err := a.repos.Recipe.Update(c.Request.Context(), recipe); err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to publish recipe")
+	recipe, err := a.recipeService.SubmitForReview(c.Request.Context(), id, currentUserID(c), req.Comment)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to submit recipe for review")
 		return
 	}
 
 	successResponse(c, recipe)
 }
 
-func (a *Application) unpublishRecipe(c *gin.Context) {
+func (a *Application) approveRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok || !requireRecipeApprover(c) {
+		return
+	}
+
+	var req ReviewRecipeRequest
+	_ = c.ShouldBindJSON(&req)
+
+	recipe, err := a.recipeService.Approve(c.Request.Context(), id, currentUserID(c), req.Comment)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to approve recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+func (a *Application) rejectRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok || !requireRecipeApprover(c) {
+		return
+	}
+
+	var req ReviewRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	recipe, err := a.recipeService.Reject(c.Request.Context(), id, currentUserID(c), req.Comment)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to reject recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
+
+func (a *Application) listRecipeAuditLog(c *gin.Context) {
 	id, ok := getObjectID(c, "id")
 	if !ok {
 		return
 	}
+	page, limit := getPagination(c)
 
-	recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+	logs, total, err := a.repos.AuditLog.ListByResource(c.Request.Context(), services.AuditResourceRecipe, id.Hex(), page, limit)
 	if err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list audit log")
 		return
 	}
-	if recipe == nil {
-		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+
+	paginatedResponse(c, logs, page, limit, total)
+}
+
+// requireRecipeApprover rejects the request unless the user holds the recipe approver role
+func requireRecipeApprover(c *gin.Context) bool {
+	user := middleware.GetUser(c)
+	if user == nil || !user.HasRole(middleware.RoleRecipeApprover) {
+		errorResponse(c, http.StatusForbidden, "INSUFFICIENT_ROLE", "Recipe approver role required")
+		return false
+	}
+	return true
+}
+
+// ==================== Recipe version history handlers ====================
+
+// getVersionParam parses the :version path parameter
+func getVersionParam(c *gin.Context) (int, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		errorResponse(c, http.StatusBadRequest, "INVALID_VERSION", "Version must be a positive integer")
+		return 0, false
+	}
+	return version, true
+}
+
+func (a *Application) listRecipeVersions(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
 		return
 	}
 
-	recipe.Status = models.RecipeStatusDraft
-	recipe.PublishedToSites = nil
-	recipe.PublishedAt = nil
+	versions, err := a.recipeService.ListVersions(c.Request.Context(), id)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list recipe versions")
+		return
+	}
 
-	if err := a.repos.Recipe.Update(c.Request.Context(), recipe); err != nil {
-		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to unpublish recipe")
+	summaries := make([]models.RecipeVersionSummary, len(versions))
+	for i, v := range versions {
+		summaries[i] = v.Summary()
+	}
+
+	successResponse(c, summaries)
+}
+
+func (a *Application) getRecipeVersion(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	version, ok := getVersionParam(c)
+	if !ok {
+		return
+	}
+
+	snapshot, err := a.recipeService.GetVersion(c.Request.Context(), id, version)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get recipe version")
+		return
+	}
+
+	successResponse(c, snapshot)
+}
+
+// diffRecipeVersions compares two versions: ?from=N&to=M (to defaults to the current version)
+func (a *Application) diffRecipeVersions(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	fromVersion, err := strconv.Atoi(c.Query("from"))
+	if err != nil || fromVersion < 1 {
+		errorResponse(c, http.StatusBadRequest, "INVALID_VERSION", "from must be a positive integer")
+		return
+	}
+
+	var toVersion int
+	if toStr := c.Query("to"); toStr != "" {
+		toVersion, err = strconv.Atoi(toStr)
+		if err != nil || toVersion < 1 {
+			errorResponse(c, http.StatusBadRequest, "INVALID_VERSION", "to must be a positive integer")
+			return
+		}
+	} else {
+		recipe, err := a.repos.Recipe.GetByID(c.Request.Context(), id)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get recipe")
+			return
+		}
+		if recipe == nil {
+			errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Recipe not found")
+			return
+		}
+		toVersion = recipe.Version
+	}
+
+	diff, err := a.recipeService.DiffVersions(c.Request.Context(), id, fromVersion, toVersion)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to diff recipe versions")
+		return
+	}
+
+	successResponse(c, diff)
+}
+
+func (a *Application) rollbackRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	version, ok := getVersionParam(c)
+	if !ok {
+		return
+	}
+
+	recipe, err := a.recipeService.Rollback(c.Request.Context(), id, version, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to roll back recipe")
 		return
 	}
 
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+)
+
+// StartRolloutRequest selects the canary sites of a rollout. Zero thresholds use the defaults.
+type StartRolloutRequest struct {
+	SiteIDs    []string                 `json:"site_ids"`
+	RegionIDs  []string                 `json:"region_ids"`
+	Thresholds models.RolloutThresholds `json:"thresholds"`
+}
+
+type RollbackRolloutRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (a *Application) startRecipeRollout(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req StartRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	targetsReq := PublishRecipeRequest{SiteIDs: req.SiteIDs, RegionIDs: req.RegionIDs}
+	targets, ok := targetsReq.toTargets(c)
+	if !ok {
+		return
+	}
+
+	rollout, err := a.rolloutService.Start(c.Request.Context(), id, services.StartRolloutRequest{
+		Targets:    targets,
+		Thresholds: req.Thresholds,
+	}, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to start rollout")
+		return
+	}
+
+	createdResponse(c, rollout)
+}
+
+func (a *Application) listRecipeRollouts(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	rollouts, err := a.rolloutService.ListForRecipe(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list rollouts")
+		return
+	}
+
+	successResponse(c, rollouts)
+}
+
+func (a *Application) getRollout(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	rollout, err := a.rolloutService.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get rollout")
+		return
+	}
+	if rollout == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Rollout not found")
+		return
+	}
+
+	successResponse(c, rollout)
+}
+
+// promoteRollout takes the same body as publish: sites or regions to add, or all
+func (a *Application) promoteRollout(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req PublishRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	targets, ok := req.toTargets(c)
+	if !ok {
+		return
+	}
+
+	rollout, err := a.rolloutService.Promote(c.Request.Context(), id, targets, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to promote rollout")
+		return
+	}
+
+	successResponse(c, rollout)
+}
+
+func (a *Application) rollbackRollout(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	var req RollbackRolloutRequest
+	// Reason is optional
+	_ = c.ShouldBindJSON(&req)
+
+	rollout, err := a.rolloutService.Rollback(c.Request.Context(), id, req.Reason, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to roll back rollout")
+		return
+	}
+
+	successResponse(c, rollout)
+}
+
+func (a *Application) evaluateRollout(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	rollout, err := a.rolloutService.Evaluate(c.Request.Context(), id)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to evaluate rollout")
+		return
+	}
+
+	successResponse(c, rollout)
+}
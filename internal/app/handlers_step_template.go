@@ -0,0 +1,177 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/services"
+	"github.com/gin-gonic/gin"
+)
+
+// listStepTemplates returns a tenant's step templates by name: ?tenant_id is required
+func (a *Application) listStepTemplates(c *gin.Context) {
+	tenantID, ok := catalogTenantID(c)
+	if !ok {
+		return
+	}
+
+	templates, err := a.stepTemplateService.List(c.Request.Context(), tenantID)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to list step templates")
+		return
+	}
+
+	successResponse(c, templates)
+}
+
+func (a *Application) createStepTemplate(c *gin.Context) {
+	var req services.StepTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	template, err := a.stepTemplateService.Create(c.Request.Context(), req, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to create step template")
+		return
+	}
+
+	createdResponse(c, template)
+}
+
+// getStepTemplateOr404 loads a step template, writing the error response when it cannot
+func (a *Application) getStepTemplateOr404(c *gin.Context) (*models.StepTemplate, bool) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return nil, false
+	}
+
+	template, err := a.repos.StepTemplate.GetByID(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get step template")
+		return nil, false
+	}
+	if template == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Step template not found")
+		return nil, false
+	}
+	return template, true
+}
+
+func (a *Application) getStepTemplate(c *gin.Context) {
+	template, ok := a.getStepTemplateOr404(c)
+	if !ok {
+		return
+	}
+
+	successResponse(c, template)
+}
+
+// updateStepTemplate saves a new version of a step template; the response's
+// flagged_recipes counts the recipes that now need revalidating
+func (a *Application) updateStepTemplate(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	var req services.StepTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+		return
+	}
+
+	template, err := a.stepTemplateService.Update(c.Request.Context(), id, req, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to update step template")
+		return
+	}
+
+	successResponse(c, template)
+}
+
+// deleteStepTemplate removes a step template no recipe uses; its versions are kept
+func (a *Application) deleteStepTemplate(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := a.stepTemplateService.Delete(c.Request.Context(), id, currentUserID(c)); err != nil {
+		serviceErrorResponse(c, err, "Failed to delete step template")
+		return
+	}
+
+	successResponse(c, gin.H{"deleted": true})
+}
+
+func (a *Application) listStepTemplateVersions(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	versions, err := a.repos.StepTemplate.ListVersions(c.Request.Context(), id)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to list step template versions")
+		return
+	}
+
+	successResponse(c, versions)
+}
+
+func (a *Application) getStepTemplateVersion(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+	version, ok := getVersionParam(c)
+	if !ok {
+		return
+	}
+
+	snapshot, err := a.repos.StepTemplate.GetVersion(c.Request.Context(), id, version)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "DATABASE_ERROR", "Failed to get step template version")
+		return
+	}
+	if snapshot == nil {
+		errorResponse(c, http.StatusNotFound, "NOT_FOUND", "Step template version not found")
+		return
+	}
+
+	successResponse(c, snapshot)
+}
+
+// getStepTemplateUsage lists the recipes using a step template and which need revalidating
+func (a *Application) getStepTemplateUsage(c *gin.Context) {
+	template, ok := a.getStepTemplateOr404(c)
+	if !ok {
+		return
+	}
+
+	usage, err := a.stepTemplateService.Usage(c.Request.Context(), template)
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to get step template usage")
+		return
+	}
+
+	successResponse(c, usage)
+}
+
+// revalidateRecipe re-expands a recipe's step templates at their latest versions and
+// clears its revalidation flag; changed steps make a new draft version
+func (a *Application) revalidateRecipe(c *gin.Context) {
+	id, ok := getObjectID(c, "id")
+	if !ok {
+		return
+	}
+
+	recipe, err := a.stepTemplateService.Revalidate(c.Request.Context(), id, currentUserID(c))
+	if err != nil {
+		serviceErrorResponse(c, err, "Failed to revalidate recipe")
+		return
+	}
+
+	successResponse(c, recipe)
+}
@@ -62,6 +62,14 @@ type UserInfo struct {
 	IsPlatformAdmin  bool
 }
 
+// RoleRecipeApprover is the tenant realm role allowed to approve or reject recipes in review
+const RoleRecipeApprover = "recipe_approver"
+
+// HasRole reports whether the user holds the given role
+func (u *UserInfo) HasRole(role string) bool {
+	return containsRole(u.Roles, role)
+}
+
 // OIDCClaims represents Keycloak JWT claims
 type OIDCClaims struct {
 	jwt.RegisteredClaims
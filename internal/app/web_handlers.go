@@ -14,6 +14,7 @@ import (
 	"github.com/ak/kws/internal/app/middleware"
 	"github.com/ak/kws/internal/domain/models"
 	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/domain/services"
 	"github.com/ak/kws/web"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -170,6 +171,9 @@ func templateFuncMap() template.FuncMap {
 			}
 			return t.Format("Jan 02")
 		},
+		"percent": func(ratio float64) string {
+			return fmt.Sprintf("%.0f%%", ratio*100)
+		},
 	}
 }
 
@@ -271,10 +275,16 @@ func (w *WebHandlers) RegisterRoutes(r *gin.Engine) {
 		protected.GET("/kos/:id", w.KOSDetail)
 		protected.GET("/recipes", w.Recipes)
 		protected.GET("/recipes/new", w.RecipeNew)
+		protected.GET("/recipes/sync", w.RecipeSync)
+		protected.GET("/recipes/import", w.RecipeImport)
+		protected.GET("/library", w.Library)
+		protected.GET("/step-templates", w.StepTemplates)
 		protected.GET("/recipes/:id", w.RecipeDetail)
 		protected.GET("/recipes/:id/edit", w.RecipeEdit)
+		protected.GET("/recipes/:id/nutrition-label", w.RecipeNutritionLabel)
 		protected.GET("/ingredients", w.Ingredients)
 		protected.GET("/ingredients/new", w.IngredientNew)
+		protected.GET("/ingredients/import", w.IngredientImport)
 		protected.GET("/ingredients/:id/edit", w.IngredientEdit)
 		protected.GET("/orders", w.Orders)
 		protected.GET("/orders/new", w.OrderNew)
@@ -1198,6 +1208,7 @@ func (w *WebHandlers) Recipes(c *gin.Context) {
 					"Category":    r.Category,
 					"Status":      string(r.Status), // Convert to string for template comparison
 					"Version":     r.Version,
+					"LiveVersion": r.LiveVersion(),
 					"Description": r.Description,
 					"PrepTime":    r.PrepTime,
 					"CookTime":    r.CookTime,
@@ -1264,6 +1275,60 @@ func (w *WebHandlers) RecipeNew(c *gin.Context) {
 	w.renderTemplate(c, "recipes-form", data)
 }
 
+// RecipeSync renders the fleet recipe sync matrix; the page loads the matrix from the API
+func (w *WebHandlers) RecipeSync(c *gin.Context) {
+	tenantID := ""
+	if tenantIDStr := middleware.GetEffectiveTenantID(c); tenantIDStr != "" {
+		if _, err := primitive.ObjectIDFromHex(tenantIDStr); err == nil {
+			tenantID = tenantIDStr
+		}
+	}
+
+	data := gin.H{
+		"CurrentPage": "recipes",
+		"TenantID":    tenantID,
+	}
+	w.renderTemplate(c, "recipes-sync", data)
+}
+
+// RecipeImport renders the recipe import and export page
+func (w *WebHandlers) RecipeImport(c *gin.Context) {
+	w.renderTemplate(c, "catalog-import", gin.H{
+		"CurrentPage": "recipes",
+		"TenantID":    selectedTenantID(c),
+		"Kind":        "recipes",
+		"Title":       "Recipes",
+		"Formats":     []string{"json", "yaml"},
+		"Accept":      ".json,.yaml,.yml",
+	})
+}
+
+// Library renders the platform recipe library and the library updates to the tenant's forks
+func (w *WebHandlers) Library(c *gin.Context) {
+	w.renderTemplate(c, "library-list", gin.H{
+		"CurrentPage": "library",
+		"TenantID":    selectedTenantID(c),
+	})
+}
+
+// StepTemplates renders the tenant's step templates and the recipes each one flags for revalidation
+func (w *WebHandlers) StepTemplates(c *gin.Context) {
+	w.renderTemplate(c, "step-templates-list", gin.H{
+		"CurrentPage": "step-templates",
+		"TenantID":    selectedTenantID(c),
+	})
+}
+
+// selectedTenantID returns the tenant selected in the session, or "" when none is
+func selectedTenantID(c *gin.Context) string {
+	if tenantIDStr := middleware.GetEffectiveTenantID(c); tenantIDStr != "" {
+		if _, err := primitive.ObjectIDFromHex(tenantIDStr); err == nil {
+			return tenantIDStr
+		}
+	}
+	return ""
+}
+
 // RecipeDetail renders the recipe detail page
 func (w *WebHandlers) RecipeDetail(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -1294,22 +1359,157 @@ func (w *WebHandlers) RecipeDetail(c *gin.Context) {
 		})
 	}
 
+	// Version history (newest first)
+	versionData := []gin.H{}
+	if versions, err := w.handlers.repos.Recipe.ListVersions(ctx, recipeOID); err == nil {
+		for _, v := range versions {
+			versionData = append(versionData, gin.H{
+				"Version":   v.Version,
+				"Name":      v.Recipe.Name,
+				"Status":    v.Recipe.Status,
+				"StepCount": len(v.Recipe.Steps),
+				"CreatedBy": v.CreatedBy,
+				"CreatedAt": v.CreatedAt,
+				"IsCurrent": v.Version == recipe.Version,
+				"IsLive":    v.Version == recipe.LiveVersion(),
+			})
+		}
+	}
+
+	// Sites currently serving the recipe, plus the tenant's sites and regions for targeting
+	siteData := []gin.H{}
+	servingSites := []gin.H{}
+	sites, _, _ := w.handlers.repos.Site.ListByTenant(ctx, recipe.TenantID, 1, 100)
+	for _, site := range sites {
+		entry := gin.H{"ID": site.ID.Hex(), "Name": site.Name, "Code": site.Code}
+		siteData = append(siteData, entry)
+		if recipe.IsPublishedToSite(site.ID) {
+			servingSites = append(servingSites, entry)
+		}
+	}
+	regionData := []gin.H{}
+	regions, _, _ := w.handlers.repos.Region.ListByTenant(ctx, recipe.TenantID, 1, 100)
+	for _, region := range regions {
+		regionData = append(regionData, gin.H{"ID": region.ID.Hex(), "Name": region.Name})
+	}
+
+	// Canary rollouts, newest first; at most one is active
+	siteNames := make(map[primitive.ObjectID]string, len(sites))
+	for _, site := range sites {
+		siteNames[site.ID] = site.Name
+	}
+	var activeRollout gin.H
+	rolloutData := []gin.H{}
+	if rollouts, err := w.handlers.repos.Rollout.ListByRecipe(ctx, recipeOID); err == nil {
+		for _, r := range rollouts {
+			canaryNames := make([]string, 0, len(r.CanarySites))
+			for _, id := range r.CanarySites {
+				if name, ok := siteNames[id]; ok {
+					canaryNames = append(canaryNames, name)
+				} else {
+					canaryNames = append(canaryNames, id.Hex())
+				}
+			}
+			entry := gin.H{
+				"ID":          r.ID.Hex(),
+				"Version":     r.Version,
+				"BaseVersion": r.BaseVersion,
+				"Status":      string(r.Status),
+				"CanarySites": canaryNames,
+				"Thresholds":  r.Thresholds,
+				"Metrics":     r.Metrics,
+				"Reason":      r.Reason,
+				"CreatedBy":   r.CreatedBy,
+				"StartedAt":   r.StartedAt,
+				"CompletedAt": r.CompletedAt,
+			}
+			if r.Status == models.RolloutStatusActive && activeRollout == nil {
+				activeRollout = entry
+			}
+			rolloutData = append(rolloutData, entry)
+		}
+	}
+	live := recipe.LiveVersion()
+	canStartRollout := activeRollout == nil && live > 0 &&
+		recipe.Status == models.RecipeStatusApproved && recipe.Version > live
+
+	// Approvers may review anything they did not write themselves
+	canReview := false
+	if user := middleware.GetUser(c); user != nil && user.HasRole(middleware.RoleRecipeApprover) {
+		canReview = user.ID != recipe.CreatedBy && user.ID != recipe.UpdatedBy
+	}
+
 	data := gin.H{
 		"CurrentPage": "recipes",
 		"Recipe": gin.H{
 			"ID":                      recipe.ID.Hex(),
 			"Name":                    recipe.Name,
-			"Status":                  recipe.Status,
-			"IsActive":                recipe.Status == "published",
+			"Status":                  string(recipe.Status),
+			"IsActive":                recipe.LiveVersion() > 0,
+			"Version":                 recipe.Version,
+			"LiveVersion":             recipe.LiveVersion(),
+			"ApprovedBy":              recipe.ApprovedBy,
+			"ReviewComments":          recipe.ReviewComments,
 			"EstimatedPrepTimeSec":    recipe.EstimatedPrepTimeSec,
 			"EstimatedCookingTimeSec": recipe.EstimatedCookingTimeSec,
 			"RecipeSteps":             stepData,
 		},
-		"Steps": stepData,
+		"Steps":               stepData,
+		"Versions":            versionData,
+		"CanReview":           canReview,
+		"Sites":               siteData,
+		"Regions":             regionData,
+		"ServingSites":        servingSites,
+		"PublishedEverywhere": recipe.LiveVersion() > 0 && len(recipe.PublishedToSites) == 0,
+		"ActiveRollout":       activeRollout,
+		"Rollouts":            rolloutData,
+		"CanStartRollout":     canStartRollout,
 	}
 	w.renderTemplate(c, "recipes-view", data)
 }
 
+// RecipeNutritionLabel renders a printable nutrition label for a recipe at
+// ?pot_percentage= (default 100), computed from the current ingredient data
+func (w *WebHandlers) RecipeNutritionLabel(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	recipeOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid recipe ID")
+		return
+	}
+	pct := 100
+	if param := c.Query("pot_percentage"); param != "" {
+		pct, err = strconv.Atoi(param)
+		if err != nil || pct < 1 || pct > 100 {
+			c.String(http.StatusBadRequest, "pot_percentage must be between 1 and 100")
+			return
+		}
+	}
+
+	recipe, err := w.handlers.repos.Recipe.GetByID(ctx, recipeOID)
+	if err != nil || recipe == nil {
+		c.String(http.StatusNotFound, "Recipe not found")
+		return
+	}
+	ingredients, err := services.LoadRecipeIngredients(ctx, w.handlers.repos.Ingredient, recipe)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to get recipe ingredients")
+		return
+	}
+
+	// The label is a standalone page, not wrapped in the app layout
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err = templates.ExecuteTemplate(c.Writer, "recipes-nutrition-label", gin.H{
+		"Recipe":         recipe,
+		"Nutrition":      services.ComputeNutrition(recipe, ingredients, pct, nil),
+		"PotPercentages": models.StandardPotPercentages,
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Template error: %v", err)
+	}
+}
+
 // RecipeEdit renders the recipe edit form
 func (w *WebHandlers) RecipeEdit(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -1338,6 +1538,7 @@ func (w *WebHandlers) RecipeEdit(c *gin.Context) {
 			"DependsOnSteps": s.DependsOnSteps,
 			"Name":           s.Name,
 			"Description":    s.Description,
+			"Scaling":        s.Scaling,
 		})
 	}
 
@@ -1461,6 +1662,18 @@ func (w *WebHandlers) IngredientNew(c *gin.Context) {
 	w.renderTemplate(c, "ingredients-form", data)
 }
 
+// IngredientImport renders the ingredient import and export page
+func (w *WebHandlers) IngredientImport(c *gin.Context) {
+	w.renderTemplate(c, "catalog-import", gin.H{
+		"CurrentPage": "ingredients",
+		"TenantID":    selectedTenantID(c),
+		"Kind":        "ingredients",
+		"Title":       "Ingredients",
+		"Formats":     []string{"csv", "json"},
+		"Accept":      ".csv,.json",
+	})
+}
+
 // IngredientEdit renders the ingredient edit form
 func (w *WebHandlers) IngredientEdit(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -1522,6 +1735,7 @@ func (w *WebHandlers) Orders(c *gin.Context) {
 		if err == nil {
 			status := c.Query("status")
 			orders, _, _ := w.handlers.repos.Order.ListByTenant(ctx, tenantID, nil, status, 1, 100)
+			_ = services.AnnotateCurrentNames(ctx, w.handlers.repos.Recipe, w.handlers.repos.Ingredient, orders)
 			for _, o := range orders {
 				// Count by status
 				switch o.Status {
@@ -1546,16 +1760,17 @@ func (w *WebHandlers) Orders(c *gin.Context) {
 				}
 
 				orderData = append(orderData, gin.H{
-					"ID":             o.ID.Hex(),
-					"OrderReference": o.OrderReference,
-					"RecipeName":     o.RecipeName,
-					"SiteID":         o.SiteID.Hex(),
-					"SiteName":       siteName,
-					"CustomerName":   o.CustomerName,
-					"Status":         string(o.Status),
-					"Priority":       o.Priority,
-					"CreatedAt":      formatRelativeTime(o.CreatedAt),
-					"CreatedAtUnix":  o.CreatedAt.Unix(),
+					"ID":                o.ID.Hex(),
+					"OrderReference":    o.OrderReference,
+					"RecipeName":        o.RecipeName,
+					"CurrentRecipeName": o.CurrentRecipeName,
+					"SiteID":            o.SiteID.Hex(),
+					"SiteName":          siteName,
+					"CustomerName":      o.CustomerName,
+					"Status":            string(o.Status),
+					"Priority":          o.Priority,
+					"CreatedAt":         formatRelativeTime(o.CreatedAt),
+					"CreatedAtUnix":     o.CreatedAt.Unix(),
 				})
 			}
 
@@ -1652,6 +1867,7 @@ func (w *WebHandlers) OrderDetail(c *gin.Context) {
 	if site, err := w.handlers.repos.Site.GetByID(ctx, order.SiteID); err == nil && site != nil {
 		siteName = site.Name
 	}
+	_ = services.AnnotateCurrentNames(ctx, w.handlers.repos.Recipe, w.handlers.repos.Ingredient, []*models.Order{order})
 
 	data := gin.H{
 		"CurrentPage": "orders",
@@ -1660,6 +1876,7 @@ func (w *WebHandlers) OrderDetail(c *gin.Context) {
 			"OrderReference":      order.OrderReference,
 			"RecipeID":            order.RecipeID.Hex(),
 			"RecipeName":          order.RecipeName,
+			"CurrentRecipeName":   order.CurrentRecipeName,
 			"SiteID":              order.SiteID.Hex(),
 			"SiteName":            siteName,
 			"CustomerName":        order.CustomerName,
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Calibration overrides ingredient parameters, such as ml_per_sec, for the devices of
+// one site. A calibration applies to the whole site, to one kitchen, or to one device
+// of a kitchen; the most specific one wins. Calibrations are never changed: each
+// measurement is a new record and the latest one for a scope is in effect, so the
+// records form the drift history of every device.
+type Calibration struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID       primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID         primitive.ObjectID `bson:"site_id" json:"site_id"`
+	KitchenID      string             `bson:"kitchen_id,omitempty" json:"kitchen_id,omitempty"` // KOS kitchen_id; empty for the whole site
+	DeviceID       string             `bson:"device_id,omitempty" json:"device_id,omitempty"`   // Pump or hydra line; empty for the whole kitchen
+	IngredientID   primitive.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	IngredientName string             `bson:"ingredient_name" json:"ingredient_name"`             // Denormalized for display
+	Parameters     map[string]float64 `bson:"parameters" json:"parameters"`                       // Overridden parameters only; empty reverts to the defaults
+	Source         CalibrationSource  `bson:"source" json:"source"`                               // manual, kos
+	RecordedBy     string             `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"` // User or KOS ID
+	Notes          string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	MeasuredAt     time.Time          `bson:"measured_at" json:"measured_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type CalibrationSource string
+
+const (
+	CalibrationSourceManual CalibrationSource = "manual"
+	CalibrationSourceKOS    CalibrationSource = "kos" // Measured by KOS
+)
+
+// CalibrationForKOS is the full parameter set of an ingredient on one kitchen or device
+// whose calibration differs from the site's
+type CalibrationForKOS struct {
+	KitchenID  string         `json:"kitchen_id"`
+	DeviceID   string         `json:"device_id,omitempty"`
+	Parameters map[string]any `json:"parameters"`
+}
+
+// CalibrationSeries is how one calibrated parameter of one device changed over time
+type CalibrationSeries struct {
+	KitchenID      string             `json:"kitchen_id,omitempty"`
+	DeviceID       string             `json:"device_id,omitempty"`
+	IngredientID   string             `json:"ingredient_id"`
+	IngredientName string             `json:"ingredient_name"`
+	Parameter      string             `json:"parameter"`
+	Points         []CalibrationPoint `json:"points"`    // Oldest first
+	Drift          float64            `json:"drift"`     // Latest value minus the first
+	DriftPct       float64            `json:"drift_pct"` // Drift as a percentage of the first value
+}
+
+type CalibrationPoint struct {
+	MeasuredAt time.Time         `json:"measured_at"`
+	Value      float64           `json:"value"`
+	Source     CalibrationSource `json:"source"`
+}
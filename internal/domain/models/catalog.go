@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+// ImportMode controls what an import does with a row whose name the tenant already uses
+type ImportMode string
+
+const (
+	ImportModeCreate ImportMode = "create" // Existing names are row errors
+	ImportModeUpsert ImportMode = "upsert" // Existing records are updated from the row
+	ImportModeSkip   ImportMode = "skip"   // Existing records are left as they are
+)
+
+// IsValid reports whether the mode is known
+func (m ImportMode) IsValid() bool {
+	switch m {
+	case ImportModeCreate, ImportModeUpsert, ImportModeSkip:
+		return true
+	}
+	return false
+}
+
+// IngredientRecord is an ingredient as it is imported and exported. Records carry no
+// IDs, so a catalog exported from one tenant imports into another.
+type IngredientRecord struct {
+	Name           string         `json:"name"`
+	MoistureType   MoistureType   `json:"moisture_type"`
+	ShelfLifeHours int            `json:"shelf_life_hours,omitempty"`
+	Allergens      []string       `json:"allergens,omitempty"`
+	Nutrition      *NutritionInfo `json:"nutrition,omitempty"`
+	Parameters     map[string]any `json:"parameters,omitempty"`
+	IsActive       *bool          `json:"is_active,omitempty"` // Active unless set to false
+}
+
+// RecipeBundle is a set of recipes as they are imported and exported. Ingredients are
+// referenced by name and resolved against the tenant's catalog on import.
+type RecipeBundle struct {
+	ExportedAt *time.Time     `json:"exported_at,omitempty"`
+	Recipes    []RecipeRecord `json:"recipes"`
+}
+
+// RecipeRecord is the working copy of a recipe without IDs, status or derived data
+type RecipeRecord struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description,omitempty"`
+	Category    string                   `json:"category,omitempty"`
+	CuisineType string                   `json:"cuisine_type,omitempty"`
+	PrepTimeSec int                      `json:"prep_time_sec,omitempty"`
+	CookTimeSec int                      `json:"cook_time_sec,omitempty"`
+	Servings    int                      `json:"servings,omitempty"`
+	Allergens   []string                 `json:"allergens,omitempty"` // Manual allergens; the rest are derived
+	Ingredients []RecipeIngredientRecord `json:"ingredients"`
+	Steps       []RecipeStep             `json:"steps"` // Steps name their ingredient with ingredient_name instead of ingredient_id
+	Parameters  map[string]any           `json:"parameters,omitempty"`
+}
+
+// RecipeIngredientRecord is a recipe ingredient referenced by name
+type RecipeIngredientRecord struct {
+	IngredientName   string       `json:"ingredient_name"`
+	QuantityRequired float64      `json:"quantity_required"`
+	Unit             string       `json:"unit"`
+	PrepNotes        string       `json:"prep_notes,omitempty"`
+	TimingStep       int          `json:"timing_step,omitempty"`
+	IsCritical       bool         `json:"is_critical,omitempty"`
+	Substitutes      []string     `json:"substitutes,omitempty"` // Ingredient names
+	Scaling          *ScalingRule `json:"scaling,omitempty"`
+	MaxExtraFactor   float64      `json:"max_extra_factor,omitempty"`
+}
+
+// ImportResult reports what an import did, or in a dry run would do, with each row
+type ImportResult struct {
+	DryRun  bool        `json:"dry_run"`
+	Mode    ImportMode  `json:"mode"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportRow is the outcome of one imported record
+type ImportRow struct {
+	Row    int           `json:"row"` // 1-based position in the import
+	Name   string        `json:"name"`
+	Action ImportAction  `json:"action"`
+	ID     string        `json:"id,omitempty"` // Empty in a dry run for new records
+	Errors []string      `json:"errors,omitempty"`
+	Issues []RecipeIssue `json:"issues,omitempty"` // Recipe validation errors with their field paths
+}
+
+type ImportAction string
+
+const (
+	ImportActionCreated ImportAction = "created"
+	ImportActionUpdated ImportAction = "updated"
+	ImportActionSkipped ImportAction = "skipped"
+	ImportActionFailed  ImportAction = "failed"
+)
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConsumptionRecord compares what one dispensing step of an order should have put in
+// the pot, from the recipe scaled and modified as ordered, with what KOS measured
+type ConsumptionRecord struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID       primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID         primitive.ObjectID `bson:"site_id" json:"site_id"`
+	OrderID        primitive.ObjectID `bson:"order_id" json:"order_id"`
+	RecipeID       primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
+	RecipeVersion  int                `bson:"recipe_version" json:"recipe_version"`
+	KitchenID      string             `bson:"kitchen_id,omitempty" json:"kitchen_id,omitempty"` // KOS kitchen_id
+	DeviceID       string             `bson:"device_id,omitempty" json:"device_id,omitempty"`
+	StepNumber     int                `bson:"step_number" json:"step_number"`
+	IngredientID   primitive.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	IngredientName string             `bson:"ingredient_name" json:"ingredient_name"`
+	Unit           string             `bson:"unit" json:"unit"` // The step's unit; actuals are converted to it
+	Expected       float64            `bson:"expected" json:"expected"`
+	Actual         float64            `bson:"actual" json:"actual"`
+	Variance       float64            `bson:"variance" json:"variance"`         // Actual minus expected
+	VariancePct    float64            `bson:"variance_pct" json:"variance_pct"` // Variance as a percentage of expected; 0 when nothing was expected
+	RecordedAt     time.Time          `bson:"recorded_at" json:"recorded_at"`
+}
+
+// Dispensers within VarianceTolerancePct of the expected quantity are on target. One
+// is flagged when at least MinVarianceSamples of its dispenses were measured and
+// VarianceConsistentShare of them were off in the same direction.
+const (
+	VarianceTolerancePct    = 5.0
+	MinVarianceSamples      = 5
+	VarianceConsistentShare = 0.8
+)
+
+// Directions a dispenser can be consistently off in
+const (
+	VarianceOver  = "over"
+	VarianceUnder = "under"
+)
+
+// VarianceReport aggregates the consumption records of a site
+type VarianceReport struct {
+	SiteID       primitive.ObjectID `json:"site_id"`
+	Since        time.Time          `json:"since"`
+	Orders       int                `json:"orders"`
+	Samples      int                `json:"samples"`
+	VariancePct  float64            `json:"variance_pct"` // Mean absolute variance of all samples
+	ByIngredient []VarianceGroup    `json:"by_ingredient"`
+	ByDevice     []VarianceGroup    `json:"by_device"`
+	Flagged      []VarianceGroup    `json:"flagged"` // Dispensers consistently over or under
+	GeneratedAt  time.Time          `json:"generated_at"`
+}
+
+// VarianceGroup is the variance of one ingredient, or of one ingredient on one device
+type VarianceGroup struct {
+	KitchenID      string  `json:"kitchen_id,omitempty"`
+	DeviceID       string  `json:"device_id,omitempty"`
+	IngredientID   string  `json:"ingredient_id"`
+	IngredientName string  `json:"ingredient_name"`
+	Unit           string  `json:"unit"`
+	Samples        int     `json:"samples"`
+	Expected       float64 `json:"expected"`
+	Actual         float64 `json:"actual"`
+	Variance       float64 `json:"variance"`
+	VariancePct    float64 `json:"variance_pct"` // Total variance as a percentage of the total expected
+	OverCount      int     `json:"over_count"`
+	UnderCount     int     `json:"under_count"`
+	Trend          string  `json:"trend,omitempty"` // over or under when consistently off
+}
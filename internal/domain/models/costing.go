@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IngredientCost is what a tenant pays for an ingredient, per unit, from a date on. A
+// cost applies to the whole tenant or, as an override, to the sites of one region.
+// Costs are never changed: a new price is a new record, so the records form the
+// ingredient's price history.
+type IngredientCost struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	TenantID       primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	IngredientID   primitive.ObjectID  `bson:"ingredient_id" json:"ingredient_id"`
+	IngredientName string              `bson:"ingredient_name" json:"ingredient_name"`             // Denormalized for display
+	RegionID       *primitive.ObjectID `bson:"region_id,omitempty" json:"region_id,omitempty"`     // Empty for the whole tenant
+	Cost           float64             `bson:"cost" json:"cost"`                                   // Per Unit
+	Unit           string              `bson:"unit" json:"unit"`                                   // A unit of the catalog
+	Currency       string              `bson:"currency" json:"currency"`                           // The tenant's default currency when recorded
+	EffectiveFrom  time.Time           `bson:"effective_from" json:"effective_from"`               // Applies from this time until a later cost takes over
+	RecordedBy     string              `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"` // User ID
+	Notes          string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// MenuPrice is what a site charges for a recipe at a pot size from a date on. Like
+// costs, prices are never changed and form a history.
+type MenuPrice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID      primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID        primitive.ObjectID `bson:"site_id" json:"site_id"`
+	RecipeID      primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
+	RecipeName    string             `bson:"recipe_name" json:"recipe_name"`       // Denormalized for display
+	PotPercentage int                `bson:"pot_percentage" json:"pot_percentage"` // 25, 50, 75, 100
+	Price         float64            `bson:"price" json:"price"`
+	Currency      string             `bson:"currency" json:"currency"`
+	EffectiveFrom time.Time          `bson:"effective_from" json:"effective_from"`
+	RecordedBy    string             `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// RecipeCost is what a recipe costs to make at each pot size, with the ingredient
+// costs in effect at one time for one region
+type RecipeCost struct {
+	RecipeID   string               `json:"recipe_id"`
+	RecipeName string               `json:"recipe_name"`
+	Version    int                  `json:"version"`
+	Currency   string               `json:"currency"`
+	SiteID     string               `json:"site_id,omitempty"`
+	RegionID   string               `json:"region_id,omitempty"`
+	At         time.Time            `json:"at"`
+	Lines      []IngredientCostLine `json:"lines"` // Full pot
+	PotSizes   []PotCost            `json:"pot_sizes"`
+	Uncosted   []string             `json:"uncosted"` // Ingredients without a usable cost, left out of the totals
+	Complete   bool                 `json:"complete"` // Every ingredient was costed
+}
+
+// IngredientCostLine is the cost of one ingredient of a full pot
+type IngredientCostLine struct {
+	IngredientID   string  `json:"ingredient_id"`
+	IngredientName string  `json:"ingredient_name"`
+	Quantity       float64 `json:"quantity"`
+	Unit           string  `json:"unit"`
+	UnitCost       float64 `json:"unit_cost"` // Per CostUnit
+	CostUnit       string  `json:"cost_unit"`
+	Cost           float64 `json:"cost"`
+	RegionOverride bool    `json:"region_override"` // The region's cost was used instead of the tenant's
+}
+
+// PotCost is the cost of a recipe at one pot size and, when the site has a menu price
+// for it, the margin
+type PotCost struct {
+	PotPercentage int      `json:"pot_percentage"`
+	Cost          float64  `json:"cost"`
+	Price         *float64 `json:"price,omitempty"`
+	Margin        *float64 `json:"margin,omitempty"`        // Price minus cost
+	FoodCostPct   *float64 `json:"food_cost_pct,omitempty"` // Cost as a percentage of the price
+}
+
+// CostHistory is what the orders a site completed cost to make, per day and per recipe
+type CostHistory struct {
+	SiteID   string              `json:"site_id"`
+	Currency string              `json:"currency"`
+	Since    time.Time           `json:"since"`
+	Until    time.Time           `json:"until"`
+	Days     []CostPeriod        `json:"days"`
+	Recipes  []RecipeCostSummary `json:"recipes"`
+	Total    CostPeriod          `json:"total"`
+}
+
+// CostPeriod totals the completed orders of one day, in the site's time zone
+type CostPeriod struct {
+	Date        string   `json:"date,omitempty"` // YYYY-MM-DD
+	Orders      int      `json:"orders"`
+	Cost        float64  `json:"cost"`
+	Revenue     float64  `json:"revenue"`                 // Menu prices of the orders that had one
+	Unpriced    int      `json:"unpriced"`                // Orders without a menu price, left out of the revenue and margin
+	Incomplete  int      `json:"incomplete"`              // Orders with an ingredient that could not be costed
+	Margin      float64  `json:"margin"`                  // Revenue minus the cost of the priced orders
+	FoodCostPct *float64 `json:"food_cost_pct,omitempty"` // Cost of the priced orders as a percentage of the revenue
+}
+
+// RecipeCostSummary totals the completed orders of one recipe
+type RecipeCostSummary struct {
+	RecipeID   string `json:"recipe_id"`
+	RecipeName string `json:"recipe_name"`
+	CostPeriod
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CanisterLoad records one lot of an ingredient loaded into a kitchen canister. KOS
+// reports loads and unloads; loading a canister again ends its previous load.
+type CanisterLoad struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID         primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID           primitive.ObjectID `bson:"site_id" json:"site_id"`
+	KitchenID        string             `bson:"kitchen_id" json:"kitchen_id"`   // KOS kitchen_id
+	CanisterID       string             `bson:"canister_id" json:"canister_id"` // KOS canister or hydra slot
+	IngredientID     primitive.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	IngredientName   string             `bson:"ingredient_name" json:"ingredient_name"` // Denormalized for display
+	Lot              string             `bson:"lot,omitempty" json:"lot,omitempty"`
+	ShelfLifeMinutes int                `bson:"shelf_life_minutes,omitempty" json:"shelf_life_minutes,omitempty"` // Ingredient shelf life when loaded
+	LoadedAt         time.Time          `bson:"loaded_at" json:"loaded_at"`
+	ExpiresAt        *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Unset for ingredients without a shelf life
+	UnloadedAt       *time.Time         `bson:"unloaded_at,omitempty" json:"unloaded_at,omitempty"`
+	ReportedAt       time.Time          `bson:"reported_at" json:"reported_at"`
+}
+
+// FreshnessStatus is where a canister load stands against its expiry
+type FreshnessStatus string
+
+const (
+	FreshnessFresh     FreshnessStatus = "fresh"
+	FreshnessExpiring  FreshnessStatus = "expiring" // Within the warning window
+	FreshnessExpired   FreshnessStatus = "expired"
+	FreshnessUntracked FreshnessStatus = "untracked" // Ingredient has no shelf life
+)
+
+// ExpiryWarningWindow is how long before expiry a load is reported as expiring. Short
+// shelf lives warn at a quarter of their length instead.
+const ExpiryWarningWindow = time.Hour
+
+// Active reports whether the lot is still loaded
+func (l *CanisterLoad) Active() bool {
+	return l.UnloadedAt == nil
+}
+
+// Freshness returns the status of the load at a time
+func (l *CanisterLoad) Freshness(at time.Time) FreshnessStatus {
+	if l.ExpiresAt == nil {
+		return FreshnessUntracked
+	}
+	if !at.Before(*l.ExpiresAt) {
+		return FreshnessExpired
+	}
+	window := ExpiryWarningWindow
+	if quarter := time.Duration(l.ShelfLifeMinutes) * time.Minute / 4; quarter < window {
+		window = quarter
+	}
+	if l.ExpiresAt.Sub(at) <= window {
+		return FreshnessExpiring
+	}
+	return FreshnessFresh
+}
+
+// FreshnessReport lists the canister loads of a site for food-safety audits: those
+// loaded now and those unloaded since the start of the report
+type FreshnessReport struct {
+	SiteID      primitive.ObjectID  `json:"site_id"`
+	Since       time.Time           `json:"since"`
+	Canisters   []CanisterFreshness `json:"canisters"`
+	Alerts      []FreshnessAlert    `json:"alerts"`
+	GeneratedAt time.Time           `json:"generated_at"`
+}
+
+// CanisterFreshness is a canister load with its status when the report was generated
+type CanisterFreshness struct {
+	CanisterLoad
+	Status           FreshnessStatus `json:"status"`
+	MinutesRemaining *int            `json:"minutes_remaining,omitempty"` // Until expiry, for loaded lots with a shelf life
+	// ExpiredInService is set when a lot stayed loaded past its expiry
+	ExpiredInService bool `json:"expired_in_service,omitempty"`
+}
+
+// FreshnessAlert warns about a loaded lot that is about to expire or has expired
+type FreshnessAlert struct {
+	Severity       string    `json:"severity"` // warning (expiring), critical (expired)
+	KitchenID      string    `json:"kitchen_id"`
+	CanisterID     string    `json:"canister_id"`
+	IngredientID   string    `json:"ingredient_id"`
+	IngredientName string    `json:"ingredient_name"`
+	Lot            string    `json:"lot,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Message        string    `json:"message"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ways a recipe can use an ingredient
+const (
+	IngredientUseListed     = "listed"     // In the recipe's ingredient list
+	IngredientUseStep       = "step"       // Added by a step
+	IngredientUseSubstitute = "substitute" // Allowed as a substitute of a listed ingredient
+)
+
+// IngredientUsage lists every recipe that uses or used an ingredient
+type IngredientUsage struct {
+	IngredientID   string        `json:"ingredient_id"`
+	IngredientName string        `json:"ingredient_name"`
+	Recipes        []RecipeUsage `json:"recipes"`
+	LiveRecipes    int           `json:"live_recipes"` // Recipes whose live version uses the ingredient
+}
+
+// RecipeUsage is how one recipe uses an ingredient across its versions
+type RecipeUsage struct {
+	RecipeID       string      `json:"recipe_id"`
+	RecipeName     string      `json:"recipe_name"`
+	Status         string      `json:"status"`
+	Version        int         `json:"version"`                // Working copy
+	Uses           []string    `json:"uses,omitempty"`         // How the working copy uses it; empty if it no longer does
+	Versions       []int       `json:"versions"`               // Every version that uses it, oldest first
+	LiveVersion    int         `json:"live_version,omitempty"` // Version served to sites, 0 if not published
+	LiveUses       bool        `json:"live_uses"`              // Whether the live version uses it
+	PublishedToAll bool        `json:"published_to_all"`       // Live at every site of the tenant
+	PublishedSites []SiteUsage `json:"published_sites"`        // Sites the recipe is live at, unless published to all
+	PublishedAt    *time.Time  `json:"published_at,omitempty"`
+}
+
+// SiteUsage identifies a site a recipe is published to
+type SiteUsage struct {
+	SiteID string `json:"site_id"`
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+}
+
+// IngredientReplacement is the result of replacing an ingredient across recipes
+type IngredientReplacement struct {
+	FromIngredientID primitive.ObjectID `json:"from_ingredient_id"`
+	ToIngredientID   primitive.ObjectID `json:"to_ingredient_id"`
+	Recipes          []*Recipe          `json:"recipes"` // The new working copies, in draft
+}
+
+// IngredientRename reports what renaming an ingredient changed. Working copies that
+// are not served to sites take the new name; live versions keep the old one until the
+// recipe is edited and published again.
+type IngredientRename struct {
+	OldName   string      `json:"old_name"`
+	NewName   string      `json:"new_name"`
+	Updated   []RecipeRef `json:"updated"`   // Working copies renamed in place
+	Published []RecipeRef `json:"published"` // Recipes whose live version still carries the old name
+}
+
+// RecipeRef identifies a recipe and the versions it is at
+type RecipeRef struct {
+	RecipeID    string `json:"recipe_id"`
+	RecipeName  string `json:"recipe_name"`
+	Status      string `json:"status"`
+	Version     int    `json:"version"`
+	LiveVersion int    `json:"live_version,omitempty"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InventoryLevel is how much of an ingredient is loaded in one kitchen's canisters or
+// hydras. KOS reports the level; KWS deducts the expected consumption of each order
+// that completes until the next report replaces it.
+type InventoryLevel struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID         primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID           primitive.ObjectID `bson:"site_id" json:"site_id"`
+	KitchenID        string             `bson:"kitchen_id" json:"kitchen_id"` // KOS kitchen_id
+	IngredientID     primitive.ObjectID `bson:"ingredient_id" json:"ingredient_id"`
+	IngredientName   string             `bson:"ingredient_name" json:"ingredient_name"` // Denormalized for display
+	Quantity         float64            `bson:"quantity" json:"quantity"`               // On hand now
+	Unit             string             `bson:"unit" json:"unit"`                       // grams, ml
+	ReportedQuantity float64            `bson:"reported_quantity" json:"reported_quantity"`
+	ReportedAt       time.Time          `bson:"reported_at" json:"reported_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// InventoryForecast projects a site's stock over its open orders, in execution order.
+// Quantities are in grams; liquids are weighed by the ingredient density.
+type InventoryForecast struct {
+	SiteID      primitive.ObjectID   `json:"site_id"`
+	Tracked     bool                 `json:"tracked"` // False until KOS first reports inventory
+	OpenOrders  int                  `json:"open_orders"`
+	Ingredients []IngredientForecast `json:"ingredients"`
+	GeneratedAt time.Time            `json:"generated_at"`
+}
+
+// IngredientForecast is the projected stock of one ingredient at a site
+type IngredientForecast struct {
+	IngredientID string     `json:"ingredient_id"`
+	Name         string     `json:"name"`
+	OnHandG      float64    `json:"on_hand_g"`
+	CommittedG   float64    `json:"committed_g"` // Needed by open orders
+	RemainingG   float64    `json:"remaining_g"` // Negative when open orders need more than is loaded
+	OpenOrders   int        `json:"open_orders"` // Open orders using the ingredient
+	ShortOrders  int        `json:"short_orders,omitempty"`
+	DepletesAt   *time.Time `json:"depletes_at,omitempty"` // Execution time of the first open order the stock cannot cover
+}
+
+// RecipeAvailability says whether a site can cook a recipe with the stock left after
+// its open orders
+type RecipeAvailability struct {
+	RecipeID   string               `json:"recipe_id"`
+	RecipeName string               `json:"recipe_name"`
+	Version    int                  `json:"version"`
+	Available  bool                 `json:"available"`
+	Shortages  []IngredientShortage `json:"shortages,omitempty"`
+}
+
+// IngredientShortage is an ingredient a site does not have enough of
+type IngredientShortage struct {
+	IngredientID string  `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	RequiredG    float64 `json:"required_g"`
+	AvailableG   float64 `json:"available_g"`
+}
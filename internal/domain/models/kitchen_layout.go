@@ -0,0 +1,124 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KitchenLayout describes the storage of a kitchen and which ingredient sits where.
+// Layouts are versioned: every change is saved as a new version and the highest one
+// is current. KOS receives the current layout of each of its kitchens.
+type KitchenLayout struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID     primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	SiteID       primitive.ObjectID `bson:"site_id" json:"site_id"`
+	KitchenID    primitive.ObjectID `bson:"kitchen_id" json:"kitchen_id"`
+	KOSKitchenID string             `bson:"kos_kitchen_id" json:"kos_kitchen_id"` // Kitchen.KitchenID, e.g. "kitchen_1"
+	Version      int                `bson:"version" json:"version"`
+	Slots        []StorageSlot      `bson:"slots" json:"slots"`
+	HydraLines   []HydraLine        `bson:"hydra_lines" json:"hydra_lines"`
+	PyroCount    int                `bson:"pyro_count" json:"pyro_count"`
+	ChangeNote   string             `bson:"change_note,omitempty" json:"change_note,omitempty"`
+	CreatedBy    string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// StorageSlot is a storage position the hulk picks canisters from
+type StorageSlot struct {
+	SlotID         string              `bson:"slot_id" json:"slot_id"`
+	CapacityG      float64             `bson:"capacity_g,omitempty" json:"capacity_g,omitempty"`
+	IngredientID   *primitive.ObjectID `bson:"ingredient_id,omitempty" json:"ingredient_id,omitempty"` // Empty slot if unset
+	IngredientName string              `bson:"ingredient_name,omitempty" json:"ingredient_name,omitempty"`
+}
+
+// HydraLine is a liquid dispensing line
+type HydraLine struct {
+	LineID         string              `bson:"line_id" json:"line_id"`
+	CapacityML     float64             `bson:"capacity_ml,omitempty" json:"capacity_ml,omitempty"`
+	IngredientID   *primitive.ObjectID `bson:"ingredient_id,omitempty" json:"ingredient_id,omitempty"` // Unused line if unset
+	IngredientName string              `bson:"ingredient_name,omitempty" json:"ingredient_name,omitempty"`
+}
+
+// SlotFor returns the storage slot holding an ingredient, if any
+func (l *KitchenLayout) SlotFor(ingredientID primitive.ObjectID) *StorageSlot {
+	for i := range l.Slots {
+		if id := l.Slots[i].IngredientID; id != nil && *id == ingredientID {
+			return &l.Slots[i]
+		}
+	}
+	return nil
+}
+
+// LineFor returns the hydra line dispensing an ingredient, if any
+func (l *KitchenLayout) LineFor(ingredientID primitive.ObjectID) *HydraLine {
+	for i := range l.HydraLines {
+		if id := l.HydraLines[i].IngredientID; id != nil && *id == ingredientID {
+			return &l.HydraLines[i]
+		}
+	}
+	return nil
+}
+
+// KitchenRecipeCheck says whether a kitchen's current layout can run a recipe
+type KitchenRecipeCheck struct {
+	RecipeID   string              `json:"recipe_id"`
+	RecipeName string              `json:"recipe_name"`
+	Version    int                 `json:"version"`
+	Runnable   bool                `json:"runnable"`
+	Missing    []MissingIngredient `json:"missing,omitempty"`
+}
+
+// MissingIngredient is a recipe ingredient the layout has nowhere to take from
+type MissingIngredient struct {
+	IngredientID string `json:"ingredient_id"`
+	Name         string `json:"name"`
+	Needs        string `json:"needs"` // slot (solids, picked canisters), hydra_line (liquids) or storage (either)
+}
+
+// KitchenLayoutForKOS is the layout format KOS applies to a kitchen
+type KitchenLayoutForKOS struct {
+	KitchenID  string            `json:"kitchen_id"` // KOS kitchen_id
+	Version    int               `json:"version"`
+	Slots      []SlotForKOS      `json:"slots"`
+	HydraLines []HydraLineForKOS `json:"hydra_lines"`
+	PyroCount  int               `json:"pyro_count"`
+}
+
+type SlotForKOS struct {
+	SlotID         string  `json:"slot_id"`
+	CapacityG      float64 `json:"capacity_g,omitempty"`
+	IngredientID   string  `json:"ingredient_id,omitempty"`
+	IngredientName string  `json:"ingredient_name,omitempty"`
+}
+
+type HydraLineForKOS struct {
+	LineID         string  `json:"line_id"`
+	CapacityML     float64 `json:"capacity_ml,omitempty"`
+	IngredientID   string  `json:"ingredient_id,omitempty"`
+	IngredientName string  `json:"ingredient_name,omitempty"`
+}
+
+// ToKOSFormat converts a KitchenLayout to the KOS format
+func (l *KitchenLayout) ToKOSFormat() KitchenLayoutForKOS {
+	out := KitchenLayoutForKOS{
+		KitchenID:  l.KOSKitchenID,
+		Version:    l.Version,
+		Slots:      make([]SlotForKOS, len(l.Slots)),
+		HydraLines: make([]HydraLineForKOS, len(l.HydraLines)),
+		PyroCount:  l.PyroCount,
+	}
+	for i, s := range l.Slots {
+		out.Slots[i] = SlotForKOS{SlotID: s.SlotID, CapacityG: s.CapacityG, IngredientName: s.IngredientName}
+		if s.IngredientID != nil {
+			out.Slots[i].IngredientID = s.IngredientID.Hex()
+		}
+	}
+	for i, h := range l.HydraLines {
+		out.HydraLines[i] = HydraLineForKOS{LineID: h.LineID, CapacityML: h.CapacityML, IngredientName: h.IngredientName}
+		if h.IngredientID != nil {
+			out.HydraLines[i].IngredientID = h.IngredientID.Hex()
+		}
+	}
+	return out
+}
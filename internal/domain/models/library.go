@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The platform library holds reference recipes and ingredients maintained by platform
+// admins. They are Recipe and Ingredient documents without a tenant, kept in their own
+// collections so they never reach a kitchen. Tenants see a library recipe once it is
+// released, at its released version, and fork it into their own catalog.
+
+// RecipeUpstream links a recipe forked from the platform library to its library recipe
+type RecipeUpstream struct {
+	LibraryRecipeID primitive.ObjectID `bson:"library_recipe_id" json:"library_recipe_id"`
+	Version         int                `bson:"version" json:"version"` // Library version last forked or merged; the base of the next merge
+	ForkedAt        time.Time          `bson:"forked_at" json:"forked_at"`
+	MergedAt        *time.Time         `bson:"merged_at,omitempty" json:"merged_at,omitempty"`
+}
+
+// UpstreamStatus compares a fork with its library recipe and previews merging the
+// library's changes since the fork last took them
+type UpstreamStatus struct {
+	RecipeID          string           `json:"recipe_id"`
+	RecipeName        string           `json:"recipe_name"`
+	RecipeVersion     int              `json:"recipe_version"`
+	LibraryRecipeID   string           `json:"library_recipe_id"`
+	LibraryRecipeName string           `json:"library_recipe_name"`
+	BaseVersion       int              `json:"base_version"`   // Library version the fork last took
+	LatestVersion     int              `json:"latest_version"` // Library version released now
+	Behind            bool             `json:"behind"`
+	Changes           []UpstreamChange `json:"changes"`
+	NewIngredients    []string         `json:"new_ingredients,omitempty"` // Library ingredients a merge adds to the tenant catalog
+}
+
+// Conflicts counts the changes the tenant also made differently
+func (s *UpstreamStatus) Conflicts() int {
+	n := 0
+	for _, change := range s.Changes {
+		if change.Conflict {
+			n++
+		}
+	}
+	return n
+}
+
+// UpstreamChange is one change the library made since the fork's base version
+type UpstreamChange struct {
+	Path     string             `json:"path"` // e.g. description, ingredients[Rice], steps[3]
+	Kind     UpstreamChangeKind `json:"kind"`
+	Conflict bool               `json:"conflict"` // The fork changed the same thing differently
+}
+
+type UpstreamChangeKind string
+
+const (
+	UpstreamChangeAdded   UpstreamChangeKind = "added"
+	UpstreamChangeRemoved UpstreamChangeKind = "removed"
+	UpstreamChangeChanged UpstreamChangeKind = "changed"
+)
+
+// MergeResolution decides conflicts when merging library changes into a fork
+type MergeResolution string
+
+const (
+	MergeResolutionNone   MergeResolution = ""       // Conflicts fail the merge
+	MergeResolutionOurs   MergeResolution = "ours"   // Conflicts keep the tenant's version
+	MergeResolutionTheirs MergeResolution = "theirs" // Conflicts take the library's version
+)
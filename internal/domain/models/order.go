@@ -23,10 +23,16 @@ type Order struct {
 
 	// Single recipe per order (enables capacity-based fetching by KOS)
 	RecipeID      primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
-	RecipeName    string             `bson:"recipe_name" json:"recipe_name"`       // Denormalized for display
-	PotPercentage int                `bson:"pot_percentage" json:"pot_percentage"` // 25, 50, 75, 100
+	RecipeName    string             `bson:"recipe_name" json:"recipe_name"`                           // Denormalized for display
+	RecipeVersion int                `bson:"recipe_version,omitempty" json:"recipe_version,omitempty"` // Version served to the site when ordered
+	PotPercentage int                `bson:"pot_percentage" json:"pot_percentage"`                     // 25, 50, 75, 100
 	Modifications []Modification     `bson:"modifications,omitempty" json:"modifications,omitempty"`
 
+	// Customer allergy guard: orders whose dish still contains a declared allergy are
+	// rejected, or created with the conflicts listed when the caller asked to flag them
+	CustomerAllergies []string `bson:"customer_allergies,omitempty" json:"customer_allergies,omitempty"` // Allergies the customer declared
+	AllergenConflicts []string `bson:"allergen_conflicts,omitempty" json:"allergen_conflicts,omitempty"` // Declared allergies the dish still contains; set when flagged instead of rejected
+
 	Status              OrderStatus    `bson:"status" json:"status"`
 	Priority            int            `bson:"priority" json:"priority"`
 	ExecutionTime       time.Time      `bson:"execution_time" json:"execution_time"` // When to execute
@@ -36,6 +42,7 @@ type Order struct {
 	Notes               string         `bson:"notes,omitempty" json:"notes,omitempty"`
 	SpecialInstructions string         `bson:"special_instructions,omitempty" json:"special_instructions,omitempty"`
 	Metadata            map[string]any `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	Options             map[string]any `bson:"options,omitempty" json:"options,omitempty"`
 	Source              OrderSource    `bson:"source" json:"source"` // kws_ui, api, pos_integration, kos_local
 	KOSSyncStatus       KOSSyncStatus  `bson:"kos_sync_status" json:"kos_sync_status"`
 	KOSSyncedAt         *time.Time     `bson:"kos_synced_at,omitempty" json:"kos_synced_at,omitempty"`
@@ -48,6 +55,12 @@ type Order struct {
 	// Task and equipment info synced from KOS
 	Tasks     []OrderTask     `bson:"tasks,omitempty" json:"tasks,omitempty"`
 	Equipment *OrderEquipment `bson:"equipment,omitempty" json:"equipment,omitempty"`
+
+	// Set once the order's expected consumption is deducted from the kitchen inventory
+	ConsumptionRecordedAt *time.Time `bson:"consumption_recorded_at,omitempty" json:"consumption_recorded_at,omitempty"`
+
+	// Names are kept as ordered; the current one is filled in on reads when the recipe was renamed since
+	CurrentRecipeName string `bson:"-" json:"current_recipe_name,omitempty"`
 }
 
 // OrderItem is used for API requests when creating multiple orders at once
@@ -62,25 +75,70 @@ type OrderItem struct {
 	Modifications []Modification     `bson:"modifications,omitempty" json:"modifications,omitempty"`
 }
 
+// Modification changes one recipe ingredient for an order. Orders placed before
+// modifications were resolved only carry the free-text Ingredient name.
 type Modification struct {
-	Type       string `bson:"type" json:"type"`             // exclude, substitute, extra
-	Ingredient string `bson:"ingredient" json:"ingredient"` // Ingredient name
-	Notes      string `bson:"notes,omitempty" json:"notes,omitempty"`
+	Type         ModificationType    `bson:"type" json:"type"`
+	IngredientID *primitive.ObjectID `bson:"ingredient_id,omitempty" json:"ingredient_id,omitempty"`
+	Ingredient   string              `bson:"ingredient" json:"ingredient"` // Ingredient name, denormalized
+	SubstituteID *primitive.ObjectID `bson:"substitute_id,omitempty" json:"substitute_id,omitempty"`
+	Substitute   string              `bson:"substitute,omitempty" json:"substitute,omitempty"`
+	Quantity     float64             `bson:"quantity,omitempty" json:"quantity,omitempty"` // At the order's pot size: the substitute's amount, or the amount added by an extra
+	Unit         string              `bson:"unit,omitempty" json:"unit,omitempty"`
+	Notes        string              `bson:"notes,omitempty" json:"notes,omitempty"`
+
+	// Current names, filled in on reads when the ingredient or substitute was renamed since
+	CurrentIngredient string `bson:"-" json:"current_ingredient,omitempty"`
+	CurrentSubstitute string `bson:"-" json:"current_substitute,omitempty"`
+}
+
+// AllergyPolicy decides what happens to an order whose dish contains a declared allergy
+type AllergyPolicy string
+
+const (
+	AllergyPolicyReject AllergyPolicy = "reject" // Default: the order is refused
+	AllergyPolicyFlag   AllergyPolicy = "flag"   // Orders are created with AllergenConflicts set
+)
+
+type ModificationType string
+
+const (
+	ModificationExclude    ModificationType = "exclude"    // Leave the ingredient out; not allowed for critical ingredients
+	ModificationSubstitute ModificationType = "substitute" // Use one of the ingredient's substitutes instead
+	ModificationExtra      ModificationType = "extra"      // Add more, up to the ingredient's MaxExtraFactor
+)
+
+// IsValid reports whether t is a known modification type
+func (t ModificationType) IsValid() bool {
+	switch t {
+	case ModificationExclude, ModificationSubstitute, ModificationExtra:
+		return true
+	}
+	return false
 }
 
 // OrderTask represents a task synced from KOS (L4 task)
 type OrderTask struct {
-	TaskID          string     `bson:"task_id" json:"task_id"`                                       // e.g., "order_123|step_1"
-	StepNumber      int        `bson:"step_number" json:"step_number"`                               // Sequential step number
-	Action          string     `bson:"action" json:"action"`                                         // L4 action name
-	Status          string     `bson:"status" json:"status"`                                         // pending, ready, running, completed, failed, blocked
-	Parameters      string     `bson:"parameters,omitempty" json:"parameters,omitempty"`             // JSON string
-	DependsOnTasks  []string   `bson:"depends_on_tasks,omitempty" json:"depends_on_tasks,omitempty"` // Task IDs this depends on
-	ActualStartTime *time.Time `bson:"actual_start_time,omitempty" json:"actual_start_time,omitempty"`
-	ActualEndTime   *time.Time `bson:"actual_end_time,omitempty" json:"actual_end_time,omitempty"`
-	ErrorMessage    string     `bson:"error_message,omitempty" json:"error_message,omitempty"`
-	ErrorCode       string     `bson:"error_code,omitempty" json:"error_code,omitempty"`
-	L2Tasks         []L2Task   `bson:"l2_tasks,omitempty" json:"l2_tasks,omitempty"` // L2 subtasks
+	TaskID          string        `bson:"task_id" json:"task_id"`                                       // e.g., "order_123|step_1"
+	StepNumber      int           `bson:"step_number" json:"step_number"`                               // Sequential step number
+	Action          string        `bson:"action" json:"action"`                                         // L4 action name
+	Status          string        `bson:"status" json:"status"`                                         // pending, ready, running, completed, failed, blocked
+	Parameters      string        `bson:"parameters,omitempty" json:"parameters,omitempty"`             // JSON string
+	DependsOnTasks  []string      `bson:"depends_on_tasks,omitempty" json:"depends_on_tasks,omitempty"` // Task IDs this depends on
+	ActualStartTime *time.Time    `bson:"actual_start_time,omitempty" json:"actual_start_time,omitempty"`
+	ActualEndTime   *time.Time    `bson:"actual_end_time,omitempty" json:"actual_end_time,omitempty"`
+	ErrorMessage    string        `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	ErrorCode       string        `bson:"error_code,omitempty" json:"error_code,omitempty"`
+	L2Tasks         []L2Task      `bson:"l2_tasks,omitempty" json:"l2_tasks,omitempty"`   // L2 subtasks
+	Dispensed       *TaskDispense `bson:"dispensed,omitempty" json:"dispensed,omitempty"` // Measured by KOS on dispensing tasks
+}
+
+// TaskDispense is what a dispensing task actually put in the pot
+type TaskDispense struct {
+	IngredientID string  `bson:"ingredient_id,omitempty" json:"ingredient_id,omitempty"` // The step's ingredient if unset
+	Quantity     float64 `bson:"quantity" json:"quantity"`
+	Unit         string  `bson:"unit" json:"unit"`                               // grams, kg, ml or l
+	DeviceID     string  `bson:"device_id,omitempty" json:"device_id,omitempty"` // Dispenser used
 }
 
 // L2Task represents a subtask within an L4 task
@@ -150,15 +208,24 @@ type OrderForKOS struct {
 	RecipeName          string               `json:"recipe_name"`
 	PotPercentage       int                  `json:"pot_percentage"`
 	Modifications       []ModificationForKOS `json:"modifications,omitempty"`
+	CustomerAllergies   []string             `json:"customer_allergies,omitempty"`
+	AllergenConflicts   []string             `json:"allergen_conflicts,omitempty"`
 	Priority            string               `json:"priority"`
 	ExecutionTime       *time.Time           `json:"execution_time,omitempty"`
 	SpecialInstructions string               `json:"special_instructions,omitempty"`
+	Recipe              *RecipeForKOS        `json:"recipe,omitempty"`           // Recipe scaled to PotPercentage
+	BlockedKitchens     []string             `json:"blocked_kitchens,omitempty"` // Kitchens whose only source of a critical ingredient has expired
 }
 
 type ModificationForKOS struct {
-	Type       string `json:"type"`
-	Ingredient string `json:"ingredient"`
-	Notes      string `json:"notes,omitempty"`
+	Type         string  `json:"type"`
+	IngredientID string  `json:"ingredient_id,omitempty"`
+	Ingredient   string  `json:"ingredient"`
+	SubstituteID string  `json:"substitute_id,omitempty"`
+	Substitute   string  `json:"substitute,omitempty"`
+	Quantity     float64 `json:"quantity,omitempty"`
+	Unit         string  `json:"unit,omitempty"`
+	Notes        string  `json:"notes,omitempty"`
 }
 
 // OrderStatusUpdate represents a status update from KOS
@@ -192,10 +259,19 @@ func (o *Order) ToKOSFormat() OrderForKOS {
 	mods := make([]ModificationForKOS, len(o.Modifications))
 	for i, mod := range o.Modifications {
 		mods[i] = ModificationForKOS{
-			Type:       mod.Type,
+			Type:       string(mod.Type),
 			Ingredient: mod.Ingredient,
+			Substitute: mod.Substitute,
+			Quantity:   mod.Quantity,
+			Unit:       mod.Unit,
 			Notes:      mod.Notes,
 		}
+		if mod.IngredientID != nil {
+			mods[i].IngredientID = mod.IngredientID.Hex()
+		}
+		if mod.SubstituteID != nil {
+			mods[i].SubstituteID = mod.SubstituteID.Hex()
+		}
 	}
 
 	return OrderForKOS{
@@ -207,6 +283,8 @@ func (o *Order) ToKOSFormat() OrderForKOS {
 		RecipeName:          o.RecipeName,
 		PotPercentage:       o.PotPercentage,
 		Modifications:       mods,
+		CustomerAllergies:   o.CustomerAllergies,
+		AllergenConflicts:   o.AllergenConflicts,
 		Priority:            fmt.Sprintf("%d", o.Priority),
 		ExecutionTime:       &o.ExecutionTime,
 		SpecialInstructions: o.SpecialInstructions,
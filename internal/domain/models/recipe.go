@@ -22,6 +22,10 @@ type Ingredient struct {
 	IsActive         bool               `bson:"is_active" json:"is_active"`
 	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+
+	UpstreamID *primitive.ObjectID `bson:"upstream_id,omitempty" json:"upstream_id,omitempty"` // Platform library ingredient it was forked from or mapped to
+
+	Rename *IngredientRename `bson:"-" json:"rename,omitempty"` // Set in the response to a rename
 }
 
 type MoistureType string
@@ -58,14 +62,24 @@ type Recipe struct {
 	Servings                int                  `bson:"servings" json:"servings"`
 	EstimatedPrepTimeSec    int                  `bson:"estimated_prep_time_sec" json:"estimated_prep_time_sec"`
 	EstimatedCookingTimeSec int                  `bson:"estimated_cooking_time_sec" json:"estimated_cooking_time_sec"`
-	AllergenWarnings        []string             `bson:"allergen_warnings,omitempty" json:"allergen_warnings,omitempty"`
+	AllergenWarnings        []string             `bson:"allergen_warnings,omitempty" json:"allergen_warnings,omitempty"` // Every allergen in Allergens
+	Allergens               []RecipeAllergen     `bson:"allergens,omitempty" json:"allergens,omitempty"`                 // Where each allergen comes from
+	Nutrition               *RecipeNutrition     `bson:"nutrition,omitempty" json:"nutrition,omitempty"`                 // Full pot, derived from ingredients
 	Ingredients             []RecipeIngredient   `bson:"ingredients" json:"ingredients"`
 	Steps                   []RecipeStep         `bson:"steps" json:"steps"`
+	SourceSteps             []RecipeStep         `bson:"source_steps,omitempty" json:"source_steps,omitempty"`             // Steps as authored when some stand for step templates; Steps holds their expansion
+	StepTemplates           []RecipeTemplateUse  `bson:"step_templates,omitempty" json:"step_templates,omitempty"`         // Template versions Steps were expanded from
+	TemplatesOutdated       bool                 `bson:"templates_outdated,omitempty" json:"templates_outdated,omitempty"` // A step template changed since; the recipe needs revalidating
 	Parameters              map[string]any       `bson:"parameters,omitempty" json:"parameters,omitempty"`
 	Status                  RecipeStatus         `bson:"status" json:"status"`
 	Version                 int                  `bson:"version" json:"version"`
 	PublishedAt             *time.Time           `bson:"published_at,omitempty" json:"published_at,omitempty"`
 	PublishedToSites        []primitive.ObjectID `bson:"published_to_sites,omitempty" json:"published_to_sites,omitempty"`
+	PublishedVersion        int                  `bson:"published_version,omitempty" json:"published_version,omitempty"` // Version served to sites; the working copy may be newer
+	ApprovedBy              string               `bson:"approved_by,omitempty" json:"approved_by,omitempty"`
+	ApprovedAt              *time.Time           `bson:"approved_at,omitempty" json:"approved_at,omitempty"`
+	ReviewComments          []ReviewComment      `bson:"review_comments,omitempty" json:"review_comments,omitempty"` // Review trail, oldest first
+	Upstream                *RecipeUpstream      `bson:"upstream,omitempty" json:"upstream,omitempty"`               // Platform library recipe this one was forked from
 	CreatedBy               string               `bson:"created_by,omitempty" json:"created_by,omitempty"`
 	UpdatedBy               string               `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
 	CreatedAt               time.Time            `bson:"created_at" json:"created_at"`
@@ -74,6 +88,49 @@ type Recipe struct {
 
 type RecipeStatus string
 
+// LiveVersion returns the version currently served to published sites, or 0 if the
+// recipe is not published. Once published, the recipe stays live at that version
+// while a newer working copy goes through review or a canary rollout.
+func (r *Recipe) LiveVersion() int {
+	if r.PublishedVersion > 0 {
+		return r.PublishedVersion
+	}
+	if r.Status == RecipeStatusPublished {
+		return r.Version // Published before versions were tracked separately
+	}
+	return 0
+}
+
+// IsPublishedToSite reports whether the recipe is live at the given site.
+// A published recipe with no PublishedToSites is published to every site.
+func (r *Recipe) IsPublishedToSite(siteID primitive.ObjectID) bool {
+	if r.LiveVersion() == 0 {
+		return false
+	}
+	if len(r.PublishedToSites) == 0 {
+		return true
+	}
+	for _, id := range r.PublishedToSites {
+		if id == siteID {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetReview returns a recipe about to be edited to draft. It must be called
+// before the version is incremented. An approval only covers the content that was
+// reviewed, so any content change must go through review again; a published recipe
+// keeps serving its current version until the new one is published.
+func (r *Recipe) ResetReview() {
+	r.PublishedVersion = r.LiveVersion()
+	if r.Status == RecipeStatusReview || r.Status == RecipeStatusApproved || r.Status == RecipeStatusPublished {
+		r.Status = RecipeStatusDraft
+	}
+	r.ApprovedBy = ""
+	r.ApprovedAt = nil
+}
+
 const (
 	RecipeStatusDraft     RecipeStatus = "draft"
 	RecipeStatusReview    RecipeStatus = "review"
@@ -82,6 +139,23 @@ const (
 	RecipeStatusArchived  RecipeStatus = "archived"
 )
 
+// ReviewComment is an entry in a recipe's review trail
+type ReviewComment struct {
+	Action    ReviewAction `bson:"action" json:"action"`
+	Version   int          `bson:"version" json:"version"` // Recipe version the comment applies to
+	Comment   string       `bson:"comment,omitempty" json:"comment,omitempty"`
+	UserID    string       `bson:"user_id" json:"user_id"`
+	CreatedAt time.Time    `bson:"created_at" json:"created_at"`
+}
+
+type ReviewAction string
+
+const (
+	ReviewActionSubmitted ReviewAction = "submitted"
+	ReviewActionApproved  ReviewAction = "approved"
+	ReviewActionRejected  ReviewAction = "rejected"
+)
+
 // RecipeIngredient represents an ingredient requirement in a recipe
 // Aligned with KOS recipe_ingredient table
 type RecipeIngredient struct {
@@ -91,8 +165,23 @@ type RecipeIngredient struct {
 	Unit             string               `bson:"unit" json:"unit"` // grams, ml
 	PrepNotes        string               `bson:"prep_notes,omitempty" json:"prep_notes,omitempty"`
 	TimingStep       int                  `bson:"timing_step" json:"timing_step"`
+	SourceTimingStep int                  `bson:"source_timing_step,omitempty" json:"source_timing_step,omitempty"` // TimingStep as authored against SourceSteps, for ingredients no step adds
 	IsCritical       bool                 `bson:"is_critical" json:"is_critical"`
 	Substitutes      []primitive.ObjectID `bson:"substitutes,omitempty" json:"substitutes,omitempty"`
+	Scaling          *ScalingRule         `bson:"scaling,omitempty" json:"scaling,omitempty"`                   // How the quantity follows the pot percentage; linear if unset
+	MaxExtraFactor   float64              `bson:"max_extra_factor,omitempty" json:"max_extra_factor,omitempty"` // Most an "extra" modification may add, as a fraction of the quantity; DefaultMaxExtraFactor if unset
+}
+
+// DefaultMaxExtraFactor caps "extra" modifications of ingredients without their own
+// MaxExtraFactor: up to half the recipe quantity more
+const DefaultMaxExtraFactor = 0.5
+
+// ExtraCap returns the most an "extra" modification may add, as a fraction of the quantity
+func (ri *RecipeIngredient) ExtraCap() float64 {
+	if ri.MaxExtraFactor > 0 {
+		return ri.MaxExtraFactor
+	}
+	return DefaultMaxExtraFactor
 }
 
 // L4Action represents the valid L4 action types for recipe steps
@@ -131,29 +220,45 @@ const (
 //   - heat:        {"power_level": int, "on_duration_sec": int}
 //   - open_pot_lid, close_pot_lid: {} (no parameters)
 //   - acquire_pot_from_staging, deliver_pot_to_serving: {} (generated by KOS, not in recipes)
+//
+// ActionSchemas holds the enforced schema (required fields, types, units, ranges) of each action.
 type RecipeStep struct {
-	StepNumber     int            `bson:"step_number" json:"step_number"`                               // Sequential order (1,2,3...), must be unique per recipe
-	Action         L4Action       `bson:"action" json:"action"`                                         // L4 action type (add_liquid, add_solid, agitate, heat, etc.)
-	Parameters     map[string]any `bson:"parameters,omitempty" json:"parameters,omitempty"`             // Action-specific parameters (see struct docs)
-	DependsOnSteps []int          `bson:"depends_on_steps,omitempty" json:"depends_on_steps,omitempty"` // Parent step numbers; this step starts after all parents complete
+	StepNumber     int                    `bson:"step_number" json:"step_number"`                               // Sequential order (1,2,3...), must be unique per recipe
+	Action         L4Action               `bson:"action" json:"action"`                                         // L4 action type (add_liquid, add_solid, agitate, heat, etc.)
+	Parameters     map[string]any         `bson:"parameters,omitempty" json:"parameters,omitempty"`             // Action-specific parameters (see struct docs)
+	DependsOnSteps []int                  `bson:"depends_on_steps,omitempty" json:"depends_on_steps,omitempty"` // Parent step numbers; this step starts after all parents complete
+	Scaling        map[string]ScalingRule `bson:"scaling,omitempty" json:"scaling,omitempty"`                   // Scaling per parameter name; unset parameters use the action schema default
 
 	// KWS-only fields for recipe authoring UI (not synced to KOS)
 	Name        string `bson:"name,omitempty" json:"name,omitempty"`               // Human-readable step name for UI
 	Description string `bson:"description,omitempty" json:"description,omitempty"` // Detailed description for recipe authors
+
+	Template *StepTemplateRef `bson:"template,omitempty" json:"template,omitempty"` // Set when Action is StepTemplateAction
 }
 
-// RecipeSyncRecord tracks recipe sync status to KOS instances
+// RecipeSyncRecord tracks recipe sync status to KOS instances, one record per recipe and KOS.
+// Deliveries are recorded when KOS pulls recipes; Version only moves once KOS acknowledges it applied one.
 type RecipeSyncRecord struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	RecipeID     primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
-	KOSID        primitive.ObjectID `bson:"kos_id" json:"kos_id"`
-	SiteID       primitive.ObjectID `bson:"site_id" json:"site_id"`
-	Version      int                `bson:"version" json:"version"`
-	SyncedAt     time.Time          `bson:"synced_at" json:"synced_at"`
-	SyncStatus   string             `bson:"sync_status" json:"sync_status"` // pending, synced, failed
-	ErrorMessage string             `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID         primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	RecipeID         primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
+	KOSID            primitive.ObjectID `bson:"kos_id" json:"kos_id"`
+	SiteID           primitive.ObjectID `bson:"site_id" json:"site_id"`
+	Version          int                `bson:"version" json:"version"` // Version KOS last applied
+	SyncedAt         time.Time          `bson:"synced_at" json:"synced_at"`
+	SyncStatus       string             `bson:"sync_status" json:"sync_status"` // pending, synced, failed
+	ErrorMessage     string             `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	FailedVersion    int                `bson:"failed_version,omitempty" json:"failed_version,omitempty"`       // Version KOS failed to apply
+	DeliveredVersion int                `bson:"delivered_version,omitempty" json:"delivered_version,omitempty"` // Version last sent to KOS
+	DeliveredAt      *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
 }
 
+const (
+	RecipeSyncStatusPending = "pending" // Delivered, never acknowledged
+	RecipeSyncStatusSynced  = "synced"
+	RecipeSyncStatusFailed  = "failed"
+)
+
 // RecipeForKOS is the simplified recipe format sent to KOS
 type RecipeForKOS struct {
 	ID                      string                   `json:"id"`
@@ -164,6 +269,9 @@ type RecipeForKOS struct {
 	Ingredients             []RecipeIngredientForKOS `json:"ingredients"`
 	Steps                   []RecipeStepForKOS       `json:"steps"`
 	Version                 int                      `json:"version"`
+	PotPercentage           int                      `json:"pot_percentage,omitempty"` // Set when quantities are scaled for an order
+	NutritionPerServing     *NutritionFacts          `json:"nutrition_per_serving,omitempty"`
+	NutritionTotal          *NutritionFacts          `json:"nutrition_total,omitempty"` // Whole pot as cooked
 }
 
 type RecipeIngredientForKOS struct {
@@ -192,6 +300,35 @@ type IngredientForKOS struct {
 	AllergenInfo     []string       `json:"allergen_info,omitempty"`
 	Nutrition        *NutritionInfo `json:"nutrition,omitempty"`
 	Parameters       map[string]any `json:"parameters,omitempty"`
+	// Calibrations lists the kitchens and devices of the site whose parameters differ
+	// from Parameters, which already holds the site-wide calibration
+	Calibrations []CalibrationForKOS `json:"calibrations,omitempty"`
+}
+
+// KOSTombstone tells KOS to drop a recipe or ingredient it received earlier
+type KOSTombstone struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+const (
+	KOSTombstoneUnpublished = "unpublished" // No longer published to the site
+	KOSTombstoneArchived    = "archived"
+	KOSTombstoneDeactivated = "deactivated"
+)
+
+// RecipeDeltaForKOS is the incremental recipe catalog returned for a since cursor
+type RecipeDeltaForKOS struct {
+	Recipes []RecipeForKOS `json:"recipes"`
+	Deleted []KOSTombstone `json:"deleted"`
+	Cursor  string         `json:"cursor"` // Pass as since on the next sync
+}
+
+// IngredientDeltaForKOS is the incremental ingredient catalog returned for a since cursor
+type IngredientDeltaForKOS struct {
+	Ingredients []IngredientForKOS `json:"ingredients"`
+	Deleted     []KOSTombstone     `json:"deleted"`
+	Cursor      string             `json:"cursor"`
 }
 
 // ToKOSFormat converts a Recipe to the simplified KOS format
@@ -223,7 +360,7 @@ func (r *Recipe) ToKOSFormat() RecipeForKOS {
 		}
 	}
 
-	return RecipeForKOS{
+	kos := RecipeForKOS{
 		ID:                      r.ID.Hex(),
 		Name:                    r.Name,
 		EstimatedPrepTimeSec:    r.EstimatedPrepTimeSec,
@@ -233,6 +370,17 @@ func (r *Recipe) ToKOSFormat() RecipeForKOS {
 		Steps:                   steps,
 		Version:                 r.Version,
 	}
+	kos.SetNutrition(r.Nutrition)
+	return kos
+}
+
+// SetNutrition fills the nutrition fields from a computed recipe nutrition
+func (r *RecipeForKOS) SetNutrition(n *RecipeNutrition) {
+	if n == nil {
+		return
+	}
+	r.NutritionPerServing = &n.PerServing
+	r.NutritionTotal = &n.Total
 }
 
 // ToKOSFormat converts an Ingredient to the simplified KOS format
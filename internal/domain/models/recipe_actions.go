@@ -0,0 +1,245 @@
+package models
+
+import (
+	"fmt"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ActionSchema describes the parameters a recipe step of the given L4 action takes.
+// The schemas are served to the recipe editor and enforced when recipes are validated.
+type ActionSchema struct {
+	Action          L4Action      `json:"action"`
+	Label           string        `json:"label"`
+	Description     string        `json:"description"`
+	SystemGenerated bool          `json:"system_generated,omitempty"` // Added by the editor or KOS, not chosen by authors
+	Fields          []ParamSchema `json:"fields"`
+}
+
+// ParamSchema describes one step parameter
+type ParamSchema struct {
+	Name        string            `json:"name"`
+	Type        ParamType         `json:"type"`
+	Required    bool              `json:"required"`
+	Unit        string            `json:"unit,omitempty"`
+	Min         *float64          `json:"min,omitempty"`
+	Max         *float64          `json:"max,omitempty"`
+	Enum        []string          `json:"enum,omitempty"`        // Allowed values for enum fields
+	EnumLabels  map[string]string `json:"enum_labels,omitempty"` // Display name of each enum value
+	Default     any               `json:"default,omitempty"`
+	Scaling     ScalingMode       `json:"scaling,omitempty"` // Default scaling of numeric fields; fixed if unset
+	Description string            `json:"description,omitempty"`
+}
+
+type ParamType string
+
+const (
+	ParamTypeInteger  ParamType = "integer"
+	ParamTypeNumber   ParamType = "number"
+	ParamTypeString   ParamType = "string"
+	ParamTypeEnum     ParamType = "enum"
+	ParamTypeObjectID ParamType = "object_id" // Hex ObjectID, e.g. an ingredient
+)
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+var ingredientIDParam = ParamSchema{
+	Name: "ingredient_id", Type: ParamTypeObjectID, Required: true,
+	Description: "Ingredient to dispense",
+}
+
+var ingredientNameParam = ParamSchema{
+	Name: "ingredient_name", Type: ParamTypeString,
+	Description: "Ingredient name, denormalized for display",
+}
+
+// ActionSchemas lists the parameter schema of every L4 action, in editor order
+var ActionSchemas = []ActionSchema{
+	{
+		Action:      L4ActionAddLiquid,
+		Label:       "Add Liquid",
+		Description: "Dispense liquid from hydra to pot",
+		Fields: []ParamSchema{
+			ingredientIDParam,
+			ingredientNameParam,
+			{Name: "quantity", Type: ParamTypeNumber, Required: true, Unit: KOSUnitVolume, Min: bound(0.1), Max: bound(5000), Scaling: ScalingLinear},
+			{Name: "metric", Type: ParamTypeEnum, Enum: []string{"ml"}, Default: "ml"},
+		},
+	},
+	{
+		Action:      L4ActionAddSolid,
+		Label:       "Add Solid",
+		Description: "Add solid ingredient from canister to pot",
+		Fields: []ParamSchema{
+			ingredientIDParam,
+			ingredientNameParam,
+			{Name: "quantity", Type: ParamTypeNumber, Required: true, Unit: KOSUnitMass, Min: bound(0.1), Max: bound(5000), Scaling: ScalingLinear},
+			{Name: "metric", Type: ParamTypeEnum, Enum: []string{"grams"}, Default: "grams"},
+		},
+	},
+	{
+		Action:      L4ActionAgitate,
+		Label:       "Agitate/Mix",
+		Description: "Spiral grinder stirs or grinds the pot contents",
+		Fields: []ParamSchema{
+			{
+				Name: "speed", Type: ParamTypeEnum, Required: true, Default: "slow_stir",
+				Enum: []string{"slow_stir", "med_stir", "fast_stir", "coarse_grind", "fine_grind"},
+				EnumLabels: map[string]string{
+					"slow_stir":    "Slow Stir",
+					"med_stir":     "Medium Stir",
+					"fast_stir":    "Fast Stir",
+					"coarse_grind": "Coarse Grind",
+					"fine_grind":   "Fine Grind",
+				},
+			},
+			{Name: "duration_sec", Type: ParamTypeInteger, Required: true, Unit: "seconds", Min: bound(1), Max: bound(3600)},
+			{
+				Name: "direction", Type: ParamTypeEnum, Required: true, Default: "scraping",
+				Enum:       []string{"scraping", "cutting"},
+				EnumLabels: map[string]string{"scraping": "Scraping", "cutting": "Cutting"},
+			},
+		},
+	},
+	{
+		Action:      L4ActionHeat,
+		Label:       "Heat",
+		Description: "Pyro heats the pot at the given power level",
+		Fields: []ParamSchema{
+			{Name: "power_level", Type: ParamTypeInteger, Required: true, Min: bound(1), Max: bound(10), Default: 3},
+			{Name: "on_duration_sec", Type: ParamTypeInteger, Required: true, Unit: "seconds", Min: bound(1), Max: bound(3600)},
+		},
+	},
+	{
+		Action:      L4ActionOpenPotLid,
+		Label:       "Open Lid",
+		Description: "Thor opens the pot lid",
+		Fields:      []ParamSchema{},
+	},
+	{
+		Action:      L4ActionClosePotLid,
+		Label:       "Close Lid",
+		Description: "Thor closes the pot lid",
+		Fields:      []ParamSchema{},
+	},
+	{
+		Action:      L4ActionPickIngredient,
+		Label:       "Pick",
+		Description: "Hulk picks the ingredient canister from storage",
+		Fields:      []ParamSchema{ingredientIDParam, ingredientNameParam},
+	},
+	{
+		Action:      L4ActionPlaceIngredient,
+		Label:       "Place",
+		Description: "Hulk returns the ingredient canister to storage",
+		Fields: []ParamSchema{
+			{Name: "ingredient_id", Type: ParamTypeObjectID, Description: "Canister to return; defaults to the one picked"},
+			ingredientNameParam,
+		},
+	},
+	{
+		Action:          L4ActionAcquirePotFromStaging,
+		Label:           "Acquire Pot",
+		Description:     "Pick pot from staging conveyor and place it on pyro",
+		SystemGenerated: true,
+		Fields:          []ParamSchema{},
+	},
+	{
+		Action:          L4ActionDeliverPotToServing,
+		Label:           "Deliver Pot",
+		Description:     "Pick pot from pyro and place it on serving conveyor",
+		SystemGenerated: true,
+		Fields:          []ParamSchema{},
+	},
+}
+
+// GetActionSchema returns the schema of an action, or nil if the action is unknown
+func GetActionSchema(action L4Action) *ActionSchema {
+	for i := range ActionSchemas {
+		if ActionSchemas[i].Action == action {
+			return &ActionSchemas[i]
+		}
+	}
+	return nil
+}
+
+// Field returns the schema of a parameter, or nil if the action does not take it
+func (s *ActionSchema) Field(name string) *ParamSchema {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Check returns why value is not valid for the parameter, or "" if it is.
+// Numbers may arrive as any numeric type depending on whether they came from JSON or BSON.
+func (p *ParamSchema) Check(value any) string {
+	switch p.Type {
+	case ParamTypeString:
+		if _, ok := value.(string); !ok {
+			return "must be a string"
+		}
+	case ParamTypeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return "must be a string"
+		}
+		for _, allowed := range p.Enum {
+			if s == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %v", p.Enum)
+	case ParamTypeObjectID:
+		s, ok := value.(string)
+		if !ok {
+			return "must be an ID string"
+		}
+		if _, err := primitive.ObjectIDFromHex(s); err != nil {
+			return "must be a valid ID"
+		}
+	case ParamTypeInteger, ParamTypeNumber:
+		n, ok := paramNumber(value)
+		if !ok {
+			return "must be a number"
+		}
+		if p.Type == ParamTypeInteger && n != math.Trunc(n) {
+			return "must be a whole number"
+		}
+		if p.Min != nil && n < *p.Min {
+			return fmt.Sprintf("must be at least %g%s", *p.Min, p.unitSuffix())
+		}
+		if p.Max != nil && n > *p.Max {
+			return fmt.Sprintf("must be at most %g%s", *p.Max, p.unitSuffix())
+		}
+	}
+	return ""
+}
+
+func (p *ParamSchema) unitSuffix() string {
+	if p.Unit == "" {
+		return ""
+	}
+	return " " + p.Unit
+}
+
+func paramNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// RecipeAllergen records where one of a recipe's allergens comes from. Allergens from
+// ingredients are derived, so only manual entries are kept when a recipe is edited.
+type RecipeAllergen struct {
+	Allergen       string              `bson:"allergen" json:"allergen"` // Lowercase, e.g. "peanuts"
+	Source         AllergenSource      `bson:"source" json:"source"`
+	IngredientID   *primitive.ObjectID `bson:"ingredient_id,omitempty" json:"ingredient_id,omitempty"` // Recipe ingredient it comes with
+	IngredientName string              `bson:"ingredient_name,omitempty" json:"ingredient_name,omitempty"`
+	SubstituteID   *primitive.ObjectID `bson:"substitute_id,omitempty" json:"substitute_id,omitempty"` // Substitute that may replace the ingredient
+	SubstituteName string              `bson:"substitute_name,omitempty" json:"substitute_name,omitempty"`
+}
+
+type AllergenSource string
+
+const (
+	AllergenSourceIngredient AllergenSource = "ingredient" // In an ingredient the recipe uses
+	AllergenSourceSubstitute AllergenSource = "substitute" // In a substitute KOS may swap in
+	AllergenSourceManual     AllergenSource = "manual"     // Added by hand, e.g. for cross-contact
+)
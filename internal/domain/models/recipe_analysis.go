@@ -0,0 +1,73 @@
+package models
+
+// RecipeAnalysis is the result of validating a recipe's step graph.
+// Schedule fields are only filled when the graph has no errors.
+type RecipeAnalysis struct {
+	Valid            bool          `json:"valid"` // No error-severity issues
+	Issues           []RecipeIssue `json:"issues"`
+	TopologicalOrder []int         `json:"topological_order,omitempty"` // Step numbers in an order that respects every dependency
+	Stages           [][]int       `json:"stages,omitempty"`            // Steps grouped by depth; steps in a stage can run in parallel
+	ParallelismWidth int           `json:"parallelism_width"`           // Largest stage
+	CriticalPath     []int         `json:"critical_path,omitempty"`     // Longest chain of steps by duration
+	CriticalPathSec  int           `json:"critical_path_sec"`           // Minimum cooking time with unlimited parallelism
+}
+
+// RecipeIssue is a single validation finding
+type RecipeIssue struct {
+	Code         string        `json:"code"`
+	Severity     IssueSeverity `json:"severity"`
+	StepNumber   int           `json:"step_number,omitempty"`
+	IngredientID string        `json:"ingredient_id,omitempty"`
+	Path         string        `json:"path,omitempty"` // Field the issue is about, e.g. steps[2].parameters.power_level
+	Message      string        `json:"message"`
+}
+
+type IssueSeverity string
+
+const (
+	IssueSeverityError   IssueSeverity = "error"   // Recipe cannot be submitted for review
+	IssueSeverityWarning IssueSeverity = "warning" // Likely mistake, but the recipe can run
+)
+
+// Recipe issue codes
+const (
+	IssueNoSteps           = "no_steps"
+	IssueInvalidStepNumber = "invalid_step_number"
+	IssueDuplicateStep     = "duplicate_step"
+	IssueMissingDependency = "missing_dependency" // depends_on_steps names a step that does not exist
+	IssueSelfDependency    = "self_dependency"
+	IssueCycle             = "cycle"
+	IssueUnreachableStep   = "unreachable_step" // Waits on a cycle or a missing step, so it can never start
+	IssueOrphanStep        = "orphan_step"      // Neither depends on nor is depended on by any other step
+	IssueInvalidTimingStep = "invalid_timing_step"
+	IssueUnknownIngredient = "unknown_ingredient"
+	IssueUnusedIngredient  = "unused_ingredient" // Listed but never added by any step
+	IssueUnknownAction     = "unknown_action"
+	IssueMissingParameter  = "missing_parameter"
+	IssueInvalidParameter  = "invalid_parameter"
+	IssueUnknownParameter  = "unknown_parameter" // Not in the action schema; KOS ignores it
+	IssueInvalidScaling    = "invalid_scaling"
+	IssueInvalidUnit       = "invalid_unit"  // Not in the unit catalog
+	IssueUnitMismatch      = "unit_mismatch" // Unit does not suit the ingredient and cannot be converted
+)
+
+// Errors returns the error-severity issues
+func (a *RecipeAnalysis) Errors() []RecipeIssue {
+	var errs []RecipeIssue
+	for _, issue := range a.Issues {
+		if issue.Severity == IssueSeverityError {
+			errs = append(errs, issue)
+		}
+	}
+	return errs
+}
+
+// FirstError returns the first error-severity issue, or nil
+func (a *RecipeAnalysis) FirstError() *RecipeIssue {
+	for i := range a.Issues {
+		if a.Issues[i].Severity == IssueSeverityError {
+			return &a.Issues[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package models
+
+// LintRule is a check for a step sequence that is valid as a graph but physically
+// unsafe or wasteful on the kitchen hardware. Lint findings are reported as recipe
+// issues whose code is the rule ID. Tenants can disable rules in their settings.
+type LintRule struct {
+	ID          string        `json:"id"`
+	Severity    IssueSeverity `json:"severity"`
+	Description string        `json:"description"`
+}
+
+// Lint rule IDs
+const (
+	LintLidClosedAddSolid  = "lid-closed-add-solid" // Solid added while Thor has the lid closed
+	LintHeatWithoutPot     = "heat-without-pot"     // Pyro heats before a pot is acquired
+	LintAddWithoutPot      = "add-without-pot"      // Ingredient added, stirred or lid moved before a pot is acquired
+	LintUnmatchedPick      = "unmatched-pick"       // Canister picked and never returned to storage
+	LintUnmatchedPlace     = "unmatched-place"      // Canister returned that was never picked
+	LintDeliverNotTerminal = "deliver-not-terminal" // Steps can still run after the pot is delivered
+	LintMissingDeliver     = "missing-deliver"      // The pot is never delivered to serving
+	LintRedundantLidMove   = "redundant-lid-move"   // Lid opened while open or closed while closed
+)
+
+// LintRules lists every lint rule
+var LintRules = []LintRule{
+	{LintLidClosedAddSolid, IssueSeverityError, "A solid is added while the pot lid is closed"},
+	{LintHeatWithoutPot, IssueSeverityError, "A heat step does not run after acquire_pot_from_staging"},
+	{LintAddWithoutPot, IssueSeverityError, "A step that works on the pot does not run after acquire_pot_from_staging"},
+	{LintUnmatchedPick, IssueSeverityError, "A pick_ingredient has no later place_ingredient returning the canister"},
+	{LintUnmatchedPlace, IssueSeverityWarning, "A place_ingredient returns a canister no earlier step picked"},
+	{LintDeliverNotTerminal, IssueSeverityError, "deliver_pot_to_serving is not the last step of the recipe"},
+	{LintMissingDeliver, IssueSeverityWarning, "The recipe never delivers the pot to serving"},
+	{LintRedundantLidMove, IssueSeverityWarning, "The lid is opened when already open or closed when already closed"},
+}
+
+// GetLintRule returns a lint rule by ID, or nil if there is none
+func GetLintRule(id string) *LintRule {
+	for i := range LintRules {
+		if LintRules[i].ID == id {
+			return &LintRules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package models
+
+// NutritionFacts are nutrient amounts, in the units ingredient NutritionInfo uses
+type NutritionFacts struct {
+	Calories float64 `bson:"calories" json:"calories"`
+	Protein  float64 `bson:"protein" json:"protein"`
+	Fat      float64 `bson:"fat" json:"fat"`
+	Carbs    float64 `bson:"carbs" json:"carbs"`
+	Fiber    float64 `bson:"fiber" json:"fiber"`
+	Sodium   float64 `bson:"sodium" json:"sodium"`
+	Sugar    float64 `bson:"sugar" json:"sugar"`
+}
+
+// RecipeNutrition is the nutrition of a recipe cooked at a pot size, computed from
+// the per-100g values of its ingredients
+type RecipeNutrition struct {
+	PotPercentage  int                   `bson:"pot_percentage" json:"pot_percentage"`
+	Servings       float64               `bson:"servings" json:"servings"` // Recipe servings scaled to the pot size
+	TotalWeightG   float64               `bson:"total_weight_g" json:"total_weight_g"`
+	Total          NutritionFacts        `bson:"total" json:"total"`
+	PerServing     NutritionFacts        `bson:"per_serving" json:"per_serving"`
+	Per100g        NutritionFacts        `bson:"per_100g" json:"per_100g"`
+	Ingredients    []IngredientNutrition `bson:"ingredients,omitempty" json:"ingredients,omitempty"`
+	Incomplete     []string              `bson:"incomplete,omitempty" json:"incomplete,omitempty"`           // Ingredients left out: no nutrition data or an unknown unit
+	AssumedDensity []string              `bson:"assumed_density,omitempty" json:"assumed_density,omitempty"` // Ingredients measured in ml without a density; 1 g/ml assumed
+}
+
+// IngredientNutrition is one ingredient's contribution to a recipe's nutrition
+type IngredientNutrition struct {
+	IngredientID string         `bson:"ingredient_id" json:"ingredient_id"`
+	Name         string         `bson:"name" json:"name"`
+	Grams        float64        `bson:"grams" json:"grams"`
+	Facts        NutritionFacts `bson:"facts" json:"facts"`
+}
+
+// Add adds the facts of amount grams of an ingredient with the given per-100g values
+func (f *NutritionFacts) Add(info *NutritionInfo, grams float64) {
+	k := grams / 100
+	f.Calories += info.CaloriesPer100g * k
+	f.Protein += info.ProteinPer100g * k
+	f.Fat += info.FatPer100g * k
+	f.Carbs += info.CarbsPer100g * k
+	f.Fiber += info.FiberPer100g * k
+	f.Sodium += info.SodiumPer100g * k
+	f.Sugar += info.SugarPer100g * k
+}
+
+// Scaled returns the facts multiplied by k
+func (f NutritionFacts) Scaled(k float64) NutritionFacts {
+	return NutritionFacts{
+		Calories: f.Calories * k,
+		Protein:  f.Protein * k,
+		Fat:      f.Fat * k,
+		Carbs:    f.Carbs * k,
+		Fiber:    f.Fiber * k,
+		Sodium:   f.Sodium * k,
+		Sugar:    f.Sugar * k,
+	}
+}
+
+// IngredientParamDensity is the ingredient parameter holding its density in g/ml,
+// used to weigh ingredients measured in ml
+const IngredientParamDensity = "density_g_per_ml"
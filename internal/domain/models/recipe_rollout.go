@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecipeRollout is a staged (canary) rollout of a new recipe version.
+// Canary sites are served Version while every other published site keeps
+// BaseVersion, until the rollout is promoted everywhere or rolled back.
+type RecipeRollout struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	TenantID    primitive.ObjectID   `bson:"tenant_id" json:"tenant_id"`
+	RecipeID    primitive.ObjectID   `bson:"recipe_id" json:"recipe_id"`
+	RecipeName  string               `bson:"recipe_name" json:"recipe_name"` // Denormalized for display
+	Version     int                  `bson:"version" json:"version"`         // Candidate version
+	BaseVersion int                  `bson:"base_version" json:"base_version"`
+	CanarySites []primitive.ObjectID `bson:"canary_sites" json:"canary_sites"`
+	Status      RolloutStatus        `bson:"status" json:"status"`
+	Thresholds  RolloutThresholds    `bson:"thresholds" json:"thresholds"`
+	Metrics     *RolloutMetrics      `bson:"metrics,omitempty" json:"metrics,omitempty"` // Latest evaluation
+	Reason      string               `bson:"reason,omitempty" json:"reason,omitempty"`   // Why it was rolled back
+	CreatedBy   string               `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	StartedAt   time.Time            `bson:"started_at" json:"started_at"`
+	CompletedAt *time.Time           `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+type RolloutStatus string
+
+const (
+	RolloutStatusActive     RolloutStatus = "active"
+	RolloutStatusPromoted   RolloutStatus = "promoted"    // Candidate is now the live version everywhere
+	RolloutStatusRolledBack RolloutStatus = "rolled_back" // Canary sites went back to the base version
+)
+
+// IsCanarySite reports whether the site is served the candidate version
+func (r *RecipeRollout) IsCanarySite(siteID primitive.ObjectID) bool {
+	for _, id := range r.CanarySites {
+		if id == siteID {
+			return true
+		}
+	}
+	return false
+}
+
+// RolloutThresholds decide when a canary is rolled back automatically
+type RolloutThresholds struct {
+	MinOrders              int     `bson:"min_orders" json:"min_orders"`                               // Finished canary orders needed before judging
+	MaxFailureRate         float64 `bson:"max_failure_rate" json:"max_failure_rate"`                   // 0-1, share of canary orders that failed
+	MaxDurationIncreasePct float64 `bson:"max_duration_increase_pct" json:"max_duration_increase_pct"` // Allowed slowdown vs the base version
+}
+
+// RolloutMetrics summarizes finished orders cooked with each version since the rollout started
+type RolloutMetrics struct {
+	Orders                 int            `bson:"orders" json:"orders"`
+	FailedOrders           int            `bson:"failed_orders" json:"failed_orders"`
+	FailureRate            float64        `bson:"failure_rate" json:"failure_rate"`
+	AvgDurationSec         float64        `bson:"avg_duration_sec" json:"avg_duration_sec"`
+	ErrorCodes             map[string]int `bson:"error_codes,omitempty" json:"error_codes,omitempty"` // Task error code counts
+	BaselineOrders         int            `bson:"baseline_orders" json:"baseline_orders"`
+	BaselineAvgDurationSec float64        `bson:"baseline_avg_duration_sec" json:"baseline_avg_duration_sec"`
+	ThresholdExceeded      string         `bson:"threshold_exceeded,omitempty" json:"threshold_exceeded,omitempty"`
+	EvaluatedAt            time.Time      `bson:"evaluated_at" json:"evaluated_at"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import "sort"
+
+// ScalingRule says how a quantity changes when an order cooks less than a full pot.
+// Recipes are authored for a full pot (100%); KOS receives values already scaled
+// for the order's pot percentage.
+type ScalingRule struct {
+	Mode   ScalingMode  `bson:"mode" json:"mode"`
+	Points []ScalePoint `bson:"points,omitempty" json:"points,omitempty"` // Custom curve only
+}
+
+type ScalingMode string
+
+const (
+	ScalingLinear ScalingMode = "linear" // Proportional to the pot percentage
+	ScalingFixed  ScalingMode = "fixed"  // Same at every pot size
+	ScalingCustom ScalingMode = "custom" // Interpolated between the curve points
+)
+
+// ScalePoint is one point of a custom scaling curve: at PotPercentage the full-pot
+// value is multiplied by Factor
+type ScalePoint struct {
+	PotPercentage int     `bson:"pot_percentage" json:"pot_percentage"`
+	Factor        float64 `bson:"factor" json:"factor"`
+}
+
+// StandardPotPercentages are the pot sizes orders are placed at
+var StandardPotPercentages = []int{25, 50, 75, 100}
+
+// Factor returns the multiplier for the full-pot value at the given pot percentage.
+// Custom curves are linear between points, flat beyond the outermost points, and
+// pass through 1.0 at 100% unless a point says otherwise.
+func (r *ScalingRule) Factor(potPercentage int) float64 {
+	switch r.Mode {
+	case ScalingFixed:
+		return 1
+	case ScalingCustom:
+		points := append([]ScalePoint(nil), r.Points...)
+		full := false
+		for _, p := range points {
+			full = full || p.PotPercentage == 100
+		}
+		if !full {
+			points = append(points, ScalePoint{PotPercentage: 100, Factor: 1})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].PotPercentage < points[j].PotPercentage })
+
+		if potPercentage <= points[0].PotPercentage {
+			return points[0].Factor
+		}
+		for i := 1; i < len(points); i++ {
+			lo, hi := points[i-1], points[i]
+			if potPercentage <= hi.PotPercentage {
+				t := float64(potPercentage-lo.PotPercentage) / float64(hi.PotPercentage-lo.PotPercentage)
+				return lo.Factor + t*(hi.Factor-lo.Factor)
+			}
+		}
+		return points[len(points)-1].Factor
+	default:
+		return float64(potPercentage) / 100
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecipeSyncState is the sync state of one recipe at one site in the fleet matrix
+type RecipeSyncState string
+
+const (
+	RecipeSyncStateSynced       RecipeSyncState = "synced"        // KOS applied the version the site should run
+	RecipeSyncStatePending      RecipeSyncState = "pending"       // Not yet delivered, or delivered and awaiting acknowledgement
+	RecipeSyncStateFailed       RecipeSyncState = "failed"        // KOS reported it could not apply the version
+	RecipeSyncStateStale        RecipeSyncState = "stale"         // KOS runs an older version, or never acknowledged the delivery
+	RecipeSyncStateNotPublished RecipeSyncState = "not_published" // The recipe is not served at the site
+)
+
+// RecipeSyncMatrix shows recipes × sites with the sync state of each cell.
+// Cells in each row line up with Sites.
+type RecipeSyncMatrix struct {
+	Sites       []RecipeSyncMatrixSite  `json:"sites"`
+	Recipes     []RecipeSyncMatrixRow   `json:"recipes"`
+	Summary     map[RecipeSyncState]int `json:"summary"`
+	GeneratedAt time.Time               `json:"generated_at"`
+}
+
+type RecipeSyncMatrixSite struct {
+	SiteID    primitive.ObjectID  `json:"site_id"`
+	SiteName  string              `json:"site_name"`
+	KOSID     *primitive.ObjectID `json:"kos_id,omitempty"` // Nil when no KOS is registered at the site
+	KOSName   string              `json:"kos_name,omitempty"`
+	KOSStatus KOSStatus           `json:"kos_status,omitempty"`
+}
+
+type RecipeSyncMatrixRow struct {
+	RecipeID    primitive.ObjectID `json:"recipe_id"`
+	RecipeName  string             `json:"recipe_name"`
+	LiveVersion int                `json:"live_version"`
+	Cells       []RecipeSyncCell   `json:"cells"`
+}
+
+type RecipeSyncCell struct {
+	State            RecipeSyncState `json:"state"`
+	ExpectedVersion  int             `json:"expected_version,omitempty"` // Version the site should run (the candidate at canary sites)
+	AppliedVersion   int             `json:"applied_version,omitempty"`
+	DeliveredVersion int             `json:"delivered_version,omitempty"`
+	DeliveredAt      *time.Time      `json:"delivered_at,omitempty"`
+	SyncedAt         *time.Time      `json:"synced_at,omitempty"`
+	ErrorMessage     string          `json:"error_message,omitempty"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecipeVersion is an immutable snapshot of a recipe as it was saved at a given version.
+// A snapshot is written the first time each version number is persisted and never modified,
+// so orders and RecipeSyncRecords can always be resolved to the exact definition they used.
+type RecipeVersion struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RecipeID  primitive.ObjectID `bson:"recipe_id" json:"recipe_id"`
+	TenantID  primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	Version   int                `bson:"version" json:"version"`
+	Recipe    Recipe             `bson:"recipe" json:"recipe"` // Full recipe document at this version
+	CreatedBy string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// RecipeVersionSummary is the lightweight form of RecipeVersion used in history listings
+type RecipeVersionSummary struct {
+	Version         int       `json:"version"`
+	Name            string    `json:"name"`
+	Status          string    `json:"status"`
+	IngredientCount int       `json:"ingredient_count"`
+	StepCount       int       `json:"step_count"`
+	CreatedBy       string    `json:"created_by,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Summary returns the history-listing form of the snapshot
+func (v *RecipeVersion) Summary() RecipeVersionSummary {
+	return RecipeVersionSummary{
+		Version:         v.Version,
+		Name:            v.Recipe.Name,
+		Status:          string(v.Recipe.Status),
+		IngredientCount: len(v.Recipe.Ingredients),
+		StepCount:       len(v.Recipe.Steps),
+		CreatedBy:       v.CreatedBy,
+		CreatedAt:       v.CreatedAt,
+	}
+}
+
+// Change types used in RecipeDiff entries
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// RecipeDiff is a structured comparison of two recipe versions
+type RecipeDiff struct {
+	RecipeID    string             `json:"recipe_id"`
+	FromVersion int                `json:"from_version"`
+	ToVersion   int                `json:"to_version"`
+	Fields      []FieldChange      `json:"fields,omitempty"`      // Top-level recipe fields (name, times, allergens...)
+	Parameters  []FieldChange      `json:"parameters,omitempty"`  // Recipe-level parameters
+	Ingredients []IngredientChange `json:"ingredients,omitempty"` // Keyed by ingredient ID
+	Steps       []StepChange       `json:"steps,omitempty"`       // Keyed by step number
+}
+
+// HasChanges reports whether the two versions differ at all
+func (d *RecipeDiff) HasChanges() bool {
+	return len(d.Fields) > 0 || len(d.Parameters) > 0 || len(d.Ingredients) > 0 || len(d.Steps) > 0
+}
+
+// FieldChange describes a single changed value
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitempty"`
+	To    any    `json:"to,omitempty"`
+}
+
+// IngredientChange describes an added, removed or modified recipe ingredient
+type IngredientChange struct {
+	IngredientID string        `json:"ingredient_id"`
+	Name         string        `json:"name"`
+	Change       string        `json:"change"` // added, removed, modified
+	Fields       []FieldChange `json:"fields,omitempty"`
+}
+
+// StepChange describes an added, removed or modified recipe step
+type StepChange struct {
+	StepNumber int           `json:"step_number"`
+	Action     L4Action      `json:"action"`
+	Change     string        `json:"change"` // added, removed, modified
+	Fields     []FieldChange `json:"fields,omitempty"`
+}
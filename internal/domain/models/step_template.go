@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StepTemplateAction is the action of a recipe step that stands for a step template's
+// steps. Such steps only appear in Recipe.SourceSteps and never reach KOS.
+const StepTemplateAction L4Action = "step_template"
+
+// StepTemplate is a named, versioned sequence of steps that recipes reuse, such as
+// acquiring a pot, opening the lid, adding oil and heating it. Step parameters may hold
+// placeholders, "{{name}}", that recipes fill with arguments when the template is expanded.
+type StepTemplate struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	TenantID    primitive.ObjectID  `bson:"tenant_id" json:"tenant_id"`
+	Name        string              `bson:"name" json:"name"`
+	Description string              `bson:"description,omitempty" json:"description,omitempty"`
+	Parameters  []StepTemplateParam `bson:"parameters,omitempty" json:"parameters,omitempty"`
+	Steps       []RecipeStep        `bson:"steps" json:"steps"` // Numbered within the template; dependencies refer to template steps
+	Version     int                 `bson:"version" json:"version"`
+	CreatedBy   string              `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	UpdatedBy   string              `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time           `bson:"updated_at" json:"updated_at"`
+
+	FlaggedRecipes int64 `bson:"-" json:"flagged_recipes,omitempty"` // Set in the response to an update
+}
+
+// StepTemplateParam is a placeholder a recipe fills when it uses the template
+type StepTemplateParam struct {
+	Name        string `bson:"name" json:"name"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	Default     any    `bson:"default,omitempty" json:"default,omitempty"` // Required when unset
+}
+
+// StepTemplateVersion is the immutable snapshot of a step template at one version
+type StepTemplateVersion struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TemplateID primitive.ObjectID `bson:"template_id" json:"template_id"`
+	TenantID   primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	Version    int                `bson:"version" json:"version"`
+	Template   StepTemplate       `bson:"template" json:"template"`
+	CreatedBy  string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// StepTemplateRef is set on a recipe step whose action is StepTemplateAction
+type StepTemplateRef struct {
+	TemplateID primitive.ObjectID `bson:"template_id" json:"template_id"`
+	Arguments  map[string]any     `bson:"arguments,omitempty" json:"arguments,omitempty"` // Placeholder values by parameter name
+}
+
+// RecipeTemplateUse records a step template version a recipe's steps were expanded from
+type RecipeTemplateUse struct {
+	TemplateID primitive.ObjectID `bson:"template_id" json:"template_id"`
+	Name       string             `bson:"name" json:"name"`
+	Version    int                `bson:"version" json:"version"`
+	SourceStep int                `bson:"source_step" json:"source_step"` // Step of SourceSteps that uses the template
+	FirstStep  int                `bson:"first_step" json:"first_step"`   // Expanded steps, FirstStep to LastStep
+	LastStep   int                `bson:"last_step" json:"last_step"`
+}
+
+// StepTemplateUsage lists the recipes whose steps use a step template
+type StepTemplateUsage struct {
+	TemplateID string                  `json:"template_id"`
+	Name       string                  `json:"name"`
+	Version    int                     `json:"version"`
+	Recipes    []StepTemplateRecipeUse `json:"recipes"`
+}
+
+// StepTemplateRecipeUse is one recipe using a step template
+type StepTemplateRecipeUse struct {
+	RecipeID        string       `json:"recipe_id"`
+	RecipeName      string       `json:"recipe_name"`
+	RecipeVersion   int          `json:"recipe_version"`
+	Status          RecipeStatus `json:"status"`
+	TemplateVersion int          `json:"template_version"` // Version the recipe's steps were expanded from
+	Outdated        bool         `json:"outdated"`         // Flagged for revalidation
+}
+
+// SetSteps replaces a recipe's steps as authored. When some stand for step templates
+// they are kept in SourceSteps, and Steps is left empty until they are expanded.
+func (r *Recipe) SetSteps(steps []RecipeStep) {
+	r.Steps = steps
+	r.SourceSteps = nil
+	r.StepTemplates = nil
+	r.TemplatesOutdated = false
+	for _, step := range steps {
+		if step.Action == StepTemplateAction {
+			r.SourceSteps = steps
+			r.Steps = nil
+			return
+		}
+	}
+}
+
+// UsesStepTemplates reports whether the recipe's steps were expanded from step templates
+func (r *Recipe) UsesStepTemplates() bool {
+	return r.SourceSteps != nil
+}
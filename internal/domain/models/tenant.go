@@ -31,10 +31,11 @@ const (
 )
 
 type TenantSettings struct {
-	DefaultTimezone   string `bson:"default_timezone" json:"default_timezone"`
-	DefaultCurrency   string `bson:"default_currency" json:"default_currency"`
-	RecipeSyncEnabled bool   `bson:"recipe_sync_enabled" json:"recipe_sync_enabled"`
-	OrderSyncEnabled  bool   `bson:"order_sync_enabled" json:"order_sync_enabled"`
+	DefaultTimezone   string   `bson:"default_timezone" json:"default_timezone"`
+	DefaultCurrency   string   `bson:"default_currency" json:"default_currency"`
+	RecipeSyncEnabled bool     `bson:"recipe_sync_enabled" json:"recipe_sync_enabled"`
+	OrderSyncEnabled  bool     `bson:"order_sync_enabled" json:"order_sync_enabled"`
+	DisabledLintRules []string `bson:"disabled_lint_rules,omitempty" json:"disabled_lint_rules,omitempty"` // Recipe lint rule IDs not checked for this tenant
 }
 
 type Address struct {
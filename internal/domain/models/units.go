@@ -0,0 +1,76 @@
+package models
+
+import "strings"
+
+// UnitDimension is what a unit measures. Units of the same dimension convert by a
+// fixed factor; mass and volume convert through an ingredient's density, and count
+// and mass through its piece weight.
+type UnitDimension string
+
+const (
+	DimensionMass   UnitDimension = "mass"
+	DimensionVolume UnitDimension = "volume"
+	DimensionCount  UnitDimension = "count"
+)
+
+// Unit is an entry of the unit catalog
+type Unit struct {
+	Symbol    string        `json:"symbol"`
+	Name      string        `json:"name"`
+	Dimension UnitDimension `json:"dimension"`
+	Factor    float64       `json:"factor"` // Base units (g, ml or pieces) in one of this unit
+	Aliases   []string      `json:"aliases,omitempty"`
+}
+
+// Units is the unit catalog. Quantities in any other unit cannot be converted.
+var Units = []Unit{
+	{Symbol: "mg", Name: "milligram", Dimension: DimensionMass, Factor: 0.001, Aliases: []string{"milligram", "milligrams"}},
+	{Symbol: "g", Name: "gram", Dimension: DimensionMass, Factor: 1, Aliases: []string{"gram", "grams", "gr"}},
+	{Symbol: "kg", Name: "kilogram", Dimension: DimensionMass, Factor: 1000, Aliases: []string{"kilogram", "kilograms"}},
+	{Symbol: "oz", Name: "ounce", Dimension: DimensionMass, Factor: 28.349523125, Aliases: []string{"ounce", "ounces"}},
+	{Symbol: "lb", Name: "pound", Dimension: DimensionMass, Factor: 453.59237, Aliases: []string{"pound", "pounds", "lbs"}},
+	{Symbol: "ml", Name: "millilitre", Dimension: DimensionVolume, Factor: 1, Aliases: []string{"milliliter", "milliliters", "millilitre", "millilitres"}},
+	{Symbol: "cl", Name: "centilitre", Dimension: DimensionVolume, Factor: 10, Aliases: []string{"centiliter", "centiliters", "centilitre", "centilitres"}},
+	{Symbol: "dl", Name: "decilitre", Dimension: DimensionVolume, Factor: 100, Aliases: []string{"deciliter", "deciliters", "decilitre", "decilitres"}},
+	{Symbol: "l", Name: "litre", Dimension: DimensionVolume, Factor: 1000, Aliases: []string{"liter", "liters", "litre", "litres"}},
+	{Symbol: "tsp", Name: "teaspoon", Dimension: DimensionVolume, Factor: 5, Aliases: []string{"teaspoon", "teaspoons"}},
+	{Symbol: "tbsp", Name: "tablespoon", Dimension: DimensionVolume, Factor: 15, Aliases: []string{"tablespoon", "tablespoons"}},
+	{Symbol: "cup", Name: "cup", Dimension: DimensionVolume, Factor: 240, Aliases: []string{"cups"}},
+	{Symbol: "pcs", Name: "piece", Dimension: DimensionCount, Factor: 1, Aliases: []string{"pc", "piece", "pieces", "each", "ea"}},
+}
+
+// LookupUnit finds a unit by symbol or alias, ignoring case and surrounding spaces
+func LookupUnit(name string) (*Unit, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i := range Units {
+		u := &Units[i]
+		if u.Symbol == name {
+			return u, true
+		}
+		for _, alias := range u.Aliases {
+			if alias == name {
+				return u, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Units KOS dispensers work in: pumps dispense ml, solid dispensers and canisters grams
+const (
+	KOSUnitVolume = "ml"
+	KOSUnitMass   = "grams"
+)
+
+// Dimension returns the dimension ingredients of this moisture type are measured in:
+// volume for liquids, mass otherwise
+func (m MoistureType) Dimension() UnitDimension {
+	if m == MoistureTypeLiquid {
+		return DimensionVolume
+	}
+	return DimensionMass
+}
+
+// IngredientParamPieceWeight is the ingredient parameter holding the grams of one
+// piece, used to weigh ingredients counted in pieces
+const IngredientParamPieceWeight = "grams_per_piece"
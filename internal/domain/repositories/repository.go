@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/ak/kws/internal/domain/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -51,6 +52,18 @@ type KitchenRepository interface {
 	ListBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Kitchen, error)
 }
 
+// KitchenLayoutRepository defines operations for versioned kitchen layouts
+type KitchenLayoutRepository interface {
+	// Create saves a new layout version; it fails if the version already exists
+	Create(ctx context.Context, layout *models.KitchenLayout) error
+	// GetCurrent returns the highest layout version of a kitchen
+	GetCurrent(ctx context.Context, kitchenID primitive.ObjectID) (*models.KitchenLayout, error)
+	GetVersion(ctx context.Context, kitchenID primitive.ObjectID, version int) (*models.KitchenLayout, error)
+	ListVersions(ctx context.Context, kitchenID primitive.ObjectID) ([]*models.KitchenLayout, error)
+	// ListCurrentBySite returns the current layout of every kitchen of a site that has one
+	ListCurrentBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.KitchenLayout, error)
+}
+
 // KOSInstanceRepository defines operations for KOS instance data access
 type KOSInstanceRepository interface {
 	Create(ctx context.Context, kos *models.KOSInstance) error
@@ -72,6 +85,8 @@ type IngredientRepository interface {
 	HardDelete(ctx context.Context, id primitive.ObjectID) error // Permanent delete
 	ListByTenant(ctx context.Context, tenantID primitive.ObjectID, activeOnly bool, page, limit int) ([]*models.Ingredient, int64, error)
 	GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.Ingredient, error)
+	// ListUpdatedSince returns the tenant's ingredients, active or not, modified after since
+	ListUpdatedSince(ctx context.Context, tenantID primitive.ObjectID, since time.Time) ([]*models.Ingredient, error)
 	CountRecipesUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) (int64, error)
 }
 
@@ -83,6 +98,61 @@ type RecipeRepository interface {
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	ListByTenant(ctx context.Context, tenantID primitive.ObjectID, status string, page, limit int) ([]*models.Recipe, int64, error)
 	GetPublishedForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error)
+	// ListUpdatedSince returns the tenant's recipes of any status modified after since
+	ListUpdatedSince(ctx context.Context, tenantID primitive.ObjectID, since time.Time) ([]*models.Recipe, error)
+	// ListUsingIngredient returns non-archived recipes that list, add or substitute the ingredient
+	ListUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) ([]*models.Recipe, error)
+	// ListVersionsUsingIngredient returns the snapshots, of any recipe status, whose content
+	// lists, adds or substitutes the ingredient, by recipe then version
+	ListVersionsUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) ([]*models.RecipeVersion, error)
+	// ListUsingStepTemplate returns non-archived recipes whose steps were expanded from the step template
+	ListUsingStepTemplate(ctx context.Context, templateID primitive.ObjectID) ([]*models.Recipe, error)
+	// MarkTemplatesOutdated flags the non-archived recipes using the step template for
+	// revalidation, without creating a new version, and returns how many it flagged
+	MarkTemplatesOutdated(ctx context.Context, templateID primitive.ObjectID) (int64, error)
+	// GetVersion returns the immutable snapshot of a recipe at the given version
+	GetVersion(ctx context.Context, recipeID primitive.ObjectID, version int) (*models.RecipeVersion, error)
+	// ListVersions returns all snapshots of a recipe, newest first
+	ListVersions(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeVersion, error)
+	// RecordSyncDeliveries records the recipe versions just sent to a KOS
+	RecordSyncDeliveries(ctx context.Context, records []*models.RecipeSyncRecord) error
+	// RecordSyncAck records whether a KOS applied a recipe version
+	RecordSyncAck(ctx context.Context, record *models.RecipeSyncRecord) error
+	ListSyncRecordsByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.RecipeSyncRecord, error)
+	ListSyncRecordsForKOS(ctx context.Context, kosID primitive.ObjectID) ([]*models.RecipeSyncRecord, error)
+}
+
+// StepTemplateRepository defines operations for versioned step templates
+type StepTemplateRepository interface {
+	Create(ctx context.Context, template *models.StepTemplate) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.StepTemplate, error)
+	// GetByIDs returns the templates that exist among ids, in no particular order
+	GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.StepTemplate, error)
+	// Update replaces the template and snapshots its version on the first save of it
+	Update(ctx context.Context, template *models.StepTemplate) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// ListByTenant returns the tenant's templates by name
+	ListByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.StepTemplate, error)
+	// GetVersion returns the immutable snapshot of a template at the given version
+	GetVersion(ctx context.Context, templateID primitive.ObjectID, version int) (*models.StepTemplateVersion, error)
+	// ListVersions returns all snapshots of a template, newest first
+	ListVersions(ctx context.Context, templateID primitive.ObjectID) ([]*models.StepTemplateVersion, error)
+}
+
+// RecipeRolloutRepository defines operations for staged recipe rollouts
+type RecipeRolloutRepository interface {
+	Create(ctx context.Context, rollout *models.RecipeRollout) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error)
+	Update(ctx context.Context, rollout *models.RecipeRollout) error
+	// GetActiveForRecipe returns the recipe's rollout in progress, if any
+	GetActiveForRecipe(ctx context.Context, recipeID primitive.ObjectID) (*models.RecipeRollout, error)
+	// ListByRecipe returns all rollouts of a recipe, newest first
+	ListByRecipe(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeRollout, error)
+	// ListActiveForSite returns rollouts in progress that include the site as a canary
+	ListActiveForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.RecipeRollout, error)
+	ListActiveByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.RecipeRollout, error)
+	// ListUpdatedForSite returns rollouts with the site as a canary that changed after since
+	ListUpdatedForSite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.RecipeRollout, error)
 }
 
 type RecipeFilter struct {
@@ -106,6 +176,72 @@ type OrderRepository interface {
 	GetActiveForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Order, error)
 	// ResetOrphanedOrders resets orders to pending that KOS no longer has
 	ResetOrphanedOrders(ctx context.Context, siteID primitive.ObjectID, activeOrderIDs []string) (int64, error)
+	// ListFinishedForRecipeVersion returns completed or failed orders cooked with a recipe version since a point in time
+	ListFinishedForRecipeVersion(ctx context.Context, recipeID primitive.ObjectID, version int, since time.Time) ([]*models.Order, error)
+	// ListOpenForSite returns every order the site has yet to finish (pending through in_progress), by execution time
+	ListOpenForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Order, error)
+	// ListCompletedForSite returns the orders a site completed in [since, until), by completion time
+	ListCompletedForSite(ctx context.Context, siteID primitive.ObjectID, since, until time.Time) ([]*models.Order, error)
+}
+
+// InventoryRepository defines operations for the ingredient stock of site kitchens
+type InventoryRepository interface {
+	// Upsert replaces the level of an ingredient in a kitchen, creating it if needed
+	Upsert(ctx context.Context, level *models.InventoryLevel) error
+	ListBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.InventoryLevel, error)
+	// Deduct subtracts quantity, in the level's unit, from an inventory level
+	Deduct(ctx context.Context, id primitive.ObjectID, quantity float64) error
+}
+
+// CanisterLoadRepository defines operations for the lots loaded into site kitchen canisters
+type CanisterLoadRepository interface {
+	Create(ctx context.Context, load *models.CanisterLoad) error
+	// Unload ends the active load of a canister, if any
+	Unload(ctx context.Context, siteID primitive.ObjectID, kitchenID, canisterID string, at time.Time) error
+	ListActiveBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.CanisterLoad, error)
+	// ListBySite returns the loads still loaded or unloaded at or after since
+	ListBySite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.CanisterLoad, error)
+}
+
+// ConsumptionRepository defines operations for the expected and actual consumption of orders
+type ConsumptionRepository interface {
+	CreateMany(ctx context.Context, records []*models.ConsumptionRecord) error
+	ListByOrder(ctx context.Context, orderID primitive.ObjectID) ([]*models.ConsumptionRecord, error)
+	// ListBySite returns a site's records recorded at or after since
+	ListBySite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.ConsumptionRecord, error)
+}
+
+// CalibrationRepository defines operations for the ingredient parameter calibrations of sites
+type CalibrationRepository interface {
+	Create(ctx context.Context, calibration *models.Calibration) error
+	// ListCurrentBySite returns the latest calibration of each ingredient for each
+	// site, kitchen and device scope of a site
+	ListCurrentBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Calibration, error)
+	// ListHistory returns a site's calibrations measured at or after since, oldest first
+	ListHistory(ctx context.Context, siteID primitive.ObjectID, filter CalibrationFilter) ([]*models.Calibration, error)
+	// ListIngredientsChangedSince returns the ingredients whose calibration at a site was recorded after since
+	ListIngredientsChangedSince(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]primitive.ObjectID, error)
+}
+
+// IngredientCostRepository defines operations for the price history of ingredients
+type IngredientCostRepository interface {
+	Create(ctx context.Context, cost *models.IngredientCost) error
+	// ListByTenant returns a tenant's costs, optionally of one ingredient, by ingredient and effective date
+	ListByTenant(ctx context.Context, tenantID primitive.ObjectID, ingredientID *primitive.ObjectID) ([]*models.IngredientCost, error)
+}
+
+// MenuPriceRepository defines operations for the price history of recipes at sites
+type MenuPriceRepository interface {
+	Create(ctx context.Context, price *models.MenuPrice) error
+	// ListBySite returns a site's prices, optionally of one recipe, by effective date
+	ListBySite(ctx context.Context, siteID primitive.ObjectID, recipeID *primitive.ObjectID) ([]*models.MenuPrice, error)
+}
+
+type CalibrationFilter struct {
+	IngredientID *primitive.ObjectID
+	KitchenID    string
+	DeviceID     string
+	Since        time.Time
 }
 
 type OrderFilter struct {
@@ -124,14 +260,14 @@ type AuditLogRepository interface {
 }
 
 type AuditLog struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty"`
-	TenantID     primitive.ObjectID `bson:"tenant_id"`
-	UserID       string             `bson:"user_id"`
-	Action       string             `bson:"action"`
-	ResourceType string             `bson:"resource_type"`
-	ResourceID   string             `bson:"resource_id"`
-	OldValue     interface{}        `bson:"old_value,omitempty"`
-	NewValue     interface{}        `bson:"new_value,omitempty"`
-	IPAddress    string             `bson:"ip_address,omitempty"`
-	CreatedAt    primitive.DateTime `bson:"created_at"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID     primitive.ObjectID `bson:"tenant_id" json:"tenant_id"`
+	UserID       string             `bson:"user_id" json:"user_id"`
+	Action       string             `bson:"action" json:"action"`
+	ResourceType string             `bson:"resource_type" json:"resource_type"`
+	ResourceID   string             `bson:"resource_id" json:"resource_id"`
+	OldValue     interface{}        `bson:"old_value,omitempty" json:"old_value,omitempty"`
+	NewValue     interface{}        `bson:"new_value,omitempty" json:"new_value,omitempty"`
+	IPAddress    string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	CreatedAt    primitive.DateTime `bson:"created_at" json:"created_at"`
 }
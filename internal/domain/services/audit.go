@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ak/kws/internal/domain/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordAudit writes an audit log entry; a nil repository disables auditing
+func recordAudit(ctx context.Context, auditRepo repositories.AuditLogRepository, tenantID primitive.ObjectID, userID, action, resourceType, resourceID string, oldValue, newValue any) error {
+	if auditRepo == nil {
+		return nil
+	}
+
+	entry := &repositories.AuditLog{
+		TenantID:     tenantID,
+		UserID:       userID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		OldValue:     oldValue,
+		NewValue:     newValue,
+	}
+	if err := auditRepo.Create(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit log: %w", err)
+	}
+	return nil
+}
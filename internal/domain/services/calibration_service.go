@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CalibrationService manages the per-site overrides of ingredient parameters and
+// merges them into what KOS receives
+type CalibrationService interface {
+	// Record stores calibrations for a site, measured by KOS or set by hand
+	Record(ctx context.Context, site *models.Site, inputs []CalibrationInput, source models.CalibrationSource, recordedBy string) ([]*models.Calibration, error)
+	// Current returns the calibrations in effect at a site
+	Current(ctx context.Context, siteID primitive.ObjectID) ([]*models.Calibration, error)
+	// History returns a site's calibrations, oldest first
+	History(ctx context.Context, siteID primitive.ObjectID, filter repositories.CalibrationFilter) ([]*models.Calibration, error)
+	// ApplyToIngredients merges a site's calibrations into ingredients sent to its KOS
+	ApplyToIngredients(ctx context.Context, siteID primitive.ObjectID, ingredients []models.IngredientForKOS) error
+	// IngredientsChangedSince returns the ingredients whose calibration at a site changed after since
+	IngredientsChangedSince(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]primitive.ObjectID, error)
+}
+
+// CalibrationInput is one calibration of an ingredient at a site, kitchen or device
+type CalibrationInput struct {
+	KitchenID    string
+	DeviceID     string
+	IngredientID primitive.ObjectID
+	Parameters   map[string]float64
+	MeasuredAt   time.Time // Now if zero
+	Notes        string
+}
+
+type calibrationService struct {
+	calibrationRepo repositories.CalibrationRepository
+	ingredientRepo  repositories.IngredientRepository
+	kitchenRepo     repositories.KitchenRepository
+}
+
+// NewCalibrationService creates a new calibration service
+func NewCalibrationService(
+	calibrationRepo repositories.CalibrationRepository,
+	ingredientRepo repositories.IngredientRepository,
+	kitchenRepo repositories.KitchenRepository,
+) CalibrationService {
+	return &calibrationService{
+		calibrationRepo: calibrationRepo,
+		ingredientRepo:  ingredientRepo,
+		kitchenRepo:     kitchenRepo,
+	}
+}
+
+func (s *calibrationService) Record(ctx context.Context, site *models.Site, inputs []CalibrationInput, source models.CalibrationSource, recordedBy string) ([]*models.Calibration, error) {
+	kitchens, err := s.kitchenRepo.ListBySite(ctx, site.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site kitchens: %w", err)
+	}
+	// Sites without registered kitchens accept any kitchen KOS names
+	known := make(map[string]bool, len(kitchens))
+	for _, k := range kitchens {
+		known[k.KitchenID] = true
+	}
+
+	ids := make([]primitive.ObjectID, len(inputs))
+	for i, in := range inputs {
+		ids[i] = in.IngredientID
+	}
+	ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingredients: %w", err)
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient, len(ingredients))
+	for _, ing := range ingredients {
+		byID[ing.ID] = ing
+	}
+
+	for i, in := range inputs {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("calibration %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"calibration": i, "ingredient_id": in.IngredientID.Hex()})
+		}
+		if ing := byID[in.IngredientID]; ing == nil || ing.TenantID != site.TenantID {
+			return nil, invalid("ingredient %s not found", in.IngredientID.Hex())
+		}
+		if in.DeviceID != "" && in.KitchenID == "" {
+			return nil, invalid("a device calibration needs its kitchen_id")
+		}
+		if in.KitchenID != "" && len(known) > 0 && !known[in.KitchenID] {
+			return nil, invalid("kitchen %s is not a kitchen of site %s", in.KitchenID, site.Code)
+		}
+		for name, value := range in.Parameters {
+			if name == "" || math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, invalid("parameter %q must be a finite number", name)
+			}
+		}
+	}
+
+	now := time.Now()
+	calibrations := make([]*models.Calibration, len(inputs))
+	for i, in := range inputs {
+		measured := in.MeasuredAt
+		if measured.IsZero() {
+			measured = now
+		}
+		parameters := in.Parameters
+		if parameters == nil {
+			parameters = map[string]float64{}
+		}
+		calibrations[i] = &models.Calibration{
+			TenantID:       site.TenantID,
+			SiteID:         site.ID,
+			KitchenID:      in.KitchenID,
+			DeviceID:       in.DeviceID,
+			IngredientID:   in.IngredientID,
+			IngredientName: byID[in.IngredientID].Name,
+			Parameters:     parameters,
+			Source:         source,
+			RecordedBy:     recordedBy,
+			Notes:          in.Notes,
+			MeasuredAt:     measured,
+		}
+		if err := s.calibrationRepo.Create(ctx, calibrations[i]); err != nil {
+			return nil, fmt.Errorf("failed to save calibration: %w", err)
+		}
+	}
+	return calibrations, nil
+}
+
+func (s *calibrationService) Current(ctx context.Context, siteID primitive.ObjectID) ([]*models.Calibration, error) {
+	calibrations, err := s.calibrationRepo.ListCurrentBySite(ctx, siteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calibrations: %w", err)
+	}
+	// A calibration with no parameters reverted its scope to the defaults
+	current := make([]*models.Calibration, 0, len(calibrations))
+	for _, c := range calibrations {
+		if len(c.Parameters) > 0 {
+			current = append(current, c)
+		}
+	}
+	return current, nil
+}
+
+func (s *calibrationService) History(ctx context.Context, siteID primitive.ObjectID, filter repositories.CalibrationFilter) ([]*models.Calibration, error) {
+	history, err := s.calibrationRepo.ListHistory(ctx, siteID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calibrations: %w", err)
+	}
+	return history, nil
+}
+
+// CalibrationDrift turns a calibration history, oldest first, into the series of each
+// calibrated parameter of each device, with how far it drifted
+func CalibrationDrift(history []*models.Calibration) []models.CalibrationSeries {
+	var series []*models.CalibrationSeries
+	index := make(map[string]*models.CalibrationSeries)
+	for _, c := range history {
+		for name, value := range c.Parameters {
+			key := fmt.Sprintf("%s/%s/%s/%s", c.KitchenID, c.DeviceID, c.IngredientID.Hex(), name)
+			entry := index[key]
+			if entry == nil {
+				entry = &models.CalibrationSeries{
+					KitchenID:      c.KitchenID,
+					DeviceID:       c.DeviceID,
+					IngredientID:   c.IngredientID.Hex(),
+					IngredientName: c.IngredientName,
+					Parameter:      name,
+				}
+				index[key] = entry
+				series = append(series, entry)
+			}
+			entry.Points = append(entry.Points, models.CalibrationPoint{MeasuredAt: c.MeasuredAt, Value: value, Source: c.Source})
+		}
+	}
+
+	result := make([]models.CalibrationSeries, len(series))
+	for i, entry := range series {
+		first, last := entry.Points[0].Value, entry.Points[len(entry.Points)-1].Value
+		entry.Drift = round2(last - first)
+		if first != 0 {
+			entry.DriftPct = round2((last - first) / first * 100)
+		}
+		result[i] = *entry
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.IngredientName != b.IngredientName {
+			return a.IngredientName < b.IngredientName
+		}
+		if a.KitchenID != b.KitchenID {
+			return a.KitchenID < b.KitchenID
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.Parameter < b.Parameter
+	})
+	return result
+}
+
+func (s *calibrationService) ApplyToIngredients(ctx context.Context, siteID primitive.ObjectID, ingredients []models.IngredientForKOS) error {
+	calibrations, err := s.Current(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if len(calibrations) == 0 {
+		return nil
+	}
+	byIngredient := make(map[string][]*models.Calibration)
+	for _, c := range calibrations {
+		byIngredient[c.IngredientID.Hex()] = append(byIngredient[c.IngredientID.Hex()], c)
+	}
+	for i := range ingredients {
+		if cals := byIngredient[ingredients[i].ID]; len(cals) > 0 {
+			MergeCalibrations(&ingredients[i], cals)
+		}
+	}
+	return nil
+}
+
+func (s *calibrationService) IngredientsChangedSince(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]primitive.ObjectID, error) {
+	return s.calibrationRepo.ListIngredientsChangedSince(ctx, siteID, since)
+}
+
+// MergeCalibrations layers the calibrations of one ingredient over its tenant
+// defaults. The site-wide calibration goes into Parameters; each kitchen and device
+// calibration is listed with its full parameter set: defaults, then the site, then
+// its kitchen, then the device.
+func MergeCalibrations(ing *models.IngredientForKOS, calibrations []*models.Calibration) {
+	var site *models.Calibration
+	kitchens := make(map[string]*models.Calibration)
+	var devices []*models.Calibration
+	for _, c := range calibrations {
+		switch {
+		case c.KitchenID == "":
+			site = c
+		case c.DeviceID == "":
+			kitchens[c.KitchenID] = c
+		default:
+			devices = append(devices, c)
+		}
+	}
+
+	overlay := func(params map[string]any, c *models.Calibration) map[string]any {
+		merged := maps.Clone(params)
+		if merged == nil {
+			merged = make(map[string]any)
+		}
+		if c != nil {
+			for name, value := range c.Parameters {
+				merged[name] = value
+			}
+		}
+		return merged
+	}
+
+	if site != nil {
+		ing.Parameters = overlay(ing.Parameters, site)
+	}
+	for _, id := range slices.Sorted(maps.Keys(kitchens)) {
+		ing.Calibrations = append(ing.Calibrations, models.CalibrationForKOS{
+			KitchenID:  id,
+			Parameters: overlay(ing.Parameters, kitchens[id]),
+		})
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].KitchenID != devices[j].KitchenID {
+			return devices[i].KitchenID < devices[j].KitchenID
+		}
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+	for _, c := range devices {
+		ing.Calibrations = append(ing.Calibrations, models.CalibrationForKOS{
+			KitchenID:  c.KitchenID,
+			DeviceID:   c.DeviceID,
+			Parameters: overlay(overlay(ing.Parameters, kitchens[c.KitchenID]), c),
+		})
+	}
+}
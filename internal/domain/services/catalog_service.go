@@ -0,0 +1,625 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditActionRecipeImported is recorded on each recipe a bulk import created or updated
+const AuditActionRecipeImported = "recipe.imported"
+
+// CatalogService imports and exports a tenant's ingredients and recipes in bulk.
+// Records reference each other by name, so a catalog exported from one tenant can be
+// imported into another.
+type CatalogService interface {
+	// ImportIngredients creates or updates ingredients by name. Every row is checked
+	// before any is saved: a dry run reports what would happen, and a real import with
+	// a failing row saves nothing and returns a validation error listing the rows.
+	ImportIngredients(ctx context.Context, tenantID primitive.ObjectID, records []models.IngredientRecord, mode models.ImportMode, dryRun bool) (*models.ImportResult, error)
+	// ImportRecipes creates or updates recipes by name, resolving their ingredients by
+	// name. Updated recipes become a new draft version, like any edit. Rows fail and save
+	// like ImportIngredients.
+	ImportRecipes(ctx context.Context, tenantID primitive.ObjectID, bundle *models.RecipeBundle, mode models.ImportMode, dryRun bool, userID string) (*models.ImportResult, error)
+	// ExportIngredients returns every ingredient of the tenant, active or not, by name
+	ExportIngredients(ctx context.Context, tenantID primitive.ObjectID) ([]models.IngredientRecord, error)
+	// ExportRecipes returns the working copy of every recipe of the tenant that is not archived, by name
+	ExportRecipes(ctx context.Context, tenantID primitive.ObjectID) (*models.RecipeBundle, error)
+}
+
+type catalogService struct {
+	ingredientRepo repositories.IngredientRepository
+	recipeRepo     repositories.RecipeRepository
+	recipeService  RecipeService
+	auditRepo      repositories.AuditLogRepository
+}
+
+// NewCatalogService creates a new catalog service
+func NewCatalogService(
+	ingredientRepo repositories.IngredientRepository,
+	recipeRepo repositories.RecipeRepository,
+	recipeService RecipeService,
+	auditRepo repositories.AuditLogRepository,
+) CatalogService {
+	return &catalogService{
+		ingredientRepo: ingredientRepo,
+		recipeRepo:     recipeRepo,
+		recipeService:  recipeService,
+		auditRepo:      auditRepo,
+	}
+}
+
+// catalogKey is how imported names are matched: trimmed and case-insensitive
+func catalogKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// newImportResult starts the result of an import, defaulting the mode to create
+func newImportResult(mode models.ImportMode, dryRun bool) (*models.ImportResult, error) {
+	if mode == "" {
+		mode = models.ImportModeCreate
+	}
+	if !mode.IsValid() {
+		return nil, apperrors.Validation(fmt.Sprintf("unknown import mode %q", mode))
+	}
+	return &models.ImportResult{DryRun: dryRun, Mode: mode, Rows: []models.ImportRow{}}, nil
+}
+
+// addRow counts a row in the result
+func addRow(result *models.ImportResult, row models.ImportRow) {
+	if len(row.Errors) > 0 || len(row.Issues) > 0 {
+		row.Action = models.ImportActionFailed
+	}
+	switch row.Action {
+	case models.ImportActionCreated:
+		result.Created++
+	case models.ImportActionUpdated:
+		result.Updated++
+	case models.ImportActionSkipped:
+		result.Skipped++
+	case models.ImportActionFailed:
+		result.Failed++
+	}
+	result.Rows = append(result.Rows, row)
+}
+
+// importFailed returns the error of a real import with failing rows, listing them
+func importFailed(result *models.ImportResult) error {
+	var failed []models.ImportRow
+	for _, row := range result.Rows {
+		if row.Action == models.ImportActionFailed {
+			failed = append(failed, row)
+		}
+	}
+	return apperrors.Validation(fmt.Sprintf("%d of %d rows failed; nothing was imported", result.Failed, len(result.Rows))).WithDetails(failed)
+}
+
+// ingredientsByName loads the tenant's ingredients, active or not, keyed by catalogKey
+func (s *catalogService) ingredientsByName(ctx context.Context, tenantID primitive.ObjectID) (map[string]*models.Ingredient, error) {
+	ingredients, err := s.ingredientRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredients: %w", err)
+	}
+	byName := make(map[string]*models.Ingredient, len(ingredients))
+	for _, ing := range ingredients {
+		byName[catalogKey(ing.Name)] = ing
+	}
+	return byName, nil
+}
+
+func (s *catalogService) ImportIngredients(ctx context.Context, tenantID primitive.ObjectID, records []models.IngredientRecord, mode models.ImportMode, dryRun bool) (*models.ImportResult, error) {
+	result, err := newImportResult(mode, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.ingredientsByName(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	type change struct {
+		ingredient     *models.Ingredient
+		row            int // Index into result.Rows
+		create         bool
+		derivedChanged bool
+	}
+	var changes []change
+	seen := make(map[string]int)
+	for i, record := range records {
+		row := models.ImportRow{Row: i + 1, Name: strings.TrimSpace(record.Name)}
+		row.Errors = validateIngredientRecord(record)
+		key := catalogKey(record.Name)
+		if first, ok := seen[key]; ok && key != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("ingredient %q is already in row %d", row.Name, first))
+		}
+		seen[key] = row.Row
+
+		current := existing[key]
+		switch {
+		case len(row.Errors) > 0:
+		case current == nil:
+			ingredient := &models.Ingredient{TenantID: tenantID, Name: row.Name, IsActive: true}
+			applyIngredientRecord(ingredient, record)
+			changes = append(changes, change{ingredient: ingredient, row: len(result.Rows), create: true})
+			row.Action = models.ImportActionCreated
+		case result.Mode == models.ImportModeCreate:
+			row.Errors = append(row.Errors, fmt.Sprintf("ingredient %q already exists", current.Name))
+		case result.Mode == models.ImportModeSkip:
+			row.ID = current.ID.Hex()
+			row.Action = models.ImportActionSkipped
+		default:
+			updated := *current
+			updated.Parameters = maps.Clone(current.Parameters)
+			applyIngredientRecord(&updated, record)
+			changes = append(changes, change{ingredient: &updated, row: len(result.Rows), derivedChanged: ingredientDerivedChanged(current, &updated)})
+			row.ID = current.ID.Hex()
+			row.Action = models.ImportActionUpdated
+		}
+		addRow(result, row)
+	}
+
+	if result.Failed > 0 && !dryRun {
+		return nil, importFailed(result)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, c := range changes {
+		if c.create {
+			if err := s.ingredientRepo.Create(ctx, c.ingredient); err != nil {
+				return nil, fmt.Errorf("failed to create ingredient %s: %w", c.ingredient.Name, err)
+			}
+			result.Rows[c.row].ID = c.ingredient.ID.Hex()
+			continue
+		}
+		if err := s.ingredientRepo.Update(ctx, c.ingredient); err != nil {
+			return nil, fmt.Errorf("failed to update ingredient %s: %w", c.ingredient.Name, err)
+		}
+		// Recipes derive their allergens and nutrition from the ingredient
+		if c.derivedChanged {
+			if err := s.recipeService.RefreshForIngredient(ctx, c.ingredient.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// validateIngredientRecord returns what is wrong with an imported ingredient
+func validateIngredientRecord(record models.IngredientRecord) []string {
+	var errs []string
+	if strings.TrimSpace(record.Name) == "" {
+		errs = append(errs, "name is required")
+	}
+	switch record.MoistureType {
+	case models.MoistureTypeDry, models.MoistureTypeWet, models.MoistureTypeLiquid:
+	case "":
+		errs = append(errs, "moisture_type is required")
+	default:
+		errs = append(errs, fmt.Sprintf("moisture_type must be dry, wet or liquid, not %q", record.MoistureType))
+	}
+	if record.ShelfLifeHours < 0 {
+		errs = append(errs, "shelf_life_hours cannot be negative")
+	}
+	if n := record.Nutrition; n != nil {
+		if n.CaloriesPer100g < 0 || n.ProteinPer100g < 0 || n.FatPer100g < 0 || n.CarbsPer100g < 0 ||
+			n.SugarPer100g < 0 || n.FiberPer100g < 0 || n.SodiumPer100g < 0 {
+			errs = append(errs, "nutrition values cannot be negative")
+		}
+	}
+	for _, name := range []string{models.IngredientParamDensity, models.IngredientParamPieceWeight} {
+		if value, ok := record.Parameters[name]; ok {
+			if v, ok := toFloat(value); !ok || v <= 0 {
+				errs = append(errs, fmt.Sprintf("parameter %s must be a positive number", name))
+			}
+		}
+	}
+	return errs
+}
+
+// applyIngredientRecord copies an imported record onto an ingredient. Parameters the
+// record does not set are kept, as is the active flag when the record leaves it out.
+func applyIngredientRecord(ingredient *models.Ingredient, record models.IngredientRecord) {
+	ingredient.MoistureType = record.MoistureType
+	ingredient.ShelfLifeMinutes = record.ShelfLifeHours * 60
+	ingredient.AllergenInfo = nil
+	for _, allergen := range record.Allergens {
+		if trimmed := strings.TrimSpace(allergen); trimmed != "" {
+			ingredient.AllergenInfo = append(ingredient.AllergenInfo, trimmed)
+		}
+	}
+	ingredient.Nutrition = nil
+	if n := record.Nutrition; n != nil && *n != (models.NutritionInfo{}) {
+		nutrition := *n
+		ingredient.Nutrition = &nutrition
+	}
+	if len(record.Parameters) > 0 {
+		if ingredient.Parameters == nil {
+			ingredient.Parameters = make(map[string]any, len(record.Parameters))
+		}
+		maps.Copy(ingredient.Parameters, record.Parameters)
+	}
+	if record.IsActive != nil {
+		ingredient.IsActive = *record.IsActive
+	}
+}
+
+// ingredientDerivedChanged reports whether an update changes what recipes derive from the ingredient
+func ingredientDerivedChanged(old, updated *models.Ingredient) bool {
+	return !slices.Equal(old.AllergenInfo, updated.AllergenInfo) ||
+		(old.Nutrition == nil) != (updated.Nutrition == nil) ||
+		(old.Nutrition != nil && *old.Nutrition != *updated.Nutrition) ||
+		fmt.Sprint(old.Parameters[models.IngredientParamDensity]) != fmt.Sprint(updated.Parameters[models.IngredientParamDensity]) ||
+		fmt.Sprint(old.Parameters[models.IngredientParamPieceWeight]) != fmt.Sprint(updated.Parameters[models.IngredientParamPieceWeight])
+}
+
+func (s *catalogService) ImportRecipes(ctx context.Context, tenantID primitive.ObjectID, bundle *models.RecipeBundle, mode models.ImportMode, dryRun bool, userID string) (*models.ImportResult, error) {
+	result, err := newImportResult(mode, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if bundle == nil || len(bundle.Recipes) == 0 {
+		return nil, apperrors.Validation("the bundle has no recipes")
+	}
+	ingredients, err := s.ingredientsByName(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	recipes, err := s.recipeRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes: %w", err)
+	}
+	existing := make(map[string]*models.Recipe, len(recipes))
+	for _, recipe := range recipes {
+		if recipe.Status != models.RecipeStatusArchived {
+			existing[catalogKey(recipe.Name)] = recipe
+		}
+	}
+
+	type change struct {
+		recipe *models.Recipe
+		row    int // Index into result.Rows
+		create bool
+	}
+	var changes []change
+	seen := make(map[string]int)
+	for i, record := range bundle.Recipes {
+		row := models.ImportRow{Row: i + 1, Name: strings.TrimSpace(record.Name)}
+		key := catalogKey(record.Name)
+		if key == "" {
+			row.Errors = append(row.Errors, "name is required")
+		} else if first, ok := seen[key]; ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("recipe %q is already in row %d", row.Name, first))
+		}
+		seen[key] = row.Row
+		if len(row.Errors) > 0 {
+			addRow(result, row)
+			continue
+		}
+
+		current := existing[key]
+		if current != nil {
+			row.ID = current.ID.Hex()
+			if result.Mode == models.ImportModeCreate {
+				row.Errors = append(row.Errors, fmt.Sprintf("recipe %q already exists", current.Name))
+				addRow(result, row)
+				continue
+			}
+			if result.Mode == models.ImportModeSkip {
+				row.Action = models.ImportActionSkipped
+				addRow(result, row)
+				continue
+			}
+		}
+
+		content, errs := resolveRecipeRecord(record, ingredients)
+		if len(errs) > 0 {
+			row.Errors = errs
+			addRow(result, row)
+			continue
+		}
+
+		now := time.Now()
+		recipe := content
+		recipe.TenantID = tenantID
+		recipe.Name = row.Name
+		recipe.UpdatedBy = userID
+		recipe.UpdatedAt = now
+		if current == nil {
+			recipe.Status = models.RecipeStatusDraft
+			recipe.Version = 1
+			recipe.CreatedBy = userID
+			recipe.CreatedAt = now
+			row.Action = models.ImportActionCreated
+		} else {
+			updated := *current
+			updated.Description = recipe.Description
+			updated.Category = recipe.Category
+			updated.CuisineType = recipe.CuisineType
+			updated.EstimatedPrepTimeSec = recipe.EstimatedPrepTimeSec
+			updated.EstimatedCookingTimeSec = recipe.EstimatedCookingTimeSec
+			updated.Servings = recipe.Servings
+			updated.Ingredients = recipe.Ingredients
+			updated.SetSteps(recipe.Steps)
+			updated.Parameters = recipe.Parameters
+			recipe = &updated
+		}
+
+		if err := s.recipeService.ValidateRecipe(ctx, recipe); err != nil {
+			var apiErr *apperrors.APIError
+			if !errors.As(err, &apiErr) {
+				return nil, err
+			}
+			if issues, ok := apiErr.Details.([]models.RecipeIssue); ok {
+				row.Issues = issues
+			} else {
+				row.Errors = append(row.Errors, apiErr.Message)
+			}
+			addRow(result, row)
+			continue
+		}
+		if err := s.recipeService.RefreshDerived(ctx, recipe, normalizeAllergens(record.Allergens)); err != nil {
+			return nil, err
+		}
+		if current != nil {
+			if sameJSON(recipeContent(current), recipeContent(recipe)) {
+				row.Action = models.ImportActionSkipped
+				addRow(result, row)
+				continue
+			}
+			// A changed recipe is imported as a new version of it
+			recipe.ResetReview()
+			recipe.UpdatedBy = userID
+			recipe.UpdatedAt = now
+			recipe.Version++
+			row.Action = models.ImportActionUpdated
+		}
+		changes = append(changes, change{recipe: recipe, row: len(result.Rows), create: current == nil})
+		addRow(result, row)
+	}
+
+	if result.Failed > 0 && !dryRun {
+		return nil, importFailed(result)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, c := range changes {
+		if c.create {
+			if err := s.recipeRepo.Create(ctx, c.recipe); err != nil {
+				return nil, fmt.Errorf("failed to create recipe %s: %w", c.recipe.Name, err)
+			}
+			result.Rows[c.row].ID = c.recipe.ID.Hex()
+		} else if err := s.recipeRepo.Update(ctx, c.recipe); err != nil {
+			return nil, fmt.Errorf("failed to update recipe %s: %w", c.recipe.Name, err)
+		}
+		newState := map[string]any{"version": c.recipe.Version, "status": c.recipe.Status, "action": result.Rows[c.row].Action}
+		if err := recordAudit(ctx, s.auditRepo, tenantID, userID, AuditActionRecipeImported, AuditResourceRecipe, c.recipe.ID.Hex(), nil, newState); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// recipeContent is what an import can change in a recipe. Empty lists compare equal
+// to missing ones, which is how a stored recipe comes back from the database.
+func recipeContent(recipe *models.Recipe) map[string]any {
+	parameters := recipe.Parameters
+	if len(parameters) == 0 {
+		parameters = nil
+	}
+	return map[string]any{
+		"description":  recipe.Description,
+		"category":     recipe.Category,
+		"cuisine_type": recipe.CuisineType,
+		"prep_time":    recipe.EstimatedPrepTimeSec,
+		"cook_time":    recipe.EstimatedCookingTimeSec,
+		"servings":     recipe.Servings,
+		"ingredients":  emptyAsNil(recipe.Ingredients),
+		"steps":        emptyAsNil(recipe.Steps),
+		"source_steps": emptyAsNil(recipe.SourceSteps),
+		"parameters":   parameters,
+		"allergens":    emptyAsNil(recipe.Allergens),
+	}
+}
+
+func emptyAsNil[T any](list []T) []T {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// normalizeAllergens returns the non-empty allergens, or an empty list so that
+// RefreshDerived replaces the manual allergens rather than keeping them
+func normalizeAllergens(allergens []string) []string {
+	manual := []string{}
+	for _, allergen := range allergens {
+		if trimmed := strings.TrimSpace(allergen); trimmed != "" {
+			manual = append(manual, trimmed)
+		}
+	}
+	return manual
+}
+
+// resolveRecipeRecord builds a recipe's content from an imported record, resolving
+// ingredient names against the tenant's catalog
+func resolveRecipeRecord(record models.RecipeRecord, ingredients map[string]*models.Ingredient) (*models.Recipe, []string) {
+	var errs []string
+	resolve := func(path, name string) *models.Ingredient {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Sprintf("%s: ingredient_name is required", path))
+			return nil
+		}
+		ing := ingredients[catalogKey(name)]
+		if ing == nil {
+			errs = append(errs, fmt.Sprintf("%s: unknown ingredient %q", path, name))
+		}
+		return ing
+	}
+
+	recipe := &models.Recipe{
+		Description:             record.Description,
+		Category:                record.Category,
+		CuisineType:             record.CuisineType,
+		EstimatedPrepTimeSec:    record.PrepTimeSec,
+		EstimatedCookingTimeSec: record.CookTimeSec,
+		Servings:                record.Servings,
+		Parameters:              record.Parameters,
+		Ingredients:             make([]models.RecipeIngredient, 0, len(record.Ingredients)),
+		Steps:                   make([]models.RecipeStep, 0, len(record.Steps)),
+	}
+	for i, ri := range record.Ingredients {
+		path := fmt.Sprintf("ingredients[%d]", i)
+		ing := resolve(path, ri.IngredientName)
+		entry := models.RecipeIngredient{
+			QuantityRequired: ri.QuantityRequired,
+			Unit:             ri.Unit,
+			PrepNotes:        ri.PrepNotes,
+			TimingStep:       ri.TimingStep,
+			IsCritical:       ri.IsCritical,
+			Scaling:          ri.Scaling,
+			MaxExtraFactor:   ri.MaxExtraFactor,
+		}
+		if ing != nil {
+			entry.IngredientID = ing.ID
+			entry.IngredientName = ing.Name
+		}
+		for j, name := range ri.Substitutes {
+			if sub := resolve(fmt.Sprintf("%s.substitutes[%d]", path, j), name); sub != nil {
+				entry.Substitutes = append(entry.Substitutes, sub.ID)
+			}
+		}
+		recipe.Ingredients = append(recipe.Ingredients, entry)
+	}
+	for i, step := range record.Steps {
+		// Exports carry expanded steps, so imported recipes never use step templates
+		if step.Action == models.StepTemplateAction {
+			errs = append(errs, fmt.Sprintf("steps[%d].action: step templates cannot be imported; import the expanded steps", i))
+		}
+		step.Parameters = maps.Clone(step.Parameters)
+		if name, ok := step.Parameters["ingredient_name"].(string); ok {
+			if ing := resolve(fmt.Sprintf("steps[%d].parameters", i), name); ing != nil {
+				step.Parameters["ingredient_id"] = ing.ID.Hex()
+				step.Parameters["ingredient_name"] = ing.Name
+			}
+		} else if _, ok := step.Parameters["ingredient_id"]; ok {
+			errs = append(errs, fmt.Sprintf("steps[%d].parameters: reference the ingredient by ingredient_name", i))
+		}
+		recipe.Steps = append(recipe.Steps, step)
+	}
+	return recipe, errs
+}
+
+func (s *catalogService) ExportIngredients(ctx context.Context, tenantID primitive.ObjectID) ([]models.IngredientRecord, error) {
+	ingredients, err := s.ingredientRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredients: %w", err)
+	}
+	slices.SortFunc(ingredients, func(a, b *models.Ingredient) int {
+		return cmp.Compare(catalogKey(a.Name), catalogKey(b.Name))
+	})
+
+	records := make([]models.IngredientRecord, 0, len(ingredients))
+	for _, ing := range ingredients {
+		active := ing.IsActive
+		records = append(records, models.IngredientRecord{
+			Name:           ing.Name,
+			MoistureType:   ing.MoistureType,
+			ShelfLifeHours: ing.ShelfLifeMinutes / 60,
+			Allergens:      ing.AllergenInfo,
+			Nutrition:      ing.Nutrition,
+			Parameters:     ing.Parameters,
+			IsActive:       &active,
+		})
+	}
+	return records, nil
+}
+
+func (s *catalogService) ExportRecipes(ctx context.Context, tenantID primitive.ObjectID) (*models.RecipeBundle, error) {
+	recipes, err := s.recipeRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes: %w", err)
+	}
+	ingredients, err := s.ingredientRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredients: %w", err)
+	}
+	// Current names, so that a renamed ingredient exports under the name it imports by
+	names := make(map[primitive.ObjectID]string, len(ingredients))
+	for _, ing := range ingredients {
+		names[ing.ID] = ing.Name
+	}
+	name := func(id primitive.ObjectID, fallback string) string {
+		if current, ok := names[id]; ok {
+			return current
+		}
+		return fallback
+	}
+
+	recipes = slices.DeleteFunc(recipes, func(r *models.Recipe) bool { return r.Status == models.RecipeStatusArchived })
+	slices.SortFunc(recipes, func(a, b *models.Recipe) int {
+		return cmp.Compare(catalogKey(a.Name), catalogKey(b.Name))
+	})
+
+	now := time.Now()
+	bundle := &models.RecipeBundle{ExportedAt: &now, Recipes: make([]models.RecipeRecord, 0, len(recipes))}
+	for _, recipe := range recipes {
+		record := models.RecipeRecord{
+			Name:        recipe.Name,
+			Description: recipe.Description,
+			Category:    recipe.Category,
+			CuisineType: recipe.CuisineType,
+			PrepTimeSec: recipe.EstimatedPrepTimeSec,
+			CookTimeSec: recipe.EstimatedCookingTimeSec,
+			Servings:    recipe.Servings,
+			Allergens:   manualAllergens(recipe),
+			Ingredients: make([]models.RecipeIngredientRecord, 0, len(recipe.Ingredients)),
+			Steps:       make([]models.RecipeStep, 0, len(recipe.Steps)),
+			Parameters:  recipe.Parameters,
+		}
+		for _, ri := range recipe.Ingredients {
+			entry := models.RecipeIngredientRecord{
+				IngredientName:   name(ri.IngredientID, ri.IngredientName),
+				QuantityRequired: ri.QuantityRequired,
+				Unit:             ri.Unit,
+				PrepNotes:        ri.PrepNotes,
+				TimingStep:       ri.TimingStep,
+				IsCritical:       ri.IsCritical,
+				Scaling:          ri.Scaling,
+				MaxExtraFactor:   ri.MaxExtraFactor,
+			}
+			for _, id := range ri.Substitutes {
+				entry.Substitutes = append(entry.Substitutes, name(id, id.Hex()))
+			}
+			record.Ingredients = append(record.Ingredients, entry)
+		}
+		for _, step := range recipe.Steps {
+			step.Parameters = maps.Clone(step.Parameters)
+			if value, ok := step.Parameters["ingredient_id"]; ok {
+				fallback, _ := step.Parameters["ingredient_name"].(string)
+				if id, err := primitive.ObjectIDFromHex(fmt.Sprint(value)); err == nil {
+					step.Parameters["ingredient_name"] = name(id, fallback)
+				}
+				delete(step.Parameters, "ingredient_id")
+			}
+			record.Steps = append(record.Steps, step)
+		}
+		bundle.Recipes = append(bundle.Recipes, record)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRecipeExportImportsBack(t *testing.T) {
+	ctx := context.Background()
+	tenantID := primitive.NewObjectID()
+	lentils := &models.Ingredient{ID: primitive.NewObjectID(), TenantID: tenantID, Name: "Lentils", MoistureType: models.MoistureTypeDry, IsActive: true}
+	ingredients := newFakeIngredientRepo(lentils)
+	recipes := newFakeRecipeRepo(stepsOnlyRecipe(tenantID, lentils))
+	s := NewCatalogService(ingredients, recipes, NewRecipeService(recipes, ingredients, nil, nil, nil, nil, nil), nil)
+
+	bundle, err := s.ExportRecipes(ctx, tenantID)
+	if err != nil {
+		t.Fatalf("ExportRecipes: %v", err)
+	}
+
+	// Into the same tenant the file changes nothing
+	result, err := s.ImportRecipes(ctx, tenantID, bundle, models.ImportModeUpsert, false, "")
+	if err != nil {
+		t.Fatalf("ImportRecipes: %v", err)
+	}
+	if result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("upsert: skipped %d, failed %d; want 1 skipped: %+v", result.Skipped, result.Failed, result.Rows)
+	}
+
+	// Into a tenant without the recipe it creates it
+	otherID := primitive.NewObjectID()
+	ingredients.ingredients[primitive.NewObjectID()] = &models.Ingredient{TenantID: otherID, Name: "Lentils", MoistureType: models.MoistureTypeDry, IsActive: true}
+	for id, ing := range ingredients.ingredients {
+		ing.ID = id
+	}
+	result, err = s.ImportRecipes(ctx, otherID, bundle, models.ImportModeCreate, false, "")
+	if err != nil {
+		t.Fatalf("ImportRecipes: %v", err)
+	}
+	if result.Created != 1 || result.Failed != 0 {
+		t.Errorf("create: created %d, failed %d; want 1 created: %+v", result.Created, result.Failed, result.Rows)
+	}
+}
@@ -0,0 +1,588 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CostingService keeps the price history of ingredients and of recipes at sites, and
+// reports what recipes cost to make and what they earn
+type CostingService interface {
+	// RecordCosts stores new ingredient costs for a tenant, in its default currency
+	RecordCosts(ctx context.Context, tenantID primitive.ObjectID, inputs []CostInput, recordedBy string) ([]*models.IngredientCost, error)
+	// Costs returns a tenant's ingredient costs, optionally of one ingredient, oldest first
+	Costs(ctx context.Context, tenantID primitive.ObjectID, ingredientID *primitive.ObjectID) ([]*models.IngredientCost, error)
+	// SetPrices stores new menu prices for a site, in its tenant's default currency
+	SetPrices(ctx context.Context, site *models.Site, inputs []PriceInput, recordedBy string) ([]*models.MenuPrice, error)
+	// Prices returns a site's menu prices, optionally of one recipe, oldest first
+	Prices(ctx context.Context, siteID primitive.ObjectID, recipeID *primitive.ObjectID) ([]*models.MenuPrice, error)
+	// RecipeCost costs a recipe at every standard pot size with the costs in effect at a
+	// time. With a site, its region's overrides apply and its menu prices give margins.
+	RecipeCost(ctx context.Context, recipe *models.Recipe, site *models.Site, at time.Time) (*models.RecipeCost, error)
+	// Margins costs the recipes served at a site, with their menu prices, as of now
+	Margins(ctx context.Context, site *models.Site, recipes []*models.Recipe) ([]*models.RecipeCost, error)
+	// History totals the cost and revenue of the orders a site completed in [since, until)
+	History(ctx context.Context, site *models.Site, since, until time.Time) (*models.CostHistory, error)
+}
+
+// CostInput is the cost of an ingredient for a whole tenant or, with RegionID, one region
+type CostInput struct {
+	IngredientID  primitive.ObjectID
+	RegionID      *primitive.ObjectID
+	Cost          float64
+	Unit          string
+	EffectiveFrom time.Time // Now if zero
+	Notes         string
+}
+
+// PriceInput is the menu price of a recipe at a pot size
+type PriceInput struct {
+	RecipeID      primitive.ObjectID
+	PotPercentage int // 100 if zero
+	Price         float64
+	EffectiveFrom time.Time // Now if zero
+}
+
+type costingService struct {
+	costRepo       repositories.IngredientCostRepository
+	priceRepo      repositories.MenuPriceRepository
+	recipeRepo     repositories.RecipeRepository
+	ingredientRepo repositories.IngredientRepository
+	orderRepo      repositories.OrderRepository
+	regionRepo     repositories.RegionRepository
+	tenantRepo     repositories.TenantRepository
+}
+
+// NewCostingService creates a new costing service
+func NewCostingService(
+	costRepo repositories.IngredientCostRepository,
+	priceRepo repositories.MenuPriceRepository,
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	orderRepo repositories.OrderRepository,
+	regionRepo repositories.RegionRepository,
+	tenantRepo repositories.TenantRepository,
+) CostingService {
+	return &costingService{
+		costRepo:       costRepo,
+		priceRepo:      priceRepo,
+		recipeRepo:     recipeRepo,
+		ingredientRepo: ingredientRepo,
+		orderRepo:      orderRepo,
+		regionRepo:     regionRepo,
+		tenantRepo:     tenantRepo,
+	}
+}
+
+func (s *costingService) RecordCosts(ctx context.Context, tenantID primitive.ObjectID, inputs []CostInput, recordedBy string) ([]*models.IngredientCost, error) {
+	currency, err := s.currency(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if currency == "" {
+		return nil, apperrors.Validation("the tenant has no default currency; set settings.default_currency first")
+	}
+
+	ids := make([]primitive.ObjectID, len(inputs))
+	for i, in := range inputs {
+		ids[i] = in.IngredientID
+	}
+	ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingredients: %w", err)
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient, len(ingredients))
+	for _, ing := range ingredients {
+		byID[ing.ID] = ing
+	}
+
+	for i, in := range inputs {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("cost %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"cost": i, "ingredient_id": in.IngredientID.Hex()})
+		}
+		if ing := byID[in.IngredientID]; ing == nil || ing.TenantID != tenantID {
+			return nil, invalid("ingredient %s not found", in.IngredientID.Hex())
+		}
+		if in.Cost < 0 || math.IsNaN(in.Cost) || math.IsInf(in.Cost, 0) {
+			return nil, invalid("cost must be a finite number of at least 0")
+		}
+		if _, ok := models.LookupUnit(in.Unit); !ok {
+			return nil, invalid("unknown unit %q", in.Unit)
+		}
+		if in.RegionID != nil {
+			region, err := s.regionRepo.GetByID(ctx, *in.RegionID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get region: %w", err)
+			}
+			if region == nil || region.TenantID != tenantID {
+				return nil, invalid("region %s not found", in.RegionID.Hex())
+			}
+		}
+	}
+
+	now := time.Now()
+	costs := make([]*models.IngredientCost, len(inputs))
+	for i, in := range inputs {
+		effective := in.EffectiveFrom
+		if effective.IsZero() {
+			effective = now
+		}
+		costs[i] = &models.IngredientCost{
+			TenantID:       tenantID,
+			IngredientID:   in.IngredientID,
+			IngredientName: byID[in.IngredientID].Name,
+			RegionID:       in.RegionID,
+			Cost:           in.Cost,
+			Unit:           in.Unit,
+			Currency:       currency,
+			EffectiveFrom:  effective,
+			RecordedBy:     recordedBy,
+			Notes:          in.Notes,
+		}
+		if err := s.costRepo.Create(ctx, costs[i]); err != nil {
+			return nil, fmt.Errorf("failed to save ingredient cost: %w", err)
+		}
+	}
+	return costs, nil
+}
+
+func (s *costingService) Costs(ctx context.Context, tenantID primitive.ObjectID, ingredientID *primitive.ObjectID) ([]*models.IngredientCost, error) {
+	costs, err := s.costRepo.ListByTenant(ctx, tenantID, ingredientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredient costs: %w", err)
+	}
+	if costs == nil {
+		costs = []*models.IngredientCost{}
+	}
+	return costs, nil
+}
+
+func (s *costingService) SetPrices(ctx context.Context, site *models.Site, inputs []PriceInput, recordedBy string) ([]*models.MenuPrice, error) {
+	currency, err := s.currency(ctx, site.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if currency == "" {
+		return nil, apperrors.Validation("the tenant has no default currency; set settings.default_currency first")
+	}
+
+	recipes := make(map[primitive.ObjectID]*models.Recipe)
+	for i, in := range inputs {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("price %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"price": i, "recipe_id": in.RecipeID.Hex()})
+		}
+		if _, ok := recipes[in.RecipeID]; !ok {
+			recipe, err := s.recipeRepo.GetByID(ctx, in.RecipeID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get recipe: %w", err)
+			}
+			if recipe == nil || recipe.TenantID != site.TenantID {
+				return nil, invalid("recipe %s not found", in.RecipeID.Hex())
+			}
+			recipes[in.RecipeID] = recipe
+		}
+		if in.PotPercentage != 0 && !slices.Contains(models.StandardPotPercentages, in.PotPercentage) {
+			return nil, invalid("pot_percentage must be one of %v", models.StandardPotPercentages)
+		}
+		if in.Price < 0 || math.IsNaN(in.Price) || math.IsInf(in.Price, 0) {
+			return nil, invalid("price must be a finite number of at least 0")
+		}
+	}
+
+	now := time.Now()
+	prices := make([]*models.MenuPrice, len(inputs))
+	for i, in := range inputs {
+		effective := in.EffectiveFrom
+		if effective.IsZero() {
+			effective = now
+		}
+		pot := in.PotPercentage
+		if pot == 0 {
+			pot = 100
+		}
+		prices[i] = &models.MenuPrice{
+			TenantID:      site.TenantID,
+			SiteID:        site.ID,
+			RecipeID:      in.RecipeID,
+			RecipeName:    recipes[in.RecipeID].Name,
+			PotPercentage: pot,
+			Price:         in.Price,
+			Currency:      currency,
+			EffectiveFrom: effective,
+			RecordedBy:    recordedBy,
+		}
+		if err := s.priceRepo.Create(ctx, prices[i]); err != nil {
+			return nil, fmt.Errorf("failed to save menu price: %w", err)
+		}
+	}
+	return prices, nil
+}
+
+func (s *costingService) Prices(ctx context.Context, siteID primitive.ObjectID, recipeID *primitive.ObjectID) ([]*models.MenuPrice, error) {
+	prices, err := s.priceRepo.ListBySite(ctx, siteID, recipeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list menu prices: %w", err)
+	}
+	if prices == nil {
+		prices = []*models.MenuPrice{}
+	}
+	return prices, nil
+}
+
+func (s *costingService) RecipeCost(ctx context.Context, recipe *models.Recipe, site *models.Site, at time.Time) (*models.RecipeCost, error) {
+	book, err := s.loadBook(ctx, recipe.TenantID, site)
+	if err != nil {
+		return nil, err
+	}
+	ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, recipe)
+	if err != nil {
+		return nil, err
+	}
+	return book.recipeCost(recipe, ingredients, at), nil
+}
+
+func (s *costingService) Margins(ctx context.Context, site *models.Site, recipes []*models.Recipe) ([]*models.RecipeCost, error) {
+	book, err := s.loadBook(ctx, site.TenantID, site)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	margins := make([]*models.RecipeCost, 0, len(recipes))
+	for _, recipe := range recipes {
+		ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, recipe)
+		if err != nil {
+			return nil, err
+		}
+		margins = append(margins, book.recipeCost(recipe, ingredients, now))
+	}
+	slices.SortStableFunc(margins, func(a, b *models.RecipeCost) int {
+		return cmp.Compare(a.RecipeName, b.RecipeName)
+	})
+	return margins, nil
+}
+
+func (s *costingService) History(ctx context.Context, site *models.Site, since, until time.Time) (*models.CostHistory, error) {
+	if !until.After(since) {
+		return nil, apperrors.Validation("until must be after since")
+	}
+	book, err := s.loadBook(ctx, site.TenantID, site)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.orderRepo.ListCompletedForSite(ctx, site.ID, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list completed orders: %w", err)
+	}
+
+	loc := time.UTC
+	if site.Timezone != "" {
+		if l, err := time.LoadLocation(site.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	history := &models.CostHistory{
+		SiteID:   site.ID.Hex(),
+		Currency: book.currency,
+		Since:    since,
+		Until:    until,
+		Days:     []models.CostPeriod{},
+		Recipes:  []models.RecipeCostSummary{},
+	}
+	days := make(map[string]*models.CostPeriod)
+	var dayOrder []string
+	recipes := make(map[primitive.ObjectID]*models.RecipeCostSummary)
+	var recipeOrder []primitive.ObjectID
+	type cached struct {
+		recipe      *models.Recipe
+		ingredients map[primitive.ObjectID]*models.Ingredient
+	}
+	versions := make(map[string]cached)
+
+	for _, o := range orders {
+		completed := o.UpdatedAt
+		if o.CompletedAt != nil {
+			completed = *o.CompletedAt
+		}
+
+		key := fmt.Sprintf("%s@%d", o.RecipeID.Hex(), o.RecipeVersion)
+		entry, ok := versions[key]
+		if !ok {
+			recipe, err := s.orderRecipe(ctx, o)
+			if err != nil {
+				return nil, err
+			}
+			entry = cached{recipe: recipe}
+			if recipe != nil {
+				if entry.ingredients, err = LoadRecipeIngredients(ctx, s.ingredientRepo, recipe); err != nil {
+					return nil, err
+				}
+			}
+			versions[key] = entry
+		}
+
+		// An order whose recipe was deleted is counted, but cannot be costed
+		cost, uncosted := 0.0, []string{o.RecipeName}
+		if entry.recipe != nil {
+			cooked := ApplyModifications(ScaleRecipe(entry.recipe, o.PotPercentage), o.Modifications)
+			_, cost, uncosted = book.costLines(cooked, entry.ingredients, completed)
+		}
+		price, priced := book.price(o.RecipeID, o.PotPercentage, completed)
+
+		date := completed.In(loc).Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &models.CostPeriod{Date: date}
+			days[date] = day
+			dayOrder = append(dayOrder, date)
+		}
+		summary, ok := recipes[o.RecipeID]
+		if !ok {
+			summary = &models.RecipeCostSummary{RecipeID: o.RecipeID.Hex(), RecipeName: o.RecipeName}
+			recipes[o.RecipeID] = summary
+			recipeOrder = append(recipeOrder, o.RecipeID)
+		}
+		for _, period := range []*models.CostPeriod{day, &summary.CostPeriod, &history.Total} {
+			period.Orders++
+			period.Cost += cost
+			if len(uncosted) > 0 {
+				period.Incomplete++
+			}
+			if priced {
+				period.Revenue += price
+				period.Margin += price - cost
+			} else {
+				period.Unpriced++
+			}
+		}
+	}
+
+	slices.Sort(dayOrder)
+	for _, date := range dayOrder {
+		history.Days = append(history.Days, finishPeriod(*days[date]))
+	}
+	for _, id := range recipeOrder {
+		summary := *recipes[id]
+		summary.CostPeriod = finishPeriod(summary.CostPeriod)
+		history.Recipes = append(history.Recipes, summary)
+	}
+	slices.SortStableFunc(history.Recipes, func(a, b models.RecipeCostSummary) int {
+		return cmp.Compare(b.Cost, a.Cost)
+	})
+	history.Total = finishPeriod(history.Total)
+	return history, nil
+}
+
+// finishPeriod rounds a period's totals and works out its food cost percentage
+func finishPeriod(p models.CostPeriod) models.CostPeriod {
+	if p.Revenue > 0 {
+		pct := round2((p.Revenue - p.Margin) / p.Revenue * 100)
+		p.FoodCostPct = &pct
+	}
+	p.Cost = round2(p.Cost)
+	p.Revenue = round2(p.Revenue)
+	p.Margin = round2(p.Margin)
+	return p
+}
+
+// orderRecipe returns the recipe version an order was cooked with, or nil if the
+// recipe was deleted
+func (s *costingService) orderRecipe(ctx context.Context, order *models.Order) (*models.Recipe, error) {
+	if order.RecipeVersion > 0 {
+		snapshot, err := s.recipeRepo.GetVersion(ctx, order.RecipeID, order.RecipeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recipe version: %w", err)
+		}
+		if snapshot != nil {
+			return &snapshot.Recipe, nil
+		}
+	}
+	recipe, err := s.recipeRepo.GetByID(ctx, order.RecipeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe: %w", err)
+	}
+	return recipe, nil
+}
+
+func (s *costingService) currency(ctx context.Context, tenantID primitive.ObjectID) (string, error) {
+	tenant, err := s.tenantRepo.GetByID(ctx, tenantID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant == nil {
+		return "", apperrors.NotFound("tenant")
+	}
+	if tenant.Settings == nil {
+		return "", nil
+	}
+	return tenant.Settings.DefaultCurrency, nil
+}
+
+// loadBook loads a tenant's ingredient costs and, with a site, its menu prices
+func (s *costingService) loadBook(ctx context.Context, tenantID primitive.ObjectID, site *models.Site) (*costBook, error) {
+	currency, err := s.currency(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	costs, err := s.costRepo.ListByTenant(ctx, tenantID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredient costs: %w", err)
+	}
+	book := &costBook{
+		currency: currency,
+		costs:    make(map[primitive.ObjectID][]*models.IngredientCost),
+	}
+	for _, c := range costs {
+		book.costs[c.IngredientID] = append(book.costs[c.IngredientID], c)
+	}
+	if site != nil {
+		book.site = site
+		prices, err := s.priceRepo.ListBySite(ctx, site.ID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list menu prices: %w", err)
+		}
+		book.prices = prices
+	}
+	return book, nil
+}
+
+// costBook holds the price history needed to cost recipes for one tenant, and one
+// site when prices and region overrides apply
+type costBook struct {
+	currency string
+	site     *models.Site
+	costs    map[primitive.ObjectID][]*models.IngredientCost // By ingredient, oldest first
+	prices   []*models.MenuPrice                             // Oldest first
+}
+
+// cost returns the cost of an ingredient in effect at a time: the site region's
+// latest override, else the tenant's latest cost
+func (b *costBook) cost(ingredientID primitive.ObjectID, at time.Time) *models.IngredientCost {
+	var tenantCost, regionCost *models.IngredientCost
+	for _, c := range b.costs[ingredientID] {
+		if c.EffectiveFrom.After(at) {
+			break
+		}
+		switch {
+		case c.RegionID == nil:
+			tenantCost = c
+		case b.site != nil && *c.RegionID == b.site.RegionID:
+			regionCost = c
+		}
+	}
+	if regionCost != nil {
+		return regionCost
+	}
+	return tenantCost
+}
+
+// price returns the site's menu price of a recipe at a pot size in effect at a time
+func (b *costBook) price(recipeID primitive.ObjectID, potPercentage int, at time.Time) (float64, bool) {
+	if potPercentage <= 0 || potPercentage > 100 {
+		potPercentage = 100
+	}
+	var price *models.MenuPrice
+	for _, p := range b.prices {
+		if p.EffectiveFrom.After(at) {
+			break
+		}
+		if p.RecipeID == recipeID && p.PotPercentage == potPercentage && p.Currency == b.currency {
+			price = p
+		}
+	}
+	if price == nil {
+		return 0, false
+	}
+	return price.Price, true
+}
+
+// costLines costs the ingredients of a recipe as cooked, already scaled and with its
+// modifications applied. Ingredients without a cost in the tenant's currency, or
+// whose quantity cannot be converted to the unit they are priced in, are returned as
+// uncosted and left out of the total.
+func (b *costBook) costLines(
+	recipe *models.Recipe,
+	ingredients map[primitive.ObjectID]*models.Ingredient,
+	at time.Time,
+) (lines []models.IngredientCostLine, total float64, uncosted []string) {
+	lines = []models.IngredientCostLine{}
+	uncosted = []string{}
+	for _, amount := range recipeAmounts(recipe) {
+		ing := ingredients[amount.ID]
+		if ing != nil {
+			amount.Name = ing.Name
+		}
+		if amount.Name == "" {
+			amount.Name = amount.ID.Hex()
+		}
+
+		c := b.cost(amount.ID, at)
+		if c == nil || c.Currency != b.currency {
+			uncosted = append(uncosted, amount.Name)
+			continue
+		}
+		quantity, ok := ConvertQuantity(amount.Quantity, amount.Unit, c.Unit, ing)
+		if !ok {
+			uncosted = append(uncosted, amount.Name)
+			continue
+		}
+		cost := quantity * c.Cost
+		lines = append(lines, models.IngredientCostLine{
+			IngredientID:   amount.ID.Hex(),
+			IngredientName: amount.Name,
+			Quantity:       amount.Quantity,
+			Unit:           amount.Unit,
+			UnitCost:       c.Cost,
+			CostUnit:       c.Unit,
+			Cost:           round2(cost),
+			RegionOverride: c.RegionID != nil,
+		})
+		total += cost
+	}
+	return lines, round2(total), uncosted
+}
+
+// recipeCost costs a recipe at every standard pot size and, with a site, adds the
+// margins of the pot sizes it has a menu price for
+func (b *costBook) recipeCost(recipe *models.Recipe, ingredients map[primitive.ObjectID]*models.Ingredient, at time.Time) *models.RecipeCost {
+	rc := &models.RecipeCost{
+		RecipeID:   recipe.ID.Hex(),
+		RecipeName: recipe.Name,
+		Version:    recipe.Version,
+		Currency:   b.currency,
+		At:         at,
+		PotSizes:   []models.PotCost{},
+	}
+	if b.site != nil {
+		rc.SiteID = b.site.ID.Hex()
+		rc.RegionID = b.site.RegionID.Hex()
+	}
+	rc.Lines, _, rc.Uncosted = b.costLines(recipe, ingredients, at)
+	rc.Complete = len(rc.Uncosted) == 0
+
+	for _, pot := range models.StandardPotPercentages {
+		_, cost, _ := b.costLines(ScaleRecipe(recipe, pot), ingredients, at)
+		pc := models.PotCost{PotPercentage: pot, Cost: cost}
+		if price, ok := b.price(recipe.ID, pot, at); ok {
+			margin := round2(price - cost)
+			pc.Price, pc.Margin = &price, &margin
+			if price > 0 {
+				pct := round2(cost / price * 100)
+				pc.FoodCostPct = &pct
+			}
+		}
+		rc.PotSizes = append(rc.PotSizes, pc)
+	}
+	return rc
+}
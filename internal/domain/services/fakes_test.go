@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeIngredientRepo keeps ingredients in memory; methods the tests do not reach panic
+type fakeIngredientRepo struct {
+	repositories.IngredientRepository
+	ingredients map[primitive.ObjectID]*models.Ingredient
+}
+
+func newFakeIngredientRepo(ingredients ...*models.Ingredient) *fakeIngredientRepo {
+	repo := &fakeIngredientRepo{ingredients: make(map[primitive.ObjectID]*models.Ingredient)}
+	for _, ing := range ingredients {
+		repo.ingredients[ing.ID] = ing
+	}
+	return repo
+}
+
+func (r *fakeIngredientRepo) Create(_ context.Context, ingredient *models.Ingredient) error {
+	if ingredient.ID.IsZero() {
+		ingredient.ID = primitive.NewObjectID()
+	}
+	r.ingredients[ingredient.ID] = ingredient
+	return nil
+}
+
+func (r *fakeIngredientRepo) Update(_ context.Context, ingredient *models.Ingredient) error {
+	r.ingredients[ingredient.ID] = ingredient
+	return nil
+}
+
+func (r *fakeIngredientRepo) GetByID(_ context.Context, id primitive.ObjectID) (*models.Ingredient, error) {
+	return r.ingredients[id], nil
+}
+
+func (r *fakeIngredientRepo) GetByIDs(_ context.Context, ids []primitive.ObjectID) ([]*models.Ingredient, error) {
+	var list []*models.Ingredient
+	for _, id := range ids {
+		if ing := r.ingredients[id]; ing != nil {
+			list = append(list, ing)
+		}
+	}
+	return list, nil
+}
+
+func (r *fakeIngredientRepo) ListUpdatedSince(_ context.Context, tenantID primitive.ObjectID, _ time.Time) ([]*models.Ingredient, error) {
+	var list []*models.Ingredient
+	for _, ing := range r.ingredients {
+		if ing.TenantID == tenantID {
+			list = append(list, ing)
+		}
+	}
+	return list, nil
+}
+
+// fakeRecipeRepo keeps recipes in memory; methods the tests do not reach panic
+type fakeRecipeRepo struct {
+	repositories.RecipeRepository
+	recipes map[primitive.ObjectID]*models.Recipe
+}
+
+func newFakeRecipeRepo(recipes ...*models.Recipe) *fakeRecipeRepo {
+	repo := &fakeRecipeRepo{recipes: make(map[primitive.ObjectID]*models.Recipe)}
+	for _, recipe := range recipes {
+		repo.recipes[recipe.ID] = recipe
+	}
+	return repo
+}
+
+func (r *fakeRecipeRepo) Create(_ context.Context, recipe *models.Recipe) error {
+	if recipe.ID.IsZero() {
+		recipe.ID = primitive.NewObjectID()
+	}
+	r.recipes[recipe.ID] = recipe
+	return nil
+}
+
+func (r *fakeRecipeRepo) GetByID(_ context.Context, id primitive.ObjectID) (*models.Recipe, error) {
+	if recipe := r.recipes[id]; recipe != nil {
+		stored := *recipe
+		return &stored, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRecipeRepo) Update(_ context.Context, recipe *models.Recipe) error {
+	r.recipes[recipe.ID] = recipe
+	return nil
+}
+
+func (r *fakeRecipeRepo) ListUpdatedSince(_ context.Context, tenantID primitive.ObjectID, _ time.Time) ([]*models.Recipe, error) {
+	var list []*models.Recipe
+	for _, recipe := range r.recipes {
+		if recipe.TenantID == tenantID {
+			stored := *recipe
+			list = append(list, &stored)
+		}
+	}
+	return list, nil
+}
+
+// stepsOnlyRecipe is a recipe as the web editor saves it: steps and no ingredient list
+func stepsOnlyRecipe(tenantID primitive.ObjectID, ingredients ...*models.Ingredient) *models.Recipe {
+	recipe := &models.Recipe{
+		ID:       primitive.NewObjectID(),
+		TenantID: tenantID,
+		Name:     "Dal",
+		Status:   models.RecipeStatusDraft,
+		Version:  1,
+		Steps: []models.RecipeStep{
+			{StepNumber: 1, Action: models.L4ActionAcquirePotFromStaging, Parameters: map[string]any{}},
+		},
+	}
+	for i, ing := range ingredients {
+		recipe.Steps = append(recipe.Steps, models.RecipeStep{
+			StepNumber:     i + 2,
+			Action:         models.L4ActionAddSolid,
+			DependsOnSteps: []int{i + 1},
+			Parameters:     map[string]any{"ingredient_id": ing.ID.Hex(), "ingredient_name": ing.Name, "quantity": 100.0, "metric": "grams"},
+		})
+	}
+	return recipe
+}
+
+// fakeStepTemplateRepo keeps step templates in memory; methods the tests do not reach panic
+type fakeStepTemplateRepo struct {
+	repositories.StepTemplateRepository
+	templates map[primitive.ObjectID]*models.StepTemplate
+}
+
+func newFakeStepTemplateRepo(templates ...*models.StepTemplate) *fakeStepTemplateRepo {
+	repo := &fakeStepTemplateRepo{templates: make(map[primitive.ObjectID]*models.StepTemplate)}
+	for _, template := range templates {
+		repo.templates[template.ID] = template
+	}
+	return repo
+}
+
+func (r *fakeStepTemplateRepo) GetByIDs(_ context.Context, ids []primitive.ObjectID) ([]*models.StepTemplate, error) {
+	var list []*models.StepTemplate
+	for _, id := range ids {
+		if template := r.templates[id]; template != nil {
+			list = append(list, template)
+		}
+	}
+	return list, nil
+}
+
+// fakeTenantRepo keeps tenants in memory; methods the tests do not reach panic
+type fakeTenantRepo struct {
+	repositories.TenantRepository
+	tenants map[primitive.ObjectID]*models.Tenant
+}
+
+func (r *fakeTenantRepo) GetByID(_ context.Context, id primitive.ObjectID) (*models.Tenant, error) {
+	return r.tenants[id], nil
+}
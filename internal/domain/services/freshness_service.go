@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FreshnessService tracks the lots loaded into site kitchen canisters and when they
+// expire, from the ingredient shelf life at the time of loading
+type FreshnessService interface {
+	// RecordEvents stores the canister loads and unloads KOS reports for a site
+	RecordEvents(ctx context.Context, site *models.Site, events []CanisterEvent) ([]*models.CanisterLoad, error)
+	ActiveLoads(ctx context.Context, siteID primitive.ObjectID) ([]*models.CanisterLoad, error)
+	// Alerts returns a warning for each loaded lot about to expire and a critical alert
+	// for each expired one, soonest expiry first
+	Alerts(ctx context.Context, siteID primitive.ObjectID) ([]models.FreshnessAlert, error)
+	// Report lists the site's loads that were in service at or after since
+	Report(ctx context.Context, siteID primitive.ObjectID, since time.Time) (*models.FreshnessReport, error)
+}
+
+// CanisterEventType is what KOS did with a canister
+type CanisterEventType string
+
+const (
+	CanisterEventLoad   CanisterEventType = "load"
+	CanisterEventUnload CanisterEventType = "unload"
+)
+
+// CanisterEvent is a lot loaded into, or removed from, a kitchen canister
+type CanisterEvent struct {
+	Type         CanisterEventType
+	KitchenID    string
+	CanisterID   string
+	IngredientID primitive.ObjectID // Loads only
+	Lot          string
+	At           time.Time // Loaded or unloaded at; now if zero
+}
+
+type freshnessService struct {
+	canisterRepo   repositories.CanisterLoadRepository
+	ingredientRepo repositories.IngredientRepository
+	kitchenRepo    repositories.KitchenRepository
+}
+
+// NewFreshnessService creates a new freshness service
+func NewFreshnessService(
+	canisterRepo repositories.CanisterLoadRepository,
+	ingredientRepo repositories.IngredientRepository,
+	kitchenRepo repositories.KitchenRepository,
+) FreshnessService {
+	return &freshnessService{
+		canisterRepo:   canisterRepo,
+		ingredientRepo: ingredientRepo,
+		kitchenRepo:    kitchenRepo,
+	}
+}
+
+func (s *freshnessService) RecordEvents(ctx context.Context, site *models.Site, events []CanisterEvent) ([]*models.CanisterLoad, error) {
+	kitchens, err := s.kitchenRepo.ListBySite(ctx, site.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site kitchens: %w", err)
+	}
+	// Sites without registered kitchens accept any kitchen KOS names
+	known := make(map[string]bool, len(kitchens))
+	for _, k := range kitchens {
+		known[k.KitchenID] = true
+	}
+
+	var ids []primitive.ObjectID
+	for _, e := range events {
+		if e.Type == CanisterEventLoad {
+			ids = append(ids, e.IngredientID)
+		}
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient)
+	if len(ids) > 0 {
+		ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ingredients: %w", err)
+		}
+		for _, ing := range ingredients {
+			byID[ing.ID] = ing
+		}
+	}
+
+	for i, e := range events {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("event %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"event": i, "kitchen_id": e.KitchenID, "canister_id": e.CanisterID})
+		}
+		if e.Type != CanisterEventLoad && e.Type != CanisterEventUnload {
+			return nil, invalid("event must be load or unload")
+		}
+		if e.KitchenID == "" || e.CanisterID == "" {
+			return nil, invalid("kitchen_id and canister_id are required")
+		}
+		if len(known) > 0 && !known[e.KitchenID] {
+			return nil, invalid("kitchen %s is not a kitchen of site %s", e.KitchenID, site.Code)
+		}
+		if e.Type == CanisterEventLoad {
+			if ing := byID[e.IngredientID]; ing == nil || ing.TenantID != site.TenantID {
+				return nil, invalid("ingredient %s not found", e.IngredientID.Hex())
+			}
+		}
+	}
+
+	now := time.Now()
+	var loads []*models.CanisterLoad
+	for _, e := range events {
+		at := e.At
+		if at.IsZero() {
+			at = now
+		}
+		// A canister holds one lot: loading it again ends the previous load
+		if err := s.canisterRepo.Unload(ctx, site.ID, e.KitchenID, e.CanisterID, at); err != nil {
+			return nil, fmt.Errorf("failed to unload canister: %w", err)
+		}
+		if e.Type == CanisterEventUnload {
+			continue
+		}
+
+		ing := byID[e.IngredientID]
+		load := &models.CanisterLoad{
+			TenantID:         site.TenantID,
+			SiteID:           site.ID,
+			KitchenID:        e.KitchenID,
+			CanisterID:       e.CanisterID,
+			IngredientID:     ing.ID,
+			IngredientName:   ing.Name,
+			Lot:              e.Lot,
+			ShelfLifeMinutes: ing.ShelfLifeMinutes,
+			LoadedAt:         at,
+		}
+		if ing.ShelfLifeMinutes > 0 {
+			expires := at.Add(time.Duration(ing.ShelfLifeMinutes) * time.Minute)
+			load.ExpiresAt = &expires
+		}
+		if err := s.canisterRepo.Create(ctx, load); err != nil {
+			return nil, fmt.Errorf("failed to save canister load: %w", err)
+		}
+		loads = append(loads, load)
+	}
+	return loads, nil
+}
+
+func (s *freshnessService) ActiveLoads(ctx context.Context, siteID primitive.ObjectID) ([]*models.CanisterLoad, error) {
+	return s.canisterRepo.ListActiveBySite(ctx, siteID)
+}
+
+func (s *freshnessService) Alerts(ctx context.Context, siteID primitive.ObjectID) ([]models.FreshnessAlert, error) {
+	loads, err := s.canisterRepo.ListActiveBySite(ctx, siteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list canister loads: %w", err)
+	}
+	return freshnessAlerts(loads, time.Now()), nil
+}
+
+func (s *freshnessService) Report(ctx context.Context, siteID primitive.ObjectID, since time.Time) (*models.FreshnessReport, error) {
+	loads, err := s.canisterRepo.ListBySite(ctx, siteID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list canister loads: %w", err)
+	}
+
+	now := time.Now()
+	report := &models.FreshnessReport{
+		SiteID:      siteID,
+		Since:       since,
+		Canisters:   make([]models.CanisterFreshness, len(loads)),
+		GeneratedAt: now,
+	}
+	var active []*models.CanisterLoad
+	for i, l := range loads {
+		entry := models.CanisterFreshness{CanisterLoad: *l}
+		if l.Active() {
+			active = append(active, l)
+			entry.Status = l.Freshness(now)
+			if l.ExpiresAt != nil {
+				minutes := int(l.ExpiresAt.Sub(now).Minutes())
+				entry.MinutesRemaining = &minutes
+			}
+		} else {
+			// Unloaded lots are judged at the time they came out
+			entry.Status = l.Freshness(*l.UnloadedAt)
+		}
+		entry.ExpiredInService = entry.Status == models.FreshnessExpired
+		report.Canisters[i] = entry
+	}
+	report.Alerts = freshnessAlerts(active, now)
+	return report, nil
+}
+
+// freshnessAlerts returns the alerts for loaded lots at a time, soonest expiry first
+func freshnessAlerts(loads []*models.CanisterLoad, at time.Time) []models.FreshnessAlert {
+	alerts := []models.FreshnessAlert{}
+	for _, l := range loads {
+		alert := models.FreshnessAlert{
+			KitchenID:      l.KitchenID,
+			CanisterID:     l.CanisterID,
+			IngredientID:   l.IngredientID.Hex(),
+			IngredientName: l.IngredientName,
+			Lot:            l.Lot,
+		}
+		switch l.Freshness(at) {
+		case models.FreshnessExpiring:
+			alert.Severity = "warning"
+			alert.Message = fmt.Sprintf("%s in %s canister %s expires in %d min", l.IngredientName, l.KitchenID, l.CanisterID, int(l.ExpiresAt.Sub(at).Minutes()))
+		case models.FreshnessExpired:
+			alert.Severity = "critical"
+			alert.Message = fmt.Sprintf("%s in %s canister %s has expired", l.IngredientName, l.KitchenID, l.CanisterID)
+		default:
+			continue
+		}
+		alert.ExpiresAt = *l.ExpiresAt
+		alerts = append(alerts, alert)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].ExpiresAt.Before(alerts[j].ExpiresAt)
+	})
+	return alerts
+}
+
+// BlockedKitchens returns the kitchens an order must not be dispatched to: those where
+// every loaded lot of one of the recipe's critical ingredients has expired. Kitchens
+// with no canister of an ingredient are not blocked, as KOS may source it otherwise.
+func BlockedKitchens(loads []*models.CanisterLoad, recipe *models.Recipe, mods []models.Modification, at time.Time) []string {
+	var blocked []string
+	seen := make(map[string]bool)
+	for _, ri := range recipe.Ingredients {
+		if !ri.IsCritical {
+			continue
+		}
+		id := ri.IngredientID
+		if mod := findModification(mods, models.ModificationSubstitute, ri.IngredientID, ri.IngredientName); mod != nil && mod.SubstituteID != nil {
+			id = *mod.SubstituteID
+		}
+
+		// Per kitchen: whether it has a lot of the ingredient that is still usable
+		usable := make(map[string]bool)
+		for _, l := range loads {
+			if !l.Active() || l.IngredientID != id {
+				continue
+			}
+			usable[l.KitchenID] = usable[l.KitchenID] || l.Freshness(at) != models.FreshnessExpired
+		}
+		for kitchen, ok := range usable {
+			if !ok && !seen[kitchen] {
+				seen[kitchen] = true
+				blocked = append(blocked, kitchen)
+			}
+		}
+	}
+	sort.Strings(blocked)
+	return blocked
+}
@@ -0,0 +1,483 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stockTolerance absorbs rounding when comparing stock with what orders need, in grams
+const stockTolerance = 0.01
+
+// InventoryService tracks what each site kitchen has loaded and whether a site can
+// cook what is ordered from it. Sites whose KOS has never reported inventory are not
+// tracked: every recipe is available there and orders are not checked. Likewise an
+// ingredient the site has never reported a level for is not checked.
+type InventoryService interface {
+	// Report stores the levels KOS reports for the kitchens of a site
+	Report(ctx context.Context, site *models.Site, reports []InventoryReport) ([]*models.InventoryLevel, error)
+	ListForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.InventoryLevel, error)
+	// RecordConsumption deducts what a completed order used from its kitchen: what KOS
+	// measured for reconciled steps, else what the recipe expected. Each order is
+	// deducted once.
+	RecordConsumption(ctx context.Context, order *models.Order) error
+	// Forecast projects the site's stock over its open orders
+	Forecast(ctx context.Context, siteID primitive.ObjectID) (*models.InventoryForecast, error)
+	// Availability reports which recipes, at the version the site cooks, the site can
+	// still make at a pot size once its open orders are covered
+	Availability(ctx context.Context, siteID primitive.ObjectID, recipes []*models.Recipe, potPercentage int) ([]models.RecipeAvailability, error)
+	// CheckStock returns a conflict when the site cannot cover new orders on top of its open ones
+	CheckStock(ctx context.Context, siteID primitive.ObjectID, demands []StockDemand) error
+}
+
+// InventoryReport is the on-hand quantity of one ingredient in one kitchen
+type InventoryReport struct {
+	KitchenID    string
+	IngredientID primitive.ObjectID
+	Quantity     float64
+	Unit         string // grams, kg, ml or l
+}
+
+// StockDemand is what a batch item will take from a site's inventory
+type StockDemand struct {
+	Recipe        *models.Recipe // At the version the site cooks
+	PotPercentage int
+	Modifications []models.Modification
+	Quantity      int // Number of orders
+}
+
+type inventoryService struct {
+	inventoryRepo   repositories.InventoryRepository
+	orderRepo       repositories.OrderRepository
+	recipeRepo      repositories.RecipeRepository
+	ingredientRepo  repositories.IngredientRepository
+	kitchenRepo     repositories.KitchenRepository
+	consumptionRepo repositories.ConsumptionRepository
+}
+
+// NewInventoryService creates a new inventory service
+func NewInventoryService(
+	inventoryRepo repositories.InventoryRepository,
+	orderRepo repositories.OrderRepository,
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	kitchenRepo repositories.KitchenRepository,
+	consumptionRepo repositories.ConsumptionRepository,
+) InventoryService {
+	return &inventoryService{
+		inventoryRepo:   inventoryRepo,
+		orderRepo:       orderRepo,
+		recipeRepo:      recipeRepo,
+		ingredientRepo:  ingredientRepo,
+		kitchenRepo:     kitchenRepo,
+		consumptionRepo: consumptionRepo,
+	}
+}
+
+func (s *inventoryService) Report(ctx context.Context, site *models.Site, reports []InventoryReport) ([]*models.InventoryLevel, error) {
+	kitchens, err := s.kitchenRepo.ListBySite(ctx, site.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site kitchens: %w", err)
+	}
+	// Sites without registered kitchens accept any kitchen KOS names
+	known := make(map[string]bool, len(kitchens))
+	for _, k := range kitchens {
+		known[k.KitchenID] = true
+	}
+
+	ids := make([]primitive.ObjectID, len(reports))
+	for i, r := range reports {
+		ids[i] = r.IngredientID
+	}
+	ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingredients: %w", err)
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient, len(ingredients))
+	for _, ing := range ingredients {
+		byID[ing.ID] = ing
+	}
+
+	for i, r := range reports {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("level %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"level": i, "kitchen_id": r.KitchenID, "ingredient_id": r.IngredientID.Hex()})
+		}
+		if r.KitchenID == "" {
+			return nil, invalid("kitchen_id is required")
+		}
+		if len(known) > 0 && !known[r.KitchenID] {
+			return nil, invalid("kitchen %s is not a kitchen of site %s", r.KitchenID, site.Code)
+		}
+		ing := byID[r.IngredientID]
+		if ing == nil || ing.TenantID != site.TenantID {
+			return nil, invalid("ingredient %s not found", r.IngredientID.Hex())
+		}
+		if r.Quantity < 0 {
+			return nil, invalid("quantity cannot be negative")
+		}
+		if _, ok := models.LookupUnit(r.Unit); !ok {
+			return nil, invalid("unknown unit %q", r.Unit)
+		}
+		// Stock is tracked by weight
+		if _, _, ok := ingredientGrams(ingredientAmount{Unit: r.Unit}, ing); !ok {
+			return nil, invalid("%s cannot be counted in %s without its %s parameter", ing.Name, r.Unit, models.IngredientParamPieceWeight)
+		}
+	}
+
+	now := time.Now()
+	levels := make([]*models.InventoryLevel, len(reports))
+	for i, r := range reports {
+		levels[i] = &models.InventoryLevel{
+			TenantID:         site.TenantID,
+			SiteID:           site.ID,
+			KitchenID:        r.KitchenID,
+			IngredientID:     r.IngredientID,
+			IngredientName:   byID[r.IngredientID].Name,
+			Quantity:         r.Quantity,
+			Unit:             strings.ToLower(strings.TrimSpace(r.Unit)),
+			ReportedQuantity: r.Quantity,
+			ReportedAt:       now,
+		}
+		if err := s.inventoryRepo.Upsert(ctx, levels[i]); err != nil {
+			return nil, fmt.Errorf("failed to save inventory level: %w", err)
+		}
+	}
+	return levels, nil
+}
+
+func (s *inventoryService) ListForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.InventoryLevel, error) {
+	return s.inventoryRepo.ListBySite(ctx, siteID)
+}
+
+func (s *inventoryService) RecordConsumption(ctx context.Context, order *models.Order) error {
+	if order.Status != models.OrderStatusCompleted || order.ConsumptionRecordedAt != nil {
+		return nil
+	}
+	levels, err := s.inventoryRepo.ListBySite(ctx, order.SiteID)
+	if err != nil {
+		return fmt.Errorf("failed to list inventory: %w", err)
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+
+	used, ingredients, err := s.orderConsumption(ctx, order, nil)
+	if err != nil {
+		return err
+	}
+	// What KOS measured it dispensed replaces what the recipe expected
+	records, err := s.consumptionRepo.ListByOrder(ctx, order.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list consumption records: %w", err)
+	}
+	for _, r := range records {
+		if grams, _, ok := ingredientGrams(ingredientAmount{Quantity: r.Variance, Unit: r.Unit}, ingredients[r.IngredientID]); ok {
+			used[r.IngredientID] = max(used[r.IngredientID]+grams, 0)
+		}
+	}
+	kitchen, err := orderKitchenID(ctx, s.kitchenRepo, order)
+	if err != nil {
+		return err
+	}
+
+	for id, grams := range used {
+		// Take from the kitchen that cooked the order, else wherever most is loaded
+		var level *models.InventoryLevel
+		for _, l := range levels {
+			if l.IngredientID != id {
+				continue
+			}
+			if l.KitchenID == kitchen {
+				level = l
+				break
+			}
+			if level == nil || l.Quantity > level.Quantity {
+				level = l
+			}
+		}
+		if level == nil {
+			continue
+		}
+		quantity, ok := gramsToUnit(grams, level.Unit, ingredients[id])
+		if !ok {
+			continue
+		}
+		if err := s.inventoryRepo.Deduct(ctx, level.ID, round2(quantity)); err != nil {
+			return fmt.Errorf("failed to deduct inventory: %w", err)
+		}
+	}
+
+	now := time.Now()
+	order.ConsumptionRecordedAt = &now
+	if err := s.orderRepo.Update(ctx, order); err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	return nil
+}
+
+func (s *inventoryService) Forecast(ctx context.Context, siteID primitive.ObjectID) (*models.InventoryForecast, error) {
+	onHand, names, tracked, err := s.siteStock(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.orderRepo.ListOpenForSite(ctx, siteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open orders: %w", err)
+	}
+
+	entries := make(map[primitive.ObjectID]*models.IngredientForecast)
+	entry := func(id primitive.ObjectID) *models.IngredientForecast {
+		if entries[id] == nil {
+			entries[id] = &models.IngredientForecast{IngredientID: id.Hex(), Name: names[id], OnHandG: onHand[id]}
+		}
+		return entries[id]
+	}
+	for id := range onHand {
+		entry(id)
+	}
+
+	remaining := make(map[primitive.ObjectID]float64, len(onHand))
+	for id, grams := range onHand {
+		remaining[id] = grams
+	}
+	recipes := make(map[string]*models.Recipe)
+	for _, order := range orders {
+		used, ingredients, err := s.orderConsumption(ctx, order, recipes)
+		if err != nil {
+			return nil, err
+		}
+		for id, grams := range used {
+			e := entry(id)
+			if e.Name == "" && ingredients[id] != nil {
+				e.Name = ingredients[id].Name
+			}
+			e.CommittedG += grams
+			e.OpenOrders++
+			remaining[id] -= grams
+			if tracked && remaining[id] < -stockTolerance {
+				e.ShortOrders++
+				if e.DepletesAt == nil {
+					t := order.ExecutionTime
+					e.DepletesAt = &t
+				}
+			}
+		}
+	}
+
+	forecast := &models.InventoryForecast{
+		SiteID:      siteID,
+		Tracked:     tracked,
+		OpenOrders:  len(orders),
+		Ingredients: make([]models.IngredientForecast, 0, len(entries)),
+		GeneratedAt: time.Now(),
+	}
+	for _, e := range entries {
+		e.OnHandG = round2(e.OnHandG)
+		e.CommittedG = round2(e.CommittedG)
+		e.RemainingG = round2(e.OnHandG - e.CommittedG)
+		forecast.Ingredients = append(forecast.Ingredients, *e)
+	}
+	// Soonest to run out first, then by name
+	sort.Slice(forecast.Ingredients, func(i, j int) bool {
+		a, b := forecast.Ingredients[i], forecast.Ingredients[j]
+		if (a.DepletesAt == nil) != (b.DepletesAt == nil) {
+			return a.DepletesAt != nil
+		}
+		if a.DepletesAt != nil && !a.DepletesAt.Equal(*b.DepletesAt) {
+			return a.DepletesAt.Before(*b.DepletesAt)
+		}
+		return a.Name < b.Name
+	})
+	return forecast, nil
+}
+
+func (s *inventoryService) Availability(ctx context.Context, siteID primitive.ObjectID, recipes []*models.Recipe, potPercentage int) ([]models.RecipeAvailability, error) {
+	remaining, names, tracked, err := s.remainingStock(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.RecipeAvailability, len(recipes))
+	for i, recipe := range recipes {
+		result[i] = models.RecipeAvailability{
+			RecipeID:   recipe.ID.Hex(),
+			RecipeName: recipe.Name,
+			Version:    recipe.Version,
+			Available:  true,
+		}
+		if !tracked {
+			continue
+		}
+		used, ingredients, err := s.consumption(ctx, recipe, potPercentage, nil)
+		if err != nil {
+			return nil, err
+		}
+		result[i].Shortages = shortages(used, remaining, names, ingredients)
+		result[i].Available = len(result[i].Shortages) == 0
+	}
+	return result, nil
+}
+
+func (s *inventoryService) CheckStock(ctx context.Context, siteID primitive.ObjectID, demands []StockDemand) error {
+	remaining, names, tracked, err := s.remainingStock(ctx, siteID)
+	if err != nil || !tracked {
+		return err
+	}
+
+	needed := make(map[primitive.ObjectID]float64)
+	ingredients := make(map[primitive.ObjectID]*models.Ingredient)
+	var recipeNames []string
+	for _, d := range demands {
+		used, ings, err := s.consumption(ctx, d.Recipe, d.PotPercentage, d.Modifications)
+		if err != nil {
+			return err
+		}
+		for id, grams := range used {
+			needed[id] += grams * float64(d.Quantity)
+			ingredients[id] = ings[id]
+		}
+		recipeNames = append(recipeNames, d.Recipe.Name)
+	}
+
+	short := shortages(needed, remaining, names, ingredients)
+	if len(short) == 0 {
+		return nil
+	}
+	missing := make([]string, len(short))
+	for i, sh := range short {
+		missing[i] = sh.Name
+	}
+	return apperrors.Conflict(fmt.Sprintf("site does not have enough %s for this order", strings.Join(missing, ", "))).
+		WithDetails(map[string]any{"site_id": siteID.Hex(), "shortages": short})
+}
+
+// siteStock returns the grams of each ingredient loaded across a site's kitchens, and
+// whether the site reports inventory at all
+func (s *inventoryService) siteStock(ctx context.Context, siteID primitive.ObjectID) (map[primitive.ObjectID]float64, map[primitive.ObjectID]string, bool, error) {
+	levels, err := s.inventoryRepo.ListBySite(ctx, siteID)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to list inventory: %w", err)
+	}
+	ids := make([]primitive.ObjectID, len(levels))
+	for i, l := range levels {
+		ids[i] = l.IngredientID
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient)
+	if len(ids) > 0 {
+		ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to get ingredients: %w", err)
+		}
+		for _, ing := range ingredients {
+			byID[ing.ID] = ing
+		}
+	}
+
+	onHand := make(map[primitive.ObjectID]float64)
+	names := make(map[primitive.ObjectID]string)
+	for _, l := range levels {
+		names[l.IngredientID] = l.IngredientName
+		// Deductions can take a level below zero until KOS reports again
+		grams, _, ok := ingredientGrams(ingredientAmount{Quantity: l.Quantity, Unit: l.Unit}, byID[l.IngredientID])
+		if !ok || grams < 0 {
+			grams = 0
+		}
+		onHand[l.IngredientID] += grams
+	}
+	return onHand, names, len(levels) > 0, nil
+}
+
+// remainingStock returns the site's stock left once its open orders are covered
+func (s *inventoryService) remainingStock(ctx context.Context, siteID primitive.ObjectID) (map[primitive.ObjectID]float64, map[primitive.ObjectID]string, bool, error) {
+	remaining, names, tracked, err := s.siteStock(ctx, siteID)
+	if err != nil || !tracked {
+		return remaining, names, tracked, err
+	}
+	orders, err := s.orderRepo.ListOpenForSite(ctx, siteID)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to list open orders: %w", err)
+	}
+	recipes := make(map[string]*models.Recipe)
+	for _, order := range orders {
+		used, _, err := s.orderConsumption(ctx, order, recipes)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		for id, grams := range used {
+			remaining[id] -= grams
+		}
+	}
+	return remaining, names, true, nil
+}
+
+// orderConsumption returns the grams of each ingredient an order is expected to use.
+// recipes caches recipe versions across calls and may be nil.
+func (s *inventoryService) orderConsumption(ctx context.Context, order *models.Order, recipes map[string]*models.Recipe) (map[primitive.ObjectID]float64, map[primitive.ObjectID]*models.Ingredient, error) {
+	key := fmt.Sprintf("%s@%d", order.RecipeID.Hex(), order.RecipeVersion)
+	recipe := recipes[key]
+	if recipe == nil {
+		var err error
+		recipe, err = servedRecipe(ctx, s.recipeRepo, order.RecipeID, order.RecipeVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		if recipes != nil {
+			recipes[key] = recipe
+		}
+	}
+	return s.consumption(ctx, recipe, order.PotPercentage, order.Modifications)
+}
+
+// consumption returns the grams of each ingredient a recipe uses at a pot size with
+// the modifications applied. Amounts in units that cannot be weighed are left out.
+func (s *inventoryService) consumption(ctx context.Context, recipe *models.Recipe, potPercentage int, mods []models.Modification) (map[primitive.ObjectID]float64, map[primitive.ObjectID]*models.Ingredient, error) {
+	ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, recipe)
+	if err != nil {
+		return nil, nil, err
+	}
+	used := make(map[primitive.ObjectID]float64)
+	for _, amount := range recipeAmounts(ApplyModifications(ScaleRecipe(recipe, potPercentage), mods)) {
+		if grams, _, ok := ingredientGrams(amount, ingredients[amount.ID]); ok {
+			used[amount.ID] += grams
+		}
+	}
+	return used, ingredients, nil
+}
+
+// shortages lists the ingredients needed beyond what is available, by name. Only
+// ingredients the site has reported a level for, and so are in names, are compared.
+func shortages(
+	needed, available map[primitive.ObjectID]float64,
+	names map[primitive.ObjectID]string,
+	ingredients map[primitive.ObjectID]*models.Ingredient,
+) []models.IngredientShortage {
+	var list []models.IngredientShortage
+	for id, grams := range needed {
+		name, reported := names[id]
+		if !reported || grams <= available[id]+stockTolerance {
+			continue
+		}
+		if ing := ingredients[id]; ing != nil {
+			name = ing.Name
+		}
+		if name == "" {
+			name = id.Hex()
+		}
+		list = append(list, models.IngredientShortage{
+			IngredientID: id.Hex(),
+			Name:         name,
+			RequiredG:    round2(grams),
+			AvailableG:   round2(max(available[id], 0)),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -88,6 +88,7 @@ func (s *keycloakService) CreateRealm(ctx context.Context, realmName, displayNam
 		{"admin", "Tenant administrator with full access"},
 		{"manager", "Kitchen manager with operational access"},
 		{"operator", "Kitchen operator with limited access"},
+		{"recipe_approver", "Approves or rejects recipes submitted for review"},
 		{"viewer", "Read-only access to dashboards"},
 	}
 
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a recipe ingredient needs from a kitchen layout
+const (
+	NeedsSlot      = "slot"       // Added as a solid or picked as a canister
+	NeedsHydraLine = "hydra_line" // Dispensed as a liquid
+	NeedsStorage   = "storage"    // Listed by the recipe only; a slot or a hydra line will do
+)
+
+// KitchenLayoutService manages the versioned storage layout of kitchens
+type KitchenLayoutService interface {
+	// Update saves a layout as the next version of a kitchen's layout
+	Update(ctx context.Context, kitchen *models.Kitchen, update LayoutUpdate, userID string) (*models.KitchenLayout, error)
+	Current(ctx context.Context, kitchenID primitive.ObjectID) (*models.KitchenLayout, error)
+	Versions(ctx context.Context, kitchenID primitive.ObjectID) ([]*models.KitchenLayout, error)
+	ForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.KitchenLayout, error)
+}
+
+// LayoutUpdate is a complete kitchen layout. BaseVersion is the version it was edited
+// from; the update is rejected when the layout changed since.
+type LayoutUpdate struct {
+	BaseVersion int
+	Slots       []models.StorageSlot
+	HydraLines  []models.HydraLine
+	PyroCount   int
+	ChangeNote  string
+}
+
+type kitchenLayoutService struct {
+	layoutRepo     repositories.KitchenLayoutRepository
+	ingredientRepo repositories.IngredientRepository
+}
+
+// NewKitchenLayoutService creates a new kitchen layout service
+func NewKitchenLayoutService(
+	layoutRepo repositories.KitchenLayoutRepository,
+	ingredientRepo repositories.IngredientRepository,
+) KitchenLayoutService {
+	return &kitchenLayoutService{
+		layoutRepo:     layoutRepo,
+		ingredientRepo: ingredientRepo,
+	}
+}
+
+func (s *kitchenLayoutService) Update(ctx context.Context, kitchen *models.Kitchen, update LayoutUpdate, userID string) (*models.KitchenLayout, error) {
+	current, err := s.layoutRepo.GetCurrent(ctx, kitchen.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kitchen layout: %w", err)
+	}
+	currentVersion := 0
+	if current != nil {
+		currentVersion = current.Version
+	}
+	if update.BaseVersion != currentVersion {
+		return nil, apperrors.Conflict(fmt.Sprintf("layout changed since version %d; current version is %d", update.BaseVersion, currentVersion)).
+			WithDetails(map[string]any{"current_version": currentVersion})
+	}
+
+	if update.PyroCount < 0 {
+		return nil, apperrors.Validation("pyro_count cannot be negative")
+	}
+	slotIDs := make(map[string]bool)
+	var ids []primitive.ObjectID
+	for _, slot := range update.Slots {
+		if slot.SlotID == "" {
+			return nil, apperrors.Validation("every slot needs a slot_id")
+		}
+		if slotIDs[slot.SlotID] {
+			return nil, apperrors.Validation(fmt.Sprintf("slot %s is listed twice", slot.SlotID))
+		}
+		slotIDs[slot.SlotID] = true
+		if slot.IngredientID != nil {
+			ids = append(ids, *slot.IngredientID)
+		}
+	}
+	lineIDs := make(map[string]bool)
+	for _, line := range update.HydraLines {
+		if line.LineID == "" {
+			return nil, apperrors.Validation("every hydra line needs a line_id")
+		}
+		if lineIDs[line.LineID] {
+			return nil, apperrors.Validation(fmt.Sprintf("hydra line %s is listed twice", line.LineID))
+		}
+		lineIDs[line.LineID] = true
+		if line.IngredientID != nil {
+			ids = append(ids, *line.IngredientID)
+		}
+	}
+
+	// Ingredient names are denormalized for KOS and the editor
+	names := make(map[primitive.ObjectID]string)
+	if len(ids) > 0 {
+		ingredients, err := s.ingredientRepo.GetByIDs(ctx, ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ingredients: %w", err)
+		}
+		for _, ing := range ingredients {
+			if ing.TenantID == kitchen.TenantID {
+				names[ing.ID] = ing.Name
+			}
+		}
+	}
+	for _, id := range ids {
+		if _, ok := names[id]; !ok {
+			return nil, apperrors.Validation(fmt.Sprintf("ingredient %s not found", id.Hex())).
+				WithDetails(map[string]any{"ingredient_id": id.Hex()})
+		}
+	}
+
+	layout := &models.KitchenLayout{
+		TenantID:     kitchen.TenantID,
+		SiteID:       kitchen.SiteID,
+		KitchenID:    kitchen.ID,
+		KOSKitchenID: kitchen.KitchenID,
+		Version:      currentVersion + 1,
+		Slots:        make([]models.StorageSlot, len(update.Slots)),
+		HydraLines:   make([]models.HydraLine, len(update.HydraLines)),
+		PyroCount:    update.PyroCount,
+		ChangeNote:   update.ChangeNote,
+		CreatedBy:    userID,
+		CreatedAt:    time.Now(),
+	}
+	for i, slot := range update.Slots {
+		slot.IngredientName = ""
+		if slot.IngredientID != nil {
+			slot.IngredientName = names[*slot.IngredientID]
+		}
+		layout.Slots[i] = slot
+	}
+	for i, line := range update.HydraLines {
+		line.IngredientName = ""
+		if line.IngredientID != nil {
+			line.IngredientName = names[*line.IngredientID]
+		}
+		layout.HydraLines[i] = line
+	}
+
+	// The unique kitchen/version index rejects a concurrent update from the same base
+	if err := s.layoutRepo.Create(ctx, layout); err != nil {
+		return nil, fmt.Errorf("failed to save kitchen layout: %w", err)
+	}
+	return layout, nil
+}
+
+func (s *kitchenLayoutService) Current(ctx context.Context, kitchenID primitive.ObjectID) (*models.KitchenLayout, error) {
+	return s.layoutRepo.GetCurrent(ctx, kitchenID)
+}
+
+func (s *kitchenLayoutService) Versions(ctx context.Context, kitchenID primitive.ObjectID) ([]*models.KitchenLayout, error) {
+	return s.layoutRepo.ListVersions(ctx, kitchenID)
+}
+
+func (s *kitchenLayoutService) ForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.KitchenLayout, error) {
+	return s.layoutRepo.ListCurrentBySite(ctx, siteID)
+}
+
+// CheckLayout reports whether a layout has every ingredient of a recipe where the
+// recipe takes it from: a hydra line for liquids, a storage slot for solids and
+// picked canisters, and either for ingredients that only the ingredient list names
+func CheckLayout(layout *models.KitchenLayout, recipe *models.Recipe) models.KitchenRecipeCheck {
+	check := models.KitchenRecipeCheck{
+		RecipeID:   recipe.ID.Hex(),
+		RecipeName: recipe.Name,
+		Version:    recipe.Version,
+	}
+
+	type need struct {
+		name       string
+		slot, line bool
+	}
+	var order []primitive.ObjectID
+	needs := make(map[primitive.ObjectID]*need)
+	add := func(id primitive.ObjectID, name string) *need {
+		n := needs[id]
+		if n == nil {
+			n = &need{name: name}
+			needs[id] = n
+			order = append(order, id)
+		}
+		if n.name == "" {
+			n.name = name
+		}
+		return n
+	}
+
+	for i := range recipe.Steps {
+		step := &recipe.Steps[i]
+		if step.Action != models.L4ActionAddLiquid && step.Action != models.L4ActionAddSolid && step.Action != models.L4ActionPickIngredient {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(stepIngredientID(step))
+		if err != nil {
+			continue
+		}
+		name, _ := step.Parameters["ingredient_name"].(string)
+		n := add(id, name)
+		if step.Action == models.L4ActionAddLiquid {
+			n.line = true
+		} else {
+			n.slot = true
+		}
+	}
+	for _, ri := range recipe.Ingredients {
+		add(ri.IngredientID, ri.IngredientName)
+	}
+
+	for _, id := range order {
+		n := needs[id]
+		slot, line := layout.SlotFor(id) != nil, layout.LineFor(id) != nil
+		missing := func(kind string) {
+			check.Missing = append(check.Missing, models.MissingIngredient{IngredientID: id.Hex(), Name: n.name, Needs: kind})
+		}
+		if n.slot && !slot {
+			missing(NeedsSlot)
+		}
+		if n.line && !line {
+			missing(NeedsHydraLine)
+		}
+		if !n.slot && !n.line && !slot && !line {
+			missing(NeedsStorage)
+		}
+	}
+	check.Runnable = len(check.Missing) == 0
+	return check
+}
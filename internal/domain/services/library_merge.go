@@ -0,0 +1,163 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mapRecipe returns a copy of a library recipe's content with its library ingredients
+// replaced by the tenant ingredients they map to. Unmapped ingredients are left as they are.
+func mapRecipe(recipe *models.Recipe, tenant map[primitive.ObjectID]*models.Ingredient) *models.Recipe {
+	mapped := *recipe
+	mapped.Parameters = maps.Clone(recipe.Parameters)
+	mapped.Ingredients = make([]models.RecipeIngredient, len(recipe.Ingredients))
+	for i, ing := range recipe.Ingredients {
+		if t := tenant[ing.IngredientID]; t != nil {
+			ing.IngredientID = t.ID
+			ing.IngredientName = t.Name
+		}
+		ing.Substitutes = slices.Clone(ing.Substitutes)
+		for j, id := range ing.Substitutes {
+			if t := tenant[id]; t != nil {
+				ing.Substitutes[j] = t.ID
+			}
+		}
+		mapped.Ingredients[i] = ing
+	}
+
+	mapped.Steps = make([]models.RecipeStep, len(recipe.Steps))
+	for i, step := range recipe.Steps {
+		step.Parameters = maps.Clone(step.Parameters)
+		if id, err := primitive.ObjectIDFromHex(stepIngredientID(&step)); err == nil {
+			if t := tenant[id]; t != nil {
+				step.Parameters["ingredient_id"] = t.ID.Hex()
+				if _, ok := step.Parameters["ingredient_name"]; ok {
+					step.Parameters["ingredient_name"] = t.Name
+				}
+			}
+		}
+		mapped.Steps[i] = step
+	}
+	return &mapped
+}
+
+// mergeRecipe merges the library's changes from base to theirs into ours, a fork, and
+// returns the merged recipe, its manual allergens and the library's changes. Ingredients
+// are matched by ingredient and steps by step number; a change conflicts when the fork
+// changed the same thing differently, and keeps the fork's version unless resolved as theirs.
+// The fork's name, review state and everything derived are left to the caller.
+func mergeRecipe(base, ours, theirs *models.Recipe, resolution models.MergeResolution) (*models.Recipe, []string, []models.UpstreamChange) {
+	changes := []models.UpstreamChange{}
+	merged := *ours
+	const changed = models.UpstreamChangeChanged
+
+	merged.Description = mergeValue(&changes, "description", base.Description, ours.Description, theirs.Description, resolution, changed)
+	merged.Category = mergeValue(&changes, "category", base.Category, ours.Category, theirs.Category, resolution, changed)
+	merged.CuisineType = mergeValue(&changes, "cuisine_type", base.CuisineType, ours.CuisineType, theirs.CuisineType, resolution, changed)
+	merged.PrepTime = mergeValue(&changes, "prep_time", base.PrepTime, ours.PrepTime, theirs.PrepTime, resolution, changed)
+	merged.CookTime = mergeValue(&changes, "cook_time", base.CookTime, ours.CookTime, theirs.CookTime, resolution, changed)
+	merged.Servings = mergeValue(&changes, "servings", base.Servings, ours.Servings, theirs.Servings, resolution, changed)
+	merged.EstimatedPrepTimeSec = mergeValue(&changes, "estimated_prep_time_sec", base.EstimatedPrepTimeSec, ours.EstimatedPrepTimeSec, theirs.EstimatedPrepTimeSec, resolution, changed)
+	merged.EstimatedCookingTimeSec = mergeValue(&changes, "estimated_cooking_time_sec", base.EstimatedCookingTimeSec, ours.EstimatedCookingTimeSec, theirs.EstimatedCookingTimeSec, resolution, changed)
+	merged.Parameters = mergeValue(&changes, "parameters", base.Parameters, ours.Parameters, theirs.Parameters, resolution, changed)
+	manual := mergeValue(&changes, "allergens", sortedAllergens(base), sortedAllergens(ours), sortedAllergens(theirs), resolution, changed)
+
+	merged.Ingredients = mergeKeyed(&changes, base.Ingredients, ours.Ingredients, theirs.Ingredients,
+		func(ing models.RecipeIngredient) primitive.ObjectID { return ing.IngredientID },
+		func(ing models.RecipeIngredient) string { return fmt.Sprintf("ingredients[%s]", ing.IngredientName) },
+		resolution)
+	merged.Steps = mergeKeyed(&changes, base.Steps, ours.Steps, theirs.Steps,
+		func(step models.RecipeStep) int { return step.StepNumber },
+		func(step models.RecipeStep) string { return fmt.Sprintf("steps[%d]", step.StepNumber) },
+		resolution)
+	slices.SortStableFunc(merged.Steps, func(a, b models.RecipeStep) int { return cmp.Compare(a.StepNumber, b.StepNumber) })
+
+	return &merged, manual, changes
+}
+
+// mergeValue is a three-way merge of one value, recording the library's change if it made one
+func mergeValue[T any](changes *[]models.UpstreamChange, path string, base, ours, theirs T, resolution models.MergeResolution, kind models.UpstreamChangeKind) T {
+	if sameJSON(base, theirs) {
+		return ours
+	}
+	conflict := !sameJSON(base, ours) && !sameJSON(ours, theirs)
+	*changes = append(*changes, models.UpstreamChange{Path: path, Kind: kind, Conflict: conflict})
+	if conflict && resolution != models.MergeResolutionTheirs {
+		return ours
+	}
+	return theirs
+}
+
+// mergeKeyed merges lists whose entries are matched by key. The fork's entries keep
+// their order and the library's additions follow in the library's order.
+func mergeKeyed[T any, K comparable](
+	changes *[]models.UpstreamChange,
+	base, ours, theirs []T,
+	key func(T) K,
+	path func(T) string,
+	resolution models.MergeResolution,
+) []T {
+	baseByKey, oursByKey, theirsByKey := keyBy(base, key), keyBy(ours, key), keyBy(theirs, key)
+	merge := func(entry T) *T {
+		k := key(entry)
+		kind := models.UpstreamChangeChanged
+		if baseByKey[k] == nil {
+			kind = models.UpstreamChangeAdded
+		} else if theirsByKey[k] == nil {
+			kind = models.UpstreamChangeRemoved
+		}
+		return mergeValue(changes, path(entry), baseByKey[k], oursByKey[k], theirsByKey[k], resolution, kind)
+	}
+
+	merged := []T{}
+	for i := range ours {
+		// Only the first entry with a key takes part in the merge; repeats are kept as they are
+		if oursByKey[key(ours[i])] != &ours[i] {
+			merged = append(merged, ours[i])
+		} else if m := merge(ours[i]); m != nil {
+			merged = append(merged, *m)
+		}
+	}
+	for i := range theirs {
+		if oursByKey[key(theirs[i])] != nil || theirsByKey[key(theirs[i])] != &theirs[i] {
+			continue
+		}
+		if m := merge(theirs[i]); m != nil {
+			merged = append(merged, *m)
+		}
+	}
+	return merged
+}
+
+// keyBy indexes list entries by key; the first entry with a key wins
+func keyBy[T any, K comparable](list []T, key func(T) K) map[K]*T {
+	byKey := make(map[K]*T, len(list))
+	for i := range list {
+		if _, ok := byKey[key(list[i])]; !ok {
+			byKey[key(list[i])] = &list[i]
+		}
+	}
+	return byKey
+}
+
+// sortedAllergens returns a recipe's manual allergens in a stable order for comparison
+func sortedAllergens(recipe *models.Recipe) []string {
+	allergens := normalizeAllergens(manualAllergens(recipe))
+	slices.Sort(allergens)
+	return allergens
+}
+
+// sameJSON reports whether two values encode to the same JSON, which ignores the
+// differences between numbers decoded from BSON as int32, int64 or float64
+func sameJSON(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
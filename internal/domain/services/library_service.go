@@ -0,0 +1,643 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AuditActionRecipeForked         = "recipe.forked"
+	AuditActionRecipeUpstreamMerged = "recipe.upstream_merged"
+)
+
+// LibraryService manages the platform library of reference recipes and ingredients and
+// the tenant recipes forked from it. Library recipes are authored with a RecipeService
+// over the library repositories; this service releases them to tenants and forks and
+// merges them into tenant catalogs.
+type LibraryService interface {
+	ListIngredients(ctx context.Context) ([]*models.Ingredient, error)
+	CreateIngredient(ctx context.Context, record models.IngredientRecord) (*models.Ingredient, error)
+	// UpdateIngredient replaces a library ingredient's data and re-derives the library recipes using it
+	UpdateIngredient(ctx context.Context, id primitive.ObjectID, record models.IngredientRecord) (*models.Ingredient, error)
+
+	// ListRecipes returns the library recipes by name; released returns each released
+	// recipe at its released version, as tenants see it, and leaves out the others
+	ListRecipes(ctx context.Context, released bool) ([]*models.Recipe, error)
+	// GetRecipe returns a library recipe, at its released version if released is set
+	GetRecipe(ctx context.Context, id primitive.ObjectID, released bool) (*models.Recipe, error)
+	// Release makes the current version of a library recipe the one tenants see and fork
+	Release(ctx context.Context, id primitive.ObjectID, userID string) (*models.Recipe, error)
+
+	// Fork copies the released version of a library recipe into a tenant's catalog as a
+	// draft, mapping its ingredients to the tenant's
+	Fork(ctx context.Context, req ForkRequest) (*models.Recipe, error)
+	// UpstreamStatus compares a fork with its library recipe and previews the merge
+	UpstreamStatus(ctx context.Context, recipe *models.Recipe) (*models.UpstreamStatus, error)
+	// UpstreamUpdates lists the tenant's forks whose library recipe has a newer release
+	UpstreamUpdates(ctx context.Context, tenantID primitive.ObjectID) ([]*models.UpstreamStatus, error)
+	// MergeUpstream merges the library's changes since the fork's base version into the
+	// fork, saving it as a new draft version
+	MergeUpstream(ctx context.Context, recipe *models.Recipe, resolution models.MergeResolution, userID string) (*models.Recipe, error)
+}
+
+// ForkRequest forks a library recipe into a tenant's catalog. Library ingredients are
+// mapped to the tenant's by IngredientMap, then by an ingredient already linked to them,
+// then by name; the rest are created in the tenant catalog if CreateMissing is set.
+type ForkRequest struct {
+	LibraryRecipeID primitive.ObjectID
+	TenantID        primitive.ObjectID
+	Name            string                                    // Defaults to the library recipe's name
+	IngredientMap   map[primitive.ObjectID]primitive.ObjectID // Library ingredient ID -> tenant ingredient ID
+	CreateMissing   bool
+	UserID          string
+}
+
+type libraryService struct {
+	libraryRecipeRepo     repositories.RecipeRepository
+	libraryIngredientRepo repositories.IngredientRepository
+	libraryRecipeService  RecipeService
+	recipeRepo            repositories.RecipeRepository
+	ingredientRepo        repositories.IngredientRepository
+	recipeService         RecipeService
+	tenantRepo            repositories.TenantRepository
+	auditRepo             repositories.AuditLogRepository
+}
+
+// NewLibraryService creates a new library service
+func NewLibraryService(
+	libraryRecipeRepo repositories.RecipeRepository,
+	libraryIngredientRepo repositories.IngredientRepository,
+	libraryRecipeService RecipeService,
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	recipeService RecipeService,
+	tenantRepo repositories.TenantRepository,
+	auditRepo repositories.AuditLogRepository,
+) LibraryService {
+	return &libraryService{
+		libraryRecipeRepo:     libraryRecipeRepo,
+		libraryIngredientRepo: libraryIngredientRepo,
+		libraryRecipeService:  libraryRecipeService,
+		recipeRepo:            recipeRepo,
+		ingredientRepo:        ingredientRepo,
+		recipeService:         recipeService,
+		tenantRepo:            tenantRepo,
+		auditRepo:             auditRepo,
+	}
+}
+
+func (s *libraryService) ListIngredients(ctx context.Context) ([]*models.Ingredient, error) {
+	ingredients, err := s.libraryIngredientRepo.ListUpdatedSince(ctx, primitive.NilObjectID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list library ingredients: %w", err)
+	}
+	slices.SortFunc(ingredients, func(a, b *models.Ingredient) int {
+		return cmp.Compare(catalogKey(a.Name), catalogKey(b.Name))
+	})
+	return ingredients, nil
+}
+
+func (s *libraryService) CreateIngredient(ctx context.Context, record models.IngredientRecord) (*models.Ingredient, error) {
+	if errs := validateIngredientRecord(record); len(errs) > 0 {
+		return nil, apperrors.Validation(errs[0]).WithDetails(errs)
+	}
+	if err := s.checkIngredientName(ctx, record.Name, primitive.NilObjectID); err != nil {
+		return nil, err
+	}
+
+	ingredient := &models.Ingredient{Name: strings.TrimSpace(record.Name)}
+	applyIngredientRecord(ingredient, record)
+	if err := s.libraryIngredientRepo.Create(ctx, ingredient); err != nil {
+		return nil, fmt.Errorf("failed to create library ingredient: %w", err)
+	}
+	return ingredient, nil
+}
+
+func (s *libraryService) UpdateIngredient(ctx context.Context, id primitive.ObjectID, record models.IngredientRecord) (*models.Ingredient, error) {
+	ingredient, err := s.libraryIngredientRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library ingredient: %w", err)
+	}
+	if ingredient == nil {
+		return nil, apperrors.NotFound("library ingredient")
+	}
+	if errs := validateIngredientRecord(record); len(errs) > 0 {
+		return nil, apperrors.Validation(errs[0]).WithDetails(errs)
+	}
+	if err := s.checkIngredientName(ctx, record.Name, id); err != nil {
+		return nil, err
+	}
+
+	old := *ingredient
+	ingredient.Name = strings.TrimSpace(record.Name)
+	ingredient.Parameters = maps.Clone(ingredient.Parameters)
+	applyIngredientRecord(ingredient, record)
+	if err := s.libraryIngredientRepo.Update(ctx, ingredient); err != nil {
+		return nil, fmt.Errorf("failed to update library ingredient: %w", err)
+	}
+	if ingredientDerivedChanged(&old, ingredient) {
+		if err := s.libraryRecipeService.RefreshForIngredient(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return ingredient, nil
+}
+
+// checkIngredientName rejects a library ingredient name another library ingredient has
+func (s *libraryService) checkIngredientName(ctx context.Context, name string, id primitive.ObjectID) error {
+	ingredients, err := s.ListIngredients(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ing := range ingredients {
+		if ing.ID != id && catalogKey(ing.Name) == catalogKey(name) {
+			return apperrors.Conflict(fmt.Sprintf("library ingredient %q already exists", ing.Name))
+		}
+	}
+	return nil
+}
+
+func (s *libraryService) ListRecipes(ctx context.Context, released bool) ([]*models.Recipe, error) {
+	recipes, err := s.libraryRecipeRepo.ListUpdatedSince(ctx, primitive.NilObjectID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list library recipes: %w", err)
+	}
+	recipes = slices.DeleteFunc(recipes, func(r *models.Recipe) bool {
+		return r.Status == models.RecipeStatusArchived || (released && r.LiveVersion() == 0)
+	})
+	slices.SortFunc(recipes, func(a, b *models.Recipe) int {
+		return cmp.Compare(catalogKey(a.Name), catalogKey(b.Name))
+	})
+	if !released {
+		return recipes, nil
+	}
+
+	for i, recipe := range recipes {
+		if recipes[i], err = s.releasedVersion(ctx, recipe); err != nil {
+			return nil, err
+		}
+	}
+	return recipes, nil
+}
+
+func (s *libraryService) GetRecipe(ctx context.Context, id primitive.ObjectID, released bool) (*models.Recipe, error) {
+	recipe, err := s.libraryRecipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library recipe: %w", err)
+	}
+	if recipe == nil || (released && (recipe.LiveVersion() == 0 || recipe.Status == models.RecipeStatusArchived)) {
+		return nil, apperrors.NotFound("library recipe")
+	}
+	if !released {
+		return recipe, nil
+	}
+	return s.releasedVersion(ctx, recipe)
+}
+
+// releasedVersion returns a library recipe as it was at its released version
+func (s *libraryService) releasedVersion(ctx context.Context, recipe *models.Recipe) (*models.Recipe, error) {
+	live := recipe.LiveVersion()
+	if live == recipe.Version {
+		return recipe, nil
+	}
+	snapshot, err := s.libraryRecipeRepo.GetVersion(ctx, recipe.ID, live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library recipe version: %w", err)
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("library recipe %s has no version %d", recipe.Name, live)
+	}
+	released := snapshot.Recipe
+	released.Status = models.RecipeStatusPublished
+	released.PublishedVersion = live
+	released.PublishedAt = recipe.PublishedAt
+	return &released, nil
+}
+
+func (s *libraryService) Release(ctx context.Context, id primitive.ObjectID, userID string) (*models.Recipe, error) {
+	recipe, err := s.libraryRecipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library recipe: %w", err)
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("library recipe")
+	}
+	if recipe.Status == models.RecipeStatusArchived {
+		return nil, apperrors.Conflict("library recipe is archived")
+	}
+	if recipe.Status == models.RecipeStatusPublished && recipe.LiveVersion() == recipe.Version {
+		return nil, apperrors.Conflict(fmt.Sprintf("version %d is already released", recipe.Version))
+	}
+	if err := s.libraryRecipeService.ValidateRecipe(ctx, recipe); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	recipe.Status = models.RecipeStatusPublished
+	recipe.PublishedVersion = recipe.Version
+	recipe.PublishedAt = &now
+	recipe.ApprovedBy = userID
+	recipe.ApprovedAt = &now
+	if err := s.libraryRecipeRepo.Update(ctx, recipe); err != nil {
+		return nil, fmt.Errorf("failed to release library recipe: %w", err)
+	}
+	return recipe, nil
+}
+
+func (s *libraryService) Fork(ctx context.Context, req ForkRequest) (*models.Recipe, error) {
+	tenant, err := s.tenantRepo.GetByID(ctx, req.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant == nil {
+		return nil, apperrors.NotFound("tenant")
+	}
+	library, err := s.GetRecipe(ctx, req.LibraryRecipeID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := s.mapIngredients(ctx, req.TenantID, libraryRecipeIngredientIDs(library), req.IngredientMap)
+	if err != nil {
+		return nil, err
+	}
+	if len(mapping.missing) > 0 && !req.CreateMissing {
+		names := ingredientNames(mapping.missing)
+		return nil, apperrors.Validation(fmt.Sprintf("%d library ingredients have no match in the tenant catalog; map them or create them", len(names))).WithDetails(names)
+	}
+	mapping.stage(req.TenantID)
+
+	// The fork takes the library recipe's content only: it is a new draft of the tenant's,
+	// with none of the library's identity, approval or publishing
+	now := time.Now()
+	mapped := mapRecipe(library, mapping.tenant)
+	recipe := &models.Recipe{
+		TenantID:                req.TenantID,
+		Name:                    library.Name,
+		Description:             mapped.Description,
+		Category:                mapped.Category,
+		CuisineType:             mapped.CuisineType,
+		PrepTime:                mapped.PrepTime,
+		CookTime:                mapped.CookTime,
+		Servings:                mapped.Servings,
+		EstimatedPrepTimeSec:    mapped.EstimatedPrepTimeSec,
+		EstimatedCookingTimeSec: mapped.EstimatedCookingTimeSec,
+		Ingredients:             mapped.Ingredients,
+		Steps:                   mapped.Steps,
+		Parameters:              mapped.Parameters,
+		Status:                  models.RecipeStatusDraft,
+		Version:                 1,
+		Upstream: &models.RecipeUpstream{
+			LibraryRecipeID: library.ID,
+			Version:         library.PublishedVersion,
+			ForkedAt:        now,
+		},
+		CreatedBy: req.UserID,
+		UpdatedBy: req.UserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		recipe.Name = name
+	}
+
+	// Nothing reaches the tenant catalog unless the fork is valid
+	if err := s.recipeService.ValidateRecipeWith(ctx, recipe, mapping.created); err != nil {
+		return nil, err
+	}
+	if err := s.saveMapping(ctx, mapping); err != nil {
+		return nil, err
+	}
+	if err := s.recipeService.RefreshDerived(ctx, recipe, normalizeAllergens(manualAllergens(library))); err != nil {
+		return nil, err
+	}
+	if err := s.recipeRepo.Create(ctx, recipe); err != nil {
+		return nil, fmt.Errorf("failed to create recipe: %w", err)
+	}
+
+	newState := map[string]any{"library_recipe_id": library.ID.Hex(), "library_version": library.PublishedVersion}
+	if err := recordAudit(ctx, s.auditRepo, recipe.TenantID, req.UserID, AuditActionRecipeForked, AuditResourceRecipe, recipe.ID.Hex(), nil, newState); err != nil {
+		return nil, err
+	}
+	return recipe, nil
+}
+
+func (s *libraryService) UpstreamStatus(ctx context.Context, recipe *models.Recipe) (*models.UpstreamStatus, error) {
+	plan, err := s.planMerge(ctx, recipe, nil)
+	if err != nil {
+		return nil, err
+	}
+	return plan.status, nil
+}
+
+func (s *libraryService) UpstreamUpdates(ctx context.Context, tenantID primitive.ObjectID) ([]*models.UpstreamStatus, error) {
+	recipes, err := s.recipeRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes: %w", err)
+	}
+	slices.SortFunc(recipes, func(a, b *models.Recipe) int {
+		return cmp.Compare(catalogKey(a.Name), catalogKey(b.Name))
+	})
+
+	libraries := make(map[primitive.ObjectID]*models.Recipe)
+	updates := []*models.UpstreamStatus{}
+	for _, recipe := range recipes {
+		if recipe.Upstream == nil || recipe.Status == models.RecipeStatusArchived {
+			continue
+		}
+		id := recipe.Upstream.LibraryRecipeID
+		library, ok := libraries[id]
+		if !ok {
+			if library, err = s.libraryRecipeRepo.GetByID(ctx, id); err != nil {
+				return nil, fmt.Errorf("failed to get library recipe: %w", err)
+			}
+			libraries[id] = library
+		}
+		if library == nil || library.LiveVersion() <= recipe.Upstream.Version {
+			continue
+		}
+
+		plan, err := s.planMerge(ctx, recipe, library)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, plan.status)
+	}
+	return updates, nil
+}
+
+func (s *libraryService) MergeUpstream(ctx context.Context, recipe *models.Recipe, resolution models.MergeResolution, userID string) (*models.Recipe, error) {
+	switch resolution {
+	case models.MergeResolutionNone, models.MergeResolutionOurs, models.MergeResolutionTheirs:
+	default:
+		return nil, apperrors.Validation(fmt.Sprintf("unknown resolution %q; use ours or theirs", resolution))
+	}
+	if recipe.Status == models.RecipeStatusArchived {
+		return nil, apperrors.Conflict("recipe is archived")
+	}
+	// Library steps would overwrite the expanded steps without touching the templated ones
+	if recipe.UsesStepTemplates() {
+		return nil, apperrors.Conflict("recipe uses step templates; merge library changes by hand")
+	}
+
+	plan, err := s.planMerge(ctx, recipe, nil)
+	if err != nil {
+		return nil, err
+	}
+	status := plan.status
+	if !status.Behind {
+		return nil, apperrors.Conflict(fmt.Sprintf("recipe is up to date with library version %d", status.LatestVersion))
+	}
+	if n := status.Conflicts(); n > 0 && resolution == models.MergeResolutionNone {
+		return nil, apperrors.Conflict(fmt.Sprintf("%d library changes conflict with changes made to the recipe; merge with resolution ours or theirs", n)).WithDetails(status)
+	}
+
+	// New library ingredients join the tenant catalog, so the merged recipe can use them
+	plan.mapping.stage(recipe.TenantID)
+	base := mapRecipe(plan.base, plan.mapping.tenant)
+	theirs := mapRecipe(plan.theirs, plan.mapping.tenant)
+	merged, manual, _ := mergeRecipe(base, recipe, theirs, resolution)
+
+	now := time.Now()
+	oldVersion := recipe.Version
+	merged.ResetReview()
+	merged.Version++
+	merged.UpdatedBy = userID
+	merged.UpdatedAt = now
+	merged.Upstream = &models.RecipeUpstream{
+		LibraryRecipeID: recipe.Upstream.LibraryRecipeID,
+		Version:         status.LatestVersion,
+		ForkedAt:        recipe.Upstream.ForkedAt,
+		MergedAt:        &now,
+	}
+
+	if err := s.recipeService.ValidateRecipeWith(ctx, merged, plan.mapping.created); err != nil {
+		return nil, err
+	}
+	if err := s.saveMapping(ctx, plan.mapping); err != nil {
+		return nil, err
+	}
+	if err := s.recipeService.RefreshDerived(ctx, merged, normalizeAllergens(manual)); err != nil {
+		return nil, err
+	}
+	if err := s.recipeRepo.Update(ctx, merged); err != nil {
+		return nil, fmt.Errorf("failed to update recipe: %w", err)
+	}
+
+	oldState := map[string]any{"version": oldVersion, "library_version": status.BaseVersion}
+	newState := map[string]any{"version": merged.Version, "library_version": status.LatestVersion, "resolution": resolution, "conflicts": status.Conflicts()}
+	if err := recordAudit(ctx, s.auditRepo, merged.TenantID, userID, AuditActionRecipeUpstreamMerged, AuditResourceRecipe, merged.ID.Hex(), oldState, newState); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergePlan is what merging a fork's library changes involves
+type mergePlan struct {
+	status  *models.UpstreamStatus
+	base    *models.Recipe // Library snapshot the fork last took
+	theirs  *models.Recipe // Library snapshot released now
+	mapping *ingredientMapping
+}
+
+// planMerge compares a fork with its library recipe, loading the library recipe unless given
+func (s *libraryService) planMerge(ctx context.Context, recipe *models.Recipe, library *models.Recipe) (*mergePlan, error) {
+	if recipe.Upstream == nil {
+		return nil, apperrors.Validation("recipe was not forked from the library")
+	}
+	if library == nil {
+		var err error
+		if library, err = s.libraryRecipeRepo.GetByID(ctx, recipe.Upstream.LibraryRecipeID); err != nil {
+			return nil, fmt.Errorf("failed to get library recipe: %w", err)
+		}
+		if library == nil {
+			return nil, apperrors.NotFound("library recipe")
+		}
+	}
+
+	latest := max(library.LiveVersion(), recipe.Upstream.Version)
+	status := &models.UpstreamStatus{
+		RecipeID:          recipe.ID.Hex(),
+		RecipeName:        recipe.Name,
+		RecipeVersion:     recipe.Version,
+		LibraryRecipeID:   library.ID.Hex(),
+		LibraryRecipeName: library.Name,
+		BaseVersion:       recipe.Upstream.Version,
+		LatestVersion:     latest,
+		Behind:            latest > recipe.Upstream.Version,
+		Changes:           []models.UpstreamChange{},
+	}
+	plan := &mergePlan{status: status, mapping: &ingredientMapping{tenant: map[primitive.ObjectID]*models.Ingredient{}}}
+	if !status.Behind {
+		return plan, nil
+	}
+
+	var err error
+	if plan.base, err = s.librarySnapshot(ctx, library.ID, status.BaseVersion); err != nil {
+		return nil, err
+	}
+	if plan.theirs, err = s.librarySnapshot(ctx, library.ID, status.LatestVersion); err != nil {
+		return nil, err
+	}
+	ids := libraryRecipeIngredientIDs(plan.base)
+	ids = append(ids, libraryRecipeIngredientIDs(plan.theirs)...)
+	if plan.mapping, err = s.mapIngredients(ctx, recipe.TenantID, ids, nil); err != nil {
+		return nil, err
+	}
+	status.NewIngredients = ingredientNames(plan.mapping.missing)
+
+	// Ingredients still to be created keep their library IDs, which match across both snapshots
+	_, _, status.Changes = mergeRecipe(mapRecipe(plan.base, plan.mapping.tenant), recipe, mapRecipe(plan.theirs, plan.mapping.tenant), models.MergeResolutionNone)
+	return plan, nil
+}
+
+// librarySnapshot returns a library recipe's content at a version
+func (s *libraryService) librarySnapshot(ctx context.Context, id primitive.ObjectID, version int) (*models.Recipe, error) {
+	snapshot, err := s.libraryRecipeRepo.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library recipe version: %w", err)
+	}
+	if snapshot == nil {
+		return nil, apperrors.NotFound(fmt.Sprintf("library recipe version %d", version))
+	}
+	return &snapshot.Recipe, nil
+}
+
+// ingredientMapping maps library ingredients to a tenant's
+type ingredientMapping struct {
+	tenant  map[primitive.ObjectID]*models.Ingredient // By library ingredient ID
+	missing []*models.Ingredient                      // Library ingredients without a tenant match
+	link    []*models.Ingredient                      // Tenant ingredients to link to the library ingredient they were matched to
+	created []*models.Ingredient                      // Tenant ingredients staged for the missing ones, not saved yet
+}
+
+// mapIngredients matches library ingredients to the tenant's: by the explicit map, then
+// by a tenant ingredient already linked to the library ingredient, then by name
+func (s *libraryService) mapIngredients(ctx context.Context, tenantID primitive.ObjectID, ids []primitive.ObjectID, explicit map[primitive.ObjectID]primitive.ObjectID) (*ingredientMapping, error) {
+	library, err := s.libraryIngredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get library ingredients: %w", err)
+	}
+	for id := range explicit {
+		if !slices.ContainsFunc(library, func(ing *models.Ingredient) bool { return ing.ID == id }) {
+			return nil, apperrors.Validation(fmt.Sprintf("ingredient_map: %s is not a library ingredient of the recipe", id.Hex()))
+		}
+	}
+
+	tenantIngredients, err := s.ingredientRepo.ListUpdatedSince(ctx, tenantID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingredients: %w", err)
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient, len(tenantIngredients))
+	byUpstream := make(map[primitive.ObjectID]*models.Ingredient)
+	byName := make(map[string]*models.Ingredient, len(tenantIngredients))
+	for _, ing := range tenantIngredients {
+		byID[ing.ID] = ing
+		byName[catalogKey(ing.Name)] = ing
+		if ing.UpstreamID != nil {
+			byUpstream[*ing.UpstreamID] = ing
+		}
+	}
+
+	mapping := &ingredientMapping{tenant: make(map[primitive.ObjectID]*models.Ingredient, len(library))}
+	seen := make(map[primitive.ObjectID]bool)
+	for _, lib := range library {
+		if seen[lib.ID] {
+			continue
+		}
+		seen[lib.ID] = true
+
+		var match *models.Ingredient
+		if id, ok := explicit[lib.ID]; ok {
+			if match = byID[id]; match == nil {
+				return nil, apperrors.Validation(fmt.Sprintf("ingredient_map: %s is not an ingredient of the tenant", id.Hex()))
+			}
+		} else if match = byUpstream[lib.ID]; match == nil {
+			match = byName[catalogKey(lib.Name)]
+		}
+
+		if match == nil {
+			mapping.missing = append(mapping.missing, lib)
+			continue
+		}
+		mapping.tenant[lib.ID] = match
+		if match.UpstreamID == nil {
+			upstreamID := lib.ID
+			match.UpstreamID = &upstreamID
+			mapping.link = append(mapping.link, match)
+		}
+	}
+	return mapping, nil
+}
+
+// stage prepares a tenant ingredient for each missing library ingredient, with the ID it
+// will be saved under, so recipes can be mapped and validated before anything is saved
+func (m *ingredientMapping) stage(tenantID primitive.ObjectID) {
+	for _, lib := range m.missing {
+		upstreamID := lib.ID
+		ingredient := &models.Ingredient{
+			ID:               primitive.NewObjectID(),
+			TenantID:         tenantID,
+			Name:             lib.Name,
+			MoistureType:     lib.MoistureType,
+			ShelfLifeMinutes: lib.ShelfLifeMinutes,
+			AllergenInfo:     slices.Clone(lib.AllergenInfo),
+			Parameters:       maps.Clone(lib.Parameters),
+			UpstreamID:       &upstreamID,
+		}
+		if lib.Nutrition != nil {
+			nutrition := *lib.Nutrition
+			ingredient.Nutrition = &nutrition
+		}
+		m.tenant[lib.ID] = ingredient
+		m.created = append(m.created, ingredient)
+	}
+	m.missing = nil
+}
+
+// saveMapping creates the staged ingredients in the tenant catalog and links the matched
+// ones, so later forks and merges map them the same way
+func (s *libraryService) saveMapping(ctx context.Context, mapping *ingredientMapping) error {
+	for _, ing := range mapping.link {
+		if err := s.ingredientRepo.Update(ctx, ing); err != nil {
+			return fmt.Errorf("failed to link ingredient %s: %w", ing.Name, err)
+		}
+	}
+	mapping.link = nil
+
+	for _, ingredient := range mapping.created {
+		if err := s.ingredientRepo.Create(ctx, ingredient); err != nil {
+			return fmt.Errorf("failed to create ingredient %s: %w", ingredient.Name, err)
+		}
+	}
+	mapping.created = nil
+	return nil
+}
+
+// libraryRecipeIngredientIDs returns the ingredients a recipe lists, adds or substitutes
+func libraryRecipeIngredientIDs(recipe *models.Recipe) []primitive.ObjectID {
+	ids := recipeIngredientIDs(recipe)
+	for _, ing := range recipe.Ingredients {
+		ids = append(ids, ing.Substitutes...)
+	}
+	return ids
+}
+
+// ingredientNames returns the names of ingredients
+func ingredientNames(ingredients []*models.Ingredient) []string {
+	names := make([]string, len(ingredients))
+	for i, ing := range ingredients {
+		names[i] = ing.Name
+	}
+	return names
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestForkStepsOnlyLibraryRecipe(t *testing.T) {
+	ctx := context.Background()
+	lentils := &models.Ingredient{ID: primitive.NewObjectID(), Name: "Lentils", MoistureType: models.MoistureTypeDry, IsActive: true}
+	library := stepsOnlyRecipe(primitive.NilObjectID, lentils)
+	library.Status = models.RecipeStatusPublished
+	library.PublishedVersion = library.Version
+
+	tenant := &models.Tenant{ID: primitive.NewObjectID(), Name: "Tenant"}
+	libraryRecipes, libraryIngredients := newFakeRecipeRepo(library), newFakeIngredientRepo(lentils)
+	recipes, ingredients := newFakeRecipeRepo(), newFakeIngredientRepo()
+	s := NewLibraryService(
+		libraryRecipes, libraryIngredients, NewRecipeService(libraryRecipes, libraryIngredients, nil, nil, nil, nil, nil),
+		recipes, ingredients, NewRecipeService(recipes, ingredients, nil, nil, nil, nil, nil),
+		&fakeTenantRepo{tenants: map[primitive.ObjectID]*models.Tenant{tenant.ID: tenant}}, nil,
+	)
+
+	fork, err := s.Fork(ctx, ForkRequest{LibraryRecipeID: library.ID, TenantID: tenant.ID, CreateMissing: true})
+	if err != nil {
+		t.Fatalf("Fork: %v", err)
+	}
+	if len(ingredients.ingredients) != 1 {
+		t.Fatalf("created %d tenant ingredients, want 1", len(ingredients.ingredients))
+	}
+	for id := range ingredients.ingredients {
+		if got := stepIngredientID(&fork.Steps[1]); got != id.Hex() {
+			t.Errorf("fork adds %s, want the tenant's ingredient %s", got, id.Hex())
+		}
+	}
+}
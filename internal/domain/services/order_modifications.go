@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// resolveModifications validates modifications against the recipe's ingredients and
+// their substitutes. Critical ingredients cannot be excluded, only listed substitutes
+// can be swapped in, and an extra adds at most the ingredient's ExtraCap. An extra
+// without a quantity gets the maximum.
+func resolveModifications(
+	ctx context.Context,
+	ingredientRepo repositories.IngredientRepository,
+	recipe *models.Recipe,
+	potPercentage int,
+	reqs []ModificationRequest,
+) ([]models.Modification, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	ingredients, err := LoadRecipeIngredients(ctx, ingredientRepo, recipe)
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[primitive.ObjectID]models.RecipeIngredient)
+	for _, line := range orderableIngredients(ScaleRecipe(recipe, potPercentage)) {
+		lines[line.IngredientID] = line
+	}
+	nameOf := func(id primitive.ObjectID, fallback string) string {
+		if ing := ingredients[id]; ing != nil {
+			return ing.Name
+		}
+		if fallback != "" {
+			return fallback
+		}
+		return id.Hex()
+	}
+
+	mods := make([]models.Modification, 0, len(reqs))
+	seen := make(map[primitive.ObjectID]bool)
+	for i, req := range reqs {
+		invalid := func(format string, args ...any) error {
+			return apperrors.Validation(fmt.Sprintf("modification %d: %s", i+1, fmt.Sprintf(format, args...))).
+				WithDetails(map[string]any{"modification": i, "ingredient_id": req.IngredientID})
+		}
+
+		modType := models.ModificationType(strings.ToLower(strings.TrimSpace(string(req.Type))))
+		if !modType.IsValid() {
+			return nil, invalid("type must be %s, %s or %s", models.ModificationExclude, models.ModificationSubstitute, models.ModificationExtra)
+		}
+		id, err := primitive.ObjectIDFromHex(req.IngredientID)
+		if err != nil {
+			return nil, invalid("ingredient_id is not a valid ID")
+		}
+		line, ok := lines[id]
+		if !ok {
+			return nil, invalid("ingredient %s is not in recipe '%s'", req.IngredientID, recipe.Name)
+		}
+		if seen[id] {
+			return nil, invalid("ingredient '%s' is modified more than once", nameOf(id, line.IngredientName))
+		}
+		seen[id] = true
+
+		mod := models.Modification{
+			Type:         modType,
+			IngredientID: &id,
+			Ingredient:   nameOf(id, line.IngredientName),
+			Notes:        req.Notes,
+		}
+		switch modType {
+		case models.ModificationExclude:
+			if line.IsCritical {
+				return nil, invalid("ingredient '%s' is critical to recipe '%s' and cannot be excluded", mod.Ingredient, recipe.Name)
+			}
+		case models.ModificationSubstitute:
+			var subID primitive.ObjectID
+			switch {
+			case len(line.Substitutes) == 0:
+				return nil, invalid("ingredient '%s' has no substitutes", mod.Ingredient)
+			case req.SubstituteID == "" && len(line.Substitutes) == 1:
+				subID = line.Substitutes[0]
+			case req.SubstituteID == "":
+				return nil, invalid("substitute_id is required: ingredient '%s' has %d substitutes", mod.Ingredient, len(line.Substitutes))
+			default:
+				subID, err = primitive.ObjectIDFromHex(req.SubstituteID)
+				if err != nil {
+					return nil, invalid("substitute_id is not a valid ID")
+				}
+				listed := false
+				for _, sub := range line.Substitutes {
+					listed = listed || sub == subID
+				}
+				if !listed {
+					return nil, invalid("%s is not a substitute for ingredient '%s'", req.SubstituteID, mod.Ingredient)
+				}
+			}
+			mod.SubstituteID = &subID
+			mod.Substitute = nameOf(subID, "")
+			mod.Quantity, mod.Unit = line.QuantityRequired, line.Unit
+		case models.ModificationExtra:
+			limit := round2(line.QuantityRequired * line.ExtraCap())
+			quantity := req.Quantity
+			if quantity < 0 {
+				return nil, invalid("quantity cannot be negative")
+			}
+			if quantity == 0 {
+				quantity = limit
+			}
+			if quantity > limit {
+				return nil, invalid("extra %g %s of '%s' is over the maximum of %g %s", quantity, line.Unit, mod.Ingredient, limit, line.Unit)
+			}
+			mod.Quantity, mod.Unit = quantity, line.Unit
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+// orderableIngredients returns the ingredients an order can modify: the recipe's
+// ingredient list or, for recipes authored only as steps, what the add steps use
+func orderableIngredients(recipe *models.Recipe) []models.RecipeIngredient {
+	if len(recipe.Ingredients) > 0 {
+		return recipe.Ingredients
+	}
+	amounts := recipeAmounts(recipe)
+	lines := make([]models.RecipeIngredient, len(amounts))
+	for i, amount := range amounts {
+		lines[i] = models.RecipeIngredient{
+			IngredientID:     amount.ID,
+			IngredientName:   amount.Name,
+			QuantityRequired: amount.Quantity,
+			Unit:             amount.Unit,
+		}
+	}
+	return lines
+}
+
+// ApplyModifications returns a copy of a recipe, already scaled to the order's pot
+// size, as the order cooks it: excluded ingredients are dropped from the ingredient
+// list, substitutes take the place of the ingredient in the list and in the steps, and
+// extras are added to the list and spread over the ingredient's add steps. The add
+// steps of excluded ingredients are dropped, and steps waiting for them wait for what
+// they waited for instead.
+func ApplyModifications(recipe *models.Recipe, mods []models.Modification) *models.Recipe {
+	modified := *recipe
+	modified.Nutrition = nil
+	resolved := false
+	for _, mod := range mods {
+		resolved = resolved || mod.IngredientID != nil
+	}
+	if !resolved {
+		return &modified
+	}
+
+	modified.Ingredients = make([]models.RecipeIngredient, 0, len(recipe.Ingredients))
+	for _, ing := range recipe.Ingredients {
+		if findModification(mods, models.ModificationExclude, ing.IngredientID, "") != nil {
+			continue
+		}
+		if extra := findModification(mods, models.ModificationExtra, ing.IngredientID, ""); extra != nil {
+			ing.QuantityRequired = round2(ing.QuantityRequired + extra.Quantity)
+		}
+		if sub := findModification(mods, models.ModificationSubstitute, ing.IngredientID, ""); sub != nil && sub.SubstituteID != nil {
+			ing.IngredientID, ing.IngredientName = *sub.SubstituteID, sub.Substitute
+			ing.Substitutes = nil
+		}
+		modified.Ingredients = append(modified.Ingredients, ing)
+	}
+
+	// Extras are spread over the add steps in proportion to what each adds
+	added := make(map[string]float64)
+	for _, step := range recipe.Steps {
+		if step.Action == models.L4ActionAddLiquid || step.Action == models.L4ActionAddSolid {
+			quantity, _ := toFloat(step.Parameters["quantity"])
+			added[stepIngredientID(&step)] += quantity
+		}
+	}
+
+	dropped := make(map[int][]int)
+	for _, step := range recipe.Steps {
+		if step.Action != models.L4ActionAddLiquid && step.Action != models.L4ActionAddSolid {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(stepIngredientID(&step))
+		if err != nil {
+			continue
+		}
+		name, _ := step.Parameters["ingredient_name"].(string)
+		if findModification(mods, models.ModificationExclude, id, name) != nil {
+			dropped[step.StepNumber] = step.DependsOnSteps
+		}
+	}
+
+	modified.Steps = make([]models.RecipeStep, 0, len(recipe.Steps)-len(dropped))
+	for _, step := range recipe.Steps {
+		if _, ok := dropped[step.StepNumber]; ok {
+			continue
+		}
+		if len(dropped) > 0 {
+			step.DependsOnSteps = keptDependencies(step.DependsOnSteps, dropped)
+		}
+		modified.Steps = append(modified.Steps, step)
+		i := len(modified.Steps) - 1
+		id, err := primitive.ObjectIDFromHex(stepIngredientID(&step))
+		if err != nil {
+			continue
+		}
+		params := make(map[string]any, len(step.Parameters))
+		for name, value := range step.Parameters {
+			params[name] = value
+		}
+		if extra := findModification(mods, models.ModificationExtra, id, ""); extra != nil && added[id.Hex()] > 0 {
+			if quantity, ok := toFloat(params["quantity"]); ok {
+				var field *models.ParamSchema
+				if schema := models.GetActionSchema(step.Action); schema != nil {
+					field = schema.Field("quantity")
+				}
+				params["quantity"] = scaleParam(quantity*(1+extra.Quantity/added[id.Hex()]), field)
+			}
+		}
+		if sub := findModification(mods, models.ModificationSubstitute, id, ""); sub != nil && sub.SubstituteID != nil {
+			params["ingredient_id"] = sub.SubstituteID.Hex()
+			if _, ok := params["ingredient_name"]; ok {
+				params["ingredient_name"] = sub.Substitute
+			}
+		}
+		modified.Steps[i].Parameters = params
+	}
+	return &modified
+}
+
+// keptDependencies replaces the dropped steps among a step's dependencies with the
+// steps they depended on, in turn
+func keptDependencies(deps []int, dropped map[int][]int) []int {
+	var kept []int
+	seen := make(map[int]bool)
+	var visit func(deps []int)
+	visit = func(deps []int) {
+		for _, d := range deps {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			if through, ok := dropped[d]; ok {
+				visit(through)
+				continue
+			}
+			kept = append(kept, d)
+		}
+	}
+	visit(deps)
+	slices.Sort(kept)
+	return kept
+}
@@ -3,10 +3,12 @@ package services
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ak/kws/internal/domain/models"
 	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -29,16 +31,19 @@ type OrderService interface {
 // CreateOrderBatchRequest is used to create multiple orders at once
 // Each item becomes a separate order (with Quantity creating N orders)
 type CreateOrderBatchRequest struct {
-	TenantID            primitive.ObjectID `json:"tenant_id" binding:"required"`
-	RegionID            primitive.ObjectID `json:"region_id" binding:"required"`
-	SiteID              primitive.ObjectID `json:"site_id" binding:"required"`
-	OrderReference      string             `json:"order_reference" binding:"required"` // Base reference
-	CustomerName        string             `json:"customer_name"`
-	Items               []OrderItemRequest `json:"items" binding:"required,min=1"`
-	Priority            int                `json:"priority"`
-	ExecutionTime       *time.Time         `json:"execution_time"`
-	SpecialInstructions string             `json:"special_instructions"`
-	Source              string             `json:"source"`
+	TenantID            primitive.ObjectID   `json:"tenant_id" binding:"required"`
+	RegionID            primitive.ObjectID   `json:"region_id" binding:"required"`
+	SiteID              primitive.ObjectID   `json:"site_id" binding:"required"`
+	OrderReference      string               `json:"order_reference" binding:"required"` // Base reference
+	CustomerName        string               `json:"customer_name"`
+	Items               []OrderItemRequest   `json:"items" binding:"required,min=1"`
+	Priority            int                  `json:"priority"`
+	ExecutionTime       *time.Time           `json:"execution_time"`
+	SpecialInstructions string               `json:"special_instructions"`
+	Notes               string               `json:"notes"` // For every order of the batch
+	Metadata            map[string]any       `json:"metadata"`
+	CustomerAllergies   []string             `json:"customer_allergies"`
+	AllergyPolicy       models.AllergyPolicy `json:"allergy_policy"` // reject (default) or flag
 }
 
 type OrderItemRequest struct {
@@ -46,12 +51,17 @@ type OrderItemRequest struct {
 	Quantity      int                   `json:"quantity" binding:"required,min=1"` // Creates N separate orders
 	PotPercentage int                   `json:"pot_percentage"`
 	Modifications []ModificationRequest `json:"modifications"`
+	Notes         string                `json:"notes"`
+	Options       map[string]any        `json:"options"`
 }
 
+// ModificationRequest changes one recipe ingredient, referenced by ID, for an order
 type ModificationRequest struct {
-	Type       string `json:"type" binding:"required"`
-	Ingredient string `json:"ingredient"`
-	Notes      string `json:"notes"`
+	Type         models.ModificationType `json:"type" binding:"required,oneof=exclude substitute extra"`
+	IngredientID string                  `json:"ingredient_id" binding:"required"`
+	SubstituteID string                  `json:"substitute_id"` // Substitute only; may be omitted when the ingredient has one substitute
+	Quantity     float64                 `json:"quantity"`      // Extra only: amount to add at the order's pot size, up to the maximum (the default)
+	Notes        string                  `json:"notes"`
 }
 
 // CreateOrderFromKOSRequest is used when KOS reports a locally-created order
@@ -89,21 +99,30 @@ type OrderListFilter struct {
 }
 
 type orderService struct {
-	orderRepo  repositories.OrderRepository
-	recipeRepo repositories.RecipeRepository
-	siteRepo   repositories.SiteRepository
+	orderRepo      repositories.OrderRepository
+	recipeRepo     repositories.RecipeRepository
+	ingredientRepo repositories.IngredientRepository
+	siteRepo       repositories.SiteRepository
+	rolloutRepo    repositories.RecipeRolloutRepository
+	inventory      InventoryService
 }
 
 // NewOrderService creates a new order service
 func NewOrderService(
 	orderRepo repositories.OrderRepository,
 	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
 	siteRepo repositories.SiteRepository,
+	rolloutRepo repositories.RecipeRolloutRepository,
+	inventory InventoryService,
 ) OrderService {
 	return &orderService{
-		orderRepo:  orderRepo,
-		recipeRepo: recipeRepo,
-		siteRepo:   siteRepo,
+		orderRepo:      orderRepo,
+		recipeRepo:     recipeRepo,
+		ingredientRepo: ingredientRepo,
+		siteRepo:       siteRepo,
+		rolloutRepo:    rolloutRepo,
+		inventory:      inventory,
 	}
 }
 
@@ -115,13 +134,13 @@ func (s *orderService) CreateBatch(ctx context.Context, req CreateOrderBatchRequ
 			return nil, fmt.Errorf("failed to validate site: %w", err)
 		}
 		if site == nil {
-			return nil, fmt.Errorf("site not found: %s", req.SiteID.Hex())
+			return nil, apperrors.NotFound("site")
 		}
 		if site.TenantID != req.TenantID {
-			return nil, fmt.Errorf("site does not belong to tenant")
+			return nil, apperrors.Validation("site does not belong to tenant")
 		}
 		if site.RegionID != req.RegionID {
-			return nil, fmt.Errorf("site does not belong to specified region")
+			return nil, apperrors.Validation("site does not belong to specified region")
 		}
 	}
 
@@ -130,11 +149,6 @@ func (s *orderService) CreateBatch(ctx context.Context, req CreateOrderBatchRequ
 		priority = 5 // Default priority
 	}
 
-	source := req.Source
-	if source == "" {
-		source = "api"
-	}
-
 	// Set execution time with default to now if not provided
 	execTime := time.Now()
 	if req.ExecutionTime != nil {
@@ -147,43 +161,80 @@ func (s *orderService) CreateBatch(ctx context.Context, req CreateOrderBatchRequ
 	var orders []*models.Order
 	orderNum := 0
 
-	// Create one order per recipe item (with quantity creating N orders)
+	// Validate every item before creating any order, so a rejected batch creates nothing
+	type plannedItem struct {
+		item          OrderItemRequest
+		recipeName    string
+		recipeVersion int
+		potPct        int
+		modifications []models.Modification
+		conflicts     []string
+	}
+	var planned []plannedItem
+	var demands []StockDemand
 	for _, item := range req.Items {
+		p := plannedItem{item: item, potPct: item.PotPercentage}
+		if p.potPct == 0 {
+			p.potPct = 100
+		}
+
 		// Validate recipe exists and is published
-		var recipeName string
 		if s.recipeRepo != nil {
 			recipe, err := s.recipeRepo.GetByID(ctx, item.RecipeID)
 			if err != nil {
 				return nil, fmt.Errorf("failed to validate recipe: %w", err)
 			}
 			if recipe == nil {
-				return nil, fmt.Errorf("recipe not found: %s", item.RecipeID.Hex())
+				return nil, apperrors.Validation(fmt.Sprintf("recipe %s not found", item.RecipeID.Hex()))
 			}
-			if recipe.Status != models.RecipeStatusPublished {
-				return nil, fmt.Errorf("recipe '%s' is not published", recipe.Name)
+			if recipe.LiveVersion() == 0 || !recipe.IsPublishedToSite(req.SiteID) {
+				return nil, apperrors.Validation(fmt.Sprintf("recipe '%s' is not published to this site", recipe.Name))
 			}
-			recipeName = recipe.Name
-		}
+			p.recipeName = recipe.Name
+
+			// Record the version this site cooks, which differs from the live one at canary sites
+			var rollout *models.RecipeRollout
+			if s.rolloutRepo != nil {
+				rollout, err = s.rolloutRepo.GetActiveForRecipe(ctx, recipe.ID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get recipe rollout: %w", err)
+				}
+			}
+			p.recipeVersion = servedVersion(recipe, rollout, req.SiteID)
 
-		var modifications []models.Modification
-		for _, mod := range item.Modifications {
-			modifications = append(modifications, models.Modification{
-				Type:       mod.Type,
-				Ingredient: mod.Ingredient,
-				Notes:      mod.Notes,
-			})
+			// Modifications, allergies and stock are checked against the version this site cooks
+			served, err := servedRecipe(ctx, s.recipeRepo, item.RecipeID, p.recipeVersion)
+			if err != nil {
+				return nil, err
+			}
+			p.modifications, err = resolveModifications(ctx, s.ingredientRepo, served, p.potPct, item.Modifications)
+			if err != nil {
+				return nil, err
+			}
+			p.conflicts, err = checkAllergies(ctx, s.ingredientRepo, served, p.modifications, req.CustomerAllergies)
+			if err != nil {
+				return nil, err
+			}
+			if len(p.conflicts) > 0 && req.AllergyPolicy != models.AllergyPolicyFlag {
+				return nil, AllergenConflictError(served, p.conflicts)
+			}
+			demands = append(demands, StockDemand{Recipe: served, PotPercentage: p.potPct, Modifications: p.modifications, Quantity: item.Quantity})
 		}
+		planned = append(planned, p)
+	}
 
-		potPct := item.PotPercentage
-		if potPct == 0 {
-			potPct = 100
+	if s.inventory != nil {
+		if err := s.inventory.CheckStock(ctx, req.SiteID, demands); err != nil {
+			return nil, err
 		}
+	}
 
-		// Create N orders based on quantity
-		for q := 0; q < item.Quantity; q++ {
+	// Create one order per recipe item (with quantity creating N orders)
+	for _, p := range planned {
+		for q := 0; q < p.item.Quantity; q++ {
 			orderNum++
 			orderRef := req.OrderReference
-			if len(req.Items) > 1 || item.Quantity > 1 {
+			if len(req.Items) > 1 || p.item.Quantity > 1 {
 				orderRef = fmt.Sprintf("%s-%d", req.OrderReference, orderNum)
 			}
 
@@ -194,15 +245,21 @@ func (s *orderService) CreateBatch(ctx context.Context, req CreateOrderBatchRequ
 				OrderReference:      orderRef,
 				OrderGroupID:        groupID,
 				CustomerName:        req.CustomerName,
-				RecipeID:            item.RecipeID,
-				RecipeName:          recipeName,
-				PotPercentage:       potPct,
-				Modifications:       modifications,
+				RecipeID:            p.item.RecipeID,
+				RecipeName:          p.recipeName,
+				RecipeVersion:       p.recipeVersion,
+				PotPercentage:       p.potPct,
+				Modifications:       p.modifications,
+				CustomerAllergies:   req.CustomerAllergies,
+				AllergenConflicts:   p.conflicts,
 				Status:              models.OrderStatusPending,
 				Priority:            priority,
 				ExecutionTime:       execTime,
 				SpecialInstructions: req.SpecialInstructions,
-				Source:              models.OrderSource(source),
+				Notes:               strings.TrimSpace(req.Notes + "\n" + p.item.Notes),
+				Metadata:            req.Metadata,
+				Options:             p.item.Options,
+				Source:              models.OrderSourceAPI,
 				KOSSyncStatus:       models.KOSSyncStatusPending,
 				CreatedAt:           time.Now(),
 				UpdatedAt:           time.Now(),
@@ -219,6 +276,31 @@ func (s *orderService) CreateBatch(ctx context.Context, req CreateOrderBatchRequ
 	return orders, nil
 }
 
+// servedRecipe returns the recipe content at the version a site is served, which at
+// canary sites or during review differs from the working copy
+func servedRecipe(ctx context.Context, recipeRepo repositories.RecipeRepository, recipeID primitive.ObjectID, version int) (*models.Recipe, error) {
+	snapshot, err := recipeRepo.GetVersion(ctx, recipeID, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe version: %w", err)
+	}
+	if snapshot != nil {
+		return &snapshot.Recipe, nil
+	}
+	recipe, err := recipeRepo.GetByID(ctx, recipeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe: %w", err)
+	}
+	if recipe == nil {
+		return nil, fmt.Errorf("recipe not found: %s", recipeID.Hex())
+	}
+	// The working copy only stands in for orders from before versioning or for its own
+	// version; any other version would serve content nobody approved
+	if version > 0 && recipe.Version != version {
+		return nil, fmt.Errorf("recipe %s has no version %d", recipe.Name, version)
+	}
+	return recipe, nil
+}
+
 func (s *orderService) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Order, error) {
 	return s.orderRepo.GetByID(ctx, id)
 }
@@ -375,13 +457,21 @@ func (s *orderService) CreateFromKOS(ctx context.Context, req CreateOrderFromKOS
 		status = models.OrderStatusPending
 	}
 
+	// KOS reports what it already cooked, so its modifications are recorded as given
 	var modifications []models.Modification
 	for _, mod := range req.Modifications {
-		modifications = append(modifications, models.Modification{
-			Type:       mod.Type,
-			Ingredient: mod.Ingredient,
-			Notes:      mod.Notes,
-		})
+		modification := models.Modification{
+			Type:     mod.Type,
+			Quantity: mod.Quantity,
+			Notes:    mod.Notes,
+		}
+		if id, err := primitive.ObjectIDFromHex(mod.IngredientID); err == nil {
+			modification.IngredientID = &id
+		}
+		if id, err := primitive.ObjectIDFromHex(mod.SubstituteID); err == nil {
+			modification.SubstituteID = &id
+		}
+		modifications = append(modifications, modification)
 	}
 
 	kitchenID := req.KitchenID
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshDerived recomputes what a recipe derives from its ingredients: allergens,
+// including those of substitutes, and full-pot nutrition. manual replaces the
+// hand-added allergens; nil keeps the current ones.
+func (s *recipeService) RefreshDerived(ctx context.Context, recipe *models.Recipe, manual []string) error {
+	if manual == nil {
+		manual = manualAllergens(recipe)
+	}
+	ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, recipe)
+	if err != nil {
+		return err
+	}
+	recipe.Allergens = DeriveAllergens(recipe, ingredients, manual)
+	recipe.AllergenWarnings = allergenNames(recipe.Allergens)
+	recipe.Nutrition = ComputeNutrition(recipe, ingredients, 100, nil)
+	return nil
+}
+
+// checkAllergies returns the declared allergies an order of the recipe would still
+// contain after its modifications. Allergens are derived from the current ingredient
+// data rather than the stored rollup, which may predate an ingredient change.
+func checkAllergies(
+	ctx context.Context,
+	ingredientRepo repositories.IngredientRepository,
+	recipe *models.Recipe,
+	mods []models.Modification,
+	allergies []string,
+) ([]string, error) {
+	if len(allergies) == 0 {
+		return nil, nil
+	}
+	ingredients, err := LoadRecipeIngredients(ctx, ingredientRepo, recipe)
+	if err != nil {
+		return nil, err
+	}
+	return AllergenConflicts(DeriveAllergens(recipe, ingredients, manualAllergens(recipe)), mods, allergies), nil
+}
+
+// RefreshForIngredient recomputes the allergens and nutrition of every recipe using
+// an ingredient, after its allergen, nutrition or density data changed. Only working copies are updated;
+// published version snapshots keep what was reviewed.
+func (s *recipeService) RefreshForIngredient(ctx context.Context, ingredientID primitive.ObjectID) error {
+	recipes, err := s.recipeRepo.ListUsingIngredient(ctx, ingredientID)
+	if err != nil {
+		return fmt.Errorf("failed to list recipes using ingredient: %w", err)
+	}
+	for _, recipe := range recipes {
+		if err := s.RefreshDerived(ctx, recipe, nil); err != nil {
+			return err
+		}
+		if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+			return fmt.Errorf("failed to update recipe: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadRecipeIngredients fetches the ingredients a recipe uses, including substitutes
+func LoadRecipeIngredients(
+	ctx context.Context,
+	ingredientRepo repositories.IngredientRepository,
+	recipe *models.Recipe,
+) (map[primitive.ObjectID]*models.Ingredient, error) {
+	ids := recipeIngredientIDs(recipe)
+	for _, ing := range recipe.Ingredients {
+		ids = append(ids, ing.Substitutes...)
+	}
+	byID := make(map[primitive.ObjectID]*models.Ingredient, len(ids))
+	if ingredientRepo == nil || len(ids) == 0 {
+		return byID, nil
+	}
+	ingredients, err := ingredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe ingredients: %w", err)
+	}
+	for _, ing := range ingredients {
+		byID[ing.ID] = ing
+	}
+	return byID, nil
+}
+
+// DeriveAllergens lists the allergens of a recipe: those of each ingredient it lists or
+// its steps add, those of substitutes that may be swapped in, and the manual ones
+func DeriveAllergens(recipe *models.Recipe, ingredients map[primitive.ObjectID]*models.Ingredient, manual []string) []models.RecipeAllergen {
+	allergens := []models.RecipeAllergen{}
+	seen := make(map[models.RecipeAllergen]bool)
+	add := func(entry models.RecipeAllergen) {
+		entry.Allergen = normalizeAllergen(entry.Allergen)
+		key := entry
+		key.IngredientID, key.SubstituteID = nil, nil // Pointers differ per call; compare by name
+		if entry.Allergen == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		allergens = append(allergens, entry)
+	}
+
+	substitutes := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for _, ing := range recipe.Ingredients {
+		substitutes[ing.IngredientID] = append(substitutes[ing.IngredientID], ing.Substitutes...)
+	}
+
+	for _, id := range recipeIngredientIDs(recipe) {
+		ing := ingredients[id]
+		if ing == nil {
+			continue
+		}
+		for _, allergen := range ing.AllergenInfo {
+			add(models.RecipeAllergen{
+				Allergen:       allergen,
+				Source:         models.AllergenSourceIngredient,
+				IngredientID:   &ing.ID,
+				IngredientName: ing.Name,
+			})
+		}
+		for _, subID := range substitutes[id] {
+			sub := ingredients[subID]
+			if sub == nil {
+				continue
+			}
+			for _, allergen := range sub.AllergenInfo {
+				add(models.RecipeAllergen{
+					Allergen:       allergen,
+					Source:         models.AllergenSourceSubstitute,
+					IngredientID:   &ing.ID,
+					IngredientName: ing.Name,
+					SubstituteID:   &sub.ID,
+					SubstituteName: sub.Name,
+				})
+			}
+		}
+	}
+
+	for _, allergen := range manual {
+		add(models.RecipeAllergen{Allergen: allergen, Source: models.AllergenSourceManual})
+	}
+	return allergens
+}
+
+// AllergenConflicts returns the declared allergies a recipe still contains once an
+// order's modifications are applied. Excluding an ingredient removes it and its
+// substitutes; substituting it removes the ingredient and, when the substitute is
+// named, the other substitutes. Otherwise any of them may be used instead.
+func AllergenConflicts(allergens []models.RecipeAllergen, mods []models.Modification, allergies []string) []string {
+	declared := make(map[string]bool, len(allergies))
+	for _, a := range allergies {
+		if a = normalizeAllergen(a); a != "" {
+			declared[a] = true
+		}
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+
+	modification := func(entry models.RecipeAllergen, modType models.ModificationType) *models.Modification {
+		if entry.IngredientID == nil {
+			return nil
+		}
+		return findModification(mods, modType, *entry.IngredientID, entry.IngredientName)
+	}
+
+	found := make(map[string]bool)
+	for _, entry := range allergens {
+		if !declared[entry.Allergen] || modification(entry, models.ModificationExclude) != nil {
+			continue
+		}
+		if sub := modification(entry, models.ModificationSubstitute); sub != nil {
+			if entry.Source == models.AllergenSourceIngredient {
+				continue
+			}
+			// Once the substitute is chosen, the others are not used
+			if entry.Source == models.AllergenSourceSubstitute && sub.SubstituteID != nil && entry.SubstituteID != nil && *sub.SubstituteID != *entry.SubstituteID {
+				continue
+			}
+		}
+		found[entry.Allergen] = true
+	}
+	return sortedSet(found)
+}
+
+// AllergenConflictError is returned when an order is rejected because the dish still
+// contains allergens the customer declared
+func AllergenConflictError(recipe *models.Recipe, conflicts []string) error {
+	return apperrors.Validation(fmt.Sprintf("recipe '%s' contains declared allergens: %s", recipe.Name, strings.Join(conflicts, ", "))).
+		WithDetails(map[string]any{"recipe_id": recipe.ID.Hex(), "allergens": conflicts})
+}
+
+// manualAllergens returns the hand-added allergens of a recipe. Recipes saved before
+// allergens were derived only have AllergenWarnings, all of which were typed by hand.
+func manualAllergens(recipe *models.Recipe) []string {
+	if recipe.Allergens == nil {
+		return recipe.AllergenWarnings
+	}
+	var manual []string
+	for _, entry := range recipe.Allergens {
+		if entry.Source == models.AllergenSourceManual {
+			manual = append(manual, entry.Allergen)
+		}
+	}
+	return manual
+}
+
+func allergenNames(allergens []models.RecipeAllergen) []string {
+	names := make(map[string]bool, len(allergens))
+	for _, entry := range allergens {
+		names[entry.Allergen] = true
+	}
+	return sortedSet(names)
+}
+
+func normalizeAllergen(allergen string) string {
+	return strings.ToLower(strings.TrimSpace(allergen))
+}
+
+func sortedSet(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(set))
+	for s := range set {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return list
+}
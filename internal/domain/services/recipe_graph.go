@@ -0,0 +1,323 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stepDurationParams are the step parameters that hold how long a step runs, in seconds.
+// Steps without one (lid moves, ingredient adds) count as instantaneous.
+var stepDurationParams = []string{"duration_sec", "on_duration_sec"}
+
+// analyzeRecipe validates the step graph of a recipe and, when it has no errors,
+// computes its schedule. known lists the ingredient IDs that exist; nil skips that check.
+func analyzeRecipe(recipe *models.Recipe, known map[primitive.ObjectID]bool) *models.RecipeAnalysis {
+	a := &models.RecipeAnalysis{Issues: []models.RecipeIssue{}}
+	addIssue := func(code string, severity models.IssueSeverity, step int, ingredientID, format string, args ...any) {
+		a.Issues = append(a.Issues, models.RecipeIssue{
+			Code:         code,
+			Severity:     severity,
+			StepNumber:   step,
+			IngredientID: ingredientID,
+			Message:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	if len(recipe.Steps) == 0 {
+		addIssue(models.IssueNoSteps, models.IssueSeverityError, 0, "", "recipe must have at least one step")
+	}
+
+	// Index steps; invalid and duplicate numbers are reported and left out of the graph
+	byNumber := make(map[int]*models.RecipeStep, len(recipe.Steps))
+	numbers := make([]int, 0, len(recipe.Steps))
+	for i := range recipe.Steps {
+		step := &recipe.Steps[i]
+		switch {
+		case step.StepNumber <= 0:
+			addIssue(models.IssueInvalidStepNumber, models.IssueSeverityError, 0, "", "step number must be positive")
+		case byNumber[step.StepNumber] != nil:
+			addIssue(models.IssueDuplicateStep, models.IssueSeverityError, step.StepNumber, "", "duplicate step number: %d", step.StepNumber)
+		default:
+			byNumber[step.StepNumber] = step
+			numbers = append(numbers, step.StepNumber)
+		}
+	}
+	sort.Ints(numbers)
+
+	parents := make(map[int][]int, len(numbers))
+	children := make(map[int][]int, len(numbers))
+	blocked := make(map[int]bool) // Steps waiting on a step that can never finish
+	for _, n := range numbers {
+		seen := make(map[int]bool)
+		for _, dep := range byNumber[n].DependsOnSteps {
+			switch {
+			case dep == n:
+				addIssue(models.IssueSelfDependency, models.IssueSeverityError, n, "", "step %d depends on itself", n)
+				blocked[n] = true
+			case byNumber[dep] == nil:
+				addIssue(models.IssueMissingDependency, models.IssueSeverityError, n, "", "step %d depends on non-existent step %d", n, dep)
+				blocked[n] = true
+			case !seen[dep]:
+				seen[dep] = true
+				parents[n] = append(parents[n], dep)
+				children[dep] = append(children[dep], n)
+			}
+		}
+	}
+
+	// Kahn's algorithm, one stage at a time: a step's stage is the length of the
+	// longest dependency chain leading to it
+	indegree := make(map[int]int, len(numbers))
+	var stage []int
+	for _, n := range numbers {
+		indegree[n] = len(parents[n])
+		if indegree[n] == 0 {
+			stage = append(stage, n)
+		}
+	}
+	var order []int
+	var stages [][]int
+	for len(stage) > 0 {
+		sort.Ints(stage)
+		stages = append(stages, stage)
+		order = append(order, stage...)
+		var next []int
+		for _, n := range stage {
+			for _, child := range children[n] {
+				indegree[child]--
+				if indegree[child] == 0 {
+					next = append(next, child)
+				}
+			}
+		}
+		stage = next
+	}
+
+	// Steps Kahn could not order are on a cycle or wait on one
+	inCycle := make(map[int]bool)
+	if len(order) < len(numbers) {
+		ordered := make(map[int]bool, len(order))
+		for _, n := range order {
+			ordered[n] = true
+		}
+		for _, n := range numbers {
+			if ordered[n] || inCycle[n] {
+				continue
+			}
+			reach := reachableSteps(n, children)
+			if !reach[n] {
+				continue
+			}
+			var cycle []int
+			for m := range reach {
+				if reachableSteps(m, children)[n] {
+					cycle = append(cycle, m)
+					inCycle[m] = true
+				}
+			}
+			sort.Ints(cycle)
+			addIssue(models.IssueCycle, models.IssueSeverityError, cycle[0], "", "steps %s form a dependency cycle", joinSteps(cycle))
+		}
+	}
+
+	unreachable := make(map[int]bool)
+	for _, n := range numbers {
+		if !inCycle[n] && !blocked[n] {
+			continue
+		}
+		for m := range reachableSteps(n, children) {
+			if !inCycle[m] && !blocked[m] {
+				unreachable[m] = true
+			}
+		}
+	}
+	for _, n := range numbers {
+		if unreachable[n] {
+			addIssue(models.IssueUnreachableStep, models.IssueSeverityError, n, "", "step %d can never start because a step it depends on cannot finish", n)
+		}
+		if len(numbers) > 1 && len(parents[n]) == 0 && len(children[n]) == 0 && !blocked[n] {
+			addIssue(models.IssueOrphanStep, models.IssueSeverityWarning, n, "", "step %d is not connected to any other step and will run as soon as the order starts", n)
+		}
+	}
+
+	if a.FirstError() == nil && len(numbers) > 0 {
+		a.TopologicalOrder = order
+		a.Stages = stages
+		for _, s := range stages {
+			if len(s) > a.ParallelismWidth {
+				a.ParallelismWidth = len(s)
+			}
+		}
+		a.CriticalPath, a.CriticalPathSec = criticalPath(order, parents, byNumber)
+	}
+
+	checkStepParameters(recipe, a)
+	checkScalingRules(recipe, a)
+	checkRecipeIngredients(recipe, byNumber, known, addIssue)
+
+	a.Valid = a.FirstError() == nil
+	return a
+}
+
+// checkStepParameters checks each step against the parameter schema of its action
+func checkStepParameters(recipe *models.Recipe, a *models.RecipeAnalysis) {
+	for i, step := range recipe.Steps {
+		addIssue := func(code string, severity models.IssueSeverity, path, format string, args ...any) {
+			a.Issues = append(a.Issues, models.RecipeIssue{
+				Code:       code,
+				Severity:   severity,
+				StepNumber: step.StepNumber,
+				Path:       fmt.Sprintf("steps[%d].%s", i, path),
+				Message:    fmt.Sprintf("step %d: ", step.StepNumber) + fmt.Sprintf(format, args...),
+			})
+		}
+
+		if step.Action == "" {
+			addIssue(models.IssueUnknownAction, models.IssueSeverityError, "action", "action is required")
+			continue
+		}
+		schema := models.GetActionSchema(step.Action)
+		if schema == nil {
+			addIssue(models.IssueUnknownAction, models.IssueSeverityError, "action", "unknown action %q", step.Action)
+			continue
+		}
+
+		for _, field := range schema.Fields {
+			value, ok := step.Parameters[field.Name]
+			if !ok || value == nil {
+				if field.Required {
+					addIssue(models.IssueMissingParameter, models.IssueSeverityError, "parameters."+field.Name, "%s requires %s", step.Action, field.Name)
+				}
+				continue
+			}
+			if reason := field.Check(value); reason != "" {
+				addIssue(models.IssueInvalidParameter, models.IssueSeverityError, "parameters."+field.Name, "%s %s", field.Name, reason)
+			}
+		}
+
+		names := make([]string, 0, len(step.Parameters))
+		for name := range step.Parameters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if schema.Field(name) == nil {
+				addIssue(models.IssueUnknownParameter, models.IssueSeverityWarning, "parameters."+name, "%s does not take parameter %s", step.Action, name)
+			}
+		}
+	}
+}
+
+// checkRecipeIngredients checks each listed ingredient is added at an existing step and
+// used by some step, and that every ingredient referenced by the recipe exists
+func checkRecipeIngredients(
+	recipe *models.Recipe,
+	byNumber map[int]*models.RecipeStep,
+	known map[primitive.ObjectID]bool,
+	addIssue func(code string, severity models.IssueSeverity, step int, ingredientID, format string, args ...any),
+) {
+	used := make(map[string]bool)
+	for _, step := range recipe.Steps {
+		if id, ok := step.Parameters["ingredient_id"]; ok {
+			used[fmt.Sprint(id)] = true
+		}
+	}
+
+	reported := make(map[primitive.ObjectID]bool)
+	checkKnown := func(id primitive.ObjectID, step int) {
+		if known == nil || known[id] || reported[id] {
+			return
+		}
+		reported[id] = true
+		addIssue(models.IssueUnknownIngredient, models.IssueSeverityError, step, id.Hex(), "ingredient not found: %s", id.Hex())
+	}
+
+	for _, ing := range recipe.Ingredients {
+		hex := ing.IngredientID.Hex()
+		name := ing.IngredientName
+		if name == "" {
+			name = hex
+		}
+		checkKnown(ing.IngredientID, 0)
+		if byNumber[ing.TimingStep] == nil {
+			addIssue(models.IssueInvalidTimingStep, models.IssueSeverityError, 0, hex, "ingredient %s is added at step %d, which does not exist", name, ing.TimingStep)
+		}
+		if !used[hex] {
+			addIssue(models.IssueUnusedIngredient, models.IssueSeverityWarning, 0, hex, "ingredient %s is listed but no step adds it", name)
+		}
+	}
+
+	// Steps written by the editor reference ingredients directly by hex ID
+	for _, step := range recipe.Steps {
+		value, ok := step.Parameters["ingredient_id"].(string)
+		if !ok {
+			continue
+		}
+		if id, err := primitive.ObjectIDFromHex(value); err == nil {
+			checkKnown(id, step.StepNumber)
+		}
+	}
+}
+
+// criticalPath returns the chain of steps with the largest total duration
+func criticalPath(order []int, parents map[int][]int, byNumber map[int]*models.RecipeStep) ([]int, int) {
+	finish := make(map[int]int, len(order))
+	via := make(map[int]int, len(order))
+	end := 0
+	for _, n := range order {
+		start := 0
+		for _, p := range parents[n] {
+			if finish[p] > start || (finish[p] == start && via[n] == 0) {
+				start = finish[p]
+				via[n] = p
+			}
+		}
+		finish[n] = start + stepDurationSec(byNumber[n])
+		if end == 0 || finish[n] >= finish[end] {
+			end = n
+		}
+	}
+
+	var path []int
+	for n := end; n != 0; n = via[n] {
+		path = append([]int{n}, path...)
+	}
+	return path, finish[end]
+}
+
+func stepDurationSec(step *models.RecipeStep) int {
+	for _, key := range stepDurationParams {
+		if v, ok := toFloat(step.Parameters[key]); ok && v > 0 {
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// reachableSteps returns the steps that transitively depend on from
+func reachableSteps(from int, children map[int][]int) map[int]bool {
+	seen := make(map[int]bool)
+	stack := append([]int(nil), children[from]...)
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		stack = append(stack, children[n]...)
+	}
+	return seen
+}
+
+func joinSteps(steps []int) string {
+	parts := make([]string, len(steps))
+	for i, n := range steps {
+		parts[i] = fmt.Sprint(n)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditActionIngredientReplaced is recorded on each recipe an ingredient was replaced in
+const AuditActionIngredientReplaced = "recipe.ingredient_replaced"
+
+// ReplaceIngredientRequest replaces one ingredient with another in selected recipes
+type ReplaceIngredientRequest struct {
+	FromID    primitive.ObjectID
+	ToID      primitive.ObjectID
+	RecipeIDs []primitive.ObjectID
+	UserID    string
+	Comment   string
+}
+
+func (s *recipeService) IngredientUsage(ctx context.Context, ingredient *models.Ingredient) (*models.IngredientUsage, error) {
+	versions, err := s.recipeRepo.ListVersionsUsingIngredient(ctx, ingredient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipe versions using ingredient: %w", err)
+	}
+	// Working copies are listed too, for recipes saved before versions were kept
+	current, err := s.recipeRepo.ListUsingIngredient(ctx, ingredient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes using ingredient: %w", err)
+	}
+
+	var order []primitive.ObjectID
+	recipes := make(map[primitive.ObjectID]*models.Recipe)
+	used := make(map[primitive.ObjectID][]int)
+	for _, r := range current {
+		order = append(order, r.ID)
+		recipes[r.ID] = r
+	}
+	for _, v := range versions {
+		if v.TenantID != ingredient.TenantID {
+			continue
+		}
+		if _, ok := recipes[v.RecipeID]; !ok {
+			recipe, err := s.recipeRepo.GetByID(ctx, v.RecipeID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get recipe: %w", err)
+			}
+			if recipe == nil {
+				recipes[v.RecipeID] = nil // Deleted; its snapshots are no longer served
+				continue
+			}
+			order = append(order, v.RecipeID)
+			recipes[v.RecipeID] = recipe
+		}
+		used[v.RecipeID] = append(used[v.RecipeID], v.Version)
+	}
+
+	sites := make(map[primitive.ObjectID]*models.Site)
+	usage := &models.IngredientUsage{
+		IngredientID:   ingredient.ID.Hex(),
+		IngredientName: ingredient.Name,
+		Recipes:        []models.RecipeUsage{},
+	}
+	for _, id := range order {
+		recipe := recipes[id]
+		if recipe == nil || recipe.TenantID != ingredient.TenantID {
+			continue
+		}
+		entry := models.RecipeUsage{
+			RecipeID:       recipe.ID.Hex(),
+			RecipeName:     recipe.Name,
+			Status:         string(recipe.Status),
+			Version:        recipe.Version,
+			Uses:           RecipeIngredientUses(recipe, ingredient.ID),
+			Versions:       used[id],
+			LiveVersion:    recipe.LiveVersion(),
+			PublishedSites: []models.SiteUsage{},
+			PublishedAt:    recipe.PublishedAt,
+		}
+		if len(entry.Uses) > 0 && !slices.Contains(entry.Versions, recipe.Version) {
+			entry.Versions = append(entry.Versions, recipe.Version)
+		}
+		slices.Sort(entry.Versions)
+		if entry.LiveVersion > 0 {
+			entry.LiveUses = slices.Contains(entry.Versions, entry.LiveVersion)
+			entry.PublishedToAll = len(recipe.PublishedToSites) == 0
+			for _, siteID := range recipe.PublishedToSites {
+				site, ok := sites[siteID]
+				if !ok {
+					if site, err = s.siteRepo.GetByID(ctx, siteID); err != nil {
+						return nil, fmt.Errorf("failed to get site: %w", err)
+					}
+					sites[siteID] = site
+				}
+				if site != nil {
+					entry.PublishedSites = append(entry.PublishedSites, models.SiteUsage{SiteID: site.ID.Hex(), Code: site.Code, Name: site.Name})
+				}
+			}
+		}
+		if entry.LiveUses {
+			usage.LiveRecipes++
+		}
+		usage.Recipes = append(usage.Recipes, entry)
+	}
+	slices.SortStableFunc(usage.Recipes, func(a, b models.RecipeUsage) int {
+		return cmp.Compare(a.RecipeName, b.RecipeName)
+	})
+	return usage, nil
+}
+
+func (s *recipeService) ReplaceIngredient(ctx context.Context, req ReplaceIngredientRequest) (*models.IngredientReplacement, error) {
+	if req.FromID == req.ToID {
+		return nil, apperrors.Validation("an ingredient cannot replace itself")
+	}
+	from, err := s.ingredientRepo.GetByID(ctx, req.FromID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingredient: %w", err)
+	}
+	if from == nil {
+		return nil, apperrors.NotFound("ingredient")
+	}
+	to, err := s.ingredientRepo.GetByID(ctx, req.ToID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingredient: %w", err)
+	}
+	if to == nil || to.TenantID != from.TenantID {
+		return nil, apperrors.NotFound("replacement ingredient")
+	}
+	if !to.IsActive {
+		return nil, apperrors.Validation(fmt.Sprintf("replacement ingredient %s is inactive", to.Name))
+	}
+
+	// Every recipe is checked before any is saved, so a bad selection changes nothing
+	var recipes []*models.Recipe
+	seen := make(map[primitive.ObjectID]bool)
+	for _, id := range req.RecipeIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		invalid := func(err *apperrors.APIError) error {
+			return err.WithDetails(map[string]any{"recipe_id": id.Hex(), "errors": err.Details})
+		}
+
+		recipe, err := s.recipeRepo.GetByID(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recipe: %w", err)
+		}
+		if recipe == nil || recipe.TenantID != from.TenantID {
+			return nil, apperrors.NotFound(fmt.Sprintf("recipe %s", id.Hex()))
+		}
+		if recipe.Status == models.RecipeStatusArchived {
+			return nil, invalid(apperrors.Conflict(fmt.Sprintf("recipe %s is archived", recipe.Name)))
+		}
+		if len(RecipeIngredientUses(recipe, from.ID)) == 0 {
+			return nil, invalid(apperrors.Validation(fmt.Sprintf("recipe %s does not use %s", recipe.Name, from.Name)))
+		}
+		if slices.ContainsFunc(recipe.Ingredients, func(ing models.RecipeIngredient) bool { return ing.IngredientID == to.ID }) &&
+			slices.ContainsFunc(recipe.Ingredients, func(ing models.RecipeIngredient) bool { return ing.IngredientID == from.ID }) {
+			return nil, invalid(apperrors.Conflict(fmt.Sprintf("recipe %s already lists %s; merge the two by editing the recipe", recipe.Name, to.Name)))
+		}
+
+		recipe.ResetReview()
+		ReplaceRecipeIngredient(recipe, from.ID, to)
+		recipe.Version++
+		recipe.UpdatedBy = req.UserID
+		recipe.UpdatedAt = time.Now()
+
+		if err := s.ValidateRecipe(ctx, recipe); err != nil {
+			var apiErr *apperrors.APIError
+			if errors.As(err, &apiErr) {
+				return nil, invalid(apperrors.New(apiErr.Code, fmt.Sprintf("recipe %s: %s", recipe.Name, apiErr.Message), apiErr.HTTPStatus).WithDetails(apiErr.Details))
+			}
+			return nil, err
+		}
+		if err := s.RefreshDerived(ctx, recipe, nil); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+
+	for _, recipe := range recipes {
+		if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+			return nil, fmt.Errorf("failed to update recipe %s: %w", recipe.Name, err)
+		}
+		oldState := map[string]any{"version": recipe.Version - 1, "ingredient_id": from.ID.Hex(), "ingredient_name": from.Name}
+		newState := map[string]any{"version": recipe.Version, "ingredient_id": to.ID.Hex(), "ingredient_name": to.Name, "status": recipe.Status}
+		if req.Comment != "" {
+			newState["comment"] = req.Comment
+		}
+		if err := recordAudit(ctx, s.auditRepo, recipe.TenantID, req.UserID, AuditActionIngredientReplaced, AuditResourceRecipe, recipe.ID.Hex(), oldState, newState); err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.IngredientReplacement{
+		FromIngredientID: from.ID,
+		ToIngredientID:   to.ID,
+		Recipes:          recipes,
+	}, nil
+}
+
+// RecipeIngredientUses lists how a recipe uses an ingredient, empty if it does not
+func RecipeIngredientUses(recipe *models.Recipe, ingredientID primitive.ObjectID) []string {
+	var uses []string
+	if slices.ContainsFunc(recipe.Ingredients, func(ing models.RecipeIngredient) bool { return ing.IngredientID == ingredientID }) {
+		uses = append(uses, models.IngredientUseListed)
+	}
+	if slices.ContainsFunc(recipe.Steps, func(step models.RecipeStep) bool { return stepIngredientID(&step) == ingredientID.Hex() }) {
+		uses = append(uses, models.IngredientUseStep)
+	}
+	if slices.ContainsFunc(recipe.Ingredients, func(ing models.RecipeIngredient) bool { return slices.Contains(ing.Substitutes, ingredientID) }) {
+		uses = append(uses, models.IngredientUseSubstitute)
+	}
+	return uses
+}
+
+// ReplaceRecipeIngredient swaps an ingredient for another wherever the recipe lists,
+// adds or substitutes it. Quantities, units and timings are kept.
+func ReplaceRecipeIngredient(recipe *models.Recipe, fromID primitive.ObjectID, to *models.Ingredient) {
+	ingredients := make([]models.RecipeIngredient, len(recipe.Ingredients))
+	for i, ing := range recipe.Ingredients {
+		if ing.IngredientID == fromID {
+			ing.IngredientID = to.ID
+			ing.IngredientName = to.Name
+		}
+		var subs []primitive.ObjectID
+		for _, sub := range ing.Substitutes {
+			if sub == fromID {
+				sub = to.ID
+			}
+			// An ingredient cannot substitute itself
+			if sub != ing.IngredientID && !slices.Contains(subs, sub) {
+				subs = append(subs, sub)
+			}
+		}
+		ing.Substitutes = subs
+		ingredients[i] = ing
+	}
+	recipe.Ingredients = ingredients
+
+	recipe.Steps = replaceStepIngredient(recipe.Steps, fromID, to)
+	if recipe.SourceSteps != nil {
+		recipe.SourceSteps = replaceStepIngredient(recipe.SourceSteps, fromID, to)
+	}
+}
+
+// replaceStepIngredient swaps an ingredient for another in the steps that add it and in
+// the arguments of steps that stand for step templates
+func replaceStepIngredient(steps []models.RecipeStep, fromID primitive.ObjectID, to *models.Ingredient) []models.RecipeStep {
+	replaced := make([]models.RecipeStep, len(steps))
+	for i, step := range steps {
+		if stepIngredientID(&step) == fromID.Hex() {
+			step.Parameters = maps.Clone(step.Parameters)
+			step.Parameters["ingredient_id"] = to.ID.Hex()
+			if _, ok := step.Parameters["ingredient_name"]; ok {
+				step.Parameters["ingredient_name"] = to.Name
+			}
+		}
+		if step.Template != nil {
+			ref := *step.Template
+			ref.Arguments = maps.Clone(ref.Arguments)
+			for name, value := range ref.Arguments {
+				if value == fromID.Hex() {
+					ref.Arguments[name] = to.ID.Hex()
+				}
+			}
+			step.Template = &ref
+		}
+		replaced[i] = step
+	}
+	return replaced
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReplaceIngredientInStepsOnlyRecipe(t *testing.T) {
+	tenantID := primitive.NewObjectID()
+	lentils := &models.Ingredient{ID: primitive.NewObjectID(), TenantID: tenantID, Name: "Lentils", MoistureType: models.MoistureTypeDry, IsActive: true}
+	moong := &models.Ingredient{ID: primitive.NewObjectID(), TenantID: tenantID, Name: "Moong", MoistureType: models.MoistureTypeDry, IsActive: true}
+	recipe := stepsOnlyRecipe(tenantID, lentils)
+
+	recipes := newFakeRecipeRepo(recipe)
+	s := NewRecipeService(recipes, newFakeIngredientRepo(lentils, moong), nil, nil, nil, nil, nil)
+	_, err := s.ReplaceIngredient(context.Background(), ReplaceIngredientRequest{
+		FromID:    lentils.ID,
+		ToID:      moong.ID,
+		RecipeIDs: []primitive.ObjectID{recipe.ID},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceIngredient: %v", err)
+	}
+
+	saved := recipes.recipes[recipe.ID]
+	if got := stepIngredientID(&saved.Steps[1]); got != moong.ID.Hex() {
+		t.Errorf("step adds %s, want %s", got, moong.ID.Hex())
+	}
+	if saved.Version != 2 {
+		t.Errorf("version = %d, want 2", saved.Version)
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/ak/kws/internal/domain/models"
+)
+
+// potActions are the actions that work on the pot sitting on pyro
+var potActions = map[models.L4Action]bool{
+	models.L4ActionAddLiquid:   true,
+	models.L4ActionAddSolid:    true,
+	models.L4ActionAgitate:     true,
+	models.L4ActionOpenPotLid:  true,
+	models.L4ActionClosePotLid: true,
+}
+
+// lintRecipe runs the lint rules over a recipe whose step graph is valid and adds
+// their findings to the analysis. A step's lid and pot state is decided by the steps
+// it depends on, directly or transitively, since only those are certain to have run.
+func lintRecipe(recipe *models.Recipe, a *models.RecipeAnalysis, disabled map[string]bool) {
+	if a.TopologicalOrder == nil {
+		return
+	}
+	addIssue := func(rule string, step int, ingredientID, format string, args ...any) {
+		if disabled[rule] {
+			return
+		}
+		a.Issues = append(a.Issues, models.RecipeIssue{
+			Code:         rule,
+			Severity:     models.GetLintRule(rule).Severity,
+			StepNumber:   step,
+			IngredientID: ingredientID,
+			Message:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	byNumber := make(map[int]*models.RecipeStep, len(recipe.Steps))
+	for i := range recipe.Steps {
+		byNumber[recipe.Steps[i].StepNumber] = &recipe.Steps[i]
+	}
+	pos := make(map[int]int, len(a.TopologicalOrder))
+	ancestors := make(map[int]map[int]bool, len(a.TopologicalOrder))
+	var acquires, delivers []int
+	for i, n := range a.TopologicalOrder {
+		pos[n] = i
+		anc := make(map[int]bool)
+		for _, dep := range byNumber[n].DependsOnSteps {
+			anc[dep] = true
+			for m := range ancestors[dep] {
+				anc[m] = true
+			}
+		}
+		ancestors[n] = anc
+		switch byNumber[n].Action {
+		case models.L4ActionAcquirePotFromStaging:
+			acquires = append(acquires, n)
+		case models.L4ActionDeliverPotToServing:
+			delivers = append(delivers, n)
+		}
+	}
+
+	// lastBefore returns the latest step n depends on whose action is one of actions, or 0
+	lastBefore := func(n int, actions ...models.L4Action) int {
+		last := 0
+		for m := range ancestors[n] {
+			for _, action := range actions {
+				if byNumber[m].Action == action && (last == 0 || pos[m] > pos[last]) {
+					last = m
+				}
+			}
+		}
+		return last
+	}
+
+	for _, n := range a.TopologicalOrder {
+		step := byNumber[n]
+		hasPot := lastBefore(n, models.L4ActionAcquirePotFromStaging) != 0
+		switch {
+		case hasPot:
+		case step.Action == models.L4ActionHeat:
+			addIssue(models.LintHeatWithoutPot, n, "", "step %d heats before a pot is acquired from staging", n)
+		case potActions[step.Action]:
+			addIssue(models.LintAddWithoutPot, n, "", "step %d (%s) runs before a pot is acquired from staging", n, step.Action)
+		}
+
+		lid := lastBefore(n, models.L4ActionOpenPotLid, models.L4ActionClosePotLid)
+		if lid == 0 {
+			continue
+		}
+		switch step.Action {
+		case models.L4ActionAddSolid:
+			if byNumber[lid].Action == models.L4ActionClosePotLid {
+				addIssue(models.LintLidClosedAddSolid, n, stepIngredientID(step), "step %d adds a solid while the lid is closed by step %d", n, lid)
+			}
+		case models.L4ActionOpenPotLid, models.L4ActionClosePotLid:
+			if byNumber[lid].Action == step.Action {
+				addIssue(models.LintRedundantLidMove, n, "", "step %d repeats %s from step %d", n, step.Action, lid)
+			}
+		}
+	}
+
+	// Each pick is returned by the first later place of the same canister; a place
+	// without an ingredient returns whatever was picked before it
+	matched := make(map[int]bool)
+	for _, n := range a.TopologicalOrder {
+		pick := byNumber[n]
+		if pick.Action != models.L4ActionPickIngredient {
+			continue
+		}
+		id := stepIngredientID(pick)
+		found := false
+		for _, m := range a.TopologicalOrder {
+			place := byNumber[m]
+			if place.Action != models.L4ActionPlaceIngredient || matched[m] || !ancestors[m][n] {
+				continue
+			}
+			if placeID := stepIngredientID(place); placeID == "" || placeID == id {
+				matched[m] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			addIssue(models.LintUnmatchedPick, n, id, "step %d picks a canister that no later step returns to storage", n)
+		}
+	}
+	for _, n := range a.TopologicalOrder {
+		if byNumber[n].Action == models.L4ActionPlaceIngredient && !matched[n] {
+			addIssue(models.LintUnmatchedPlace, n, stepIngredientID(byNumber[n]), "step %d returns a canister that no earlier step picked", n)
+		}
+	}
+
+	if len(acquires) > 0 && len(delivers) == 0 {
+		addIssue(models.LintMissingDeliver, 0, "", "the pot is acquired but never delivered to serving")
+	}
+	for _, d := range delivers {
+		var after []int
+		for _, n := range a.TopologicalOrder {
+			if n != d && !ancestors[d][n] {
+				after = append(after, n)
+			}
+		}
+		if len(after) > 0 {
+			addIssue(models.LintDeliverNotTerminal, d, "", "steps %s can run after the pot is delivered at step %d", joinSteps(after), d)
+		}
+	}
+
+	a.Valid = a.FirstError() == nil
+}
+
+func stepIngredientID(step *models.RecipeStep) string {
+	if id, ok := step.Parameters["ingredient_id"].(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ak/kws/internal/domain/models"
+)
+
+func TestLintHeatWithoutAnyPot(t *testing.T) {
+	recipe := &models.Recipe{Steps: []models.RecipeStep{
+		{StepNumber: 1, Action: models.L4ActionHeat, Parameters: map[string]any{"power_level": 4, "on_duration_sec": 60}},
+	}}
+	analysis := analyzeRecipe(recipe, nil)
+	lintRecipe(recipe, analysis, nil)
+
+	issue := analysis.FirstError()
+	if issue == nil || issue.Code != models.LintHeatWithoutPot {
+		t.Fatalf("first error = %+v, want %s", issue, models.LintHeatWithoutPot)
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExtraPortionFactor is how much of an ingredient an order gets with an "extra"
+// modification that carries no quantity, relative to the recipe quantity. Only
+// orders placed before modifications were resolved lack the quantity.
+const ExtraPortionFactor = 1.5
+
+// Nutrition computes the nutrition of a recipe at a pot size with an order's
+// modifications applied, from the current ingredient data
+func (s *recipeService) Nutrition(ctx context.Context, recipe *models.Recipe, potPercentage int, mods []models.Modification) (*models.RecipeNutrition, error) {
+	ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, recipe)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeNutrition(recipe, ingredients, potPercentage, mods), nil
+}
+
+// ingredientAmount is how much of one ingredient a recipe uses
+type ingredientAmount struct {
+	ID       primitive.ObjectID
+	Name     string
+	Quantity float64
+	Unit     string
+}
+
+// recipeAmounts returns the ingredient quantities of a recipe. The ingredient list is
+// authoritative; recipes authored only as steps are measured by their add steps.
+func recipeAmounts(recipe *models.Recipe) []ingredientAmount {
+	var amounts []ingredientAmount
+	if len(recipe.Ingredients) > 0 {
+		for _, ing := range recipe.Ingredients {
+			amounts = append(amounts, ingredientAmount{ID: ing.IngredientID, Quantity: ing.QuantityRequired, Unit: ing.Unit})
+		}
+		return amounts
+	}
+
+	index := make(map[primitive.ObjectID]int)
+	for i := range recipe.Steps {
+		step := &recipe.Steps[i]
+		unit := stepDispenseUnit(step.Action)
+		if unit == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(stepIngredientID(step))
+		if err != nil {
+			continue
+		}
+		quantity, _ := toFloat(step.Parameters["quantity"])
+		if i, ok := index[id]; ok && amounts[i].Unit == unit {
+			amounts[i].Quantity += quantity
+			continue
+		}
+		name, _ := step.Parameters["ingredient_name"].(string)
+		index[id] = len(amounts)
+		amounts = append(amounts, ingredientAmount{ID: id, Name: name, Quantity: quantity, Unit: unit})
+	}
+	return amounts
+}
+
+// ComputeNutrition computes the nutrition of a recipe at a pot size. Quantities are
+// scaled as KOS would cook them; excluded ingredients are left out and extra ones
+// counted at ExtraPortionFactor. Liquids are weighed by the ingredient density,
+// assuming 1 g/ml when it is not set. Ingredients without nutrition data or with a
+// unit that cannot be weighed are listed as incomplete rather than guessed.
+func ComputeNutrition(
+	recipe *models.Recipe,
+	ingredients map[primitive.ObjectID]*models.Ingredient,
+	potPercentage int,
+	mods []models.Modification,
+) *models.RecipeNutrition {
+	if potPercentage <= 0 || potPercentage > 100 {
+		potPercentage = 100
+	}
+	servings := float64(recipe.Servings)
+	if servings <= 0 {
+		servings = 1
+	}
+	n := &models.RecipeNutrition{
+		PotPercentage: potPercentage,
+		Servings:      servings * float64(potPercentage) / 100,
+	}
+
+	for _, amount := range recipeAmounts(ScaleRecipe(recipe, potPercentage)) {
+		ing := ingredients[amount.ID]
+		if ing != nil {
+			amount.Name = ing.Name
+		}
+		if amount.Name == "" {
+			amount.Name = amount.ID.Hex()
+		}
+		if findModification(mods, models.ModificationExclude, amount.ID, amount.Name) != nil {
+			continue
+		}
+		extra := findModification(mods, models.ModificationExtra, amount.ID, amount.Name)
+		// A resolved substitute is cooked in place of the ingredient
+		if sub := findModification(mods, models.ModificationSubstitute, amount.ID, amount.Name); sub != nil && sub.SubstituteID != nil {
+			amount.ID, amount.Name, ing = *sub.SubstituteID, sub.Substitute, ingredients[*sub.SubstituteID]
+			if sub.Quantity > 0 {
+				amount.Quantity, amount.Unit = sub.Quantity, sub.Unit
+			}
+			if ing != nil {
+				amount.Name = ing.Name
+			}
+		}
+
+		grams, assumed, ok := ingredientGrams(amount, ing)
+		if !ok || ing == nil || ing.Nutrition == nil {
+			n.Incomplete = append(n.Incomplete, amount.Name)
+			continue
+		}
+		if assumed {
+			n.AssumedDensity = append(n.AssumedDensity, amount.Name)
+		}
+		if extra != nil {
+			if extra.Quantity > 0 {
+				added := ingredientAmount{Quantity: extra.Quantity, Unit: extra.Unit}
+				if added.Unit == "" {
+					added.Unit = amount.Unit
+				}
+				extraGrams, _, _ := ingredientGrams(added, ing)
+				grams += extraGrams
+			} else {
+				grams *= ExtraPortionFactor
+			}
+		}
+
+		var facts models.NutritionFacts
+		facts.Add(ing.Nutrition, grams)
+		n.Ingredients = append(n.Ingredients, models.IngredientNutrition{
+			IngredientID: amount.ID.Hex(),
+			Name:         amount.Name,
+			Grams:        round2(grams),
+			Facts:        roundFacts(facts),
+		})
+		n.TotalWeightG += grams
+		n.Total.Add(ing.Nutrition, grams)
+	}
+
+	n.PerServing = roundFacts(n.Total.Scaled(1 / n.Servings))
+	if n.TotalWeightG > 0 {
+		n.Per100g = roundFacts(n.Total.Scaled(100 / n.TotalWeightG))
+	}
+	n.Total = roundFacts(n.Total)
+	n.TotalWeightG = round2(n.TotalWeightG)
+	return n
+}
+
+// findModification returns the order modification of the given type for an
+// ingredient, or nil. Resolved modifications match by ingredient ID; older ones by
+// the ID or case-insensitive name in their free-text Ingredient.
+func findModification(mods []models.Modification, modType models.ModificationType, id primitive.ObjectID, name string) *models.Modification {
+	for i := range mods {
+		mod := &mods[i]
+		if mod.Type != modType {
+			continue
+		}
+		if mod.IngredientID != nil {
+			if *mod.IngredientID == id {
+				return mod
+			}
+			continue
+		}
+		ref := strings.TrimSpace(mod.Ingredient)
+		if ref == id.Hex() || (name != "" && strings.EqualFold(ref, name)) {
+			return mod
+		}
+	}
+	return nil
+}
+
+func roundFacts(f models.NutritionFacts) models.NutritionFacts {
+	return models.NutritionFacts{
+		Calories: round2(f.Calories),
+		Protein:  round2(f.Protein),
+		Fat:      round2(f.Fat),
+		Carbs:    round2(f.Carbs),
+		Fiber:    round2(f.Fiber),
+		Sodium:   round2(f.Sodium),
+		Sugar:    round2(f.Sugar),
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PropagateIngredientRename copies an ingredient's new name into the recipes that use
+// it. Working copies that are not what sites are served are renamed in place, like
+// other derived data; the live version of a published recipe is what was reviewed, so
+// it keeps the old name and the recipe is reported instead.
+func (s *recipeService) PropagateIngredientRename(ctx context.Context, ingredient *models.Ingredient, oldName string) (*models.IngredientRename, error) {
+	recipes, err := s.recipeRepo.ListUsingIngredient(ctx, ingredient.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes using ingredient: %w", err)
+	}
+
+	rename := &models.IngredientRename{
+		OldName:   oldName,
+		NewName:   ingredient.Name,
+		Updated:   []models.RecipeRef{},
+		Published: []models.RecipeRef{},
+	}
+	for _, recipe := range recipes {
+		if recipe.TenantID != ingredient.TenantID {
+			continue
+		}
+		ref := models.RecipeRef{
+			RecipeID:    recipe.ID.Hex(),
+			RecipeName:  recipe.Name,
+			Status:      string(recipe.Status),
+			Version:     recipe.Version,
+			LiveVersion: recipe.LiveVersion(),
+		}
+
+		if ref.LiveVersion > 0 {
+			live := recipe
+			if ref.LiveVersion != recipe.Version {
+				snapshot, err := s.recipeRepo.GetVersion(ctx, recipe.ID, ref.LiveVersion)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get recipe version: %w", err)
+				}
+				if snapshot != nil {
+					live = &snapshot.Recipe
+				}
+			}
+			if len(RecipeIngredientUses(live, ingredient.ID)) > 0 {
+				rename.Published = append(rename.Published, ref)
+			}
+			if ref.LiveVersion == recipe.Version {
+				continue
+			}
+		}
+
+		if !RenameRecipeIngredient(recipe, ingredient) {
+			continue
+		}
+		// Allergens carry ingredient names too
+		if err := s.RefreshDerived(ctx, recipe, nil); err != nil {
+			return nil, err
+		}
+		if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+			return nil, fmt.Errorf("failed to update recipe: %w", err)
+		}
+		rename.Updated = append(rename.Updated, ref)
+	}
+	return rename, nil
+}
+
+// RenameRecipeIngredient sets an ingredient's current name wherever the recipe lists or
+// adds it, in its steps and in the source steps they are expanded from, and reports
+// whether anything changed
+func RenameRecipeIngredient(recipe *models.Recipe, ingredient *models.Ingredient) bool {
+	changed := false
+	for i := range recipe.Ingredients {
+		if recipe.Ingredients[i].IngredientID == ingredient.ID && recipe.Ingredients[i].IngredientName != ingredient.Name {
+			recipe.Ingredients[i].IngredientName = ingredient.Name
+			changed = true
+		}
+	}
+	if renameStepIngredient(recipe.Steps, ingredient) {
+		changed = true
+	}
+	if renameStepIngredient(recipe.SourceSteps, ingredient) {
+		changed = true
+	}
+	return changed
+}
+
+// renameStepIngredient sets an ingredient's current name in the steps that add it
+func renameStepIngredient(steps []models.RecipeStep, ingredient *models.Ingredient) bool {
+	changed := false
+	for i := range steps {
+		step := &steps[i]
+		if stepIngredientID(step) != ingredient.ID.Hex() {
+			continue
+		}
+		if name, ok := step.Parameters["ingredient_name"].(string); ok && name != ingredient.Name {
+			step.Parameters = maps.Clone(step.Parameters)
+			step.Parameters["ingredient_name"] = ingredient.Name
+			changed = true
+		}
+	}
+	return changed
+}
+
+// AnnotateCurrentNames fills in the current recipe and ingredient names of orders whose
+// recipe or modified ingredients were renamed since they were placed. The stored names
+// are left as ordered.
+func AnnotateCurrentNames(
+	ctx context.Context,
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	orders []*models.Order,
+) error {
+	recipeNames := make(map[primitive.ObjectID]string)
+	var ingredientIDs []primitive.ObjectID
+	for _, order := range orders {
+		if _, ok := recipeNames[order.RecipeID]; !ok {
+			recipe, err := recipeRepo.GetByID(ctx, order.RecipeID)
+			if err != nil {
+				return fmt.Errorf("failed to get recipe: %w", err)
+			}
+			recipeNames[order.RecipeID] = ""
+			if recipe != nil {
+				recipeNames[order.RecipeID] = recipe.Name
+			}
+		}
+		for _, mod := range order.Modifications {
+			if mod.IngredientID != nil {
+				ingredientIDs = append(ingredientIDs, *mod.IngredientID)
+			}
+			if mod.SubstituteID != nil {
+				ingredientIDs = append(ingredientIDs, *mod.SubstituteID)
+			}
+		}
+	}
+
+	ingredientNames := make(map[primitive.ObjectID]string)
+	if len(ingredientIDs) > 0 {
+		ingredients, err := ingredientRepo.GetByIDs(ctx, ingredientIDs)
+		if err != nil {
+			return fmt.Errorf("failed to get ingredients: %w", err)
+		}
+		for _, ing := range ingredients {
+			ingredientNames[ing.ID] = ing.Name
+		}
+	}
+	current := func(stored string, id *primitive.ObjectID) string {
+		if id == nil {
+			return ""
+		}
+		if name := ingredientNames[*id]; name != "" && name != stored {
+			return name
+		}
+		return ""
+	}
+
+	for _, order := range orders {
+		if name := recipeNames[order.RecipeID]; name != "" && name != order.RecipeName {
+			order.CurrentRecipeName = name
+		}
+		for i := range order.Modifications {
+			mod := &order.Modifications[i]
+			mod.CurrentIngredient = current(mod.Ingredient, mod.IngredientID)
+			mod.CurrentSubstitute = current(mod.Substitute, mod.SubstituteID)
+		}
+	}
+	return nil
+}
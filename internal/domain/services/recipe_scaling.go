@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ak/kws/internal/domain/models"
+)
+
+// ScaleRecipe returns a copy of a full-pot recipe with ingredient quantities and step
+// parameters scaled for the given pot percentage. Ingredients scale linearly unless
+// they have a rule; step parameters follow their rule, else the action schema default,
+// else stay fixed. Scaled parameters are rounded to the schema type and kept within
+// the schema bounds so KOS always receives values it can execute.
+func ScaleRecipe(recipe *models.Recipe, potPercentage int) *models.Recipe {
+	scaled := *recipe
+	if potPercentage <= 0 || potPercentage >= 100 {
+		return &scaled
+	}
+	scaled.Nutrition = nil // Stored for the full pot
+
+	scaled.Ingredients = make([]models.RecipeIngredient, len(recipe.Ingredients))
+	for i, ing := range recipe.Ingredients {
+		rule := models.ScalingRule{Mode: models.ScalingLinear}
+		if ing.Scaling != nil {
+			rule = *ing.Scaling
+		}
+		ing.QuantityRequired = math.Round(ing.QuantityRequired*rule.Factor(potPercentage)*100) / 100
+		scaled.Ingredients[i] = ing
+	}
+
+	scaled.Steps = make([]models.RecipeStep, len(recipe.Steps))
+	for i, step := range recipe.Steps {
+		schema := models.GetActionSchema(step.Action)
+		params := make(map[string]any, len(step.Parameters))
+		for name, value := range step.Parameters {
+			params[name] = value
+
+			var field *models.ParamSchema
+			if schema != nil {
+				field = schema.Field(name)
+			}
+			rule, ok := step.Scaling[name]
+			if !ok {
+				if field == nil || field.Scaling == "" {
+					continue
+				}
+				rule = models.ScalingRule{Mode: field.Scaling}
+			}
+			n, ok := toFloat(value)
+			if !ok {
+				continue
+			}
+			params[name] = scaleParam(n*rule.Factor(potPercentage), field)
+		}
+		step.Parameters = params
+		scaled.Steps[i] = step
+	}
+	return &scaled
+}
+
+func scaleParam(v float64, field *models.ParamSchema) any {
+	if field == nil {
+		return math.Round(v*100) / 100
+	}
+	if field.Min != nil && v < *field.Min {
+		v = *field.Min
+	}
+	if field.Max != nil && v > *field.Max {
+		v = *field.Max
+	}
+	if field.Type == models.ParamTypeInteger {
+		return int(math.Round(v))
+	}
+	return math.Round(v*10) / 10
+}
+
+// checkScalingRules checks the scaling rules of ingredients and step parameters
+func checkScalingRules(recipe *models.Recipe, a *models.RecipeAnalysis) {
+	addIssue := func(step int, ingredientID, path, format string, args ...any) {
+		a.Issues = append(a.Issues, models.RecipeIssue{
+			Code:         models.IssueInvalidScaling,
+			Severity:     models.IssueSeverityError,
+			StepNumber:   step,
+			IngredientID: ingredientID,
+			Path:         path,
+			Message:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	for i, ing := range recipe.Ingredients {
+		if ing.Scaling == nil {
+			continue
+		}
+		if reason := scalingRuleProblem(*ing.Scaling); reason != "" {
+			addIssue(0, ing.IngredientID.Hex(), fmt.Sprintf("ingredients[%d].scaling", i), "ingredient %s scaling %s", ing.IngredientID.Hex(), reason)
+		}
+	}
+
+	for i, step := range recipe.Steps {
+		schema := models.GetActionSchema(step.Action)
+		names := make([]string, 0, len(step.Scaling))
+		for name := range step.Scaling {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := fmt.Sprintf("steps[%d].scaling.%s", i, name)
+			if schema != nil {
+				field := schema.Field(name)
+				if field == nil || (field.Type != models.ParamTypeInteger && field.Type != models.ParamTypeNumber) {
+					addIssue(step.StepNumber, "", path, "step %d: %s is not a numeric parameter of %s and cannot be scaled", step.StepNumber, name, step.Action)
+					continue
+				}
+			}
+			if reason := scalingRuleProblem(step.Scaling[name]); reason != "" {
+				addIssue(step.StepNumber, "", path, "step %d: %s scaling %s", step.StepNumber, name, reason)
+			}
+		}
+	}
+}
+
+func scalingRuleProblem(rule models.ScalingRule) string {
+	switch rule.Mode {
+	case models.ScalingLinear, models.ScalingFixed:
+		if len(rule.Points) > 0 {
+			return "points are only used by custom curves"
+		}
+	case models.ScalingCustom:
+		if len(rule.Points) == 0 {
+			return "custom curve needs at least one point"
+		}
+		seen := make(map[int]bool)
+		for _, p := range rule.Points {
+			if p.PotPercentage < 1 || p.PotPercentage > 100 {
+				return "curve points must be between 1 and 100 percent"
+			}
+			if seen[p.PotPercentage] {
+				return fmt.Sprintf("has more than one point at %d%%", p.PotPercentage)
+			}
+			seen[p.PotPercentage] = true
+			if p.Factor < 0 {
+				return "curve factors cannot be negative"
+			}
+		}
+	default:
+		return fmt.Sprintf("mode must be %s, %s or %s", models.ScalingLinear, models.ScalingFixed, models.ScalingCustom)
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ak/kws/internal/domain/models"
 	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -17,10 +18,48 @@ type RecipeService interface {
 	Update(ctx context.Context, id primitive.ObjectID, req UpdateRecipeRequest) (*models.Recipe, error)
 	Delete(ctx context.Context, id primitive.ObjectID) error
 	List(ctx context.Context, tenantID primitive.ObjectID, filter RecipeListFilter) ([]*models.Recipe, int64, error)
-	Publish(ctx context.Context, id primitive.ObjectID) error
-	Unpublish(ctx context.Context, id primitive.ObjectID) error
 	GetPublishedForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error)
+	// ValidateRecipe checks a recipe's schema, step graph and units; lint rules are
+	// only enforced on submission and publishing
 	ValidateRecipe(ctx context.Context, recipe *models.Recipe) error
+	// ValidateRecipeWith validates a recipe whose pending ingredients are created along
+	// with it, so they are not in the catalog yet
+	ValidateRecipeWith(ctx context.Context, recipe *models.Recipe, pending []*models.Ingredient) error
+	// Analyze validates the step graph, computes its schedule and lints the recipe
+	// without saving anything
+	Analyze(ctx context.Context, recipe *models.Recipe) (*models.RecipeAnalysis, error)
+	// RefreshDerived derives the recipe's allergens and nutrition from its ingredients;
+	// manual replaces the hand-added allergens, nil keeps them. The recipe is not saved.
+	RefreshDerived(ctx context.Context, recipe *models.Recipe, manual []string) error
+	// RefreshForIngredient re-derives and saves every recipe using the ingredient
+	RefreshForIngredient(ctx context.Context, ingredientID primitive.ObjectID) error
+	// IngredientUsage lists every recipe and version that uses an ingredient, and where each is published
+	IngredientUsage(ctx context.Context, ingredient *models.Ingredient) (*models.IngredientUsage, error)
+	// ReplaceIngredient swaps an ingredient for another in the selected recipes, saving
+	// each as a new draft version that goes through review and publishing again
+	ReplaceIngredient(ctx context.Context, req ReplaceIngredientRequest) (*models.IngredientReplacement, error)
+	// PropagateIngredientRename renames an ingredient in the recipe copies not served to
+	// sites and reports the published recipes still carrying the old name
+	PropagateIngredientRename(ctx context.Context, ingredient *models.Ingredient, oldName string) (*models.IngredientRename, error)
+	// Nutrition computes the recipe's nutrition at a pot size with the modifications applied
+	Nutrition(ctx context.Context, recipe *models.Recipe, potPercentage int, mods []models.Modification) (*models.RecipeNutrition, error)
+
+	// Review workflow: draft -> review -> approved -> published
+	SubmitForReview(ctx context.Context, id primitive.ObjectID, userID, comment string) (*models.Recipe, error)
+	Approve(ctx context.Context, id primitive.ObjectID, reviewerID, comment string) (*models.Recipe, error)
+	Reject(ctx context.Context, id primitive.ObjectID, reviewerID, comment string) (*models.Recipe, error)
+	// Publish requires an approved recipe; publishing an already published recipe adds targets
+	Publish(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.Recipe, error)
+	// Unpublish removes targets; with no targets (or when none are left) the recipe is
+	// withdrawn from KOS entirely and returns to approved
+	Unpublish(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.Recipe, error)
+
+	// Version history
+	ListVersions(ctx context.Context, id primitive.ObjectID) ([]*models.RecipeVersion, error)
+	GetVersion(ctx context.Context, id primitive.ObjectID, version int) (*models.RecipeVersion, error)
+	DiffVersions(ctx context.Context, id primitive.ObjectID, fromVersion, toVersion int) (*models.RecipeDiff, error)
+	// Rollback creates a new version whose content is copied from an older version
+	Rollback(ctx context.Context, id primitive.ObjectID, version int, userID string) (*models.Recipe, error)
 }
 
 type CreateRecipeRequest struct {
@@ -58,13 +97,31 @@ type RecipeListFilter struct {
 type recipeService struct {
 	recipeRepo     repositories.RecipeRepository
 	ingredientRepo repositories.IngredientRepository
+	siteRepo       repositories.SiteRepository
+	regionRepo     repositories.RegionRepository
+	tenantRepo     repositories.TenantRepository
+	templateRepo   repositories.StepTemplateRepository
+	auditRepo      repositories.AuditLogRepository
 }
 
 // NewRecipeService creates a new recipe service
-func NewRecipeService(recipeRepo repositories.RecipeRepository, ingredientRepo repositories.IngredientRepository) RecipeService {
+func NewRecipeService(
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	siteRepo repositories.SiteRepository,
+	regionRepo repositories.RegionRepository,
+	tenantRepo repositories.TenantRepository,
+	templateRepo repositories.StepTemplateRepository,
+	auditRepo repositories.AuditLogRepository,
+) RecipeService {
 	return &recipeService{
 		recipeRepo:     recipeRepo,
 		ingredientRepo: ingredientRepo,
+		siteRepo:       siteRepo,
+		regionRepo:     regionRepo,
+		tenantRepo:     tenantRepo,
+		templateRepo:   templateRepo,
+		auditRepo:      auditRepo,
 	}
 }
 
@@ -77,7 +134,6 @@ func (s *recipeService) Create(ctx context.Context, req CreateRecipeRequest) (*m
 		EstimatedPrepTimeSec:    req.PrepTimeSec,
 		EstimatedCookingTimeSec: req.CookTimeSec,
 		Servings:                req.Servings,
-		AllergenWarnings:        req.Allergens,
 		Ingredients:             req.Ingredients,
 		Steps:                   req.Steps,
 		Status:                  models.RecipeStatusDraft,
@@ -90,6 +146,9 @@ func (s *recipeService) Create(ctx context.Context, req CreateRecipeRequest) (*m
 	if err := s.ValidateRecipe(ctx, recipe); err != nil {
 		return nil, err
 	}
+	if err := s.RefreshDerived(ctx, recipe, req.Allergens); err != nil {
+		return nil, err
+	}
 
 	if err := s.recipeRepo.Create(ctx, recipe); err != nil {
 		return nil, fmt.Errorf("failed to create recipe: %w", err)
@@ -111,10 +170,8 @@ func (s *recipeService) Update(ctx context.Context, id primitive.ObjectID, req U
 		return nil, fmt.Errorf("recipe not found")
 	}
 
-	// Cannot update published recipes directly
-	if recipe.Status == models.RecipeStatusPublished {
-		return nil, fmt.Errorf("cannot update published recipe, unpublish first")
-	}
+	// Published recipes keep serving their live version until the edit is published
+	recipe.ResetReview()
 
 	if req.Name != "" {
 		recipe.Name = req.Name
@@ -134,9 +191,6 @@ func (s *recipeService) Update(ctx context.Context, id primitive.ObjectID, req U
 	if req.Servings > 0 {
 		recipe.Servings = req.Servings
 	}
-	if req.Allergens != nil {
-		recipe.AllergenWarnings = req.Allergens
-	}
 	if req.Ingredients != nil {
 		recipe.Ingredients = req.Ingredients
 	}
@@ -151,6 +205,9 @@ func (s *recipeService) Update(ctx context.Context, id primitive.ObjectID, req U
 	if err := s.ValidateRecipe(ctx, recipe); err != nil {
 		return nil, err
 	}
+	if err := s.RefreshDerived(ctx, recipe, req.Allergens); err != nil {
+		return nil, err
+	}
 
 	if err := s.recipeRepo.Update(ctx, recipe); err != nil {
 		return nil, err
@@ -169,7 +226,7 @@ func (s *recipeService) Delete(ctx context.Context, id primitive.ObjectID) error
 	}
 
 	// Cannot delete published recipes
-	if recipe.Status == models.RecipeStatusPublished {
+	if recipe.LiveVersion() > 0 {
 		return fmt.Errorf("cannot delete published recipe, unpublish first")
 	}
 
@@ -180,107 +237,123 @@ func (s *recipeService) List(ctx context.Context, tenantID primitive.ObjectID, f
 	return s.recipeRepo.ListByTenant(ctx, tenantID, filter.Status, filter.Page, filter.Limit)
 }
 
-func (s *recipeService) Publish(ctx context.Context, id primitive.ObjectID) error {
-	recipe, err := s.recipeRepo.GetByID(ctx, id)
-	if err != nil {
-		return err
-	}
-	if recipe == nil {
-		return fmt.Errorf("recipe not found")
-	}
+func (s *recipeService) GetPublishedForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error) {
+	return s.recipeRepo.GetPublishedForSite(ctx, siteID)
+}
 
-	// Validate before publishing
-	if err := s.ValidateRecipe(ctx, recipe); err != nil {
-		return fmt.Errorf("recipe validation failed: %w", err)
-	}
+func (s *recipeService) ValidateRecipe(ctx context.Context, recipe *models.Recipe) error {
+	return s.ValidateRecipeWith(ctx, recipe, nil)
+}
 
-	recipe.Status = models.RecipeStatusPublished
-	recipe.PublishedAt = timePtr(time.Now())
-	recipe.UpdatedAt = time.Now()
+func (s *recipeService) ValidateRecipeWith(ctx context.Context, recipe *models.Recipe, pending []*models.Ingredient) error {
+	if recipe.Name == "" {
+		return apperrors.Validation("recipe name is required")
+	}
 
-	return s.recipeRepo.Update(ctx, recipe)
-}
+	// Recipes built in the editor have no ingredient list; their add steps stand for it
+	if len(recipeAmounts(recipe)) == 0 {
+		return apperrors.Validation("recipe must have at least one ingredient")
+	}
 
-func (s *recipeService) Unpublish(ctx context.Context, id primitive.ObjectID) error {
-	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	analysis, err := s.analyze(ctx, recipe, pending, false)
 	if err != nil {
 		return err
 	}
-	if recipe == nil {
-		return fmt.Errorf("recipe not found")
-	}
-
-	recipe.Status = models.RecipeStatusDraft
-	recipe.UpdatedAt = time.Now()
+	return analysisError(analysis)
+}
 
-	return s.recipeRepo.Update(ctx, recipe)
+// analysisError turns the errors of an analysis into a validation error whose details
+// list every error with its field path, or returns nil if there are none
+func analysisError(analysis *models.RecipeAnalysis) error {
+	issue := analysis.FirstError()
+	if issue == nil {
+		return nil
+	}
+	return apperrors.Validation(issue.Message).WithDetails(analysis.Errors())
 }
 
-func (s *recipeService) GetPublishedForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error) {
-	return s.recipeRepo.GetPublishedForSite(ctx, siteID)
+func (s *recipeService) Analyze(ctx context.Context, recipe *models.Recipe) (*models.RecipeAnalysis, error) {
+	return s.analyze(ctx, recipe, nil, true)
 }
 
-func (s *recipeService) ValidateRecipe(ctx context.Context, recipe *models.Recipe) error {
-	if recipe.Name == "" {
-		return fmt.Errorf("recipe name is required")
+// analyze is Analyze with pending ingredients counted as part of the catalog, and the
+// lint rules applied only when lint is set
+func (s *recipeService) analyze(ctx context.Context, recipe *models.Recipe, pending []*models.Ingredient, lint bool) (*models.RecipeAnalysis, error) {
+	var known map[primitive.ObjectID]bool
+	ingredients := make(map[primitive.ObjectID]*models.Ingredient)
+	if s.ingredientRepo != nil {
+		ids := recipeIngredientIDs(recipe)
+		known = make(map[primitive.ObjectID]bool, len(ids))
+		if len(ids) > 0 {
+			list, err := s.ingredientRepo.GetByIDs(ctx, ids)
+			if err != nil {
+				return nil, fmt.Errorf("failed to validate ingredients: %w", err)
+			}
+			for _, ing := range list {
+				known[ing.ID] = true
+				ingredients[ing.ID] = ing
+			}
+		}
+		for _, ing := range pending {
+			known[ing.ID] = true
+			ingredients[ing.ID] = ing
+		}
 	}
 
-	if len(recipe.Ingredients) == 0 {
-		return fmt.Errorf("recipe must have at least one ingredient")
+	analysis := analyzeRecipe(recipe, known)
+	checkRecipeUnits(recipe, analysis, ingredients)
+	analysis.Valid = analysis.FirstError() == nil
+	if !lint {
+		return analysis, nil
 	}
 
-	if len(recipe.Steps) == 0 {
-		return fmt.Errorf("recipe must have at least one step")
+	disabled, err := s.disabledLintRules(ctx, recipe.TenantID)
+	if err != nil {
+		return nil, err
 	}
+	lintRecipe(recipe, analysis, disabled)
+	return analysis, nil
+}
 
-	// Validate ingredient references exist
-	var ingredientIDs []primitive.ObjectID
-	for _, ing := range recipe.Ingredients {
-		ingredientIDs = append(ingredientIDs, ing.IngredientID)
+// disabledLintRules returns the lint rules the recipe's tenant has turned off
+func (s *recipeService) disabledLintRules(ctx context.Context, tenantID primitive.ObjectID) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+	if s.tenantRepo == nil || tenantID.IsZero() {
+		return disabled, nil
 	}
-
-	if s.ingredientRepo != nil {
-		ingredients, err := s.ingredientRepo.GetByIDs(ctx, ingredientIDs)
-		if err != nil {
-			return fmt.Errorf("failed to validate ingredients: %w", err)
-		}
-
-		existingIDs := make(map[primitive.ObjectID]bool)
-		for _, ing := range ingredients {
-			existingIDs[ing.ID] = true
-		}
-
-		for _, id := range ingredientIDs {
-			if !existingIDs[id] {
-				return fmt.Errorf("ingredient not found: %s", id.Hex())
-			}
+	tenant, err := s.tenantRepo.GetByID(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if tenant != nil && tenant.Settings != nil {
+		for _, rule := range tenant.Settings.DisabledLintRules {
+			disabled[rule] = true
 		}
 	}
+	return disabled, nil
+}
 
-	// Validate step dependencies
-	stepNumbers := make(map[int]bool)
-	for _, step := range recipe.Steps {
-		if step.StepNumber <= 0 {
-			return fmt.Errorf("step number must be positive")
-		}
-		if stepNumbers[step.StepNumber] {
-			return fmt.Errorf("duplicate step number: %d", step.StepNumber)
+// recipeIngredientIDs returns the ingredients a recipe lists or its steps add
+func recipeIngredientIDs(recipe *models.Recipe) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool)
+	var ids []primitive.ObjectID
+	add := func(id primitive.ObjectID) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
 		}
-		stepNumbers[step.StepNumber] = true
 	}
-
+	for _, ing := range recipe.Ingredients {
+		add(ing.IngredientID)
+	}
 	for _, step := range recipe.Steps {
-		for _, dep := range step.DependsOnSteps {
-			if !stepNumbers[dep] {
-				return fmt.Errorf("step %d depends on non-existent step %d", step.StepNumber, dep)
-			}
-			if dep >= step.StepNumber {
-				return fmt.Errorf("step %d cannot depend on step %d (circular or forward dependency)", step.StepNumber, dep)
+		if value, ok := step.Parameters["ingredient_id"].(string); ok {
+			if id, err := primitive.ObjectIDFromHex(value); err == nil {
+				add(id)
 			}
 		}
 	}
-
-	return nil
+	return ids
 }
 
 func timePtr(t time.Time) *time.Time {
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecipeSyncAckTimeout is how long a delivered version may go unacknowledged before
+// the matrix reports the site as stale rather than pending
+const RecipeSyncAckTimeout = 15 * time.Minute
+
+// maxRecipesPerMatrix bounds the recipes loaded for the fleet sync matrix
+const maxRecipesPerMatrix = 500
+
+// RecipeSyncService tracks which recipe versions each KOS has received and applied
+type RecipeSyncService interface {
+	// RecordDeliveries records the recipes a KOS just pulled
+	RecordDeliveries(ctx context.Context, instance *models.KOSInstance, recipes []*models.Recipe) error
+	// Acknowledge records the versions a KOS reports it applied or failed to apply
+	Acknowledge(ctx context.Context, instance *models.KOSInstance, acks []RecipeSyncAck) ([]*models.RecipeSyncRecord, error)
+	ListForKOS(ctx context.Context, kosID primitive.ObjectID) ([]*models.RecipeSyncRecord, error)
+	// ChangesSince narrows the recipes served to a KOS to those changed after since,
+	// and returns tombstones for recipes it received before that are no longer served
+	ChangesSince(ctx context.Context, instance *models.KOSInstance, served []*models.Recipe, since time.Time) ([]*models.Recipe, []models.KOSTombstone, error)
+	// Matrix builds the recipes × sites sync state of a tenant's fleet
+	Matrix(ctx context.Context, tenantID primitive.ObjectID) (*models.RecipeSyncMatrix, error)
+}
+
+// RecipeSyncAck is one entry of a KOS acknowledgement
+type RecipeSyncAck struct {
+	RecipeID     primitive.ObjectID
+	Version      int
+	Failed       bool
+	ErrorMessage string
+}
+
+type recipeSyncService struct {
+	recipeRepo  repositories.RecipeRepository
+	rolloutRepo repositories.RecipeRolloutRepository
+	siteRepo    repositories.SiteRepository
+	kosRepo     repositories.KOSInstanceRepository
+}
+
+// NewRecipeSyncService creates a new recipe sync service
+func NewRecipeSyncService(
+	recipeRepo repositories.RecipeRepository,
+	rolloutRepo repositories.RecipeRolloutRepository,
+	siteRepo repositories.SiteRepository,
+	kosRepo repositories.KOSInstanceRepository,
+) RecipeSyncService {
+	return &recipeSyncService{
+		recipeRepo:  recipeRepo,
+		rolloutRepo: rolloutRepo,
+		siteRepo:    siteRepo,
+		kosRepo:     kosRepo,
+	}
+}
+
+func (s *recipeSyncService) RecordDeliveries(ctx context.Context, instance *models.KOSInstance, recipes []*models.Recipe) error {
+	now := time.Now()
+	records := make([]*models.RecipeSyncRecord, len(recipes))
+	for i, recipe := range recipes {
+		records[i] = &models.RecipeSyncRecord{
+			TenantID:         instance.TenantID,
+			RecipeID:         recipe.ID,
+			KOSID:            instance.ID,
+			SiteID:           instance.SiteID,
+			DeliveredVersion: recipe.Version,
+			DeliveredAt:      &now,
+		}
+	}
+	return s.recipeRepo.RecordSyncDeliveries(ctx, records)
+}
+
+func (s *recipeSyncService) Acknowledge(ctx context.Context, instance *models.KOSInstance, acks []RecipeSyncAck) ([]*models.RecipeSyncRecord, error) {
+	// Validate everything first so a bad entry does not leave a partial acknowledgement
+	for _, ack := range acks {
+		if ack.Version < 1 {
+			return nil, apperrors.Validation(fmt.Sprintf("invalid version for recipe %s", ack.RecipeID.Hex()))
+		}
+		recipe, err := s.recipeRepo.GetByID(ctx, ack.RecipeID)
+		if err != nil {
+			return nil, err
+		}
+		if recipe == nil || recipe.TenantID != instance.TenantID {
+			return nil, apperrors.NotFound(fmt.Sprintf("recipe %s", ack.RecipeID.Hex()))
+		}
+	}
+
+	now := time.Now()
+	records := make([]*models.RecipeSyncRecord, 0, len(acks))
+	for _, ack := range acks {
+		record := &models.RecipeSyncRecord{
+			TenantID:   instance.TenantID,
+			RecipeID:   ack.RecipeID,
+			KOSID:      instance.ID,
+			SiteID:     instance.SiteID,
+			SyncedAt:   now,
+			SyncStatus: models.RecipeSyncStatusSynced,
+			Version:    ack.Version,
+		}
+		if ack.Failed {
+			record.SyncStatus = models.RecipeSyncStatusFailed
+			record.Version = 0
+			record.FailedVersion = ack.Version
+			record.ErrorMessage = ack.ErrorMessage
+		}
+		if err := s.recipeRepo.RecordSyncAck(ctx, record); err != nil {
+			return nil, fmt.Errorf("failed to record recipe sync: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *recipeSyncService) ListForKOS(ctx context.Context, kosID primitive.ObjectID) ([]*models.RecipeSyncRecord, error) {
+	return s.recipeRepo.ListSyncRecordsForKOS(ctx, kosID)
+}
+
+func (s *recipeSyncService) ChangesSince(ctx context.Context, instance *models.KOSInstance, served []*models.Recipe, since time.Time) ([]*models.Recipe, []models.KOSTombstone, error) {
+	updated, err := s.recipeRepo.ListUpdatedSince(ctx, instance.TenantID, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Starting, rolling back or promoting a canary changes what the site is served
+	// without touching the recipe itself
+	rollouts, err := s.rolloutRepo.ListUpdatedForSite(ctx, instance.SiteID, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := s.recipeRepo.ListSyncRecordsForKOS(ctx, instance.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updatedByID := make(map[primitive.ObjectID]*models.Recipe, len(updated))
+	for _, recipe := range updated {
+		updatedByID[recipe.ID] = recipe
+	}
+	rolledOut := make(map[primitive.ObjectID]bool, len(rollouts))
+	for _, rollout := range rollouts {
+		rolledOut[rollout.RecipeID] = true
+	}
+
+	changed := make([]*models.Recipe, 0)
+	servedIDs := make(map[primitive.ObjectID]bool, len(served))
+	for _, recipe := range served {
+		servedIDs[recipe.ID] = true
+		if updatedByID[recipe.ID] != nil || rolledOut[recipe.ID] {
+			changed = append(changed, recipe)
+		}
+	}
+
+	// Only recipes this KOS was actually sent need a tombstone
+	deleted := make([]models.KOSTombstone, 0)
+	for _, record := range records {
+		recipe := updatedByID[record.RecipeID]
+		if servedIDs[record.RecipeID] || recipe == nil {
+			continue
+		}
+		reason := models.KOSTombstoneUnpublished
+		if recipe.Status == models.RecipeStatusArchived {
+			reason = models.KOSTombstoneArchived
+		}
+		deleted = append(deleted, models.KOSTombstone{ID: record.RecipeID.Hex(), Reason: reason})
+	}
+
+	return changed, deleted, nil
+}
+
+func (s *recipeSyncService) Matrix(ctx context.Context, tenantID primitive.ObjectID) (*models.RecipeSyncMatrix, error) {
+	recipes, _, err := s.recipeRepo.ListByTenant(ctx, tenantID, "", 1, maxRecipesPerMatrix)
+	if err != nil {
+		return nil, err
+	}
+	sites, _, err := s.siteRepo.ListByTenant(ctx, tenantID, 1, maxSitesPerQuery)
+	if err != nil {
+		return nil, err
+	}
+	instances, _, err := s.kosRepo.ListByTenant(ctx, tenantID, 1, maxSitesPerQuery)
+	if err != nil {
+		return nil, err
+	}
+	rollouts, err := s.rolloutRepo.ListActiveByTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.recipeRepo.ListSyncRecordsByTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	kosBySite := make(map[primitive.ObjectID]*models.KOSInstance, len(instances))
+	for _, instance := range instances {
+		if _, ok := kosBySite[instance.SiteID]; !ok {
+			kosBySite[instance.SiteID] = instance
+		}
+	}
+	rolloutByRecipe := make(map[primitive.ObjectID]*models.RecipeRollout, len(rollouts))
+	for _, rollout := range rollouts {
+		rolloutByRecipe[rollout.RecipeID] = rollout
+	}
+	type syncKey struct{ recipeID, kosID primitive.ObjectID }
+	recordByKey := make(map[syncKey]*models.RecipeSyncRecord, len(records))
+	for _, rec := range records {
+		recordByKey[syncKey{rec.RecipeID, rec.KOSID}] = rec
+	}
+
+	matrix := &models.RecipeSyncMatrix{
+		Sites:       make([]models.RecipeSyncMatrixSite, len(sites)),
+		Recipes:     []models.RecipeSyncMatrixRow{},
+		Summary:     make(map[models.RecipeSyncState]int),
+		GeneratedAt: time.Now(),
+	}
+	for i, site := range sites {
+		column := models.RecipeSyncMatrixSite{SiteID: site.ID, SiteName: site.Name}
+		if instance := kosBySite[site.ID]; instance != nil {
+			column.KOSID = &instance.ID
+			column.KOSName = instance.Name
+			column.KOSStatus = instance.Status
+		}
+		matrix.Sites[i] = column
+	}
+
+	for _, recipe := range recipes {
+		live := recipe.LiveVersion()
+		if live == 0 {
+			continue
+		}
+
+		row := models.RecipeSyncMatrixRow{
+			RecipeID:    recipe.ID,
+			RecipeName:  recipe.Name,
+			LiveVersion: live,
+			Cells:       make([]models.RecipeSyncCell, len(sites)),
+		}
+		for i, site := range sites {
+			if !recipe.IsPublishedToSite(site.ID) {
+				row.Cells[i] = models.RecipeSyncCell{State: models.RecipeSyncStateNotPublished}
+				continue
+			}
+
+			var record *models.RecipeSyncRecord
+			if instance := kosBySite[site.ID]; instance != nil {
+				record = recordByKey[syncKey{recipe.ID, instance.ID}]
+			}
+			cell := syncCell(servedVersion(recipe, rolloutByRecipe[recipe.ID], site.ID), record, matrix.GeneratedAt)
+			row.Cells[i] = cell
+			matrix.Summary[cell.State]++
+		}
+		matrix.Recipes = append(matrix.Recipes, row)
+	}
+
+	return matrix, nil
+}
+
+// syncCell compares what a site's KOS reported with the version the site should run
+func syncCell(expected int, record *models.RecipeSyncRecord, now time.Time) models.RecipeSyncCell {
+	cell := models.RecipeSyncCell{ExpectedVersion: expected}
+	if record == nil {
+		cell.State = models.RecipeSyncStatePending
+		return cell
+	}
+
+	cell.AppliedVersion = record.Version
+	cell.DeliveredVersion = record.DeliveredVersion
+	cell.DeliveredAt = record.DeliveredAt
+	cell.ErrorMessage = record.ErrorMessage
+	if !record.SyncedAt.IsZero() {
+		syncedAt := record.SyncedAt
+		cell.SyncedAt = &syncedAt
+	}
+
+	switch {
+	case record.Version == expected:
+		cell.State = models.RecipeSyncStateSynced
+	case record.SyncStatus == models.RecipeSyncStatusFailed && record.FailedVersion == expected:
+		cell.State = models.RecipeSyncStateFailed
+	case record.DeliveredVersion == expected && record.DeliveredAt != nil && now.Sub(*record.DeliveredAt) < RecipeSyncAckTimeout:
+		cell.State = models.RecipeSyncStatePending
+	case record.Version == 0 && record.DeliveredVersion == 0:
+		cell.State = models.RecipeSyncStatePending
+	default:
+		cell.State = models.RecipeSyncStateStale
+	}
+	return cell
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxSitesPerQuery bounds site listings when expanding regions or "all sites"
+const maxSitesPerQuery = 1000
+
+// PublishTargets selects the sites a publish or unpublish applies to.
+// Regions are expanded to their sites when the request is processed, so sites
+// added to a region later are not picked up automatically.
+type PublishTargets struct {
+	All       bool                 // Every site of the tenant
+	SiteIDs   []primitive.ObjectID // Individual sites
+	RegionIDs []primitive.ObjectID // All sites in each region
+}
+
+// IsEmpty reports whether no target was given
+func (t PublishTargets) IsEmpty() bool {
+	return !t.All && len(t.SiteIDs) == 0 && len(t.RegionIDs) == 0
+}
+
+// resolveSites expands targets to a de-duplicated list of site IDs, checking
+// every site and region belongs to the recipe's tenant
+func resolveSites(ctx context.Context, siteRepo repositories.SiteRepository, regionRepo repositories.RegionRepository, tenantID primitive.ObjectID, targets PublishTargets) ([]primitive.ObjectID, error) {
+	seen := make(map[primitive.ObjectID]bool)
+	var siteIDs []primitive.ObjectID
+	add := func(id primitive.ObjectID) {
+		if !seen[id] {
+			seen[id] = true
+			siteIDs = append(siteIDs, id)
+		}
+	}
+
+	if targets.All {
+		sites, _, err := siteRepo.ListByTenant(ctx, tenantID, 1, maxSitesPerQuery)
+		if err != nil {
+			return nil, err
+		}
+		for _, site := range sites {
+			add(site.ID)
+		}
+		return siteIDs, nil
+	}
+
+	for _, siteID := range targets.SiteIDs {
+		site, err := siteRepo.GetByID(ctx, siteID)
+		if err != nil {
+			return nil, err
+		}
+		if site == nil || site.TenantID != tenantID {
+			return nil, apperrors.NotFound(fmt.Sprintf("site %s", siteID.Hex()))
+		}
+		add(site.ID)
+	}
+
+	for _, regionID := range targets.RegionIDs {
+		region, err := regionRepo.GetByID(ctx, regionID)
+		if err != nil {
+			return nil, err
+		}
+		if region == nil || region.TenantID != tenantID {
+			return nil, apperrors.NotFound(fmt.Sprintf("region %s", regionID.Hex()))
+		}
+		sites, _, err := siteRepo.ListByRegion(ctx, regionID, 1, maxSitesPerQuery)
+		if err != nil {
+			return nil, err
+		}
+		if len(sites) == 0 {
+			return nil, apperrors.Validation(fmt.Sprintf("region %s has no sites", region.Name))
+		}
+		for _, site := range sites {
+			add(site.ID)
+		}
+	}
+
+	return siteIDs, nil
+}
+
+// applyPublishTargets adds targets to a recipe being published
+func (s *recipeService) applyPublishTargets(ctx context.Context, recipe *models.Recipe, targets PublishTargets) error {
+	wasPublished := recipe.LiveVersion() > 0
+
+	// Empty targets: first publish goes to every site, a republish changes nothing
+	if targets.All || (targets.IsEmpty() && !wasPublished) {
+		recipe.PublishedToSites = nil
+		return nil
+	}
+
+	siteIDs, err := resolveSites(ctx, s.siteRepo, s.regionRepo, recipe.TenantID, targets)
+	if err != nil {
+		return err
+	}
+
+	// Already published everywhere; adding sites cannot widen that
+	if wasPublished && len(recipe.PublishedToSites) == 0 {
+		return nil
+	}
+
+	recipe.PublishedToSites = mergeSiteIDs(recipe.PublishedToSites, siteIDs)
+	return nil
+}
+
+// removePublishTargets withdraws a published recipe from some sites and reports
+// whether any site is left. A recipe published everywhere is first expanded to
+// the tenant's current sites so individual sites can be removed.
+func (s *recipeService) removePublishTargets(ctx context.Context, recipe *models.Recipe, targets PublishTargets) (bool, error) {
+	if targets.All || targets.IsEmpty() {
+		return false, nil
+	}
+
+	remove, err := resolveSites(ctx, s.siteRepo, s.regionRepo, recipe.TenantID, targets)
+	if err != nil {
+		return false, err
+	}
+
+	current := recipe.PublishedToSites
+	if len(current) == 0 {
+		current, err = resolveSites(ctx, s.siteRepo, s.regionRepo, recipe.TenantID, PublishTargets{All: true})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	removed := make(map[primitive.ObjectID]bool, len(remove))
+	for _, id := range remove {
+		removed[id] = true
+	}
+	remaining := make([]primitive.ObjectID, 0, len(current))
+	for _, id := range current {
+		if !removed[id] {
+			remaining = append(remaining, id)
+		}
+	}
+
+	// An empty list means "all sites", so never store one for a partial unpublish
+	if len(remaining) == 0 {
+		return false, nil
+	}
+	recipe.PublishedToSites = remaining
+	return true, nil
+}
+
+func mergeSiteIDs(existing, added []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(existing)+len(added))
+	merged := make([]primitive.ObjectID, 0, len(existing)+len(added))
+	for _, id := range append(append([]primitive.ObjectID{}, existing...), added...) {
+		if !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *recipeService) ListVersions(ctx context.Context, id primitive.ObjectID) ([]*models.RecipeVersion, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+
+	return s.recipeRepo.ListVersions(ctx, id)
+}
+
+func (s *recipeService) GetVersion(ctx context.Context, id primitive.ObjectID, version int) (*models.RecipeVersion, error) {
+	snapshot, err := s.recipeRepo.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, apperrors.NotFound(fmt.Sprintf("recipe version %d", version))
+	}
+	return snapshot, nil
+}
+
+func (s *recipeService) DiffVersions(ctx context.Context, id primitive.ObjectID, fromVersion, toVersion int) (*models.RecipeDiff, error) {
+	from, err := s.GetVersion(ctx, id, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetVersion(ctx, id, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := DiffRecipes(&from.Recipe, &to.Recipe)
+	diff.RecipeID = id.Hex()
+	diff.FromVersion = fromVersion
+	diff.ToVersion = toVersion
+	return diff, nil
+}
+
+func (s *recipeService) Rollback(ctx context.Context, id primitive.ObjectID, version int, userID string) (*models.Recipe, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+
+	if version >= recipe.Version {
+		return nil, apperrors.Validation(fmt.Sprintf("can only roll back to a version older than %d", recipe.Version))
+	}
+
+	snapshot, err := s.GetVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy authored content only; identity, status and publishing state stay with the live recipe
+	old := snapshot.Recipe
+	recipe.Name = old.Name
+	recipe.Description = old.Description
+	recipe.Category = old.Category
+	recipe.CuisineType = old.CuisineType
+	recipe.PrepTime = old.PrepTime
+	recipe.CookTime = old.CookTime
+	recipe.Servings = old.Servings
+	recipe.EstimatedPrepTimeSec = old.EstimatedPrepTimeSec
+	recipe.EstimatedCookingTimeSec = old.EstimatedCookingTimeSec
+	recipe.AllergenWarnings = old.AllergenWarnings
+	recipe.Allergens = old.Allergens
+	recipe.Ingredients = old.Ingredients
+	recipe.Steps = old.Steps
+	recipe.SourceSteps = old.SourceSteps
+	recipe.StepTemplates = old.StepTemplates
+	recipe.Parameters = old.Parameters
+
+	// Keep the old manual allergens, but derive the rest from today's ingredient data
+	if err := s.RefreshDerived(ctx, recipe, nil); err != nil {
+		return nil, err
+	}
+
+	// The restored content is a new version on top of the history, not a rewind of it
+	recipe.ResetReview()
+	recipe.Version++
+	recipe.UpdatedBy = userID
+	recipe.UpdatedAt = time.Now()
+
+	if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+		return nil, err
+	}
+
+	return recipe, nil
+}
+
+// DiffRecipes computes a structured diff between two recipe definitions.
+// Ingredients are matched by ingredient ID, in order when one is listed more than once,
+// and steps by step number.
+func DiffRecipes(from, to *models.Recipe) *models.RecipeDiff {
+	diff := &models.RecipeDiff{
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+	}
+
+	fields := []struct {
+		name     string
+		from, to any
+	}{
+		{"name", from.Name, to.Name},
+		{"description", from.Description, to.Description},
+		{"category", from.Category, to.Category},
+		{"cuisine_type", from.CuisineType, to.CuisineType},
+		{"prep_time", from.PrepTime, to.PrepTime},
+		{"cook_time", from.CookTime, to.CookTime},
+		{"servings", from.Servings, to.Servings},
+		{"estimated_prep_time_sec", from.EstimatedPrepTimeSec, to.EstimatedPrepTimeSec},
+		{"estimated_cooking_time_sec", from.EstimatedCookingTimeSec, to.EstimatedCookingTimeSec},
+		{"allergen_warnings", from.AllergenWarnings, to.AllergenWarnings},
+	}
+	for _, f := range fields {
+		if !diffValuesEqual(f.from, f.to) {
+			diff.Fields = append(diff.Fields, models.FieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+
+	diff.Parameters = diffParameters("", from.Parameters, to.Parameters)
+	diff.Ingredients = diffIngredients(from.Ingredients, to.Ingredients)
+	diff.Steps = diffSteps(from.Steps, to.Steps)
+
+	return diff
+}
+
+// ingredientKey identifies a recipe ingredient line: the ingredient and which of its
+// lines it is, so a recipe listing an ingredient twice diffs each line on its own
+type ingredientKey struct {
+	id primitive.ObjectID
+	n  int
+}
+
+// ingredientKeys returns the key of each ingredient line, in order
+func ingredientKeys(ingredients []models.RecipeIngredient) []ingredientKey {
+	seen := make(map[primitive.ObjectID]int, len(ingredients))
+	keys := make([]ingredientKey, len(ingredients))
+	for i, ing := range ingredients {
+		keys[i] = ingredientKey{id: ing.IngredientID, n: seen[ing.IngredientID]}
+		seen[ing.IngredientID]++
+	}
+	return keys
+}
+
+func diffIngredients(from, to []models.RecipeIngredient) []models.IngredientChange {
+	fromKeys, toKeys := ingredientKeys(from), ingredientKeys(to)
+	fromByKey := make(map[ingredientKey]models.RecipeIngredient, len(from))
+	for i, ing := range from {
+		fromByKey[fromKeys[i]] = ing
+	}
+	toByKey := make(map[ingredientKey]models.RecipeIngredient, len(to))
+	for i, ing := range to {
+		toByKey[toKeys[i]] = ing
+	}
+
+	var changes []models.IngredientChange
+	for i, old := range from {
+		if _, ok := toByKey[fromKeys[i]]; !ok {
+			changes = append(changes, models.IngredientChange{
+				IngredientID: old.IngredientID.Hex(),
+				Name:         old.IngredientName,
+				Change:       models.DiffRemoved,
+			})
+		}
+	}
+
+	for i, cur := range to {
+		old, ok := fromByKey[toKeys[i]]
+		if !ok {
+			changes = append(changes, models.IngredientChange{
+				IngredientID: cur.IngredientID.Hex(),
+				Name:         cur.IngredientName,
+				Change:       models.DiffAdded,
+			})
+			continue
+		}
+
+		var fields []models.FieldChange
+		pairs := []struct {
+			name     string
+			from, to any
+		}{
+			{"quantity_required", old.QuantityRequired, cur.QuantityRequired},
+			{"unit", old.Unit, cur.Unit},
+			{"prep_notes", old.PrepNotes, cur.PrepNotes},
+			{"timing_step", old.TimingStep, cur.TimingStep},
+			{"is_critical", old.IsCritical, cur.IsCritical},
+			{"substitutes", hexIDs(old.Substitutes), hexIDs(cur.Substitutes)},
+			{"scaling", old.Scaling, cur.Scaling},
+			{"max_extra_factor", old.MaxExtraFactor, cur.MaxExtraFactor},
+		}
+		for _, p := range pairs {
+			if !diffValuesEqual(p.from, p.to) {
+				fields = append(fields, models.FieldChange{Field: p.name, From: p.from, To: p.to})
+			}
+		}
+		if len(fields) > 0 {
+			changes = append(changes, models.IngredientChange{
+				IngredientID: cur.IngredientID.Hex(),
+				Name:         cur.IngredientName,
+				Change:       models.DiffModified,
+				Fields:       fields,
+			})
+		}
+	}
+
+	return changes
+}
+
+func diffSteps(from, to []models.RecipeStep) []models.StepChange {
+	fromByNum := make(map[int]models.RecipeStep, len(from))
+	for _, st := range from {
+		fromByNum[st.StepNumber] = st
+	}
+	toByNum := make(map[int]models.RecipeStep, len(to))
+	for _, st := range to {
+		toByNum[st.StepNumber] = st
+	}
+
+	var changes []models.StepChange
+	for _, old := range from {
+		if _, ok := toByNum[old.StepNumber]; !ok {
+			changes = append(changes, models.StepChange{
+				StepNumber: old.StepNumber,
+				Action:     old.Action,
+				Change:     models.DiffRemoved,
+			})
+		}
+	}
+
+	for _, cur := range to {
+		old, ok := fromByNum[cur.StepNumber]
+		if !ok {
+			changes = append(changes, models.StepChange{
+				StepNumber: cur.StepNumber,
+				Action:     cur.Action,
+				Change:     models.DiffAdded,
+			})
+			continue
+		}
+
+		var fields []models.FieldChange
+		pairs := []struct {
+			name     string
+			from, to any
+		}{
+			{"action", old.Action, cur.Action},
+			{"depends_on_steps", old.DependsOnSteps, cur.DependsOnSteps},
+			{"name", old.Name, cur.Name},
+			{"description", old.Description, cur.Description},
+			{"scaling", old.Scaling, cur.Scaling},
+		}
+		for _, p := range pairs {
+			if !diffValuesEqual(p.from, p.to) {
+				fields = append(fields, models.FieldChange{Field: p.name, From: p.from, To: p.to})
+			}
+		}
+		fields = append(fields, diffParameters("parameters.", old.Parameters, cur.Parameters)...)
+
+		if len(fields) > 0 {
+			changes = append(changes, models.StepChange{
+				StepNumber: cur.StepNumber,
+				Action:     cur.Action,
+				Change:     models.DiffModified,
+				Fields:     fields,
+			})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].StepNumber < changes[j].StepNumber
+	})
+	return changes
+}
+
+// diffParameters compares two parameter maps key by key, in sorted key order
+func diffParameters(prefix string, from, to map[string]any) []models.FieldChange {
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []models.FieldChange
+	for _, k := range sorted {
+		oldVal, hadOld := from[k]
+		newVal, hasNew := to[k]
+		if hadOld && hasNew && diffValuesEqual(oldVal, newVal) {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: prefix + k, From: oldVal, To: newVal})
+	}
+	return changes
+}
+
+// diffValuesEqual compares values loaded from BSON, where the same number may come back
+// as int32, int64 or float64 depending on how it was written
+func diffValuesEqual(a, b any) bool {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return af == bf
+		}
+	}
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	if av.IsValid() && bv.IsValid() && av.Kind() == reflect.Slice && bv.Kind() == reflect.Slice {
+		// Treat nil and empty slices as equal
+		if av.Len() == 0 && bv.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// toFloat converts any numeric value to float64
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func hexIDs(ids []primitive.ObjectID) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id.Hex()
+	}
+	return out
+}
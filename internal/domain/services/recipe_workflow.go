@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit log entries written for recipe workflow transitions
+const (
+	AuditResourceRecipe = "recipe"
+
+	AuditActionRecipeSubmitted   = "recipe.submitted"
+	AuditActionRecipeApproved    = "recipe.approved"
+	AuditActionRecipeRejected    = "recipe.rejected"
+	AuditActionRecipePublished   = "recipe.published"
+	AuditActionRecipeUnpublished = "recipe.unpublished"
+)
+
+func (s *recipeService) SubmitForReview(ctx context.Context, id primitive.ObjectID, userID, comment string) (*models.Recipe, error) {
+	recipe, err := s.getForTransition(ctx, id, models.RecipeStatusDraft)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reviewers should not have to catch broken step graphs
+	analysis, err := s.Analyze(ctx, recipe)
+	if err != nil {
+		return nil, err
+	}
+	if err := analysisError(analysis); err != nil {
+		return nil, err
+	}
+
+	oldState := transitionState(recipe)
+	recipe.Status = models.RecipeStatusReview
+	recipe.ReviewComments = append(recipe.ReviewComments, newReviewComment(recipe, models.ReviewActionSubmitted, userID, comment))
+
+	return s.saveTransition(ctx, recipe, oldState, userID, AuditActionRecipeSubmitted, comment)
+}
+
+func (s *recipeService) Approve(ctx context.Context, id primitive.ObjectID, reviewerID, comment string) (*models.Recipe, error) {
+	recipe, err := s.getForTransition(ctx, id, models.RecipeStatusReview)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReviewer(recipe, reviewerID); err != nil {
+		return nil, err
+	}
+
+	oldState := transitionState(recipe)
+	now := time.Now()
+	recipe.Status = models.RecipeStatusApproved
+	recipe.ApprovedBy = reviewerID
+	recipe.ApprovedAt = &now
+	recipe.ReviewComments = append(recipe.ReviewComments, newReviewComment(recipe, models.ReviewActionApproved, reviewerID, comment))
+
+	return s.saveTransition(ctx, recipe, oldState, reviewerID, AuditActionRecipeApproved, comment)
+}
+
+func (s *recipeService) Reject(ctx context.Context, id primitive.ObjectID, reviewerID, comment string) (*models.Recipe, error) {
+	if comment == "" {
+		return nil, apperrors.Validation("a comment is required when rejecting a recipe")
+	}
+
+	recipe, err := s.getForTransition(ctx, id, models.RecipeStatusReview)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReviewer(recipe, reviewerID); err != nil {
+		return nil, err
+	}
+
+	oldState := transitionState(recipe)
+	recipe.Status = models.RecipeStatusDraft
+	recipe.ReviewComments = append(recipe.ReviewComments, newReviewComment(recipe, models.ReviewActionRejected, reviewerID, comment))
+
+	return s.saveTransition(ctx, recipe, oldState, reviewerID, AuditActionRecipeRejected, comment)
+}
+
+func (s *recipeService) Publish(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.Recipe, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+	if recipe.Status != models.RecipeStatusApproved && recipe.Status != models.RecipeStatusPublished {
+		return nil, apperrors.Conflict(fmt.Sprintf("recipe must be approved before publishing (status: %s)", recipe.Status))
+	}
+	// Templated steps follow the latest template versions when published, as when saved.
+	// Steps that come out differently were not what was approved, so they need review.
+	if recipe.UsesStepTemplates() && s.templateRepo != nil {
+		expanded := *recipe
+		if err := expandRecipeTemplates(ctx, s.templateRepo, &expanded); err != nil {
+			return nil, err
+		}
+		if !sameJSON(expanded.Steps, recipe.Steps) || !sameJSON(expanded.Ingredients, recipe.Ingredients) {
+			return nil, apperrors.Conflict("a step template the recipe uses has changed its steps; revalidate the recipe and have it reviewed again before publishing")
+		}
+		recipe.StepTemplates = expanded.StepTemplates
+		recipe.TemplatesOutdated = false
+	}
+
+	// Lint rules can change after approval, so recheck before the recipe reaches kitchens
+	analysis, err := s.Analyze(ctx, recipe)
+	if err != nil {
+		return nil, err
+	}
+	if err := analysisError(analysis); err != nil {
+		return nil, err
+	}
+
+	oldState := transitionState(recipe)
+	if err := s.applyPublishTargets(ctx, recipe, targets); err != nil {
+		return nil, err
+	}
+
+	// Publishing always makes the approved working copy the live version at every target
+	if recipe.Status != models.RecipeStatusPublished {
+		recipe.Status = models.RecipeStatusPublished
+		recipe.PublishedAt = timePtr(time.Now())
+	}
+	recipe.PublishedVersion = recipe.Version
+
+	return s.saveTransition(ctx, recipe, oldState, userID, AuditActionRecipePublished, "")
+}
+
+func (s *recipeService) Unpublish(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.Recipe, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+	if recipe.LiveVersion() == 0 {
+		return nil, apperrors.Conflict("recipe is not published")
+	}
+
+	oldState := transitionState(recipe)
+	stillPublished, err := s.removePublishTargets(ctx, recipe, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stillPublished {
+		// Content is unchanged, so the approval still stands and it can be republished directly
+		if recipe.Status == models.RecipeStatusPublished {
+			recipe.Status = models.RecipeStatusApproved
+		}
+		recipe.PublishedVersion = 0
+		recipe.PublishedToSites = nil
+		recipe.PublishedAt = nil
+	}
+
+	return s.saveTransition(ctx, recipe, oldState, userID, AuditActionRecipeUnpublished, "")
+}
+
+// getForTransition loads a recipe and checks it is in the state a transition starts from
+func (s *recipeService) getForTransition(ctx context.Context, id primitive.ObjectID, from models.RecipeStatus) (*models.Recipe, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+	if recipe.Status != from {
+		return nil, apperrors.Conflict(fmt.Sprintf("recipe is %s, expected %s", recipe.Status, from))
+	}
+	return recipe, nil
+}
+
+// saveTransition records a status change in the audit log and persists it. The audit
+// entry is written first, so no transition is ever applied without one.
+func (s *recipeService) saveTransition(ctx context.Context, recipe *models.Recipe, oldState map[string]any, userID, action, comment string) (*models.Recipe, error) {
+	newState := transitionState(recipe)
+	newState["version"] = recipe.Version
+	if comment != "" {
+		newState["comment"] = comment
+	}
+
+	if err := recordAudit(ctx, s.auditRepo, recipe.TenantID, userID, action, AuditResourceRecipe, recipe.ID.Hex(), oldState, newState); err != nil {
+		return nil, err
+	}
+
+	if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+		return nil, err
+	}
+
+	return recipe, nil
+}
+
+// transitionState captures the workflow fields recorded in audit entries
+func transitionState(recipe *models.Recipe) map[string]any {
+	state := map[string]any{"status": recipe.Status}
+	if live := recipe.LiveVersion(); live > 0 {
+		state["published_version"] = live
+	}
+	if len(recipe.PublishedToSites) > 0 {
+		state["published_to_sites"] = append([]primitive.ObjectID{}, recipe.PublishedToSites...)
+	}
+	return state
+}
+
+// checkReviewer enforces the four-eyes rule: whoever wrote or last edited a recipe cannot review it
+func checkReviewer(recipe *models.Recipe, reviewerID string) error {
+	if reviewerID == "" {
+		return apperrors.Unauthorized("reviewer identity is required")
+	}
+	if reviewerID == recipe.CreatedBy || reviewerID == recipe.UpdatedBy {
+		return apperrors.Forbidden("recipe authors cannot review their own recipe")
+	}
+	return nil
+}
+
+func newReviewComment(recipe *models.Recipe, action models.ReviewAction, userID, comment string) models.ReviewComment {
+	return models.ReviewComment{
+		Action:    action,
+		Version:   recipe.Version,
+		Comment:   comment,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReconciliationService compares what orders should have consumed with what KOS
+// measured its dispensers put in the pot
+type ReconciliationService interface {
+	// ReconcileOrder records the consumption of each dispensing task KOS measured on a
+	// completed order. Each order is reconciled once; later calls return the records.
+	ReconcileOrder(ctx context.Context, order *models.Order) ([]*models.ConsumptionRecord, error)
+	ForOrder(ctx context.Context, orderID primitive.ObjectID) ([]*models.ConsumptionRecord, error)
+	// VarianceReport aggregates a site's records since a time by ingredient and by
+	// device, and flags the dispensers that are consistently off
+	VarianceReport(ctx context.Context, siteID primitive.ObjectID, since time.Time) (*models.VarianceReport, error)
+}
+
+type reconciliationService struct {
+	consumptionRepo repositories.ConsumptionRepository
+	recipeRepo      repositories.RecipeRepository
+	ingredientRepo  repositories.IngredientRepository
+	kitchenRepo     repositories.KitchenRepository
+}
+
+// NewReconciliationService creates a new reconciliation service
+func NewReconciliationService(
+	consumptionRepo repositories.ConsumptionRepository,
+	recipeRepo repositories.RecipeRepository,
+	ingredientRepo repositories.IngredientRepository,
+	kitchenRepo repositories.KitchenRepository,
+) ReconciliationService {
+	return &reconciliationService{
+		consumptionRepo: consumptionRepo,
+		recipeRepo:      recipeRepo,
+		ingredientRepo:  ingredientRepo,
+		kitchenRepo:     kitchenRepo,
+	}
+}
+
+func (s *reconciliationService) ReconcileOrder(ctx context.Context, order *models.Order) ([]*models.ConsumptionRecord, error) {
+	existing, err := s.consumptionRepo.ListByOrder(ctx, order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumption records: %w", err)
+	}
+	if len(existing) > 0 || order.Status != models.OrderStatusCompleted {
+		return existing, nil
+	}
+	if !slices.ContainsFunc(order.Tasks, func(t models.OrderTask) bool { return t.Dispensed != nil }) {
+		return nil, nil
+	}
+
+	recipe, err := servedRecipe(ctx, s.recipeRepo, order.RecipeID, order.RecipeVersion)
+	if err != nil {
+		return nil, err
+	}
+	// Expected quantities are those KOS was sent: scaled to the pot, modifications applied
+	prepared := ApplyModifications(ScaleRecipe(recipe, order.PotPercentage), order.Modifications)
+	ingredients, err := LoadRecipeIngredients(ctx, s.ingredientRepo, prepared)
+	if err != nil {
+		return nil, err
+	}
+	kitchen, err := orderKitchenID(ctx, s.kitchenRepo, order)
+	if err != nil {
+		return nil, err
+	}
+
+	records := ReconcileTasks(order, prepared, ingredients)
+	for _, r := range records {
+		r.KitchenID = kitchen
+	}
+	if err := s.consumptionRepo.CreateMany(ctx, records); err != nil {
+		return nil, fmt.Errorf("failed to save consumption records: %w", err)
+	}
+	return records, nil
+}
+
+func (s *reconciliationService) ForOrder(ctx context.Context, orderID primitive.ObjectID) ([]*models.ConsumptionRecord, error) {
+	records, err := s.consumptionRepo.ListByOrder(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumption records: %w", err)
+	}
+	return records, nil
+}
+
+func (s *reconciliationService) VarianceReport(ctx context.Context, siteID primitive.ObjectID, since time.Time) (*models.VarianceReport, error) {
+	records, err := s.consumptionRepo.ListBySite(ctx, siteID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumption records: %w", err)
+	}
+
+	report := &models.VarianceReport{
+		SiteID:       siteID,
+		Since:        since,
+		ByIngredient: aggregateVariance(records, false),
+		ByDevice:     aggregateVariance(records, true),
+		Flagged:      []models.VarianceGroup{},
+		GeneratedAt:  time.Now(),
+	}
+	orders := make(map[primitive.ObjectID]bool)
+	var sumPct float64
+	for _, r := range records {
+		orders[r.OrderID] = true
+		sumPct += math.Abs(r.VariancePct)
+	}
+	report.Orders = len(orders)
+	report.Samples = len(records)
+	if len(records) > 0 {
+		report.VariancePct = round2(sumPct / float64(len(records)))
+	}
+	for _, g := range report.ByDevice {
+		if g.Trend != "" {
+			report.Flagged = append(report.Flagged, g)
+		}
+	}
+	return report, nil
+}
+
+// ReconcileTasks builds a consumption record for each task of an order that reports
+// what it dispensed. recipe is the recipe as KOS received it for the order. Actual
+// quantities are converted to the step's unit; a dispense whose unit cannot be
+// converted is left out.
+func ReconcileTasks(order *models.Order, recipe *models.Recipe, ingredients map[primitive.ObjectID]*models.Ingredient) []*models.ConsumptionRecord {
+	steps := make(map[int]*models.RecipeStep, len(recipe.Steps))
+	for i := range recipe.Steps {
+		steps[recipe.Steps[i].StepNumber] = &recipe.Steps[i]
+	}
+
+	now := time.Now()
+	var records []*models.ConsumptionRecord
+	for _, task := range order.Tasks {
+		d := task.Dispensed
+		if d == nil {
+			continue
+		}
+
+		var id primitive.ObjectID
+		var name, unit string
+		var expected float64
+		if step := steps[task.StepNumber]; step != nil && (step.Action == models.L4ActionAddLiquid || step.Action == models.L4ActionAddSolid) {
+			id, _ = primitive.ObjectIDFromHex(stepIngredientID(step))
+			name, _ = step.Parameters["ingredient_name"].(string)
+			unit = "grams"
+			if step.Action == models.L4ActionAddLiquid {
+				unit = "ml"
+			}
+			expected, _ = toFloat(step.Parameters["quantity"])
+		}
+		if id.IsZero() {
+			// A dispense outside the recipe's dispensing steps was not expected at all
+			id, _ = primitive.ObjectIDFromHex(d.IngredientID)
+			unit = d.Unit
+		}
+		if id.IsZero() {
+			continue
+		}
+
+		ing := ingredients[id]
+		if ing != nil {
+			name = ing.Name
+		}
+		grams, _, ok := ingredientGrams(ingredientAmount{Quantity: d.Quantity, Unit: d.Unit}, ing)
+		if !ok {
+			continue
+		}
+		actual, ok := gramsToUnit(grams, unit, ing)
+		if !ok {
+			continue
+		}
+
+		record := &models.ConsumptionRecord{
+			TenantID:       order.TenantID,
+			SiteID:         order.SiteID,
+			OrderID:        order.ID,
+			RecipeID:       order.RecipeID,
+			RecipeVersion:  order.RecipeVersion,
+			DeviceID:       dispenseDevice(task),
+			StepNumber:     task.StepNumber,
+			IngredientID:   id,
+			IngredientName: name,
+			Unit:           unit,
+			Expected:       round2(expected),
+			Actual:         round2(actual),
+			Variance:       round2(actual - expected),
+			RecordedAt:     now,
+		}
+		if expected > 0 {
+			record.VariancePct = round2((actual - expected) / expected * 100)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// dispenseDevice returns the dispenser a task used: the one KOS names in the
+// dispense, else the only device its L2 tasks selected
+func dispenseDevice(task models.OrderTask) string {
+	if task.Dispensed.DeviceID != "" {
+		return task.Dispensed.DeviceID
+	}
+	var device string
+	for _, l2 := range task.L2Tasks {
+		for _, d := range l2.SelectedDevices {
+			if device != "" && d != device {
+				return ""
+			}
+			device = d
+		}
+	}
+	return device
+}
+
+// aggregateVariance groups consumption records by ingredient, or by ingredient on each
+// device, ordered by ingredient name
+func aggregateVariance(records []*models.ConsumptionRecord, byDevice bool) []models.VarianceGroup {
+	var groups []*models.VarianceGroup
+	index := make(map[string]*models.VarianceGroup)
+	for _, r := range records {
+		key := r.IngredientID.Hex() + "/" + r.Unit
+		if byDevice {
+			key = r.KitchenID + "/" + r.DeviceID + "/" + key
+		}
+		g := index[key]
+		if g == nil {
+			g = &models.VarianceGroup{
+				IngredientID:   r.IngredientID.Hex(),
+				IngredientName: r.IngredientName,
+				Unit:           r.Unit,
+			}
+			if byDevice {
+				g.KitchenID, g.DeviceID = r.KitchenID, r.DeviceID
+			}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.Samples++
+		g.Expected += r.Expected
+		g.Actual += r.Actual
+		switch {
+		case r.Expected == 0 && r.Actual > 0, r.VariancePct > models.VarianceTolerancePct:
+			g.OverCount++
+		case r.VariancePct < -models.VarianceTolerancePct:
+			g.UnderCount++
+		}
+	}
+
+	result := make([]models.VarianceGroup, len(groups))
+	for i, g := range groups {
+		g.Variance = round2(g.Actual - g.Expected)
+		if g.Expected > 0 {
+			g.VariancePct = round2(g.Variance / g.Expected * 100)
+		}
+		g.Expected, g.Actual = round2(g.Expected), round2(g.Actual)
+		// Only dispensers are flagged; an ingredient spread over devices can hide one that is off
+		if byDevice && g.Samples >= models.MinVarianceSamples {
+			switch {
+			case float64(g.OverCount) >= models.VarianceConsistentShare*float64(g.Samples):
+				g.Trend = models.VarianceOver
+			case float64(g.UnderCount) >= models.VarianceConsistentShare*float64(g.Samples):
+				g.Trend = models.VarianceUnder
+			}
+		}
+		result[i] = *g
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.IngredientName != b.IngredientName {
+			return a.IngredientName < b.IngredientName
+		}
+		if a.KitchenID != b.KitchenID {
+			return a.KitchenID < b.KitchenID
+		}
+		return a.DeviceID < b.DeviceID
+	})
+	return result
+}
+
+// orderKitchenID returns the KOS kitchen_id that cooked an order, or "" if unknown
+func orderKitchenID(ctx context.Context, kitchenRepo repositories.KitchenRepository, order *models.Order) (string, error) {
+	if order.KitchenID != nil {
+		kitchen, err := kitchenRepo.GetByID(ctx, *order.KitchenID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get kitchen: %w", err)
+		}
+		if kitchen != nil {
+			return kitchen.KitchenID, nil
+		}
+	}
+	if order.Equipment != nil {
+		return order.Equipment.KitchenName, nil
+	}
+	return "", nil
+}
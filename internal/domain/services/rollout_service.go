@@ -0,0 +1,532 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit log entries written for canary rollouts (recorded against the recipe)
+const (
+	AuditActionRolloutStarted    = "recipe.rollout_started"
+	AuditActionRolloutExpanded   = "recipe.rollout_expanded"
+	AuditActionRolloutPromoted   = "recipe.rollout_promoted"
+	AuditActionRolloutRolledBack = "recipe.rollout_rolled_back"
+)
+
+// SystemUserID is recorded as the actor of automatic rollbacks
+const SystemUserID = "system"
+
+// Default thresholds applied when a rollout is started without them
+const (
+	DefaultRolloutMinOrders              = 5
+	DefaultRolloutMaxFailureRate         = 0.1
+	DefaultRolloutMaxDurationIncreasePct = 25
+)
+
+// RolloutService handles staged (canary) rollouts of new recipe versions.
+// While a rollout is active, canary sites are served the candidate version and
+// every other site keeps the live version.
+type RolloutService interface {
+	// Start sends an approved working copy of a published recipe to a set of canary sites
+	Start(ctx context.Context, recipeID primitive.ObjectID, req StartRolloutRequest, userID string) (*models.RecipeRollout, error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error)
+	ListForRecipe(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeRollout, error)
+	// Promote adds canary sites; once every site serving the recipe is covered (or All
+	// is given) the candidate becomes the live version and the rollout completes
+	Promote(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.RecipeRollout, error)
+	// Rollback returns canary sites to the live version and sends the candidate back to draft
+	Rollback(ctx context.Context, id primitive.ObjectID, reason, userID string) (*models.RecipeRollout, error)
+	// Evaluate refreshes the rollout metrics and rolls back automatically when a threshold is exceeded
+	Evaluate(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error)
+	// EvaluateForOrder re-evaluates the rollout an order belongs to once it has finished
+	EvaluateForOrder(ctx context.Context, order *models.Order) error
+	// RecipesForSite returns the recipes KOS at a site should run, at the version served to that site
+	RecipesForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error)
+	// ServedVersion returns the recipe version a site is served, or 0 if it is not published there
+	ServedVersion(ctx context.Context, recipe *models.Recipe, siteID primitive.ObjectID) (int, error)
+}
+
+type StartRolloutRequest struct {
+	Targets    PublishTargets // Canary sites; All is not allowed
+	Thresholds models.RolloutThresholds
+}
+
+type rolloutService struct {
+	recipeRepo  repositories.RecipeRepository
+	rolloutRepo repositories.RecipeRolloutRepository
+	orderRepo   repositories.OrderRepository
+	siteRepo    repositories.SiteRepository
+	regionRepo  repositories.RegionRepository
+	auditRepo   repositories.AuditLogRepository
+}
+
+// NewRolloutService creates a new rollout service
+func NewRolloutService(
+	recipeRepo repositories.RecipeRepository,
+	rolloutRepo repositories.RecipeRolloutRepository,
+	orderRepo repositories.OrderRepository,
+	siteRepo repositories.SiteRepository,
+	regionRepo repositories.RegionRepository,
+	auditRepo repositories.AuditLogRepository,
+) RolloutService {
+	return &rolloutService{
+		recipeRepo:  recipeRepo,
+		rolloutRepo: rolloutRepo,
+		orderRepo:   orderRepo,
+		siteRepo:    siteRepo,
+		regionRepo:  regionRepo,
+		auditRepo:   auditRepo,
+	}
+}
+
+func (s *rolloutService) Start(ctx context.Context, recipeID primitive.ObjectID, req StartRolloutRequest, userID string) (*models.RecipeRollout, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+	live := recipe.LiveVersion()
+	if live == 0 {
+		return nil, apperrors.Conflict("recipe is not published yet; publish it directly instead")
+	}
+	if recipe.Status != models.RecipeStatusApproved || recipe.Version <= live {
+		return nil, apperrors.Conflict(fmt.Sprintf("a rollout needs an approved version newer than the live version %d", live))
+	}
+
+	active, err := s.rolloutRepo.GetActiveForRecipe(ctx, recipe.ID)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return nil, apperrors.Conflict(fmt.Sprintf("version %d is already being rolled out", active.Version))
+	}
+
+	if req.Targets.All {
+		return nil, apperrors.Validation("a canary cannot include every site; publish the recipe instead")
+	}
+	if req.Targets.IsEmpty() {
+		return nil, apperrors.Validation("at least one canary site or region is required")
+	}
+	canarySites, err := s.resolveCanarySites(ctx, recipe, req.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := withDefaultThresholds(req.Thresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	rollout := &models.RecipeRollout{
+		TenantID:    recipe.TenantID,
+		RecipeID:    recipe.ID,
+		RecipeName:  recipe.Name,
+		Version:     recipe.Version,
+		BaseVersion: live,
+		CanarySites: canarySites,
+		Status:      models.RolloutStatusActive,
+		Thresholds:  thresholds,
+		CreatedBy:   userID,
+	}
+	if err := s.rolloutRepo.Create(ctx, rollout); err != nil {
+		return nil, fmt.Errorf("failed to create rollout: %w", err)
+	}
+
+	if err := s.audit(ctx, rollout, userID, AuditActionRolloutStarted, nil); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+func (s *rolloutService) GetByID(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error) {
+	return s.rolloutRepo.GetByID(ctx, id)
+}
+
+func (s *rolloutService) ListForRecipe(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeRollout, error) {
+	return s.rolloutRepo.ListByRecipe(ctx, recipeID)
+}
+
+func (s *rolloutService) Promote(ctx context.Context, id primitive.ObjectID, targets PublishTargets, userID string) (*models.RecipeRollout, error) {
+	rollout, recipe, err := s.getActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if targets.IsEmpty() {
+		return nil, apperrors.Validation("select sites or regions to promote to, or all sites")
+	}
+
+	oldState := rolloutState(rollout)
+	if !targets.All {
+		added, err := s.resolveCanarySites(ctx, recipe, targets)
+		if err != nil {
+			return nil, err
+		}
+		rollout.CanarySites = mergeSiteIDs(rollout.CanarySites, added)
+
+		covered, err := s.coversAllServedSites(ctx, recipe, rollout)
+		if err != nil {
+			return nil, err
+		}
+		if !covered {
+			if err := s.rolloutRepo.Update(ctx, rollout); err != nil {
+				return nil, err
+			}
+			if err := s.audit(ctx, rollout, userID, AuditActionRolloutExpanded, oldState); err != nil {
+				return nil, err
+			}
+			return rollout, nil
+		}
+	}
+
+	// Every site now gets the candidate, so it becomes the live version
+	recipe.PublishedVersion = rollout.Version
+	if recipe.Version == rollout.Version && recipe.Status == models.RecipeStatusApproved {
+		recipe.Status = models.RecipeStatusPublished
+	}
+	if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+		return nil, err
+	}
+
+	s.complete(rollout, models.RolloutStatusPromoted, "")
+	if err := s.rolloutRepo.Update(ctx, rollout); err != nil {
+		return nil, err
+	}
+	if err := s.audit(ctx, rollout, userID, AuditActionRolloutPromoted, oldState); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+func (s *rolloutService) Rollback(ctx context.Context, id primitive.ObjectID, reason, userID string) (*models.RecipeRollout, error) {
+	rollout, recipe, err := s.getActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.rollback(ctx, rollout, recipe, reason, userID)
+}
+
+func (s *rolloutService) rollback(ctx context.Context, rollout *models.RecipeRollout, recipe *models.Recipe, reason, userID string) (*models.RecipeRollout, error) {
+	if reason == "" {
+		reason = "rolled back manually"
+	}
+	oldState := rolloutState(rollout)
+
+	// The candidate failed in the field, so its approval no longer stands
+	if recipe.Version == rollout.Version && recipe.Status == models.RecipeStatusApproved {
+		recipe.Status = models.RecipeStatusDraft
+		recipe.ApprovedBy = ""
+		recipe.ApprovedAt = nil
+		recipe.ReviewComments = append(recipe.ReviewComments, newReviewComment(recipe, models.ReviewActionRejected, userID, "Canary rollout rolled back: "+reason))
+		if err := s.recipeRepo.Update(ctx, recipe); err != nil {
+			return nil, err
+		}
+	}
+
+	s.complete(rollout, models.RolloutStatusRolledBack, reason)
+	if err := s.rolloutRepo.Update(ctx, rollout); err != nil {
+		return nil, err
+	}
+	if err := s.audit(ctx, rollout, userID, AuditActionRolloutRolledBack, oldState); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+func (s *rolloutService) Evaluate(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error) {
+	rollout, recipe, err := s.getActive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.evaluate(ctx, rollout, recipe)
+}
+
+func (s *rolloutService) EvaluateForOrder(ctx context.Context, order *models.Order) error {
+	if order.RecipeVersion == 0 || (order.Status != models.OrderStatusCompleted && order.Status != models.OrderStatusFailed) {
+		return nil
+	}
+
+	rollout, err := s.rolloutRepo.GetActiveForRecipe(ctx, order.RecipeID)
+	if err != nil {
+		return err
+	}
+	if rollout == nil || rollout.Version != order.RecipeVersion {
+		return nil
+	}
+
+	_, err = s.Evaluate(ctx, rollout.ID)
+	return err
+}
+
+func (s *rolloutService) evaluate(ctx context.Context, rollout *models.RecipeRollout, recipe *models.Recipe) (*models.RecipeRollout, error) {
+	canaryOrders, err := s.orderRepo.ListFinishedForRecipeVersion(ctx, rollout.RecipeID, rollout.Version, rollout.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	baselineOrders, err := s.orderRepo.ListFinishedForRecipeVersion(ctx, rollout.RecipeID, rollout.BaseVersion, rollout.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := &models.RolloutMetrics{ErrorCodes: make(map[string]int), EvaluatedAt: time.Now()}
+	var canaryDuration, baselineDuration float64
+	var canaryTimed, baselineTimed int
+
+	for _, order := range canaryOrders {
+		metrics.Orders++
+		if orderFailed(order, metrics.ErrorCodes) {
+			metrics.FailedOrders++
+		} else if d, ok := orderDurationSec(order); ok {
+			canaryDuration += d
+			canaryTimed++
+		}
+	}
+	for _, order := range baselineOrders {
+		metrics.BaselineOrders++
+		if orderFailed(order, nil) {
+			continue
+		}
+		if d, ok := orderDurationSec(order); ok {
+			baselineDuration += d
+			baselineTimed++
+		}
+	}
+
+	if metrics.Orders > 0 {
+		metrics.FailureRate = float64(metrics.FailedOrders) / float64(metrics.Orders)
+	}
+	if canaryTimed > 0 {
+		metrics.AvgDurationSec = canaryDuration / float64(canaryTimed)
+	}
+	if baselineTimed > 0 {
+		metrics.BaselineAvgDurationSec = baselineDuration / float64(baselineTimed)
+	}
+	metrics.ThresholdExceeded = exceededThreshold(rollout.Thresholds, metrics)
+	rollout.Metrics = metrics
+
+	if metrics.ThresholdExceeded != "" {
+		return s.rollback(ctx, rollout, recipe, metrics.ThresholdExceeded, SystemUserID)
+	}
+	if err := s.rolloutRepo.Update(ctx, rollout); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+func (s *rolloutService) RecipesForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Recipe, error) {
+	recipes, err := s.recipeRepo.GetPublishedForSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts, err := s.rolloutRepo.ListActiveForSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	byRecipe := make(map[primitive.ObjectID]*models.RecipeRollout, len(rollouts))
+	for _, rollout := range rollouts {
+		byRecipe[rollout.RecipeID] = rollout
+	}
+
+	served := make([]*models.Recipe, 0, len(recipes))
+	for _, recipe := range recipes {
+		version := servedVersion(recipe, byRecipe[recipe.ID], siteID)
+		if version == recipe.Version {
+			served = append(served, recipe)
+			continue
+		}
+
+		snapshot, err := s.recipeRepo.GetVersion(ctx, recipe.ID, version)
+		if err != nil {
+			return nil, err
+		}
+		// Never fall back to the working copy: it has not been approved for this site
+		if snapshot == nil {
+			continue
+		}
+		snapshot.Recipe.Version = snapshot.Version
+		served = append(served, &snapshot.Recipe)
+	}
+
+	return served, nil
+}
+
+func (s *rolloutService) ServedVersion(ctx context.Context, recipe *models.Recipe, siteID primitive.ObjectID) (int, error) {
+	if !recipe.IsPublishedToSite(siteID) {
+		return 0, nil
+	}
+	rollout, err := s.rolloutRepo.GetActiveForRecipe(ctx, recipe.ID)
+	if err != nil {
+		return 0, err
+	}
+	return servedVersion(recipe, rollout, siteID), nil
+}
+
+// servedVersion picks the candidate for canary sites of an active rollout and the
+// live version everywhere else. A rollout whose candidate was since published
+// directly has been superseded and no longer matters.
+func servedVersion(recipe *models.Recipe, rollout *models.RecipeRollout, siteID primitive.ObjectID) int {
+	live := recipe.LiveVersion()
+	if rollout != nil && rollout.Status == models.RolloutStatusActive && rollout.Version > live && rollout.IsCanarySite(siteID) {
+		return rollout.Version
+	}
+	return live
+}
+
+// getActive loads an active rollout and its recipe. A rollout overtaken by a direct
+// publish of the same or a newer version is closed as promoted.
+func (s *rolloutService) getActive(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, *models.Recipe, error) {
+	rollout, err := s.rolloutRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rollout == nil {
+		return nil, nil, apperrors.NotFound("rollout")
+	}
+	if rollout.Status != models.RolloutStatusActive {
+		return nil, nil, apperrors.Conflict(fmt.Sprintf("rollout is already %s", rollout.Status))
+	}
+
+	recipe, err := s.recipeRepo.GetByID(ctx, rollout.RecipeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if recipe == nil {
+		return nil, nil, apperrors.NotFound("recipe")
+	}
+
+	if recipe.LiveVersion() >= rollout.Version {
+		s.complete(rollout, models.RolloutStatusPromoted, "")
+		if err := s.rolloutRepo.Update(ctx, rollout); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, apperrors.Conflict(fmt.Sprintf("version %d was published directly; the rollout is closed", rollout.Version))
+	}
+
+	return rollout, recipe, nil
+}
+
+// resolveCanarySites expands targets and checks the recipe is published at each site
+func (s *rolloutService) resolveCanarySites(ctx context.Context, recipe *models.Recipe, targets PublishTargets) ([]primitive.ObjectID, error) {
+	siteIDs, err := resolveSites(ctx, s.siteRepo, s.regionRepo, recipe.TenantID, targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, siteID := range siteIDs {
+		if !recipe.IsPublishedToSite(siteID) {
+			return nil, apperrors.Validation(fmt.Sprintf("recipe is not published to site %s", siteID.Hex()))
+		}
+	}
+	return siteIDs, nil
+}
+
+// coversAllServedSites reports whether every site serving the recipe is a canary
+func (s *rolloutService) coversAllServedSites(ctx context.Context, recipe *models.Recipe, rollout *models.RecipeRollout) (bool, error) {
+	servedSites := recipe.PublishedToSites
+	if len(servedSites) == 0 {
+		var err error
+		servedSites, err = resolveSites(ctx, s.siteRepo, s.regionRepo, recipe.TenantID, PublishTargets{All: true})
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, siteID := range servedSites {
+		if !rollout.IsCanarySite(siteID) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *rolloutService) complete(rollout *models.RecipeRollout, status models.RolloutStatus, reason string) {
+	rollout.Status = status
+	rollout.Reason = reason
+	rollout.CompletedAt = timePtr(time.Now())
+}
+
+func (s *rolloutService) audit(ctx context.Context, rollout *models.RecipeRollout, userID, action string, oldState map[string]any) error {
+	return recordAudit(ctx, s.auditRepo, rollout.TenantID, userID, action, AuditResourceRecipe, rollout.RecipeID.Hex(), oldState, rolloutState(rollout))
+}
+
+// rolloutState captures the rollout fields recorded in audit entries
+func rolloutState(rollout *models.RecipeRollout) map[string]any {
+	state := map[string]any{
+		"rollout_id":   rollout.ID.Hex(),
+		"status":       rollout.Status,
+		"version":      rollout.Version,
+		"base_version": rollout.BaseVersion,
+		"canary_sites": append([]primitive.ObjectID{}, rollout.CanarySites...),
+	}
+	if rollout.Reason != "" {
+		state["reason"] = rollout.Reason
+	}
+	return state
+}
+
+func withDefaultThresholds(t models.RolloutThresholds) (models.RolloutThresholds, error) {
+	if t.MinOrders < 0 || t.MaxFailureRate < 0 || t.MaxFailureRate > 1 || t.MaxDurationIncreasePct < 0 {
+		return t, apperrors.Validation("rollout thresholds must be positive and max_failure_rate at most 1")
+	}
+	if t.MinOrders == 0 {
+		t.MinOrders = DefaultRolloutMinOrders
+	}
+	if t.MaxFailureRate == 0 {
+		t.MaxFailureRate = DefaultRolloutMaxFailureRate
+	}
+	if t.MaxDurationIncreasePct == 0 {
+		t.MaxDurationIncreasePct = DefaultRolloutMaxDurationIncreasePct
+	}
+	return t, nil
+}
+
+// exceededThreshold describes the first threshold the canary breaks, or returns ""
+// while there are too few finished canary orders to judge
+func exceededThreshold(t models.RolloutThresholds, m *models.RolloutMetrics) string {
+	if m.Orders < t.MinOrders {
+		return ""
+	}
+	if m.FailureRate > t.MaxFailureRate {
+		return fmt.Sprintf("failure rate %.0f%% exceeds %.0f%% (%d of %d orders)",
+			m.FailureRate*100, t.MaxFailureRate*100, m.FailedOrders, m.Orders)
+	}
+	if m.BaselineAvgDurationSec > 0 && m.AvgDurationSec > 0 {
+		increase := (m.AvgDurationSec - m.BaselineAvgDurationSec) / m.BaselineAvgDurationSec * 100
+		if increase > t.MaxDurationIncreasePct {
+			return fmt.Sprintf("average duration %.0fs is %.0f%% slower than %.0fs (limit %.0f%%)",
+				m.AvgDurationSec, increase, m.BaselineAvgDurationSec, t.MaxDurationIncreasePct)
+		}
+	}
+	return ""
+}
+
+// orderFailed reports whether an order failed or any of its tasks reported an
+// error code, counting the codes into errorCodes when given
+func orderFailed(order *models.Order, errorCodes map[string]int) bool {
+	failed := order.Status == models.OrderStatusFailed
+	for _, task := range order.Tasks {
+		if task.ErrorCode == "" {
+			continue
+		}
+		failed = true
+		if errorCodes != nil {
+			errorCodes[task.ErrorCode]++
+		}
+	}
+	return failed
+}
+
+// orderDurationSec returns how long KOS took to prepare a completed order
+func orderDurationSec(order *models.Order) (float64, bool) {
+	if order.Status != models.OrderStatusCompleted || order.StartedAt == nil || order.CompletedAt == nil {
+		return 0, false
+	}
+	d := order.CompletedAt.Sub(*order.StartedAt).Seconds()
+	return d, d > 0
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// templatePlaceholder matches a "{{name}}" placeholder in a step template's parameters
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// templateParamName is the form of a step template parameter name
+var templateParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// stepSpan is the run of expanded steps one source step became
+type stepSpan struct {
+	first, last int
+	exits       []int // Expanded steps that steps depending on the source step wait for
+}
+
+// expandRecipeTemplates expands a recipe's source steps with the latest versions of
+// the tenant's step templates they use
+func expandRecipeTemplates(ctx context.Context, templateRepo repositories.StepTemplateRepository, recipe *models.Recipe) error {
+	if !recipe.UsesStepTemplates() {
+		recipe.StepTemplates = nil
+		recipe.TemplatesOutdated = false
+		// The steps are as authored again, and so are the timing steps
+		for i := range recipe.Ingredients {
+			if source := recipe.Ingredients[i].SourceTimingStep; source > 0 {
+				recipe.Ingredients[i].TimingStep = source
+				recipe.Ingredients[i].SourceTimingStep = 0
+			}
+		}
+		return nil
+	}
+
+	var ids []primitive.ObjectID
+	for _, step := range recipe.SourceSteps {
+		if step.Action == models.StepTemplateAction && step.Template != nil {
+			ids = append(ids, step.Template.TemplateID)
+		}
+	}
+	templates, err := templateRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to get step templates: %w", err)
+	}
+
+	// Another tenant's templates are as good as missing
+	byID := make(map[primitive.ObjectID]*models.StepTemplate, len(templates))
+	for _, template := range templates {
+		if template.TenantID == recipe.TenantID {
+			byID[template.ID] = template
+		}
+	}
+	return expandRecipe(recipe, byID)
+}
+
+// expandRecipe expands the recipe's source steps into its steps with the given
+// templates. Ingredients added by a step get the first expanded step adding them as
+// their timing step, since authors cannot know the expanded numbering; the timing step
+// of any other ingredient is moved to where its source step starts.
+func expandRecipe(recipe *models.Recipe, templates map[primitive.ObjectID]*models.StepTemplate) error {
+	steps, uses, spans, err := expandSteps(recipe.SourceSteps, templates)
+	if err != nil {
+		return err
+	}
+
+	ingredients := slices.Clone(recipe.Ingredients)
+	for i := range ingredients {
+		ing := &ingredients[i]
+		added := slices.IndexFunc(steps, func(step models.RecipeStep) bool {
+			return stepIngredientID(&step) == ing.IngredientID.Hex()
+		})
+		if added >= 0 {
+			ing.TimingStep = steps[added].StepNumber
+			ing.SourceTimingStep = 0
+			continue
+		}
+
+		// Saved ingredients keep the source step they were authored against
+		source := ing.SourceTimingStep
+		if source == 0 {
+			source = ing.TimingStep
+		}
+		span, ok := spans[source]
+		if !ok {
+			return apperrors.Validation(fmt.Sprintf("ingredient %s is added at step %d, which does not exist", cmp.Or(ing.IngredientName, ing.IngredientID.Hex()), source))
+		}
+		ing.TimingStep = span.first
+		ing.SourceTimingStep = source
+	}
+
+	recipe.Steps = steps
+	recipe.StepTemplates = uses
+	recipe.TemplatesOutdated = false
+	recipe.Ingredients = ingredients
+	return nil
+}
+
+// expandSteps replaces the source steps standing for step templates with the templates'
+// steps, renumbering every step from 1. A template's first steps wait for whatever the
+// source step depended on, and steps depending on the source step wait for the
+// template's last steps. The spans map each source step to its expanded steps.
+func expandSteps(source []models.RecipeStep, templates map[primitive.ObjectID]*models.StepTemplate) ([]models.RecipeStep, []models.RecipeTemplateUse, map[int]stepSpan, error) {
+	ordered := slices.Clone(source)
+	slices.SortStableFunc(ordered, func(a, b models.RecipeStep) int { return cmp.Compare(a.StepNumber, b.StepNumber) })
+
+	// Number the expanded steps first, so dependencies can point forwards
+	spans := make(map[int]stepSpan, len(ordered))
+	locals := make(map[int]map[int]int)
+	next := 1
+	for _, step := range ordered {
+		if _, dup := spans[step.StepNumber]; dup {
+			return nil, nil, nil, apperrors.Validation(fmt.Sprintf("duplicate step number %d", step.StepNumber))
+		}
+		if step.Action != models.StepTemplateAction {
+			spans[step.StepNumber] = stepSpan{first: next, last: next, exits: []int{next}}
+			next++
+			continue
+		}
+
+		template, err := stepTemplate(step, templates)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		local := make(map[int]int, len(template.Steps))
+		depended := make(map[int]bool)
+		for i, ts := range sortedTemplateSteps(template) {
+			local[ts.StepNumber] = next + i
+			for _, d := range ts.DependsOnSteps {
+				depended[d] = true
+			}
+		}
+		span := stepSpan{first: next, last: next + len(template.Steps) - 1}
+		for _, ts := range sortedTemplateSteps(template) {
+			if !depended[ts.StepNumber] {
+				span.exits = append(span.exits, local[ts.StepNumber])
+			}
+		}
+		spans[step.StepNumber] = span
+		locals[step.StepNumber] = local
+		next += len(template.Steps)
+	}
+
+	outer := func(step models.RecipeStep) ([]int, error) {
+		var deps []int
+		for _, d := range step.DependsOnSteps {
+			span, ok := spans[d]
+			if !ok {
+				return nil, apperrors.Validation(fmt.Sprintf("step %d depends on step %d, which does not exist", step.StepNumber, d))
+			}
+			deps = append(deps, span.exits...)
+		}
+		slices.Sort(deps)
+		return slices.Compact(deps), nil
+	}
+
+	steps := make([]models.RecipeStep, 0, next-1)
+	var uses []models.RecipeTemplateUse
+	for _, step := range ordered {
+		deps, err := outer(step)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		span := spans[step.StepNumber]
+
+		if step.Action != models.StepTemplateAction {
+			step.StepNumber = span.first
+			step.DependsOnSteps = deps
+			steps = append(steps, step)
+			continue
+		}
+
+		template, _ := stepTemplate(step, templates)
+		args, err := templateArguments(step, template)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		local := locals[step.StepNumber]
+		for _, ts := range sortedTemplateSteps(template) {
+			expanded := ts
+			expanded.StepNumber = local[ts.StepNumber]
+			expanded.DependsOnSteps = deps
+			if len(ts.DependsOnSteps) > 0 {
+				expanded.DependsOnSteps = make([]int, len(ts.DependsOnSteps))
+				for i, d := range ts.DependsOnSteps {
+					expanded.DependsOnSteps[i] = local[d]
+				}
+			}
+			expanded.Parameters, _ = substitutePlaceholders(ts.Parameters, args).(map[string]any)
+			expanded.Scaling = maps.Clone(ts.Scaling)
+			expanded.Name = fmt.Sprint(substitutePlaceholders(ts.Name, args))
+			if expanded.Name == "" {
+				expanded.Name = template.Name
+			}
+			expanded.Description = fmt.Sprint(substitutePlaceholders(ts.Description, args))
+			expanded.Template = nil
+			steps = append(steps, expanded)
+		}
+		uses = append(uses, models.RecipeTemplateUse{
+			TemplateID: template.ID,
+			Name:       template.Name,
+			Version:    template.Version,
+			SourceStep: step.StepNumber,
+			FirstStep:  span.first,
+			LastStep:   span.last,
+		})
+	}
+
+	return steps, uses, spans, nil
+}
+
+// stepTemplate returns the template a source step stands for
+func stepTemplate(step models.RecipeStep, templates map[primitive.ObjectID]*models.StepTemplate) (*models.StepTemplate, error) {
+	if step.Template == nil {
+		return nil, apperrors.Validation(fmt.Sprintf("step %d uses a step template but does not say which", step.StepNumber))
+	}
+	template := templates[step.Template.TemplateID]
+	if template == nil {
+		return nil, apperrors.Validation(fmt.Sprintf("step %d uses step template %s, which does not exist", step.StepNumber, step.Template.TemplateID.Hex()))
+	}
+	return template, nil
+}
+
+func sortedTemplateSteps(template *models.StepTemplate) []models.RecipeStep {
+	steps := slices.Clone(template.Steps)
+	slices.SortStableFunc(steps, func(a, b models.RecipeStep) int { return cmp.Compare(a.StepNumber, b.StepNumber) })
+	return steps
+}
+
+// templateArguments resolves the value of every template parameter for a source step,
+// falling back to the parameters' defaults
+func templateArguments(step models.RecipeStep, template *models.StepTemplate) (map[string]any, error) {
+	for name := range step.Template.Arguments {
+		if !slices.ContainsFunc(template.Parameters, func(p models.StepTemplateParam) bool { return p.Name == name }) {
+			return nil, apperrors.Validation(fmt.Sprintf("step %d: step template %s has no parameter %q", step.StepNumber, template.Name, name))
+		}
+	}
+
+	args := make(map[string]any, len(template.Parameters))
+	for _, param := range template.Parameters {
+		value := step.Template.Arguments[param.Name]
+		if value == nil {
+			value = param.Default
+		}
+		if value == nil {
+			return nil, apperrors.Validation(fmt.Sprintf("step %d: step template %s needs an argument for %q", step.StepNumber, template.Name, param.Name))
+		}
+		args[param.Name] = value
+	}
+	return args, nil
+}
+
+// substitutePlaceholders fills the placeholders in a parameter value. A string that is
+// a single placeholder takes the argument as is, keeping numbers numbers; placeholders
+// inside longer strings are formatted into them.
+func substitutePlaceholders(value any, args map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if m := templatePlaceholder.FindStringSubmatch(v); m != nil && m[0] == v {
+			if arg, ok := args[m[1]]; ok {
+				return arg
+			}
+			return v
+		}
+		return templatePlaceholder.ReplaceAllStringFunc(v, func(placeholder string) string {
+			if arg, ok := args[templatePlaceholder.FindStringSubmatch(placeholder)[1]]; ok {
+				return fmt.Sprint(arg)
+			}
+			return placeholder
+		})
+	case map[string]any:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = substitutePlaceholders(item, args)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = substitutePlaceholders(item, args)
+		}
+		return out
+	case primitive.A:
+		out := make(primitive.A, len(v))
+		for i, item := range v {
+			out[i] = substitutePlaceholders(item, args)
+		}
+		return out
+	}
+	return value
+}
+
+// templatePlaceholders returns the names of the placeholders in a parameter value
+func templatePlaceholders(value any) []string {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		for _, m := range templatePlaceholder.FindAllStringSubmatch(v, -1) {
+			names = append(names, m[1])
+		}
+	case map[string]any:
+		for _, item := range v {
+			names = append(names, templatePlaceholders(item)...)
+		}
+	case []any:
+		for _, item := range v {
+			names = append(names, templatePlaceholders(item)...)
+		}
+	case primitive.A:
+		for _, item := range v {
+			names = append(names, templatePlaceholders(item)...)
+		}
+	}
+	return names
+}
+
+// validateStepTemplate checks a template's parameters and steps; the expanded steps are
+// validated again as part of each recipe using the template
+func validateStepTemplate(template *models.StepTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return apperrors.Validation("step template name is required")
+	}
+
+	declared := make(map[string]bool, len(template.Parameters))
+	for _, param := range template.Parameters {
+		if !templateParamName.MatchString(param.Name) {
+			return apperrors.Validation(fmt.Sprintf("parameter name %q must start with a letter or underscore and contain only letters, digits and underscores", param.Name))
+		}
+		if declared[param.Name] {
+			return apperrors.Validation(fmt.Sprintf("duplicate parameter %q", param.Name))
+		}
+		declared[param.Name] = true
+	}
+
+	if len(template.Steps) == 0 {
+		return apperrors.Validation("step template needs at least one step")
+	}
+	numbers := make(map[int]bool, len(template.Steps))
+	for _, step := range template.Steps {
+		if step.StepNumber <= 0 {
+			return apperrors.Validation(fmt.Sprintf("step number must be positive, got %d", step.StepNumber))
+		}
+		if numbers[step.StepNumber] {
+			return apperrors.Validation(fmt.Sprintf("duplicate step number %d", step.StepNumber))
+		}
+		numbers[step.StepNumber] = true
+	}
+
+	for _, step := range template.Steps {
+		if step.Action == models.StepTemplateAction {
+			return apperrors.Validation(fmt.Sprintf("step %d: step templates cannot use other step templates", step.StepNumber))
+		}
+		if models.GetActionSchema(step.Action) == nil {
+			return apperrors.Validation(fmt.Sprintf("step %d: unknown action %q", step.StepNumber, step.Action))
+		}
+		for _, d := range step.DependsOnSteps {
+			if d == step.StepNumber {
+				return apperrors.Validation(fmt.Sprintf("step %d depends on itself", step.StepNumber))
+			}
+			if !numbers[d] {
+				return apperrors.Validation(fmt.Sprintf("step %d depends on step %d, which is not in the template", step.StepNumber, d))
+			}
+		}
+		used := templatePlaceholders(step.Parameters)
+		used = append(used, templatePlaceholders(step.Name)...)
+		used = append(used, templatePlaceholders(step.Description)...)
+		for _, name := range used {
+			if !declared[name] {
+				return apperrors.Validation(fmt.Sprintf("step %d uses placeholder %q, which is not a template parameter", step.StepNumber, name))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	apperrors "github.com/ak/kws/internal/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit log entries written for step templates and the recipes using them
+const (
+	AuditResourceStepTemplate = "step_template"
+
+	AuditActionStepTemplateCreated        = "step_template.created"
+	AuditActionStepTemplateUpdated        = "step_template.updated"
+	AuditActionStepTemplateDeleted        = "step_template.deleted"
+	AuditActionRecipeTemplatesRevalidated = "recipe.templates_revalidated"
+)
+
+// StepTemplateService manages step templates, the reusable step sequences recipes
+// reference, and expands them into the steps of the recipes using them
+type StepTemplateService interface {
+	List(ctx context.Context, tenantID primitive.ObjectID) ([]*models.StepTemplate, error)
+	Create(ctx context.Context, req StepTemplateRequest, userID string) (*models.StepTemplate, error)
+	// Update saves a new version of a template and flags every recipe using it for
+	// revalidation; saving the same content again changes nothing
+	Update(ctx context.Context, id primitive.ObjectID, req StepTemplateRequest, userID string) (*models.StepTemplate, error)
+	// Delete removes a template no recipe uses any more
+	Delete(ctx context.Context, id primitive.ObjectID, userID string) error
+	// Usage lists the recipes using a template and whether they are flagged
+	Usage(ctx context.Context, template *models.StepTemplate) (*models.StepTemplateUsage, error)
+
+	// ExpandRecipe expands the step templates of a recipe's source steps at their latest
+	// versions into its steps. Recipes without templated steps keep their steps as
+	// authored. The recipe is not saved.
+	ExpandRecipe(ctx context.Context, recipe *models.Recipe) error
+	// Revalidate re-expands a recipe with the latest template versions and validates it.
+	// When its steps change it is saved as a new draft version that goes through review
+	// again; either way its revalidation flag is cleared.
+	Revalidate(ctx context.Context, id primitive.ObjectID, userID string) (*models.Recipe, error)
+}
+
+// StepTemplateRequest is the content of a step template. TenantID is only read on create.
+type StepTemplateRequest struct {
+	TenantID    primitive.ObjectID         `json:"tenant_id"`
+	Name        string                     `json:"name" binding:"required"`
+	Description string                     `json:"description"`
+	Parameters  []models.StepTemplateParam `json:"parameters"`
+	Steps       []models.RecipeStep        `json:"steps" binding:"required"`
+}
+
+type stepTemplateService struct {
+	templateRepo  repositories.StepTemplateRepository
+	recipeRepo    repositories.RecipeRepository
+	recipeService RecipeService
+	auditRepo     repositories.AuditLogRepository
+}
+
+// NewStepTemplateService creates a new step template service
+func NewStepTemplateService(
+	templateRepo repositories.StepTemplateRepository,
+	recipeRepo repositories.RecipeRepository,
+	recipeService RecipeService,
+	auditRepo repositories.AuditLogRepository,
+) StepTemplateService {
+	return &stepTemplateService{
+		templateRepo:  templateRepo,
+		recipeRepo:    recipeRepo,
+		recipeService: recipeService,
+		auditRepo:     auditRepo,
+	}
+}
+
+func (s *stepTemplateService) List(ctx context.Context, tenantID primitive.ObjectID) ([]*models.StepTemplate, error) {
+	templates, err := s.templateRepo.ListByTenant(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list step templates: %w", err)
+	}
+	return templates, nil
+}
+
+func (s *stepTemplateService) Create(ctx context.Context, req StepTemplateRequest, userID string) (*models.StepTemplate, error) {
+	if req.TenantID.IsZero() {
+		return nil, apperrors.Validation("tenant_id is required")
+	}
+
+	template := &models.StepTemplate{
+		TenantID:  req.TenantID,
+		CreatedBy: userID,
+	}
+	applyStepTemplateRequest(template, req)
+	if err := validateStepTemplate(template); err != nil {
+		return nil, err
+	}
+	if err := s.checkName(ctx, template); err != nil {
+		return nil, err
+	}
+
+	if err := s.templateRepo.Create(ctx, template); err != nil {
+		return nil, fmt.Errorf("failed to create step template: %w", err)
+	}
+	if err := recordAudit(ctx, s.auditRepo, template.TenantID, userID, AuditActionStepTemplateCreated, AuditResourceStepTemplate, template.ID.Hex(), nil, stepTemplateState(template)); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (s *stepTemplateService) Update(ctx context.Context, id primitive.ObjectID, req StepTemplateRequest, userID string) (*models.StepTemplate, error) {
+	template, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *template
+	applyStepTemplateRequest(&updated, req)
+	if err := validateStepTemplate(&updated); err != nil {
+		return nil, err
+	}
+	if sameJSON(stepTemplateContent(template), stepTemplateContent(&updated)) {
+		return template, nil
+	}
+	if err := s.checkName(ctx, &updated); err != nil {
+		return nil, err
+	}
+
+	updated.Version++
+	updated.UpdatedBy = userID
+	if err := s.templateRepo.Update(ctx, &updated); err != nil {
+		return nil, fmt.Errorf("failed to update step template: %w", err)
+	}
+
+	// Recipes keep their expanded steps until someone revalidates them against the new version
+	flagged, err := s.recipeRepo.MarkTemplatesOutdated(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flag recipes using the step template: %w", err)
+	}
+	updated.FlaggedRecipes = flagged
+
+	newState := stepTemplateState(&updated)
+	newState["flagged_recipes"] = flagged
+	if err := recordAudit(ctx, s.auditRepo, updated.TenantID, userID, AuditActionStepTemplateUpdated, AuditResourceStepTemplate, id.Hex(), stepTemplateState(template), newState); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (s *stepTemplateService) Delete(ctx context.Context, id primitive.ObjectID, userID string) error {
+	template, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	recipes, err := s.recipeRepo.ListUsingStepTemplate(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to list recipes using the step template: %w", err)
+	}
+	if len(recipes) > 0 {
+		names := make([]string, len(recipes))
+		for i, recipe := range recipes {
+			names[i] = recipe.Name
+		}
+		return apperrors.Conflict(fmt.Sprintf("step template is used by %d recipes", len(recipes))).WithDetails(names)
+	}
+
+	if err := s.templateRepo.Delete(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete step template: %w", err)
+	}
+	return recordAudit(ctx, s.auditRepo, template.TenantID, userID, AuditActionStepTemplateDeleted, AuditResourceStepTemplate, id.Hex(), stepTemplateState(template), nil)
+}
+
+func (s *stepTemplateService) Usage(ctx context.Context, template *models.StepTemplate) (*models.StepTemplateUsage, error) {
+	recipes, err := s.recipeRepo.ListUsingStepTemplate(ctx, template.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipes using the step template: %w", err)
+	}
+
+	usage := &models.StepTemplateUsage{
+		TemplateID: template.ID.Hex(),
+		Name:       template.Name,
+		Version:    template.Version,
+		Recipes:    make([]models.StepTemplateRecipeUse, 0, len(recipes)),
+	}
+	for _, recipe := range recipes {
+		use := models.StepTemplateRecipeUse{
+			RecipeID:      recipe.ID.Hex(),
+			RecipeName:    recipe.Name,
+			RecipeVersion: recipe.Version,
+			Status:        recipe.Status,
+			Outdated:      recipe.TemplatesOutdated,
+		}
+		// A recipe using the template twice reports the oldest version it was expanded from
+		for _, t := range recipe.StepTemplates {
+			if t.TemplateID == template.ID && (use.TemplateVersion == 0 || t.Version < use.TemplateVersion) {
+				use.TemplateVersion = t.Version
+			}
+		}
+		usage.Recipes = append(usage.Recipes, use)
+	}
+	return usage, nil
+}
+
+func (s *stepTemplateService) ExpandRecipe(ctx context.Context, recipe *models.Recipe) error {
+	return expandRecipeTemplates(ctx, s.templateRepo, recipe)
+}
+
+func (s *stepTemplateService) Revalidate(ctx context.Context, id primitive.ObjectID, userID string) (*models.Recipe, error) {
+	recipe, err := s.recipeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipe: %w", err)
+	}
+	if recipe == nil {
+		return nil, apperrors.NotFound("recipe")
+	}
+	if recipe.Status == models.RecipeStatusArchived {
+		return nil, apperrors.Conflict("recipe is archived")
+	}
+	if !recipe.UsesStepTemplates() {
+		return nil, apperrors.Validation("recipe does not use step templates")
+	}
+
+	revised := *recipe
+	if err := s.ExpandRecipe(ctx, &revised); err != nil {
+		return nil, err
+	}
+	if err := s.recipeService.ValidateRecipe(ctx, &revised); err != nil {
+		return nil, err
+	}
+
+	// Only changed steps need review; otherwise the recipe was just confirmed as it is
+	if !sameJSON(revised.Steps, recipe.Steps) || !sameJSON(revised.Ingredients, recipe.Ingredients) {
+		revised.ResetReview()
+		revised.Version++
+		revised.UpdatedBy = userID
+		if err := s.recipeService.RefreshDerived(ctx, &revised, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.recipeRepo.Update(ctx, &revised); err != nil {
+		return nil, fmt.Errorf("failed to update recipe: %w", err)
+	}
+	oldState := map[string]any{"version": recipe.Version, "step_templates": recipe.StepTemplates}
+	newState := map[string]any{"version": revised.Version, "step_templates": revised.StepTemplates, "status": revised.Status}
+	if err := recordAudit(ctx, s.auditRepo, revised.TenantID, userID, AuditActionRecipeTemplatesRevalidated, AuditResourceRecipe, id.Hex(), oldState, newState); err != nil {
+		return nil, err
+	}
+	return &revised, nil
+}
+
+func (s *stepTemplateService) get(ctx context.Context, id primitive.ObjectID) (*models.StepTemplate, error) {
+	template, err := s.templateRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get step template: %w", err)
+	}
+	if template == nil {
+		return nil, apperrors.NotFound("step template")
+	}
+	return template, nil
+}
+
+// checkName rejects a template whose name another of the tenant's templates already has
+func (s *stepTemplateService) checkName(ctx context.Context, template *models.StepTemplate) error {
+	existing, err := s.templateRepo.ListByTenant(ctx, template.TenantID)
+	if err != nil {
+		return fmt.Errorf("failed to list step templates: %w", err)
+	}
+	for _, other := range existing {
+		if other.ID != template.ID && strings.EqualFold(other.Name, template.Name) {
+			return apperrors.Conflict(fmt.Sprintf("a step template named %q already exists", other.Name))
+		}
+	}
+	return nil
+}
+
+func applyStepTemplateRequest(template *models.StepTemplate, req StepTemplateRequest) {
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Parameters = req.Parameters
+	template.Steps = req.Steps
+}
+
+// stepTemplateContent is what makes up a template version
+func stepTemplateContent(template *models.StepTemplate) map[string]any {
+	return map[string]any{
+		"name":        template.Name,
+		"description": template.Description,
+		"parameters":  template.Parameters,
+		"steps":       template.Steps,
+	}
+}
+
+func stepTemplateState(template *models.StepTemplate) map[string]any {
+	state := stepTemplateContent(template)
+	state["version"] = template.Version
+	return state
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ak/kws/internal/domain/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRevalidateStepsOnlyRecipe(t *testing.T) {
+	ctx := context.Background()
+	tenantID := primitive.NewObjectID()
+	lentils := &models.Ingredient{ID: primitive.NewObjectID(), TenantID: tenantID, Name: "Lentils", MoistureType: models.MoistureTypeDry, IsActive: true}
+	simmer := &models.StepTemplate{
+		ID:       primitive.NewObjectID(),
+		TenantID: tenantID,
+		Name:     "Simmer",
+		Version:  2,
+		Steps: []models.RecipeStep{
+			{StepNumber: 1, Action: models.L4ActionHeat, Parameters: map[string]any{"power_level": 4, "on_duration_sec": 300}},
+		},
+	}
+
+	recipe := stepsOnlyRecipe(tenantID, lentils)
+	recipe.SetSteps(append(recipe.Steps, models.RecipeStep{
+		StepNumber:     3,
+		Action:         models.StepTemplateAction,
+		DependsOnSteps: []int{2},
+		Template:       &models.StepTemplateRef{TemplateID: simmer.ID},
+	}))
+	templates := newFakeStepTemplateRepo(simmer)
+	if err := expandRecipeTemplates(ctx, templates, recipe); err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	recipe.TemplatesOutdated = true
+
+	recipes := newFakeRecipeRepo(recipe)
+	recipeService := NewRecipeService(recipes, newFakeIngredientRepo(lentils), nil, nil, nil, templates, nil)
+	revalidated, err := NewStepTemplateService(templates, recipes, recipeService, nil).Revalidate(ctx, recipe.ID, "")
+	if err != nil {
+		t.Fatalf("Revalidate: %v", err)
+	}
+	if revalidated.TemplatesOutdated {
+		t.Error("recipe is still flagged as outdated")
+	}
+}
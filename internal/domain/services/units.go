@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConvertQuantity converts a quantity of an ingredient between units of the catalog.
+// Units of the same dimension convert by their factors; mass and volume need the
+// ingredient's density and count its piece weight. ok is false for unknown units and
+// conversions the ingredient has no data for.
+func ConvertQuantity(quantity float64, from, to string, ing *models.Ingredient) (float64, bool) {
+	fromUnit, ok := models.LookupUnit(from)
+	if !ok {
+		return 0, false
+	}
+	toUnit, ok := models.LookupUnit(to)
+	if !ok {
+		return 0, false
+	}
+	if fromUnit.Dimension == toUnit.Dimension {
+		return quantity * fromUnit.Factor / toUnit.Factor, true
+	}
+	if !canWeigh(fromUnit, ing) || !canWeigh(toUnit, ing) {
+		return 0, false
+	}
+	grams, _, ok := ingredientGrams(ingredientAmount{Quantity: quantity, Unit: from}, ing)
+	if !ok {
+		return 0, false
+	}
+	return gramsToUnit(grams, to, ing)
+}
+
+// ingredientGrams converts an ingredient amount to grams. assumed reports that a
+// volume was weighed without a known density.
+func ingredientGrams(amount ingredientAmount, ing *models.Ingredient) (grams float64, assumed, ok bool) {
+	unit, ok := models.LookupUnit(amount.Unit)
+	if !ok {
+		return 0, false, false
+	}
+	switch unit.Dimension {
+	case models.DimensionMass:
+		return amount.Quantity * unit.Factor, false, true
+	case models.DimensionVolume:
+		density, assumed := ingredientDensity(ing)
+		return amount.Quantity * unit.Factor * density, assumed, true
+	case models.DimensionCount:
+		if weight, ok := ingredientPieceWeight(ing); ok {
+			return amount.Quantity * unit.Factor * weight, false, true
+		}
+	}
+	return 0, false, false
+}
+
+// gramsToUnit converts grams of an ingredient to a unit of the catalog
+func gramsToUnit(grams float64, unit string, ing *models.Ingredient) (float64, bool) {
+	u, ok := models.LookupUnit(unit)
+	if !ok {
+		return 0, false
+	}
+	switch u.Dimension {
+	case models.DimensionMass:
+		return grams / u.Factor, true
+	case models.DimensionVolume:
+		density, _ := ingredientDensity(ing)
+		return grams / density / u.Factor, true
+	case models.DimensionCount:
+		if weight, ok := ingredientPieceWeight(ing); ok {
+			return grams / weight / u.Factor, true
+		}
+	}
+	return 0, false
+}
+
+// ingredientDensity returns an ingredient's density in g/ml. Without one, water's is
+// assumed.
+func ingredientDensity(ing *models.Ingredient) (float64, bool) {
+	if ing != nil {
+		if density, ok := toFloat(ing.Parameters[models.IngredientParamDensity]); ok && density > 0 {
+			return density, false
+		}
+	}
+	return 1, true
+}
+
+func ingredientPieceWeight(ing *models.Ingredient) (float64, bool) {
+	if ing != nil {
+		if weight, ok := toFloat(ing.Parameters[models.IngredientParamPieceWeight]); ok && weight > 0 {
+			return weight, true
+		}
+	}
+	return 0, false
+}
+
+// canWeigh reports whether an ingredient measured in a unit can be converted to and
+// from grams without assuming anything: always for mass, with a density for volume
+// and with a piece weight for count
+func canWeigh(unit *models.Unit, ing *models.Ingredient) bool {
+	return canWeighDimension(unit.Dimension, ing)
+}
+
+func canWeighDimension(dimension models.UnitDimension, ing *models.Ingredient) bool {
+	switch dimension {
+	case models.DimensionMass:
+		return true
+	case models.DimensionVolume:
+		_, assumed := ingredientDensity(ing)
+		return !assumed
+	case models.DimensionCount:
+		_, ok := ingredientPieceWeight(ing)
+		return ok
+	}
+	return false
+}
+
+// unitMismatch explains why an ingredient cannot be measured in a dimension, or
+// returns "" if it can. Ingredients are measured by volume if liquid and by mass
+// otherwise; any other dimension needs the data to convert.
+func unitMismatch(dimension models.UnitDimension, ing *models.Ingredient) string {
+	native := ing.MoistureType.Dimension()
+	if dimension == native || (canWeighDimension(dimension, ing) && canWeighDimension(native, ing)) {
+		return ""
+	}
+	param := models.IngredientParamDensity
+	if !canWeighDimension(dimension, ing) && dimension == models.DimensionCount {
+		param = models.IngredientParamPieceWeight
+	}
+	moisture := ing.MoistureType
+	if moisture == "" {
+		moisture = models.MoistureTypeDry
+	}
+	return fmt.Sprintf("a %s ingredient measured by %s needs its %s parameter", moisture, dimension, param)
+}
+
+// checkRecipeUnits checks every listed ingredient has a unit of the catalog that
+// suits its moisture type, and that the ingredients dispensed by steps can be
+// measured in the unit the dispenser works in. ingredients maps the known ingredient
+// documents; units of ingredients missing from it are only checked against the catalog.
+func checkRecipeUnits(recipe *models.Recipe, a *models.RecipeAnalysis, ingredients map[primitive.ObjectID]*models.Ingredient) {
+	addIssue := func(code string, step int, ingredientID, path, format string, args ...any) {
+		a.Issues = append(a.Issues, models.RecipeIssue{
+			Code:         code,
+			Severity:     models.IssueSeverityError,
+			StepNumber:   step,
+			IngredientID: ingredientID,
+			Path:         path,
+			Message:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	for i, ri := range recipe.Ingredients {
+		path := fmt.Sprintf("ingredients[%d].unit", i)
+		name := ri.IngredientName
+		if name == "" {
+			name = ri.IngredientID.Hex()
+		}
+		unit, ok := models.LookupUnit(ri.Unit)
+		if !ok {
+			if ri.Unit == "" {
+				addIssue(models.IssueInvalidUnit, 0, ri.IngredientID.Hex(), path, "ingredient %s has no unit", name)
+			} else {
+				addIssue(models.IssueInvalidUnit, 0, ri.IngredientID.Hex(), path, "ingredient %s has unknown unit %q", name, ri.Unit)
+			}
+			continue
+		}
+		if ing := ingredients[ri.IngredientID]; ing != nil {
+			if reason := unitMismatch(unit.Dimension, ing); reason != "" {
+				addIssue(models.IssueUnitMismatch, 0, ri.IngredientID.Hex(), path, "ingredient %s is measured in %s, but %s", name, ri.Unit, reason)
+			}
+		}
+	}
+
+	for i := range recipe.Steps {
+		step := &recipe.Steps[i]
+		unit, ok := models.LookupUnit(stepDispenseUnit(step.Action))
+		if !ok {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(stepIngredientID(step))
+		if err != nil {
+			continue
+		}
+		ing := ingredients[id]
+		if ing == nil {
+			continue
+		}
+		if reason := unitMismatch(unit.Dimension, ing); reason != "" {
+			addIssue(models.IssueUnitMismatch, step.StepNumber, id.Hex(), fmt.Sprintf("steps[%d].parameters.quantity", i),
+				"step %d: %s dispenses %s, but %s", step.StepNumber, step.Action, stepDispenseUnit(step.Action), reason)
+		}
+	}
+}
+
+// stepDispenseUnit returns the unit KOS expects a dispensing action's quantity in, or
+// "" for actions that dispense nothing
+func stepDispenseUnit(action models.L4Action) string {
+	schema := models.GetActionSchema(action)
+	if schema == nil {
+		return ""
+	}
+	if field := schema.Field("quantity"); field != nil {
+		return field.Unit
+	}
+	return ""
+}
+
+// NormalizeKOSUnits converts the ingredient quantities of a recipe sent to KOS to the
+// unit of the dispenser that adds them: the unit of the step dispensing the ingredient
+// or, if none does, ml for liquids and grams otherwise. Quantities that cannot be
+// converted are sent as written.
+func NormalizeKOSUnits(ctx context.Context, ingredientRepo repositories.IngredientRepository, recipe *models.RecipeForKOS) error {
+	var ids []primitive.ObjectID
+	for _, ri := range recipe.Ingredients {
+		if id, err := primitive.ObjectIDFromHex(ri.IngredientID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	list, err := ingredientRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to get ingredients: %w", err)
+	}
+	ingredients := make(map[string]*models.Ingredient, len(list))
+	for _, ing := range list {
+		ingredients[ing.ID.Hex()] = ing
+	}
+
+	dispensed := make(map[string]string)
+	for _, step := range recipe.Steps {
+		id, _ := step.Parameters["ingredient_id"].(string)
+		if unit := stepDispenseUnit(step.Action); id != "" && unit != "" {
+			if _, ok := dispensed[id]; !ok {
+				dispensed[id] = unit
+			}
+		}
+	}
+
+	for i := range recipe.Ingredients {
+		ri := &recipe.Ingredients[i]
+		ing := ingredients[ri.IngredientID]
+		unit, ok := dispensed[ri.IngredientID]
+		if !ok {
+			if ing == nil {
+				continue
+			}
+			unit = models.KOSUnitMass
+			if ing.MoistureType.Dimension() == models.DimensionVolume {
+				unit = models.KOSUnitVolume
+			}
+		}
+		if unit == ri.Unit {
+			continue
+		}
+		if quantity, ok := ConvertQuantity(ri.QuantityRequired, ri.Unit, unit, ing); ok {
+			ri.QuantityRequired = round2(quantity)
+			ri.Unit = unit
+		}
+	}
+	return nil
+}
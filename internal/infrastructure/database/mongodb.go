@@ -93,11 +93,29 @@ const (
 	CollectionKOSHeartbeats     = "kos_heartbeats"
 	CollectionIngredients       = "ingredients"
 	CollectionRecipes           = "recipes"
+	CollectionRecipeVersions    = "recipe_versions"
 	CollectionRecipeSyncRecords = "recipe_sync_records"
+	CollectionRecipeRollouts    = "recipe_rollouts"
 	CollectionOrders            = "orders"
 	CollectionOrderSyncRecords  = "order_sync_records"
+	CollectionInventory         = "inventory"
+	CollectionCanisterLoads     = "canister_loads"
+	CollectionKitchenLayouts    = "kitchen_layouts"
+	CollectionCalibrations      = "calibrations"
+	CollectionConsumption       = "consumption_records"
+	CollectionIngredientCosts   = "ingredient_costs"
+	CollectionMenuPrices        = "menu_prices"
 	CollectionAuditLogs         = "audit_logs"
 	CollectionAPIKeys           = "api_keys"
+
+	// Platform library, shared by all tenants
+	CollectionLibraryIngredients    = "library_ingredients"
+	CollectionLibraryRecipes        = "library_recipes"
+	CollectionLibraryRecipeVersions = "library_recipe_versions"
+
+	// Reusable step sequences recipes expand into their steps
+	CollectionStepTemplates        = "step_templates"
+	CollectionStepTemplateVersions = "step_template_versions"
 )
 
 // createIndexes creates necessary indexes for all collections
@@ -134,15 +152,47 @@ func (m *MongoDB) createIndexes(ctx context.Context) error {
 		CollectionIngredients: {
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "is_active", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "updated_at", Value: 1}}},
 		},
 		CollectionRecipes: {
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}},
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "status", Value: 1}}},
 			{Keys: bson.D{{Key: "published_to_sites", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "upstream.library_recipe_id", Value: 1}}},
+			{Keys: bson.D{{Key: "step_templates.template_id", Value: 1}}},
+		},
+		CollectionRecipeVersions: {
+			{Keys: bson.D{{Key: "recipe_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+			{Keys: bson.D{{Key: "recipe.ingredients.ingredient_id", Value: 1}}},
+		},
+		CollectionLibraryIngredients: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		CollectionLibraryRecipes: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		CollectionLibraryRecipeVersions: {
+			{Keys: bson.D{{Key: "recipe_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "recipe.ingredients.ingredient_id", Value: 1}}},
+		},
+		CollectionStepTemplates: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		CollectionStepTemplateVersions: {
+			{Keys: bson.D{{Key: "template_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
 		},
 		CollectionRecipeSyncRecords: {
 			{Keys: bson.D{{Key: "recipe_id", Value: 1}, {Key: "kos_id", Value: 1}}},
 			{Keys: bson.D{{Key: "kos_id", Value: 1}, {Key: "sync_status", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+		},
+		CollectionRecipeRollouts: {
+			{Keys: bson.D{{Key: "recipe_id", Value: 1}, {Key: "started_at", Value: -1}}},
+			{Keys: bson.D{{Key: "canary_sites", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "canary_sites", Value: 1}, {Key: "updated_at", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "status", Value: 1}}},
 		},
 		CollectionOrders: {
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
@@ -151,11 +201,38 @@ func (m *MongoDB) createIndexes(ctx context.Context) error {
 			{Keys: bson.D{{Key: "order_reference", Value: 1}}},
 			{Keys: bson.D{{Key: "kos_order_id", Value: 1}}, Options: options.Index().SetSparse(true)},
 			{Keys: bson.D{{Key: "kos_sync_status", Value: 1}}},
+			{Keys: bson.D{{Key: "recipe_id", Value: 1}, {Key: "recipe_version", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "status", Value: 1}, {Key: "completed_at", Value: 1}}},
 		},
 		CollectionOrderSyncRecords: {
 			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "synced_at", Value: -1}}},
 			{Keys: bson.D{{Key: "kos_id", Value: 1}}},
 		},
+		CollectionInventory: {
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "kitchen_id", Value: 1}, {Key: "ingredient_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		CollectionCanisterLoads: {
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "kitchen_id", Value: 1}, {Key: "canister_id", Value: 1}, {Key: "unloaded_at", Value: 1}}},
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "loaded_at", Value: -1}}},
+		},
+		CollectionKitchenLayouts: {
+			{Keys: bson.D{{Key: "kitchen_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "site_id", Value: 1}}},
+		},
+		CollectionCalibrations: {
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "ingredient_id", Value: 1}, {Key: "measured_at", Value: -1}}},
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		CollectionConsumption: {
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "step_number", Value: 1}}},
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "recorded_at", Value: -1}}},
+		},
+		CollectionIngredientCosts: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "ingredient_id", Value: 1}, {Key: "effective_from", Value: 1}}},
+		},
+		CollectionMenuPrices: {
+			{Keys: bson.D{{Key: "site_id", Value: 1}, {Key: "recipe_id", Value: 1}, {Key: "effective_from", Value: 1}}},
+		},
 		CollectionAuditLogs: {
 			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
 			{Keys: bson.D{{Key: "resource_type", Value: 1}, {Key: "resource_id", Value: 1}}},
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type auditLogRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAuditLogRepository(db *database.MongoDB) repositories.AuditLogRepository {
+	return &auditLogRepository{
+		collection: db.Collection(database.CollectionAuditLogs),
+	}
+}
+
+func (r *auditLogRepository) Create(ctx context.Context, log *repositories.AuditLog) error {
+	log.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	result, err := r.collection.InsertOne(ctx, log)
+	if err != nil {
+		return err
+	}
+	log.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *auditLogRepository) ListByTenant(ctx context.Context, tenantID primitive.ObjectID, page, limit int) ([]*repositories.AuditLog, int64, error) {
+	return r.list(ctx, bson.M{"tenant_id": tenantID}, page, limit)
+}
+
+func (r *auditLogRepository) ListByResource(ctx context.Context, resourceType, resourceID string, page, limit int) ([]*repositories.AuditLog, int64, error) {
+	return r.list(ctx, bson.M{"resource_type": resourceType, "resource_id": resourceID}, page, limit)
+}
+
+func (r *auditLogRepository) list(ctx context.Context, query bson.M, page, limit int) ([]*repositories.AuditLog, int64, error) {
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 50
+	}
+	skip := (page - 1) * limit
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var logs []*repositories.AuditLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+
+	return logs, total, nil
+}
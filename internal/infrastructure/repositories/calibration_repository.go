@@ -0,0 +1,111 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type calibrationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewCalibrationRepository(db *database.MongoDB) repositories.CalibrationRepository {
+	return &calibrationRepository{
+		collection: db.Collection(database.CollectionCalibrations),
+	}
+}
+
+func (r *calibrationRepository) Create(ctx context.Context, calibration *models.Calibration) error {
+	calibration.ID = primitive.NewObjectID()
+	calibration.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, calibration)
+	return err
+}
+
+func (r *calibrationRepository) ListCurrentBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Calibration, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"site_id": siteID}}},
+		{{Key: "$sort", Value: bson.D{{Key: "measured_at", Value: -1}, {Key: "created_at", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"kitchen_id":    "$kitchen_id",
+				"device_id":     "$device_id",
+				"ingredient_id": "$ingredient_id",
+			},
+			"calibration": bson.M{"$first": "$$ROOT"},
+		}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$calibration"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "ingredient_name", Value: 1}, {Key: "kitchen_id", Value: 1}, {Key: "device_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var calibrations []*models.Calibration
+	if err := cursor.All(ctx, &calibrations); err != nil {
+		return nil, err
+	}
+
+	return calibrations, nil
+}
+
+func (r *calibrationRepository) ListHistory(ctx context.Context, siteID primitive.ObjectID, filter repositories.CalibrationFilter) ([]*models.Calibration, error) {
+	query := bson.M{"site_id": siteID}
+	if filter.IngredientID != nil {
+		query["ingredient_id"] = *filter.IngredientID
+	}
+	if filter.KitchenID != "" {
+		query["kitchen_id"] = filter.KitchenID
+	}
+	if filter.DeviceID != "" {
+		query["device_id"] = filter.DeviceID
+	}
+	if !filter.Since.IsZero() {
+		query["measured_at"] = bson.M{"$gte": filter.Since}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "measured_at", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var calibrations []*models.Calibration
+	if err := cursor.All(ctx, &calibrations); err != nil {
+		return nil, err
+	}
+
+	return calibrations, nil
+}
+
+func (r *calibrationRepository) ListIngredientsChangedSince(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]primitive.ObjectID, error) {
+	values, err := r.collection.Distinct(ctx, "ingredient_id", bson.M{
+		"site_id":    siteID,
+		"created_at": bson.M{"$gt": since},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
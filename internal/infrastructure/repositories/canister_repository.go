@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type canisterLoadRepository struct {
+	collection *mongo.Collection
+}
+
+func NewCanisterLoadRepository(db *database.MongoDB) repositories.CanisterLoadRepository {
+	return &canisterLoadRepository{
+		collection: db.Collection(database.CollectionCanisterLoads),
+	}
+}
+
+func (r *canisterLoadRepository) Create(ctx context.Context, load *models.CanisterLoad) error {
+	load.ID = primitive.NewObjectID()
+	load.ReportedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, load)
+	return err
+}
+
+func (r *canisterLoadRepository) Unload(ctx context.Context, siteID primitive.ObjectID, kitchenID, canisterID string, at time.Time) error {
+	filter := bson.M{
+		"site_id":     siteID,
+		"kitchen_id":  kitchenID,
+		"canister_id": canisterID,
+		"unloaded_at": bson.M{"$exists": false},
+	}
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"unloaded_at": at}})
+	return err
+}
+
+func (r *canisterLoadRepository) ListActiveBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.CanisterLoad, error) {
+	return r.find(ctx, bson.M{
+		"site_id":     siteID,
+		"unloaded_at": bson.M{"$exists": false},
+	})
+}
+
+func (r *canisterLoadRepository) ListBySite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.CanisterLoad, error) {
+	return r.find(ctx, bson.M{
+		"site_id": siteID,
+		"$or": []bson.M{
+			{"unloaded_at": bson.M{"$exists": false}},
+			{"unloaded_at": bson.M{"$gte": since}},
+		},
+	})
+}
+
+func (r *canisterLoadRepository) find(ctx context.Context, filter bson.M) ([]*models.CanisterLoad, error) {
+	opts := options.Find().SetSort(bson.D{
+		{Key: "kitchen_id", Value: 1},
+		{Key: "canister_id", Value: 1},
+		{Key: "loaded_at", Value: -1},
+	})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var loads []*models.CanisterLoad
+	if err := cursor.All(ctx, &loads); err != nil {
+		return nil, err
+	}
+
+	return loads, nil
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type consumptionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewConsumptionRepository(db *database.MongoDB) repositories.ConsumptionRepository {
+	return &consumptionRepository{
+		collection: db.Collection(database.CollectionConsumption),
+	}
+}
+
+func (r *consumptionRepository) CreateMany(ctx context.Context, records []*models.ConsumptionRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(records))
+	for i, record := range records {
+		record.ID = primitive.NewObjectID()
+		docs[i] = record
+	}
+
+	_, err := r.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (r *consumptionRepository) ListByOrder(ctx context.Context, orderID primitive.ObjectID) ([]*models.ConsumptionRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "step_number", Value: 1}})
+	return r.find(ctx, bson.M{"order_id": orderID}, opts)
+}
+
+func (r *consumptionRepository) ListBySite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.ConsumptionRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "recorded_at", Value: 1}})
+	return r.find(ctx, bson.M{"site_id": siteID, "recorded_at": bson.M{"$gte": since}}, opts)
+}
+
+func (r *consumptionRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.ConsumptionRecord, error) {
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []*models.ConsumptionRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ingredientCostRepository struct {
+	collection *mongo.Collection
+}
+
+func NewIngredientCostRepository(db *database.MongoDB) repositories.IngredientCostRepository {
+	return &ingredientCostRepository{
+		collection: db.Collection(database.CollectionIngredientCosts),
+	}
+}
+
+func (r *ingredientCostRepository) Create(ctx context.Context, cost *models.IngredientCost) error {
+	cost.ID = primitive.NewObjectID()
+	cost.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, cost)
+	return err
+}
+
+func (r *ingredientCostRepository) ListByTenant(ctx context.Context, tenantID primitive.ObjectID, ingredientID *primitive.ObjectID) ([]*models.IngredientCost, error) {
+	query := bson.M{"tenant_id": tenantID}
+	if ingredientID != nil {
+		query["ingredient_id"] = *ingredientID
+	}
+
+	opts := options.Find().SetSort(bson.D{
+		{Key: "ingredient_id", Value: 1},
+		{Key: "effective_from", Value: 1},
+		{Key: "created_at", Value: 1},
+	})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var costs []*models.IngredientCost
+	if err := cursor.All(ctx, &costs); err != nil {
+		return nil, err
+	}
+
+	return costs, nil
+}
@@ -25,6 +25,14 @@ func NewIngredientRepository(db *database.MongoDB) repositories.IngredientReposi
 	}
 }
 
+// NewLibraryIngredientRepository returns the ingredients of the platform library
+func NewLibraryIngredientRepository(db *database.MongoDB) repositories.IngredientRepository {
+	return &ingredientRepository{
+		collection:        db.Collection(database.CollectionLibraryIngredients),
+		recipesCollection: db.Collection(database.CollectionLibraryRecipes),
+	}
+}
+
 func (r *ingredientRepository) Create(ctx context.Context, ingredient *models.Ingredient) error {
 	ingredient.CreatedAt = time.Now()
 	ingredient.UpdatedAt = time.Now()
@@ -125,6 +133,26 @@ func (r *ingredientRepository) GetByIDs(ctx context.Context, ids []primitive.Obj
 	return ingredients, nil
 }
 
+func (r *ingredientRepository) ListUpdatedSince(ctx context.Context, tenantID primitive.ObjectID, since time.Time) ([]*models.Ingredient, error) {
+	query := bson.M{
+		"tenant_id":  tenantID,
+		"updated_at": bson.M{"$gt": since},
+	}
+
+	cursor, err := r.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ingredients []*models.Ingredient
+	if err := cursor.All(ctx, &ingredients); err != nil {
+		return nil, err
+	}
+
+	return ingredients, nil
+}
+
 func (r *ingredientRepository) CountRecipesUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) (int64, error) {
 	// Count non-archived recipes that use this ingredient in either:
 	// 1. The ingredients array (RecipeIngredient items)
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type inventoryRepository struct {
+	collection *mongo.Collection
+}
+
+func NewInventoryRepository(db *database.MongoDB) repositories.InventoryRepository {
+	return &inventoryRepository{
+		collection: db.Collection(database.CollectionInventory),
+	}
+}
+
+func (r *inventoryRepository) Upsert(ctx context.Context, level *models.InventoryLevel) error {
+	level.UpdatedAt = time.Now()
+	filter := bson.M{
+		"site_id":       level.SiteID,
+		"kitchen_id":    level.KitchenID,
+		"ingredient_id": level.IngredientID,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"tenant_id":         level.TenantID,
+			"ingredient_name":   level.IngredientName,
+			"quantity":          level.Quantity,
+			"unit":              level.Unit,
+			"reported_quantity": level.ReportedQuantity,
+			"reported_at":       level.ReportedAt,
+			"updated_at":        level.UpdatedAt,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	return r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(level)
+}
+
+func (r *inventoryRepository) ListBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.InventoryLevel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "kitchen_id", Value: 1}, {Key: "ingredient_name", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"site_id": siteID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var levels []*models.InventoryLevel
+	if err := cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+func (r *inventoryRepository) Deduct(ctx context.Context, id primitive.ObjectID, quantity float64) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$inc": bson.M{"quantity": -quantity},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type kitchenLayoutRepository struct {
+	collection *mongo.Collection
+}
+
+func NewKitchenLayoutRepository(db *database.MongoDB) repositories.KitchenLayoutRepository {
+	return &kitchenLayoutRepository{
+		collection: db.Collection(database.CollectionKitchenLayouts),
+	}
+}
+
+func (r *kitchenLayoutRepository) Create(ctx context.Context, layout *models.KitchenLayout) error {
+	layout.ID = primitive.NewObjectID()
+
+	_, err := r.collection.InsertOne(ctx, layout)
+	return err
+}
+
+func (r *kitchenLayoutRepository) GetCurrent(ctx context.Context, kitchenID primitive.ObjectID) (*models.KitchenLayout, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+	return r.findOne(ctx, bson.M{"kitchen_id": kitchenID}, opts)
+}
+
+func (r *kitchenLayoutRepository) GetVersion(ctx context.Context, kitchenID primitive.ObjectID, version int) (*models.KitchenLayout, error) {
+	return r.findOne(ctx, bson.M{"kitchen_id": kitchenID, "version": version})
+}
+
+func (r *kitchenLayoutRepository) ListVersions(ctx context.Context, kitchenID primitive.ObjectID) ([]*models.KitchenLayout, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"kitchen_id": kitchenID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var layouts []*models.KitchenLayout
+	if err := cursor.All(ctx, &layouts); err != nil {
+		return nil, err
+	}
+
+	return layouts, nil
+}
+
+func (r *kitchenLayoutRepository) ListCurrentBySite(ctx context.Context, siteID primitive.ObjectID) ([]*models.KitchenLayout, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"site_id": siteID}}},
+		{{Key: "$sort", Value: bson.D{{Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$kitchen_id", "layout": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$layout"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "kos_kitchen_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var layouts []*models.KitchenLayout
+	if err := cursor.All(ctx, &layouts); err != nil {
+		return nil, err
+	}
+
+	return layouts, nil
+}
+
+func (r *kitchenLayoutRepository) findOne(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) (*models.KitchenLayout, error) {
+	var layout models.KitchenLayout
+	err := r.collection.FindOne(ctx, filter, opts...).Decode(&layout)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &layout, nil
+}
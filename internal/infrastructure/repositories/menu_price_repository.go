@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type menuPriceRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMenuPriceRepository(db *database.MongoDB) repositories.MenuPriceRepository {
+	return &menuPriceRepository{
+		collection: db.Collection(database.CollectionMenuPrices),
+	}
+}
+
+func (r *menuPriceRepository) Create(ctx context.Context, price *models.MenuPrice) error {
+	price.ID = primitive.NewObjectID()
+	price.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, price)
+	return err
+}
+
+func (r *menuPriceRepository) ListBySite(ctx context.Context, siteID primitive.ObjectID, recipeID *primitive.ObjectID) ([]*models.MenuPrice, error) {
+	query := bson.M{"site_id": siteID}
+	if recipeID != nil {
+		query["recipe_id"] = *recipeID
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "effective_from", Value: 1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var prices []*models.MenuPrice
+	if err := cursor.All(ctx, &prices); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
@@ -189,6 +189,35 @@ func (r *orderRepository) GetByKOSOrderID(ctx context.Context, kosOrderID string
 	return &order, nil
 }
 
+// ListFinishedForRecipeVersion returns completed or failed orders cooked with a recipe version
+// since the given time, used to compare how a new version performs against the previous one
+func (r *orderRepository) ListFinishedForRecipeVersion(ctx context.Context, recipeID primitive.ObjectID, version int, since time.Time) ([]*models.Order, error) {
+	query := bson.M{
+		"recipe_id":      recipeID,
+		"recipe_version": version,
+		"status": bson.M{
+			"$in": []models.OrderStatus{
+				models.OrderStatusCompleted,
+				models.OrderStatusFailed,
+			},
+		},
+		"created_at": bson.M{"$gte": since},
+	}
+
+	cursor, err := r.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []*models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
 // GetActiveForSite returns orders that are in non-terminal states (accepted, scheduled, in_progress)
 // These are orders that KOS should have in its local database
 func (r *orderRepository) GetActiveForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Order, error) {
@@ -266,3 +295,62 @@ func (r *orderRepository) ResetOrphanedOrders(ctx context.Context, siteID primit
 
 	return result.ModifiedCount, nil
 }
+
+// ListOpenForSite returns the orders a site has yet to finish, in the order they are
+// due, used to forecast what the site's inventory must still cover
+func (r *orderRepository) ListOpenForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.Order, error) {
+	query := bson.M{
+		"site_id": siteID,
+		"status": bson.M{
+			"$in": []models.OrderStatus{
+				models.OrderStatusPending,
+				models.OrderStatusAccepted,
+				models.OrderStatusScheduled,
+				models.OrderStatusInProgress,
+			},
+		},
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{
+			{Key: "execution_time", Value: 1},
+			{Key: "priority", Value: -1},
+			{Key: "created_at", Value: 1},
+		})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []*models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (r *orderRepository) ListCompletedForSite(ctx context.Context, siteID primitive.ObjectID, since, until time.Time) ([]*models.Order, error) {
+	query := bson.M{
+		"site_id":      siteID,
+		"status":       models.OrderStatusCompleted,
+		"completed_at": bson.M{"$gte": since, "$lt": until},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "completed_at", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []*models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
@@ -14,7 +14,22 @@ type Provider struct {
 	KOSInstance repositories.KOSInstanceRepository
 	Ingredient  repositories.IngredientRepository
 	Recipe      repositories.RecipeRepository
+	Rollout     repositories.RecipeRolloutRepository
 	Order       repositories.OrderRepository
+	Inventory   repositories.InventoryRepository
+	Canister    repositories.CanisterLoadRepository
+	Layout      repositories.KitchenLayoutRepository
+	Calibration repositories.CalibrationRepository
+	Consumption repositories.ConsumptionRepository
+	Cost        repositories.IngredientCostRepository
+	MenuPrice   repositories.MenuPriceRepository
+	AuditLog    repositories.AuditLogRepository
+
+	// The platform library's recipes and ingredients, which belong to no tenant
+	LibraryRecipe     repositories.RecipeRepository
+	LibraryIngredient repositories.IngredientRepository
+
+	StepTemplate repositories.StepTemplateRepository
 }
 
 // NewProvider creates a new repository provider
@@ -27,6 +42,20 @@ func NewProvider(db *database.MongoDB) *Provider {
 		KOSInstance: NewKOSInstanceRepository(db),
 		Ingredient:  NewIngredientRepository(db),
 		Recipe:      NewRecipeRepository(db),
+		Rollout:     NewRecipeRolloutRepository(db),
 		Order:       NewOrderRepository(db),
+		Inventory:   NewInventoryRepository(db),
+		Canister:    NewCanisterLoadRepository(db),
+		Layout:      NewKitchenLayoutRepository(db),
+		Calibration: NewCalibrationRepository(db),
+		Consumption: NewConsumptionRepository(db),
+		Cost:        NewIngredientCostRepository(db),
+		MenuPrice:   NewMenuPriceRepository(db),
+		AuditLog:    NewAuditLogRepository(db),
+
+		LibraryRecipe:     NewLibraryRecipeRepository(db),
+		LibraryIngredient: NewLibraryIngredientRepository(db),
+
+		StepTemplate: NewStepTemplateRepository(db),
 	}
 }
@@ -14,14 +14,26 @@ import (
 )
 
 type recipeRepository struct {
-	collection     *mongo.Collection
-	syncCollection *mongo.Collection
+	collection        *mongo.Collection
+	syncCollection    *mongo.Collection
+	versionCollection *mongo.Collection
 }
 
 func NewRecipeRepository(db *database.MongoDB) repositories.RecipeRepository {
 	return &recipeRepository{
-		collection:     db.Collection(database.CollectionRecipes),
-		syncCollection: db.Collection(database.CollectionRecipeSyncRecords),
+		collection:        db.Collection(database.CollectionRecipes),
+		syncCollection:    db.Collection(database.CollectionRecipeSyncRecords),
+		versionCollection: db.Collection(database.CollectionRecipeVersions),
+	}
+}
+
+// NewLibraryRecipeRepository returns the recipes of the platform library, which live in
+// their own collections so that no site query can reach them. They are never synced.
+func NewLibraryRecipeRepository(db *database.MongoDB) repositories.RecipeRepository {
+	return &recipeRepository{
+		collection:        db.Collection(database.CollectionLibraryRecipes),
+		syncCollection:    db.Collection(database.CollectionRecipeSyncRecords),
+		versionCollection: db.Collection(database.CollectionLibraryRecipeVersions),
 	}
 }
 
@@ -38,7 +50,7 @@ func (r *recipeRepository) Create(ctx context.Context, recipe *models.Recipe) er
 		return err
 	}
 	recipe.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	return r.saveVersion(ctx, recipe)
 }
 
 func (r *recipeRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Recipe, error) {
@@ -54,11 +66,184 @@ func (r *recipeRepository) GetByID(ctx context.Context, id primitive.ObjectID) (
 }
 
 func (r *recipeRepository) Update(ctx context.Context, recipe *models.Recipe) error {
+	// Recipes saved before versioning have no snapshot of their stored version; keep it
+	// before it is replaced, since it may still be the one served to sites
+	stored, err := r.GetByID(ctx, recipe.ID)
+	if err != nil {
+		return err
+	}
+	if stored != nil && stored.Version != recipe.Version {
+		if err := r.saveVersion(ctx, stored); err != nil {
+			return err
+		}
+	}
+
 	recipe.UpdatedAt = time.Now()
-	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": recipe.ID}, recipe)
+	if _, err := r.collection.ReplaceOne(ctx, bson.M{"_id": recipe.ID}, recipe); err != nil {
+		return err
+	}
+	return r.saveVersion(ctx, recipe)
+}
+
+// saveVersion records the snapshot for the recipe's current version number.
+// Only the first save of a version is kept ($setOnInsert), so status-only updates
+// such as publish/unpublish never rewrite history.
+func (r *recipeRepository) saveVersion(ctx context.Context, recipe *models.Recipe) error {
+	createdBy := recipe.UpdatedBy
+	if createdBy == "" {
+		createdBy = recipe.CreatedBy
+	}
+
+	snapshot := models.RecipeVersion{
+		RecipeID:  recipe.ID,
+		TenantID:  recipe.TenantID,
+		Version:   recipe.Version,
+		Recipe:    *recipe,
+		CreatedBy: createdBy,
+		CreatedAt: recipe.UpdatedAt,
+	}
+
+	_, err := r.versionCollection.UpdateOne(ctx,
+		bson.M{"recipe_id": recipe.ID, "version": recipe.Version},
+		bson.M{"$setOnInsert": snapshot},
+		options.Update().SetUpsert(true),
+	)
 	return err
 }
 
+func (r *recipeRepository) GetVersion(ctx context.Context, recipeID primitive.ObjectID, version int) (*models.RecipeVersion, error) {
+	var snapshot models.RecipeVersion
+	err := r.versionCollection.FindOne(ctx, bson.M{"recipe_id": recipeID, "version": version}).Decode(&snapshot)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *recipeRepository) ListVersions(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeVersion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	cursor, err := r.versionCollection.Find(ctx, bson.M{"recipe_id": recipeID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []*models.RecipeVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func (r *recipeRepository) ListVersionsUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) ([]*models.RecipeVersion, error) {
+	query := bson.M{
+		"$or": []bson.M{
+			{"recipe.ingredients.ingredient_id": ingredientID},
+			{"recipe.ingredients.substitutes": ingredientID},
+			{"recipe.steps.parameters.ingredient_id": ingredientID.Hex()},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "recipe_id", Value: 1}, {Key: "version", Value: 1}})
+
+	cursor, err := r.versionCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []*models.RecipeVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// RecordSyncDeliveries upserts one record per recipe and KOS. Only the delivery fields change,
+// so what KOS last acknowledged is kept until it acknowledges the new version.
+func (r *recipeRepository) RecordSyncDeliveries(ctx context.Context, records []*models.RecipeSyncRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(records))
+	for _, rec := range records {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"recipe_id": rec.RecipeID, "kos_id": rec.KOSID}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"tenant_id":         rec.TenantID,
+					"site_id":           rec.SiteID,
+					"delivered_version": rec.DeliveredVersion,
+					"delivered_at":      rec.DeliveredAt,
+				},
+				"$setOnInsert": bson.M{
+					"version":     0,
+					"sync_status": models.RecipeSyncStatusPending,
+				},
+			}).
+			SetUpsert(true))
+	}
+
+	_, err := r.syncCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// RecordSyncAck stores a KOS acknowledgement. A failure keeps the previously applied version.
+func (r *recipeRepository) RecordSyncAck(ctx context.Context, record *models.RecipeSyncRecord) error {
+	set := bson.M{
+		"tenant_id":   record.TenantID,
+		"site_id":     record.SiteID,
+		"synced_at":   record.SyncedAt,
+		"sync_status": record.SyncStatus,
+	}
+	update := bson.M{"$set": set}
+
+	if record.SyncStatus == models.RecipeSyncStatusFailed {
+		set["failed_version"] = record.FailedVersion
+		set["error_message"] = record.ErrorMessage
+		update["$setOnInsert"] = bson.M{"version": 0}
+	} else {
+		set["version"] = record.Version
+		update["$unset"] = bson.M{"failed_version": "", "error_message": ""}
+	}
+
+	_, err := r.syncCollection.UpdateOne(ctx,
+		bson.M{"recipe_id": record.RecipeID, "kos_id": record.KOSID},
+		update,
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *recipeRepository) ListSyncRecordsByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.RecipeSyncRecord, error) {
+	return r.findSyncRecords(ctx, bson.M{"tenant_id": tenantID})
+}
+
+func (r *recipeRepository) ListSyncRecordsForKOS(ctx context.Context, kosID primitive.ObjectID) ([]*models.RecipeSyncRecord, error) {
+	return r.findSyncRecords(ctx, bson.M{"kos_id": kosID})
+}
+
+func (r *recipeRepository) findSyncRecords(ctx context.Context, query bson.M) ([]*models.RecipeSyncRecord, error) {
+	cursor, err := r.syncCollection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []*models.RecipeSyncRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
 func (r *recipeRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	// Soft delete by archiving
 	_, err := r.collection.UpdateOne(ctx,
@@ -114,13 +299,19 @@ func (r *recipeRepository) GetPublishedForSite(ctx context.Context, siteID primi
 	// Return published recipes that are either:
 	// 1. Published globally (published_to_sites is empty/null)
 	// 2. Published specifically to this site
+	// A recipe is live while it has a published version, even if a newer working copy is in review
 	query := bson.M{
-		"status": models.RecipeStatusPublished,
-		"$or": []bson.M{
-			{"published_to_sites": siteID},                   // site-specific
-			{"published_to_sites": bson.M{"$size": 0}},       // global (empty array)
-			{"published_to_sites": bson.M{"$exists": false}}, // global (null/missing)
-			{"published_to_sites": nil},                      // global (explicit null)
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"status": models.RecipeStatusPublished},
+				{"published_version": bson.M{"$gt": 0}},
+			}},
+			{"$or": []bson.M{
+				{"published_to_sites": siteID},                   // site-specific
+				{"published_to_sites": bson.M{"$size": 0}},       // global (empty array)
+				{"published_to_sites": bson.M{"$exists": false}}, // global (null/missing)
+				{"published_to_sites": nil},                      // global (explicit null)
+			}},
 		},
 	}
 
@@ -139,3 +330,82 @@ func (r *recipeRepository) GetPublishedForSite(ctx context.Context, siteID primi
 
 	return recipes, nil
 }
+
+func (r *recipeRepository) ListUpdatedSince(ctx context.Context, tenantID primitive.ObjectID, since time.Time) ([]*models.Recipe, error) {
+	query := bson.M{
+		"tenant_id":  tenantID,
+		"updated_at": bson.M{"$gt": since},
+	}
+
+	cursor, err := r.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var recipes []*models.Recipe
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+func (r *recipeRepository) ListUsingIngredient(ctx context.Context, ingredientID primitive.ObjectID) ([]*models.Recipe, error) {
+	// Steps store ingredient IDs as hex strings
+	query := bson.M{
+		"status": bson.M{"$ne": models.RecipeStatusArchived},
+		"$or": []bson.M{
+			{"ingredients.ingredient_id": ingredientID},
+			{"ingredients.substitutes": ingredientID},
+			{"steps.parameters.ingredient_id": ingredientID.Hex()},
+		},
+	}
+
+	cursor, err := r.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var recipes []*models.Recipe
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+func (r *recipeRepository) ListUsingStepTemplate(ctx context.Context, templateID primitive.ObjectID) ([]*models.Recipe, error) {
+	query := bson.M{
+		"status":                     bson.M{"$ne": models.RecipeStatusArchived},
+		"step_templates.template_id": templateID,
+	}
+
+	cursor, err := r.collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var recipes []*models.Recipe
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return nil, err
+	}
+
+	return recipes, nil
+}
+
+func (r *recipeRepository) MarkTemplatesOutdated(ctx context.Context, templateID primitive.ObjectID) (int64, error) {
+	query := bson.M{
+		"status":                     bson.M{"$ne": models.RecipeStatusArchived},
+		"step_templates.template_id": templateID,
+	}
+
+	// updated_at is left alone: the recipe's content has not changed and KOS need not resync it
+	result, err := r.collection.UpdateMany(ctx, query, bson.M{"$set": bson.M{"templates_outdated": true}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type recipeRolloutRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRecipeRolloutRepository(db *database.MongoDB) repositories.RecipeRolloutRepository {
+	return &recipeRolloutRepository{
+		collection: db.Collection(database.CollectionRecipeRollouts),
+	}
+}
+
+func (r *recipeRolloutRepository) Create(ctx context.Context, rollout *models.RecipeRollout) error {
+	rollout.StartedAt = time.Now()
+	rollout.UpdatedAt = time.Now()
+	if rollout.Status == "" {
+		rollout.Status = models.RolloutStatusActive
+	}
+
+	result, err := r.collection.InsertOne(ctx, rollout)
+	if err != nil {
+		return err
+	}
+	rollout.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *recipeRolloutRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.RecipeRollout, error) {
+	var rollout models.RecipeRollout
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&rollout)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+func (r *recipeRolloutRepository) Update(ctx context.Context, rollout *models.RecipeRollout) error {
+	rollout.UpdatedAt = time.Now()
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": rollout.ID}, rollout)
+	return err
+}
+
+func (r *recipeRolloutRepository) GetActiveForRecipe(ctx context.Context, recipeID primitive.ObjectID) (*models.RecipeRollout, error) {
+	var rollout models.RecipeRollout
+	err := r.collection.FindOne(ctx, bson.M{
+		"recipe_id": recipeID,
+		"status":    models.RolloutStatusActive,
+	}).Decode(&rollout)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+func (r *recipeRolloutRepository) ListByRecipe(ctx context.Context, recipeID primitive.ObjectID) ([]*models.RecipeRollout, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"recipe_id": recipeID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollouts []*models.RecipeRollout
+	if err := cursor.All(ctx, &rollouts); err != nil {
+		return nil, err
+	}
+
+	return rollouts, nil
+}
+
+func (r *recipeRolloutRepository) ListActiveForSite(ctx context.Context, siteID primitive.ObjectID) ([]*models.RecipeRollout, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"canary_sites": siteID,
+		"status":       models.RolloutStatusActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollouts []*models.RecipeRollout
+	if err := cursor.All(ctx, &rollouts); err != nil {
+		return nil, err
+	}
+
+	return rollouts, nil
+}
+
+func (r *recipeRolloutRepository) ListActiveByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.RecipeRollout, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"tenant_id": tenantID,
+		"status":    models.RolloutStatusActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollouts []*models.RecipeRollout
+	if err := cursor.All(ctx, &rollouts); err != nil {
+		return nil, err
+	}
+
+	return rollouts, nil
+}
+
+func (r *recipeRolloutRepository) ListUpdatedForSite(ctx context.Context, siteID primitive.ObjectID, since time.Time) ([]*models.RecipeRollout, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"canary_sites": siteID,
+		"updated_at":   bson.M{"$gt": since},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollouts []*models.RecipeRollout
+	if err := cursor.All(ctx, &rollouts); err != nil {
+		return nil, err
+	}
+
+	return rollouts, nil
+}
@@ -0,0 +1,154 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ak/kws/internal/domain/models"
+	"github.com/ak/kws/internal/domain/repositories"
+	"github.com/ak/kws/internal/infrastructure/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type stepTemplateRepository struct {
+	collection        *mongo.Collection
+	versionCollection *mongo.Collection
+}
+
+func NewStepTemplateRepository(db *database.MongoDB) repositories.StepTemplateRepository {
+	return &stepTemplateRepository{
+		collection:        db.Collection(database.CollectionStepTemplates),
+		versionCollection: db.Collection(database.CollectionStepTemplateVersions),
+	}
+}
+
+func (r *stepTemplateRepository) Create(ctx context.Context, template *models.StepTemplate) error {
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	template.Version = 1
+
+	result, err := r.collection.InsertOne(ctx, template)
+	if err != nil {
+		return err
+	}
+	template.ID = result.InsertedID.(primitive.ObjectID)
+	return r.saveVersion(ctx, template)
+}
+
+func (r *stepTemplateRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.StepTemplate, error) {
+	var template models.StepTemplate
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&template)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (r *stepTemplateRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.StepTemplate, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var templates []*models.StepTemplate
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (r *stepTemplateRepository) Update(ctx context.Context, template *models.StepTemplate) error {
+	template.UpdatedAt = time.Now()
+	if _, err := r.collection.ReplaceOne(ctx, bson.M{"_id": template.ID}, template); err != nil {
+		return err
+	}
+	return r.saveVersion(ctx, template)
+}
+
+// saveVersion records the snapshot for the template's current version number; only the
+// first save of a version is kept
+func (r *stepTemplateRepository) saveVersion(ctx context.Context, template *models.StepTemplate) error {
+	createdBy := template.UpdatedBy
+	if createdBy == "" {
+		createdBy = template.CreatedBy
+	}
+
+	snapshot := models.StepTemplateVersion{
+		TemplateID: template.ID,
+		TenantID:   template.TenantID,
+		Version:    template.Version,
+		Template:   *template,
+		CreatedBy:  createdBy,
+		CreatedAt:  template.UpdatedAt,
+	}
+
+	_, err := r.versionCollection.UpdateOne(ctx,
+		bson.M{"template_id": template.ID, "version": template.Version},
+		bson.M{"$setOnInsert": snapshot},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *stepTemplateRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *stepTemplateRepository) ListByTenant(ctx context.Context, tenantID primitive.ObjectID) ([]*models.StepTemplate, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{"tenant_id": tenantID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var templates []*models.StepTemplate
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (r *stepTemplateRepository) GetVersion(ctx context.Context, templateID primitive.ObjectID, version int) (*models.StepTemplateVersion, error) {
+	var snapshot models.StepTemplateVersion
+	err := r.versionCollection.FindOne(ctx, bson.M{"template_id": templateID, "version": version}).Decode(&snapshot)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *stepTemplateRepository) ListVersions(ctx context.Context, templateID primitive.ObjectID) ([]*models.StepTemplateVersion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+
+	cursor, err := r.versionCollection.Find(ctx, bson.M{"template_id": templateID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []*models.StepTemplateVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
@@ -89,6 +89,10 @@ func AlreadyExists(resource string) *APIError {
 	return New(ErrAlreadyExists, fmt.Sprintf("%s already exists", resource), http.StatusConflict)
 }
 
+func Conflict(message string) *APIError {
+	return New(ErrConflict, message, http.StatusConflict)
+}
+
 func Validation(message string) *APIError {
 	return New(ErrValidation, message, http.StatusBadRequest)
 }
@@ -6,6 +6,7 @@ let ingredientGroupCounter = 1; // For tracking pick/add/place groups
 // DAG visualization state (no external library needed)
 let sortableInstance = null;
 let tomSelectInstances = {}; // Store Tom Select instances for cleanup
+let actionSchemas = {}; // Parameter schemas by action, from GET /api/v1/recipes/actions
 
 // System step constants
 const SYSTEM_STEP_ACQUIRE = 'acquire_pot_from_staging';
@@ -197,7 +198,79 @@ document.addEventListener('DOMContentLoaded', function () {
     }
 });
 
-function initializeRecipeStepsManagement() {
+// Load the per-action parameter schemas the step forms and validation are built from
+async function loadActionSchemas() {
+    try {
+        const response = await fetch('/api/v1/recipes/actions');
+        if (!response.ok) throw new Error(response.statusText);
+        const result = await response.json();
+        actionSchemas = {};
+        (result.data || []).forEach(schema => {
+            actionSchemas[schema.action] = schema;
+        });
+    } catch (error) {
+        console.error('Error loading action schemas:', error);
+    }
+}
+
+function getParamSchema(action, name) {
+    const schema = actionSchemas[action];
+    return schema ? schema.fields.find(f => f.name === name) : null;
+}
+
+// min/max attributes for a numeric parameter input
+function paramInputAttrs(action, name) {
+    const field = getParamSchema(action, name);
+    if (!field) return '';
+    const attrs = [];
+    if (field.min !== undefined) attrs.push(`min="${field.min}"`);
+    if (field.max !== undefined) attrs.push(`max="${field.max}"`);
+    return attrs.join(' ');
+}
+
+// Returns an error message if the value does not satisfy the parameter schema
+function checkParamValue(action, name, value) {
+    const field = getParamSchema(action, name);
+    if (!field) return '';
+    const unit = field.unit ? ` ${field.unit}` : '';
+    if (value === undefined || value === null || value === '' || Number.isNaN(value)) {
+        if (!field.required) return '';
+        return field.type === 'object_id' ? 'Please select an ingredient' : 'Please enter a value';
+    }
+    if (field.type === 'integer' || field.type === 'number') {
+        const n = Number(value);
+        if (Number.isNaN(n)) return 'Please enter a number';
+        if (field.type === 'integer' && !Number.isInteger(n)) return 'Please enter a whole number';
+        if (field.min !== undefined && n < field.min) return `Must be at least ${field.min}${unit}`;
+        if (field.max !== undefined && n > field.max) return `Must be at most ${field.max}${unit}`;
+    }
+    if (field.type === 'enum' && !field.enum.includes(value)) {
+        return `Must be one of ${field.enum.join(', ')}`;
+    }
+    return '';
+}
+
+// Radio buttons for an enum parameter of agitate (kind is 'speed' or 'direction')
+function renderEnumButtons(step, name, kind) {
+    const field = getParamSchema('agitate', name);
+    if (!field) return '';
+    const current = step.parameters[name] || field.default;
+    return field.enum.map((value, i) => {
+        const selected = current === value;
+        const label = (field.enum_labels && field.enum_labels[value]) || value;
+        return `
+                            <label class="cursor-pointer ${kind}-btn ${i > 0 ? 'border-l border-gray-300 dark:border-border-dark' : ''} ${selected ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
+                                <input type="radio" name="${kind}-${step.id}" value="${value}" class="sr-only ${kind}-radio" ${selected ? 'checked' : ''}>
+                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
+                                    ${label}
+                                </div>
+                            </label>`;
+    }).join('');
+}
+
+async function initializeRecipeStepsManagement() {
+    await loadActionSchemas();
+
     // Set up event listeners for Add Step buttons
     document.getElementById('add-step-btn').addEventListener('click', addStep);
     const bottomStepBtn = document.getElementById('add-step-btn-bottom');
@@ -272,7 +345,8 @@ function initializeSteps(existingSteps) {
             parameters: step.Parameters || step.parameters || {},
             depends_on_steps: step.DependsOnSteps || step.depends_on_steps || [],
             name: step.Name || step.name || '',
-            description: step.Description || step.description || ''
+            description: step.Description || step.description || '',
+            scaling: step.Scaling || step.scaling || undefined
         }));
 
         // Sort by step_number first
@@ -318,6 +392,7 @@ function initializeSteps(existingSteps) {
                         action: step.action,
                         parameters: params,
                         depends_on_steps: deps,
+                        scaling: step.scaling,
                         ingredientGroupId: groupId,
                         ingredientGroupRole: 'pick'
                     });
@@ -333,6 +408,7 @@ function initializeSteps(existingSteps) {
                         action: addStep.action,
                         parameters: addParams,
                         depends_on_steps: addDeps,
+                        scaling: addStep.scaling,
                         ingredientGroupId: groupId,
                         ingredientGroupRole: 'add'
                     });
@@ -348,6 +424,7 @@ function initializeSteps(existingSteps) {
                         action: placeStep.action,
                         parameters: placeParams,
                         depends_on_steps: placeDeps,
+                        scaling: placeStep.scaling,
                         ingredientGroupId: groupId,
                         ingredientGroupRole: 'place'
                     });
@@ -363,7 +440,8 @@ function initializeSteps(existingSteps) {
                 step_number: step.step_number,
                 action: step.action || '',
                 parameters: params,
-                depends_on_steps: deps
+                depends_on_steps: deps,
+                scaling: step.scaling
             });
             processedIndices.add(i);
         }
@@ -899,7 +977,7 @@ function createStepCard(step, index) {
                                 Quantity (grams) <span class="text-red-500">*</span>
                             </label>
                             <input type="number" class="quantity-input w-full px-4 py-2.5 bg-gray-50 dark:bg-surface-highlight border border-gray-300 dark:border-border-dark rounded-lg"
-                                   min="0.1" step="0.1" value="${step.parameters.quantity || ''}">
+                                   ${paramInputAttrs('add_solid', 'quantity')} step="0.1" value="${step.parameters.quantity || ''}">
                         </div>
                         <!-- Additional Dependencies for add_solid step -->
                         <div class="mt-3">
@@ -1012,7 +1090,7 @@ function createStepCard(step, index) {
                             Quantity (ml) <span class="text-red-500">*</span>
                         </label>
                         <input type="number" class="quantity-input w-full px-4 py-2.5 bg-gray-50 dark:bg-surface-highlight border border-gray-300 dark:border-border-dark rounded-lg"
-                               min="0.1" step="0.1" value="${step.parameters.quantity || ''}">
+                               ${paramInputAttrs('add_liquid', 'quantity')} step="0.1" value="${step.parameters.quantity || ''}">
                     </div>
 
                     <!-- heat parameters -->
@@ -1021,66 +1099,26 @@ function createStepCard(step, index) {
                             Power Level (1-10) <span class="text-red-500">*</span>
                         </label>
                         <input type="number" class="power-level-input w-full px-4 py-2.5 bg-gray-50 dark:bg-surface-highlight border border-gray-300 dark:border-border-dark rounded-lg mb-4"
-                               min="1" max="10" step="1" value="${step.parameters.power_level || 3}">
+                               ${paramInputAttrs('heat', 'power_level')} step="1" value="${step.parameters.power_level || 3}">
                         <label class="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
                             Duration (seconds) <span class="text-red-500">*</span>
                         </label>
                         <input type="number" class="heat-duration-input w-full px-4 py-2.5 bg-gray-50 dark:bg-surface-highlight border border-gray-300 dark:border-border-dark rounded-lg"
-                               min="1" step="1" value="${step.parameters.on_duration_sec || ''}">
+                               ${paramInputAttrs('heat', 'on_duration_sec')} step="1" value="${step.parameters.on_duration_sec || ''}">
                     </div>
 
                     <!-- agitate parameters -->
                     <div class="param-agitate ${step.action === 'agitate' ? '' : 'hidden'}">
                         <label class="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">Speed</label>
                         <div class="inline-flex rounded-lg border border-gray-300 dark:border-border-dark overflow-hidden mb-4 speed-group">
-                            <label class="cursor-pointer speed-btn ${!step.parameters.speed || step.parameters.speed === 'slow_stir' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="speed-${step.id}" value="slow_stir" class="sr-only speed-radio" ${!step.parameters.speed || step.parameters.speed === 'slow_stir' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Slow Stir
-                                </div>
-                            </label>
-                            <label class="cursor-pointer speed-btn border-l border-gray-300 dark:border-border-dark ${step.parameters.speed === 'med_stir' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="speed-${step.id}" value="med_stir" class="sr-only speed-radio" ${step.parameters.speed === 'med_stir' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Medium Stir
-                                </div>
-                            </label>
-                            <label class="cursor-pointer speed-btn border-l border-gray-300 dark:border-border-dark ${step.parameters.speed === 'fast_stir' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="speed-${step.id}" value="fast_stir" class="sr-only speed-radio" ${step.parameters.speed === 'fast_stir' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Fast Stir
-                                </div>
-                            </label>
-                            <label class="cursor-pointer speed-btn border-l border-gray-300 dark:border-border-dark ${step.parameters.speed === 'coarse_grind' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="speed-${step.id}" value="coarse_grind" class="sr-only speed-radio" ${step.parameters.speed === 'coarse_grind' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Coarse Grind
-                                </div>
-                            </label>
-                            <label class="cursor-pointer speed-btn border-l border-gray-300 dark:border-border-dark ${step.parameters.speed === 'fine_grind' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="speed-${step.id}" value="fine_grind" class="sr-only speed-radio" ${step.parameters.speed === 'fine_grind' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Fine Grind
-                                </div>
-                            </label>
+                            ${renderEnumButtons(step, 'speed', 'speed')}
                         </div>
                         <label class="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">Duration (seconds) <span class="text-red-500">*</span></label>
                         <input type="number" class="agitate-duration-input w-full px-4 py-2.5 bg-gray-50 dark:bg-surface-highlight border border-gray-300 dark:border-border-dark rounded-lg mb-4"
-                               min="1" step="1" value="${step.parameters.duration_sec || ''}">
+                               ${paramInputAttrs('agitate', 'duration_sec')} step="1" value="${step.parameters.duration_sec || ''}">
                         <label class="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">Direction</label>
                         <div class="inline-flex rounded-lg border border-gray-300 dark:border-border-dark overflow-hidden direction-group">
-                            <label class="cursor-pointer direction-btn ${!step.parameters.direction || step.parameters.direction === 'scraping' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="direction-${step.id}" value="scraping" class="sr-only direction-radio" ${!step.parameters.direction || step.parameters.direction === 'scraping' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Scraping
-                                </div>
-                            </label>
-                            <label class="cursor-pointer direction-btn border-l border-gray-300 dark:border-border-dark ${step.parameters.direction === 'cutting' ? 'bg-primary text-white' : 'bg-white dark:bg-surface-dark text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-surface-highlight'}">
-                                <input type="radio" name="direction-${step.id}" value="cutting" class="sr-only direction-radio" ${step.parameters.direction === 'cutting' ? 'checked' : ''}>
-                                <div class="px-3 py-1.5 text-center text-sm font-medium transition-colors">
-                                    Cutting
-                                </div>
-                            </label>
+                            ${renderEnumButtons(step, 'direction', 'direction')}
                         </div>
                     </div>
 
@@ -1607,6 +1645,60 @@ function updateDAG() {
 
     // Draw arrows after DOM is updated
     requestAnimationFrame(() => drawDAGArrows(dagSteps));
+
+    scheduleRecipeValidation(dagSteps);
+}
+
+// Server-side graph validation (cycles, unreachable steps, critical path), debounced while editing
+let validationTimer = null;
+
+function scheduleRecipeValidation(dagSteps) {
+    clearTimeout(validationTimer);
+    validationTimer = setTimeout(() => runRecipeValidation(dagSteps), 400);
+}
+
+async function runRecipeValidation(dagSteps) {
+    const container = document.getElementById('dag-analysis');
+    if (!container) return;
+
+    try {
+        const response = await fetch('/api/v1/recipes/validate', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ steps: dagSteps })
+        });
+        if (!response.ok) return;
+        const result = await response.json();
+        renderRecipeAnalysis(container, result.data);
+    } catch (error) {
+        console.error('Error validating recipe:', error);
+    }
+}
+
+function renderRecipeAnalysis(container, analysis) {
+    const escape = text => String(text).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+
+    let html = '';
+    if (analysis.valid) {
+        const minutes = Math.floor(analysis.critical_path_sec / 60);
+        const seconds = analysis.critical_path_sec % 60;
+        html += `
+            <div class="flex flex-wrap gap-4 text-sm text-gray-700 dark:text-gray-300">
+                <span><span class="font-semibold">Critical path:</span> ${minutes}m ${seconds}s (steps ${(analysis.critical_path || []).join(' → ')})</span>
+                <span><span class="font-semibold">Max parallel steps:</span> ${analysis.parallelism_width}</span>
+            </div>
+        `;
+    }
+    if (analysis.issues && analysis.issues.length > 0) {
+        html += '<ul class="mt-2 space-y-1 text-sm">';
+        analysis.issues.forEach(issue => {
+            const color = issue.severity === 'error' ? 'text-red-600 dark:text-red-400' : 'text-yellow-600 dark:text-yellow-400';
+            const icon = issue.severity === 'error' ? 'error' : 'warning';
+            html += `<li class="flex items-center gap-2 ${color}"><span class="material-symbols-outlined text-base">${icon}</span>${escape(issue.message)}</li>`;
+        });
+        html += '</ul>';
+    }
+    container.innerHTML = html;
 }
 
 // Redraw DAG arrows on window resize
@@ -1665,49 +1757,36 @@ function validateStepParameters() {
         const step = steps.find(s => s.id === parseInt(stepId));
         if (!step) continue;
 
-        // Validate based on action type
+        // Validate against the action's parameter schema
+        const check = (input, action, name, value) => {
+            const message = checkParamValue(action, name, value);
+            if (message) {
+                showFieldError(input, message);
+                if (errors.length === 0) errors.push({ stepId: step.id, input: input });
+            }
+        };
+
         if (step.ingredientGroupId) {
             if (step.ingredientGroupRole === 'pick') {
                 const select = card.querySelector('.ingredient-select-group');
-                if (!select || !select.value) {
-                    showFieldError(select, 'Please select an ingredient');
-                    if (errors.length === 0) errors.push({ stepId: step.id, input: select });
-                }
+                check(select, 'pick_ingredient', 'ingredient_id', select?.value);
             } else if (step.ingredientGroupRole === 'add') {
                 const qty = card.querySelector('.quantity-input');
-                if (!qty || !qty.value || parseFloat(qty.value) < 0.1) {
-                    showFieldError(qty, 'Please enter a quantity');
-                    if (errors.length === 0) errors.push({ stepId: step.id, input: qty });
-                }
+                check(qty, 'add_solid', 'quantity', qty?.value === '' ? undefined : parseFloat(qty?.value));
             }
         } else if (step.action === 'add_liquid') {
             const select = card.querySelector('.ingredient-select-liquid');
-            if (!select || !select.value) {
-                showFieldError(select, 'Please select an ingredient');
-                if (errors.length === 0) errors.push({ stepId: step.id, input: select });
-            }
+            check(select, 'add_liquid', 'ingredient_id', select?.value);
             const qty = card.querySelector('.param-add-liquid .quantity-input');
-            if (!qty || !qty.value || parseFloat(qty.value) < 0.1) {
-                showFieldError(qty, 'Please enter a quantity');
-                if (errors.length === 0) errors.push({ stepId: step.id, input: qty });
-            }
+            check(qty, 'add_liquid', 'quantity', qty?.value === '' ? undefined : parseFloat(qty?.value));
         } else if (step.action === 'heat') {
             const power = card.querySelector('.power-level-input');
-            if (!power || !power.value || parseInt(power.value) < 1 || parseInt(power.value) > 10) {
-                showFieldError(power, 'Please enter a power level (1-10)');
-                if (errors.length === 0) errors.push({ stepId: step.id, input: power });
-            }
+            check(power, 'heat', 'power_level', power?.value === '' ? undefined : Number(power?.value));
             const duration = card.querySelector('.heat-duration-input');
-            if (!duration || !duration.value || parseInt(duration.value) < 1) {
-                showFieldError(duration, 'Please enter a duration');
-                if (errors.length === 0) errors.push({ stepId: step.id, input: duration });
-            }
+            check(duration, 'heat', 'on_duration_sec', duration?.value === '' ? undefined : Number(duration?.value));
         } else if (step.action === 'agitate') {
             const duration = card.querySelector('.agitate-duration-input');
-            if (!duration || !duration.value || parseInt(duration.value) < 1) {
-                showFieldError(duration, 'Please enter a duration');
-                if (errors.length === 0) errors.push({ stepId: step.id, input: duration });
-            }
+            check(duration, 'agitate', 'duration_sec', duration?.value === '' ? undefined : Number(duration?.value));
         }
     }
 
@@ -1804,7 +1883,8 @@ async function handleFormSubmit(e) {
         parameters: step.parameters || {},
         depends_on_steps: step.depends_on_steps || [],
         name: step.name || '',
-        description: step.description || ''
+        description: step.description || '',
+        scaling: step.scaling // Set through the API; kept so saving does not drop it
     }));
 
     // Collect recipe data with embedded steps
@@ -1846,11 +1926,12 @@ async function saveRecipe(data) {
         body: JSON.stringify(data)
     });
 
+    const result = await response.json();
+
     if (!response.ok) {
-        throw new Error(`Failed to save recipe: ${response.statusText}`);
+        throw new Error(result.error?.message || result.message || response.statusText);
     }
 
-    const result = await response.json();
     return result.data.id;
 }
 